@@ -0,0 +1,257 @@
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/libp2p/hydra-booster/periodictasks"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+var log = logging.Logger("hydra/denylist")
+
+// codecs that content keys are checked against when matching double-hashed entries,
+// since provider records are keyed by multihash and the codec of the denied CID is unknown
+var doubleHashCodecs = []uint64{cid.DagProtobuf, cid.Raw}
+
+// Denylist holds lists of denied content and peers, loaded from files.
+//
+// Content files use the "badbits" format: one entry per line, either a double-hashed CID "//<hex sha256 of '<CIDv1 base32>/'>"
+// or a plain CID. Peer files have one peer ID per line. Empty lines and lines starting with "#" are ignored.
+type Denylist struct {
+	contentFiles []string
+	peerFiles    []string
+
+	mut          sync.RWMutex
+	doubleHashes map[string]struct{}
+	multihashes  map[string]struct{}
+	peers        map[peer.ID]struct{}
+	files        map[string]FileStatus
+	loadedAt     time.Time
+}
+
+// FileStatus describes a loaded denylist file.
+type FileStatus struct {
+	Path     string
+	Kind     string
+	Entries  int
+	Modified time.Time
+}
+
+// Status describes the loaded denylists.
+type Status struct {
+	LoadedAt       time.Time
+	Files          []FileStatus
+	ContentEntries int
+	Peers          []peer.ID
+}
+
+// New loads the given content and peer denylist files.
+func New(contentFiles, peerFiles []string) (*Denylist, error) {
+	d := &Denylist{contentFiles: contentFiles, peerFiles: peerFiles}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload reloads the denylist files if any of them changed since they were last loaded.
+// If a file cannot be loaded, the previously loaded lists are kept.
+func (d *Denylist) Reload() (bool, error) {
+	changed, err := d.changed()
+	if err != nil || !changed {
+		return false, err
+	}
+	if err := d.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewReloadTask creates a task that periodically reloads the denylist files when they change.
+func NewReloadTask(d *Denylist, interval time.Duration) periodictasks.PeriodicTask {
+	return periodictasks.PeriodicTask{
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			reloaded, err := d.Reload()
+			if err != nil {
+				return fmt.Errorf("reloading denylists: %w", err)
+			}
+			if reloaded {
+				log.Infof("reloaded denylists")
+			}
+			return nil
+		},
+	}
+}
+
+func (d *Denylist) changed() (bool, error) {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	for _, path := range append(append([]string{}, d.contentFiles...), d.peerFiles...) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if !fi.ModTime().Equal(d.files[path].Modified) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *Denylist) load() error {
+	doubleHashes := map[string]struct{}{}
+	multihashes := map[string]struct{}{}
+	peers := map[peer.ID]struct{}{}
+	files := map[string]FileStatus{}
+
+	for _, path := range d.contentFiles {
+		fs, err := readFile(path, "content", func(line string) error {
+			if strings.HasPrefix(line, "//") {
+				h := strings.ToLower(strings.TrimPrefix(line, "//"))
+				if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+					return fmt.Errorf("invalid double hash %q", line)
+				}
+				doubleHashes[h] = struct{}{}
+				return nil
+			}
+			c, err := cid.Decode(strings.TrimSuffix(strings.TrimPrefix(line, "/ipfs/"), "/"))
+			if err != nil {
+				return fmt.Errorf("invalid CID %q: %w", line, err)
+			}
+			multihashes[string(c.Hash())] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		files[path] = fs
+	}
+	for _, path := range d.peerFiles {
+		fs, err := readFile(path, "peer", func(line string) error {
+			p, err := peer.Decode(line)
+			if err != nil {
+				return fmt.Errorf("invalid peer ID %q: %w", line, err)
+			}
+			peers[p] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		files[path] = fs
+	}
+
+	d.mut.Lock()
+	d.doubleHashes = doubleHashes
+	d.multihashes = multihashes
+	d.peers = peers
+	d.files = files
+	d.loadedAt = time.Now()
+	d.mut.Unlock()
+
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(metrics.KeyKind, "content")}, metrics.DenylistEntries.M(int64(len(doubleHashes)+len(multihashes))))
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(metrics.KeyKind, "peer")}, metrics.DenylistEntries.M(int64(len(peers))))
+	return nil
+}
+
+// readFile calls parse for each entry of a denylist file.
+func readFile(path string, kind string, parse func(line string) error) (FileStatus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileStatus{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return FileStatus{}, err
+	}
+
+	fs := FileStatus{Path: path, Kind: kind, Modified: fi.ModTime()}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return FileStatus{}, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		fs.Entries++
+	}
+	if err := scanner.Err(); err != nil {
+		return FileStatus{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return fs, nil
+}
+
+// IsContentDenied returns true if the content with the given multihash is denied.
+func (d *Denylist) IsContentDenied(key []byte) bool {
+	mh, err := multihash.Cast(key)
+	if err != nil {
+		return false
+	}
+
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	if _, ok := d.multihashes[string(mh)]; ok {
+		return true
+	}
+	if len(d.doubleHashes) == 0 {
+		return false
+	}
+	for _, codec := range doubleHashCodecs {
+		s, err := cid.NewCidV1(codec, mh).StringOfBase(multibase.Base32)
+		if err != nil {
+			continue
+		}
+		h := sha256.Sum256([]byte(s + "/"))
+		if _, ok := d.doubleHashes[hex.EncodeToString(h[:])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPeerDenied returns true if the given peer is denied.
+func (d *Denylist) IsPeerDenied(p peer.ID) bool {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	_, ok := d.peers[p]
+	return ok
+}
+
+// Status describes the currently loaded denylists.
+func (d *Denylist) Status() Status {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	s := Status{
+		LoadedAt:       d.loadedAt,
+		ContentEntries: len(d.doubleHashes) + len(d.multihashes),
+	}
+	for _, fs := range d.files {
+		s.Files = append(s.Files, fs)
+	}
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Path < s.Files[j].Path })
+	for p := range d.peers {
+		s.Peers = append(s.Peers, p)
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i] < s.Peers[j] })
+	return s
+}
@@ -0,0 +1,113 @@
+package denylist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCid(t *testing.T, codec uint64, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(codec, mh)
+}
+
+func doubleHash(c cid.Cid) string {
+	h := sha256.Sum256([]byte(c.String() + "/"))
+	return "//" + hex.EncodeToString(h[:])
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	dir := t.TempDir()
+	doubleHashed := testCid(t, cid.DagProtobuf, "double hashed")
+	plain := testCid(t, cid.Raw, "plain")
+	allowed := testCid(t, cid.Raw, "allowed")
+	deniedPeer, err := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contentFile := filepath.Join(dir, "badbits.deny")
+	writeFile(t, contentFile, "# badbits\n\n"+doubleHash(doubleHashed)+"\n/ipfs/"+plain.String()+"\n")
+	peerFile := filepath.Join(dir, "peers.deny")
+	writeFile(t, peerFile, deniedPeer.String()+"\n")
+
+	d, err := New([]string{contentFile}, []string{peerFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, d.IsContentDenied(doubleHashed.Hash()))
+	// provider records are keyed by multihash, so any CID with the same multihash is denied
+	assert.True(t, d.IsContentDenied(cid.NewCidV1(cid.Raw, doubleHashed.Hash()).Hash()))
+	assert.True(t, d.IsContentDenied(plain.Hash()))
+	assert.False(t, d.IsContentDenied(allowed.Hash()))
+	assert.False(t, d.IsContentDenied([]byte("not a multihash")))
+	assert.True(t, d.IsPeerDenied(deniedPeer))
+	assert.False(t, d.IsPeerDenied(peer.ID("allowed")))
+
+	status := d.Status()
+	assert.Equal(t, 2, status.ContentEntries)
+	assert.Equal(t, []peer.ID{deniedPeer}, status.Peers)
+	assert.Len(t, status.Files, 2)
+}
+
+func TestDenylistReload(t *testing.T) {
+	dir := t.TempDir()
+	c := testCid(t, cid.Raw, "content")
+	contentFile := filepath.Join(dir, "badbits.deny")
+	writeFile(t, contentFile, "")
+
+	d, err := New([]string{contentFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, d.IsContentDenied(c.Hash()))
+
+	reloaded, err := d.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeFile(t, contentFile, c.String()+"\n")
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(contentFile, future, future))
+	reloaded, err = d.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.True(t, d.IsContentDenied(c.Hash()))
+
+	// an invalid file keeps the previously loaded lists
+	writeFile(t, contentFile, "invalid\n")
+	past := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(contentFile, past, past))
+	_, err = d.Reload()
+	assert.ErrorContains(t, err, "badbits.deny:1")
+	assert.True(t, d.IsContentDenied(c.Hash()))
+}
+
+func TestDenylistInvalid(t *testing.T) {
+	dir := t.TempDir()
+	contentFile := filepath.Join(dir, "badbits.deny")
+	writeFile(t, contentFile, "//nothex\n")
+	_, err := New([]string{contentFile}, nil)
+	assert.Error(t, err)
+
+	_, err = New(nil, []string{filepath.Join(dir, "missing")})
+	assert.Error(t, err)
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/libp2p/hydra-booster/metricstasks"
 	"github.com/libp2p/hydra-booster/periodictasks"
 	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/values"
 	"github.com/libp2p/hydra-booster/version"
 	"github.com/multiformats/go-multiaddr"
 )
@@ -103,7 +104,9 @@ func buildRcmgr(ctx context.Context, disableRM bool, limitsFile string) (network
 // NewHead constructs a new Hydra Booster head node
 func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan BootstrapStatus, error) {
 	cfg := opts.Options{}
-	cfg.Apply(append([]opts.Option{opts.Defaults}, options...)...)
+	if err := cfg.Apply(append([]opts.Option{opts.Defaults}, options...)...); err != nil {
+		return nil, nil, err
+	}
 
 	cmgr, err := connmgr.NewConnManager(cfg.ConnMgrLowWater, cfg.ConnMgrHighWater, connmgr.WithGracePeriod(cfg.ConnMgrGracePeriod))
 	if err != nil {
@@ -150,11 +153,20 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 		node.Close()
 	}()
 
+	valueStore := cfg.ValueStore
+	if valueStore == nil {
+		valueStore = cfg.Datastore
+	}
+	var prefetchingValueStore *values.PrefetchingStore
+	if cfg.ValuePrefetcher != nil && !cfg.DisableValues {
+		prefetchingValueStore = values.NewPrefetchingStore(valueStore, cfg.ValuePrefetcher)
+		valueStore = prefetchingValueStore
+	}
 	dhtOpts := []dht.Option{
 		dht.Mode(dht.ModeServer),
 		dht.ProtocolPrefix(cfg.ProtocolPrefix),
 		dht.BucketSize(cfg.BucketSize),
-		dht.Datastore(cfg.Datastore),
+		dht.Datastore(valueStore),
 		dht.QueryFilter(dht.PublicQueryFilter),
 		dht.RoutingTableFilter(dht.PublicRoutingTableFilter),
 	}
@@ -162,10 +174,14 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 	if cfg.DisableValues {
 		dhtOpts = append(dhtOpts, dht.DisableValues())
 	} else {
-		dhtOpts = append(dhtOpts, dht.Validator(record.NamespacedValidator{
+		validator := record.NamespacedValidator{
 			"pk":   record.PublicKeyValidator{},
 			"ipns": ipns.Validator{KeyBook: node.Peerstore()},
-		}))
+		}
+		for ns, v := range cfg.Validators {
+			validator[ns] = v
+		}
+		dhtOpts = append(dhtOpts, dht.Validator(validator))
 	}
 	if cfg.DisableProviders {
 		dhtOpts = append(dhtOpts, dht.DisableProviders())
@@ -173,11 +189,11 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 
 	var providerStore providers.ProviderStore
 	if cfg.ProviderStoreBuilder == nil {
-		ps, err := newDefaultProviderStore(ctx, cfg, node)
+		ps, err := NewDefaultProviderStore(ctx, cfg, node)
 		if err != nil {
 			return nil, nil, err
 		}
-		providerStore = ps
+		providerStore = hproviders.NewInstrumentedProviderStore(ps, "datastore", node.ID())
 	} else {
 		ps, err := cfg.ProviderStoreBuilder(cfg, node)
 		if err != nil {
@@ -186,16 +202,78 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 		providerStore = ps
 	}
 
+	// shard before any other wrapper, so that they apply to the requests forwarded to other hydras too
+	if cfg.Shards != nil {
+		// the records forwarded by other hydras don't go through the caching provider store, so they clear the negative
+		// cache of their keys themselves
+		forwarded := providerStore
+		if cfg.ProvidersFinder != nil {
+			forwarded = hproviders.NewInvalidatingProviderStore(forwarded, cfg.ProvidersFinder)
+		}
+		cfg.Shards.Serve(ctx, node, forwarded)
+		providerStore = hproviders.NewShardedProviderStore(providerStore, cfg.Shards.Forwarder())
+	}
+
 	if !cfg.DisableProvCounts {
 		periodictasks.RunTasks(ctx, []periodictasks.PeriodicTask{metricstasks.NewProviderRecordsTask(cfg.Datastore, providerStore, providerRecordsTaskInterval)})
 	}
 
+	// add the records replicated by other hydras through the denylist and the address filter, but without limiting them,
+	// and without prefetching the keys they are checked for. They clear the negative cache of their keys once added.
+	if cfg.Replicator != nil {
+		replicas := providerStore
+		if cfg.ProvidersFinder != nil {
+			replicas = hproviders.NewInvalidatingProviderStore(replicas, cfg.ProvidersFinder)
+		}
+		if cfg.Denylist != nil {
+			replicas = hproviders.NewDenylistProviderStore(replicas, cfg.Denylist)
+		}
+		if cfg.AddrFilter != "" && cfg.AddrFilter != hproviders.AddrFilterNone {
+			replicas = hproviders.NewAddrFilterProviderStore(replicas, cfg.AddrFilter)
+		}
+		cfg.Replicator.Serve(ctx, node, replicas)
+	}
+
 	var cachingProviderStore *hproviders.CachingProviderStore
 	if cfg.ProvidersFinder != nil {
 		cachingProviderStore = hproviders.NewCachingProviderStore(providerStore, providerStore, cfg.ProvidersFinder, nil)
+		cachingProviderStore.Revalidator = cfg.Revalidator
+		cachingProviderStore.PrefetchedTTL = cfg.PrefetchedRecordTTL
 		providerStore = cachingProviderStore
 	}
 
+	// replicate the records that passed the limits, the denylist and the address filter
+	if cfg.Replicator != nil {
+		providerStore = hproviders.NewReplicatingProviderStore(providerStore, cfg.Replicator)
+	}
+
+	// only limit the provider records added by peers, not the ones prefetched by the caching provider store
+	if cfg.PeerLimiter != nil {
+		providerStore = hproviders.NewRateLimitedProviderStore(providerStore, cfg.PeerLimiter)
+	}
+
+	// check the keyspace before the limits, so that the records of keys the hydra is not responsible for don't count
+	if cfg.KeyspaceFilter != nil {
+		providerStore = hproviders.NewKeyspaceFilteredProviderStore(providerStore, cfg.KeyspaceFilter)
+	}
+
+	// check the denylist before the caching provider store, so that denied content is not prefetched
+	if cfg.Denylist != nil {
+		providerStore = hproviders.NewDenylistProviderStore(providerStore, cfg.Denylist)
+	}
+
+	// resolve the addresses of the providers after the denylist, so that denied providers are not resolved
+	var addrResolvingProviderStore *hproviders.AddrResolvingProviderStore
+	if cfg.AddrResolver != nil {
+		addrResolvingProviderStore = hproviders.NewAddrResolvingProviderStore(providerStore, cfg.AddrResolver, node.Peerstore(), cfg.AddrResolutionWait)
+		providerStore = addrResolvingProviderStore
+	}
+
+	// filter the addresses last, so that the addresses of resolved providers are filtered too
+	if cfg.AddrFilter != "" && cfg.AddrFilter != hproviders.AddrFilterNone {
+		providerStore = hproviders.NewAddrFilterProviderStore(providerStore, cfg.AddrFilter)
+	}
+
 	dhtOpts = append(dhtOpts, dht.ProviderStore(providerStore))
 
 	dhtNode, err := dht.New(ctx, node, dhtOpts...)
@@ -206,6 +284,28 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 	// if we are using the caching provider store, we need to give it the content router to use (the DHT)
 	if cachingProviderStore != nil {
 		cachingProviderStore.Router = dhtNode
+		if len(cfg.PrefetchSources) > 0 {
+			sources := make([]hproviders.PrefetchSource, len(cfg.PrefetchSources))
+			for i, src := range cfg.PrefetchSources {
+				if src.Router == nil {
+					src.Router = dhtNode
+				}
+				sources[i] = src
+			}
+			cachingProviderStore.Router = hproviders.NewMultiContentRouter(cfg.PrefetchStrategy, sources...)
+		}
+	}
+
+	if addrResolvingProviderStore != nil {
+		addrResolvingProviderStore.Router = dhtNode
+	}
+
+	if prefetchingValueStore != nil {
+		prefetchingValueStore.Router = dhtNode
+	}
+
+	if cfg.KeyspaceFilter != nil {
+		cfg.KeyspaceFilter.AddHead(node.ID(), dhtNode.RoutingTable())
 	}
 
 	// bootstrap in the background
@@ -278,25 +378,20 @@ func NewHead(ctx context.Context, options ...opts.Option) (*Head, chan Bootstrap
 	return &hd, bsCh, nil
 }
 
-func newDefaultProviderStore(ctx context.Context, options opts.Options, h host.Host) (providers.ProviderStore, error) {
+// NewDefaultProviderStore creates the default provider store, which keeps provider records in the datastore of the options.
+func NewDefaultProviderStore(ctx context.Context, options opts.Options, h host.Host) (providers.ProviderStore, error) {
 	fmt.Fprintf(os.Stderr, "🥞 Using default providerstore\n")
 	var provMgrOpts []providers.Option
+	var cache hproviders.ProviderManagerCache
 	if options.DisableProvGC {
-		cache, err := simplelru.NewLRUWithExpire(provCacheSize, provCacheExpiry, nil)
+		c, err := simplelru.NewLRUWithExpire(provCacheSize, provCacheExpiry, nil)
 		if err != nil {
 			return nil, err
 		}
-		provMgrOpts = append(provMgrOpts,
-			providers.CleanupInterval(provDisabledGCInterval),
-			providers.Cache(cache),
-		)
-	}
-	var ps providers.ProviderStore
-	ps, err := providers.NewProviderManager(ctx, h.ID(), h.Peerstore(), options.Datastore, provMgrOpts...)
-	if err != nil {
-		return nil, err
+		cache = c
+		provMgrOpts = append(provMgrOpts, providers.CleanupInterval(provDisabledGCInterval))
 	}
-	return ps, nil
+	return hproviders.NewDatastoreProviderStore(ctx, h.ID(), h.Peerstore(), options.Datastore, cache, provMgrOpts...)
 }
 
 // RoutingTable returns the underlying RoutingTable for this head
@@ -2,14 +2,17 @@ package head
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
 	"github.com/libp2p/hydra-booster/head/opts"
 	hydratesting "github.com/libp2p/hydra-booster/testing"
+	"github.com/libp2p/hydra-booster/values"
 )
 
 func TestSpawnHead(t *testing.T) { // TODO spawn a node to bootstrap from so we don't hit the public bootstrappers
@@ -91,6 +94,38 @@ func TestSpawnHeadWithCustomProtocolPrefix(t *testing.T) { // TODO spawn a node
 	}
 }
 
+func TestSpawnHeadWithCustomValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(hydratesting.NewContext())
+	defer cancel()
+
+	hd, _, err := NewHead(
+		ctx,
+		opts.Datastore(datastore.NewMapDatastore()),
+		opts.ProtocolPrefix("/myapp"),
+		opts.BootstrapPeers(nil),
+		opts.Validator("myapp", values.SizeLimitValidator{MaxSize: 4}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator := hd.Routing.(*dht.IpfsDHT).Validator
+	if err := validator.Validate("/myapp/key", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if err := validator.Validate("/myapp/key", []byte("too large")); !errors.Is(err, values.ErrValueTooLarge) {
+		t.Fatalf("expected value too large error, got %v", err)
+	}
+	if err := validator.Validate("/other/key", []byte("ok")); err == nil {
+		t.Fatal("expected values of unregistered namespaces to be rejected")
+	}
+
+	_, _, err = NewHead(ctx, opts.Validator("ipns", values.SizeLimitValidator{MaxSize: 4}))
+	if err == nil {
+		t.Fatal("expected built-in namespaces to not be registered")
+	}
+}
+
 func TestSpawnHeadWithCustomPeerstore(t *testing.T) {
 	ctx, cancel := context.WithCancel(hydratesting.NewContext())
 	defer cancel()
@@ -3,6 +3,7 @@ package opts
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	ds "github.com/ipfs/go-datastore"
@@ -10,11 +11,15 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	record "github.com/libp2p/go-libp2p-record"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/replication"
+	"github.com/libp2p/hydra-booster/shard"
+	"github.com/libp2p/hydra-booster/values"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -23,6 +28,7 @@ type ProviderStoreBuilderFunc func(opts Options, host host.Host) (providers.Prov
 // Options are Hydra Head options
 type Options struct {
 	Datastore                 ds.Batching
+	ValueStore                ds.Batching
 	Peerstore                 peerstore.Peerstore
 	ProviderStoreBuilder      ProviderStoreBuilderFunc
 	DelegateHTTPClient        *http.Client
@@ -38,7 +44,21 @@ type Options struct {
 	DisableProvCounts         bool
 	DisableProviders          bool
 	DisableValues             bool
+	Validators                map[string]record.Validator
+	ValuePrefetcher           *values.Prefetcher
 	ProvidersFinder           hproviders.ProvidersFinder
+	Revalidator               *hproviders.Revalidator
+	PrefetchedRecordTTL       time.Duration
+	PrefetchStrategy          hproviders.RouterStrategy
+	PrefetchSources           []hproviders.PrefetchSource
+	Denylist                  hproviders.Denylist
+	PeerLimiter               *hproviders.PeerLimiter
+	KeyspaceFilter            *hproviders.KeyspaceFilter
+	AddrResolver              *hproviders.AddrResolver
+	AddrResolutionWait        time.Duration
+	AddrFilter                hproviders.AddrFilterPolicy
+	Shards                    *shard.Shards
+	Replicator                *replication.Replicator
 	DisableResourceManager    bool
 	ResourceManagerLimitsFile string
 	ConnMgrHighWater          int
@@ -84,6 +104,15 @@ func Datastore(ds ds.Batching) Option {
 	}
 }
 
+// ValueStore configures the Hydra Head to store the DHT's value records, e.g. IPNS records, in the specified datastore.
+// Defaults to the Datastore.
+func ValueStore(ds ds.Batching) Option {
+	return func(o *Options) error {
+		o.ValueStore = ds
+		return nil
+	}
+}
+
 // Peerstore configures the Hydra Head to use the specified peerstore.
 // Defaults to an in-memory (temporary) map.
 func Peerstore(ps peerstore.Peerstore) Option {
@@ -213,6 +242,36 @@ func DisableValues() Option {
 	}
 }
 
+// Validator registers the validator of the value records of a custom namespace, in addition to the built-in "pk" and
+// "ipns" namespaces, e.g. for a private DHT with application specific records. Each namespace can only be registered once.
+func Validator(namespace string, v record.Validator) Option {
+	return func(o *Options) error {
+		if namespace == "" || strings.Contains(namespace, "/") {
+			return fmt.Errorf("invalid value namespace %q", namespace)
+		}
+		if namespace == "pk" || namespace == "ipns" {
+			return fmt.Errorf("value namespace %q is built-in", namespace)
+		}
+		if _, ok := o.Validators[namespace]; ok {
+			return fmt.Errorf("value namespace %q registered twice", namespace)
+		}
+		if o.Validators == nil {
+			o.Validators = map[string]record.Validator{}
+		}
+		o.Validators[namespace] = v
+		return nil
+	}
+}
+
+// ValuePrefetcher configures the Hydra Head to prefetch the IPNS records missing from its value store from the network,
+// when they are requested. Pass the same prefetcher to all the heads to bound the prefetches across them.
+func ValuePrefetcher(p *values.Prefetcher) Option {
+	return func(o *Options) error {
+		o.ValuePrefetcher = p
+		return nil
+	}
+}
+
 // DisableProvCounts disables counting the number of providers in the provider store.
 func DisableProvCounts() Option {
 	return func(o *Options) error {
@@ -228,6 +287,98 @@ func ProvidersFinder(f hproviders.ProvidersFinder) Option {
 	}
 }
 
+// PrefetchSources configures the content routers the Hydra Head prefetches providers from, instead of only its DHT.
+// A source without a router stands for the DHT of the Hydra Head. It has no effect without a ProvidersFinder.
+func PrefetchSources(strategy hproviders.RouterStrategy, sources ...hproviders.PrefetchSource) Option {
+	return func(o *Options) error {
+		o.PrefetchStrategy = strategy
+		o.PrefetchSources = sources
+		return nil
+	}
+}
+
+// Revalidator configures the Hydra Head to refresh stale prefetched provider records in the background.
+// It has no effect without a ProvidersFinder.
+func Revalidator(r *hproviders.Revalidator) Option {
+	return func(o *Options) error {
+		o.Revalidator = r
+		return nil
+	}
+}
+
+// PrefetchedRecordTTL configures how long provider records found by prefetching are kept, instead of the TTL of
+// announced records. It has no effect without a ProvidersFinder, or if the provider store doesn't track record origins.
+func PrefetchedRecordTTL(d time.Duration) Option {
+	return func(o *Options) error {
+		o.PrefetchedRecordTTL = d
+		return nil
+	}
+}
+
+// Denylist configures the Hydra Head to neither store nor return provider records of denied content or peers.
+func Denylist(d hproviders.Denylist) Option {
+	return func(o *Options) error {
+		o.Denylist = d
+		return nil
+	}
+}
+
+// PeerLimiter configures the Hydra Head to drop the provider records of peers that exceed their limits.
+// Pass the same limiter to all the heads to apply the limits across them.
+func PeerLimiter(l *hproviders.PeerLimiter) Option {
+	return func(o *Options) error {
+		o.PeerLimiter = l
+		return nil
+	}
+}
+
+// KeyspaceFilter configures the Hydra Head to check that its Hydra is responsible for the keys of the provider records
+// added by peers, and registers the routing table of the Hydra Head with it. Pass the same filter to all the heads of a
+// Hydra.
+func KeyspaceFilter(f *hproviders.KeyspaceFilter) Option {
+	return func(o *Options) error {
+		o.KeyspaceFilter = f
+		return nil
+	}
+}
+
+// AddrResolver configures the Hydra Head to resolve the addresses of the providers returned without addresses, waiting
+// at most the given time for them to be resolved. Pass the same resolver to all the heads to bound the resolutions across them.
+func AddrResolver(r *hproviders.AddrResolver, wait time.Duration) Option {
+	return func(o *Options) error {
+		o.AddrResolver = r
+		o.AddrResolutionWait = wait
+		return nil
+	}
+}
+
+// AddrFilter configures which addresses of providers the Hydra Head stores and returns.
+// All the addresses are kept by default.
+func AddrFilter(policy hproviders.AddrFilterPolicy) Option {
+	return func(o *Options) error {
+		o.AddrFilter = policy
+		return nil
+	}
+}
+
+// Shards configures the Hydra Head to only store the provider records of the keys owned by its Hydra, and to forward the
+// requests for other keys to the Hydras owning them. Pass the same shards to all the heads of a Hydra.
+func Shards(s *shard.Shards) Option {
+	return func(o *Options) error {
+		o.Shards = s
+		return nil
+	}
+}
+
+// Replicator configures the Hydra Head to replicate the provider records announced to it to other Hydras, and to add
+// the records replicated by them. Pass the same replicator to all the heads of a Hydra.
+func Replicator(r *replication.Replicator) Option {
+	return func(o *Options) error {
+		o.Replicator = r
+		return nil
+	}
+}
+
 func DisableResourceManager(b bool) Option {
 	return func(o *Options) error {
 		o.DisableResourceManager = b
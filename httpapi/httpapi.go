@@ -12,8 +12,11 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 
 	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	dsq "github.com/ipfs/go-datastore/query"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -21,6 +24,8 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/hydra-booster/hydra"
 	"github.com/libp2p/hydra-booster/idgen"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/values"
 )
 
 // ListenAndServe instructs a Hydra HTTP API server to listen and serve on the passed address
@@ -46,6 +51,16 @@ func NewRouter(hy *hydra.Hydra) *mux.Router {
 	mux.HandleFunc("/idgen/remove", idgenRemoveHandler()).Methods("POST")
 	mux.HandleFunc("/swarm/peers", swarmPeersHandler(hy))
 	mux.HandleFunc("/pstore/list", pstoreListHandler(hy))
+	mux.HandleFunc("/denylist", denylistHandler(hy))
+	mux.HandleFunc("/providers/offenders", providerOffendersHandler(hy))
+	mux.HandleFunc("/providers/keyspace-filter", keyspaceFilterHandler(hy))
+	mux.HandleFunc("/prefetch/negative-cache", negativeCacheListHandler(hy)).Methods("GET")
+	mux.HandleFunc("/prefetch/negative-cache", negativeCachePurgeHandler(hy)).Methods("DELETE")
+	mux.HandleFunc("/prefetch/negative-cache/{key}", negativeCacheGetHandler(hy)).Methods("GET")
+	mux.HandleFunc("/prefetch/negative-cache/{key}", negativeCacheDeleteHandler(hy)).Methods("DELETE")
+	mux.HandleFunc("/shards", shardsHandler(hy))
+	mux.HandleFunc("/ipns", ipnsListHandler(hy))
+	mux.HandleFunc("/ipns/{name}", ipnsGetHandler(hy))
 	return mux
 }
 
@@ -104,6 +119,12 @@ func recordFetchHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request
 	}
 }
 
+// recordListEntry is a provider record datastore entry, with the origin of the record.
+type recordListEntry struct {
+	dsq.Entry
+	Origin hproviders.RecordOrigin
+}
+
 // "/records/list" Receive a record and fetch it from the network, if available
 func recordListHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -121,7 +142,9 @@ func recordListHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request)
 		enc := json.NewEncoder(w)
 
 		for result := range results.Next() {
-			enc.Encode(result.Entry)
+			// entries whose value cannot be parsed are listed as announced, as before origins were tracked
+			_, origin, _ := hproviders.DecodeDatastoreValue(result.Value)
+			enc.Encode(recordListEntry{Entry: result.Entry, Origin: origin})
 		}
 		results.Close()
 	}
@@ -265,3 +288,269 @@ func swarmPeersHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request)
 		}
 	}
 }
+
+type denylistCheck struct {
+	CID    string `json:",omitempty"`
+	Peer   string `json:",omitempty"`
+	Denied bool
+}
+
+// "/denylist[?cid=|?peer=]" Get the loaded denylists, or check whether a CID or peer is denied
+func denylistHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.Denylist == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+
+		if cidStr := r.FormValue("cid"); cidStr != "" {
+			c, err := cid.Decode(cidStr)
+			if err != nil {
+				fmt.Printf("Received invalid CID, got %s\n", cidStr)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			enc.Encode(denylistCheck{CID: cidStr, Denied: hy.Denylist.IsContentDenied(c.Hash())})
+			return
+		}
+		if peerStr := r.FormValue("peer"); peerStr != "" {
+			p, err := peer.Decode(peerStr)
+			if err != nil {
+				fmt.Printf("Received invalid peer ID, got %s\n", peerStr)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			enc.Encode(denylistCheck{Peer: peerStr, Denied: hy.Denylist.IsPeerDenied(p)})
+			return
+		}
+
+		enc.Encode(hy.Denylist.Status())
+	}
+}
+
+// "/providers/offenders[?n=]" Get the peers whose provider records were most throttled in the last hour (ndjson)
+func providerOffendersHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.PeerLimiter == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		n := 10
+		nStr := r.FormValue("n")
+		if nStr != "" {
+			var err error
+			n, err = strconv.Atoi(nStr)
+			if err != nil {
+				fmt.Printf("Received invalid n, got %s\n", nStr)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		enc := json.NewEncoder(w)
+		for _, o := range hy.PeerLimiter.TopOffenders(n) {
+			enc.Encode(o)
+		}
+	}
+}
+
+type keyspaceResponsibility struct {
+	CID         string
+	Responsible bool
+}
+
+// "/providers/keyspace-filter[?cid=]" Get the provider records checked by the keyspace filter, or whether the Hydra is
+// responsible for a CID
+func keyspaceFilterHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.KeyspaceFilter == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+
+		if cidStr := r.FormValue("cid"); cidStr != "" {
+			c, err := cid.Decode(cidStr)
+			if err != nil {
+				fmt.Printf("Received invalid CID, got %s\n", cidStr)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			enc.Encode(keyspaceResponsibility{CID: cidStr, Responsible: hy.KeyspaceFilter.Responsible(c.Hash())})
+			return
+		}
+
+		enc.Encode(hy.KeyspaceFilter.Stats())
+	}
+}
+
+type negativeCacheEntry struct {
+	// CID is a CIDv1 with the multihash of the key, using the raw codec
+	CID     string
+	Expires time.Time
+}
+
+func newNegativeCacheEntry(e hproviders.NegativeCacheEntry) negativeCacheEntry {
+	return negativeCacheEntry{
+		CID:     cid.NewCidV1(uint64(multicodec.Raw), multihash.Multihash(e.Key)).String(),
+		Expires: e.Expires,
+	}
+}
+
+// negativeCacheKey returns the negative cache key of the CID of the request, or writes an error response and returns false.
+func negativeCacheKey(hy *hydra.Hydra, w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if hy.PrefetchNegativeCache == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	cidStr := mux.Vars(r)["key"]
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		fmt.Printf("Received invalid CID, got %s\n", cidStr)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	return c.Hash(), true
+}
+
+// "GET /prefetch/negative-cache" Get the keys whose prefetch found no providers (ndjson)
+func negativeCacheListHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.PrefetchNegativeCache == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		enc := json.NewEncoder(w)
+		for _, e := range hy.PrefetchNegativeCache.Entries() {
+			enc.Encode(newNegativeCacheEntry(e))
+		}
+	}
+}
+
+// "DELETE /prefetch/negative-cache" Remove all the keys from the negative cache, so that they are prefetched again
+func negativeCachePurgeHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.PrefetchNegativeCache == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hy.PrefetchNegativeCache.Purge()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// "GET /prefetch/negative-cache/{cid}" Get the negative cache entry of a CID, or 404 if it is not cached
+func negativeCacheGetHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := negativeCacheKey(hy, w, r)
+		if !ok {
+			return
+		}
+		e, ok := hy.PrefetchNegativeCache.Get(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(newNegativeCacheEntry(e))
+	}
+}
+
+// "DELETE /prefetch/negative-cache/{cid}" Remove a CID from the negative cache, so that it is prefetched again, or 404 if it is not cached
+func negativeCacheDeleteHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := negativeCacheKey(hy, w, r)
+		if !ok {
+			return
+		}
+		if !hy.PrefetchNegativeCache.Remove(key) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type shardOwner struct {
+	CID string
+	// Owner is empty if no head of this Hydra is sharding yet
+	Owner peer.ID `json:",omitempty"`
+	// Self is true if the key is owned by this Hydra
+	Self bool
+}
+
+// "/shards[?cid=]" Get the Hydras sharing the keyspace and their share of it, or the Hydra owning a CID
+func shardsHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.Shards == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+
+		if cidStr := r.FormValue("cid"); cidStr != "" {
+			c, err := cid.Decode(cidStr)
+			if err != nil {
+				fmt.Printf("Received invalid CID, got %s\n", cidStr)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			owner, self := hy.Shards.Owner(c.Hash())
+			enc.Encode(shardOwner{CID: cidStr, Owner: owner, Self: self})
+			return
+		}
+
+		enc.Encode(hy.Shards.Status())
+	}
+}
+
+// "/ipns" Lists and decodes the IPNS records of the value store (ndjson)
+func ipnsListHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.Values == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		err := hy.Values.IterateIPNSRecords(r.Context(), func(rec values.IPNSRecord) error {
+			return enc.Encode(rec)
+		})
+		if err != nil {
+			fmt.Printf("Error on listing IPNS records: %s\n", err)
+		}
+	}
+}
+
+// "/ipns/{name}" Decodes the IPNS record of a name, given as a peer ID or a CID
+func ipnsGetHandler(hy *hydra.Hydra) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hy.Values == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		nameStr := mux.Vars(r)["name"]
+		name, err := peer.Decode(nameStr)
+		if err != nil {
+			fmt.Printf("Received invalid IPNS name, got %s\n", nameStr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rec, err := hy.Values.GetIPNSRecord(r.Context(), name)
+		if err == datastore.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error on retrieving IPNS record: %s\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rec)
+	}
+}
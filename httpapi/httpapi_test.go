@@ -8,16 +8,29 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipns"
+	record_pb "github.com/libp2p/go-libp2p-record/pb"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/denylist"
 	"github.com/libp2p/hydra-booster/head"
 	"github.com/libp2p/hydra-booster/hydra"
 	"github.com/libp2p/hydra-booster/idgen"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/shard"
 	hydratesting "github.com/libp2p/hydra-booster/testing"
+	"github.com/libp2p/hydra-booster/values"
+	"github.com/multiformats/go-base32"
 )
 
 func TestHTTPAPIHeads(t *testing.T) {
@@ -113,6 +126,62 @@ func TestHTTPAPIRecordsListWithoutRecords(t *testing.T) {
 	}
 }
 
+func TestHTTPAPIRecordsListOrigins(t *testing.T) {
+	ctx, cancel := context.WithCancel(hydratesting.NewContext())
+	defer cancel()
+
+	ds := datastore.NewMapDatastore()
+	origins := map[string]hproviders.RecordOrigin{
+		"announced-key":  hproviders.OriginAnnounced,
+		"prefetched-key": hproviders.OriginPrefetched,
+		"imported-key":   hproviders.OriginImported,
+	}
+	for key, origin := range origins {
+		err := ds.Put(ctx, hproviders.DatastoreProviderKey([]byte(key), peer.ID("peer")), hproviders.EncodeDatastoreValue(time.Now(), origin))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, NewRouter(&hydra.Hydra{SharedDatastore: ds}))
+	defer listener.Close()
+
+	url := fmt.Sprintf("http://%s/records/list", listener.Addr().String())
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	dec := json.NewDecoder(res.Body)
+	found := map[string]string{}
+	for {
+		var e struct {
+			Key    string
+			Origin string
+		}
+		if err := dec.Decode(&e); err != nil {
+			break
+		}
+		found[e.Key] = e.Origin
+	}
+
+	if len(found) != len(origins) {
+		t.Fatalf("expected %d records, found %d", len(origins), len(found))
+	}
+	for key, origin := range origins {
+		k := hproviders.DatastoreProviderKey([]byte(key), peer.ID("peer")).String()
+		if found[k] != origin.String() {
+			t.Fatalf("expected record %s to have origin %s, got %q", k, origin, found[k])
+		}
+	}
+}
+
 func TestHTTPAPIRecordsFetch(t *testing.T) {
 	ctx, cancel := context.WithCancel(hydratesting.NewContext())
 	defer cancel()
@@ -534,3 +603,346 @@ func TestHTTPAPISwarmPeersHeadFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestHTTPAPIDenylist(t *testing.T) {
+	c, err := cid.Decode("QmVBEScm197eQiqgUpstf9baFAaEnhQCgzHKiXnkCoED2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	denylistFile := filepath.Join(t.TempDir(), "badbits.deny")
+	if err := os.WriteFile(denylistFile, []byte(c.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := denylist.New([]string{denylistFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, NewRouter(&hydra.Hydra{Denylist: dl}))
+	defer listener.Close()
+
+	url := fmt.Sprintf("http://%s/denylist", listener.Addr().String())
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatal(fmt.Errorf("got non-2XX status code %d: %s", res.StatusCode, url))
+	}
+	var status denylist.Status
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.ContentEntries != 1 {
+		t.Fatalf("expected 1 content entry, got %d", status.ContentEntries)
+	}
+
+	url = fmt.Sprintf("http://%s/denylist?cid=%s", listener.Addr().String(), c)
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var check denylistCheck
+	if err := json.NewDecoder(res.Body).Decode(&check); err != nil {
+		t.Fatal(err)
+	}
+	if !check.Denied {
+		t.Fatalf("expected %s to be denied", c)
+	}
+}
+
+func TestHTTPAPINegativeCache(t *testing.T) {
+	nc, err := hproviders.NewNegativeCache(10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := cid.Decode("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := cid.Decode("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Add(c1.Hash())
+	nc.Add(c2.Hash())
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(listener, NewRouter(&hydra.Hydra{PrefetchNegativeCache: nc}))
+	defer listener.Close()
+	base := fmt.Sprintf("http://%s/prefetch/negative-cache", listener.Addr().String())
+
+	do := func(method, url string) *http.Response {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := do(http.MethodGet, base)
+	dec := json.NewDecoder(res.Body)
+	var cids []string
+	for {
+		var e negativeCacheEntry
+		if err := dec.Decode(&e); err != nil {
+			break
+		}
+		cids = append(cids, e.CID)
+	}
+	// entries are listed with a CIDv1 of their multihash
+	expCIDs := []string{c1.String(), cid.NewCidV1(cid.Raw, c2.Hash()).String()}
+	if !reflect.DeepEqual(cids, expCIDs) {
+		t.Fatalf("expected entries %v, got %v", expCIDs, cids)
+	}
+
+	// entries are looked up by multihash, whatever the CID version and codec
+	if res := do(http.MethodGet, base+"/"+c2.String()); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 looking up cached CID, got %d", res.StatusCode)
+	}
+	if res := do(http.MethodDelete, base+"/"+c2.String()); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204 deleting cached CID, got %d", res.StatusCode)
+	}
+	if res := do(http.MethodGet, base+"/"+c2.String()); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 looking up deleted CID, got %d", res.StatusCode)
+	}
+	if res := do(http.MethodDelete, base+"/"+c2.String()); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 deleting deleted CID, got %d", res.StatusCode)
+	}
+	if res := do(http.MethodGet, base+"/invalid"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400 looking up invalid CID, got %d", res.StatusCode)
+	}
+
+	if res := do(http.MethodDelete, base); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204 purging, got %d", res.StatusCode)
+	}
+	if nc.Len() != 0 {
+		t.Fatalf("expected the negative cache to be empty, found %d entries", nc.Len())
+	}
+}
+
+func TestHTTPAPINegativeCacheDisabled(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(listener, NewRouter(&hydra.Hydra{}))
+	defer listener.Close()
+
+	res, err := http.Get(fmt.Sprintf("http://%s/prefetch/negative-cache", listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404 without prefetching, got %d", res.StatusCode)
+	}
+}
+
+func TestHTTPAPIShards(t *testing.T) {
+	c, err := cid.Decode("QmVBEScm197eQiqgUpstf9baFAaEnhQCgzHKiXnkCoED2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, NewRouter(&hydra.Hydra{Shards: shard.New(http.DefaultClient, nil)}))
+	defer listener.Close()
+
+	url := fmt.Sprintf("http://%s/shards", listener.Addr().String())
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatal(fmt.Errorf("got non-2XX status code %d: %s", res.StatusCode, url))
+	}
+	var status []shard.MemberStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 0 {
+		t.Fatalf("expected no members before a head is served, got %d", len(status))
+	}
+
+	// all the keys are owned by this Hydra until a head is served
+	url = fmt.Sprintf("http://%s/shards?cid=%s", listener.Addr().String(), c)
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner shardOwner
+	if err := json.NewDecoder(res.Body).Decode(&owner); err != nil {
+		t.Fatal(err)
+	}
+	if !owner.Self {
+		t.Fatalf("expected %s to be owned by this Hydra", c)
+	}
+}
+
+func TestHTTPAPIKeyspaceFilter(t *testing.T) {
+	c, err := cid.Decode("QmVBEScm197eQiqgUpstf9baFAaEnhQCgzHKiXnkCoED2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, NewRouter(&hydra.Hydra{KeyspaceFilter: hproviders.NewKeyspaceFilter(hproviders.KeyspaceFilterDryRun, 20)}))
+	defer listener.Close()
+
+	url := fmt.Sprintf("http://%s/providers/keyspace-filter", listener.Addr().String())
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatal(fmt.Errorf("got non-2XX status code %d: %s", res.StatusCode, url))
+	}
+	var stats hproviders.KeyspaceFilterStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Mode != hproviders.KeyspaceFilterDryRun || stats.K != 20 {
+		t.Fatalf("unexpected keyspace filter stats %+v", stats)
+	}
+
+	// the Hydra is responsible for all the keys until it has heads
+	url = fmt.Sprintf("http://%s/providers/keyspace-filter?cid=%s", listener.Addr().String(), c)
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp keyspaceResponsibility
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Responsible {
+		t.Fatalf("expected this Hydra to be responsible for %s", c)
+	}
+}
+
+func TestHTTPAPIIPNS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), 7, time.Now().Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := record_pb.Record{Key: []byte(ipns.RecordKey(pid)), Value: value}
+	data, err := rec.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vs := values.NewStore(dssync.MutexWrap(datastore.NewMapDatastore()), false)
+	// the DHT stores records under the base32 encoding of their key
+	err = vs.Put(ctx, datastore.NewKey(base32.RawStdEncoding.EncodeToString(rec.Key)), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go http.Serve(listener, NewRouter(&hydra.Hydra{Values: vs}))
+	defer listener.Close()
+
+	url := fmt.Sprintf("http://%s/ipns", listener.Addr().String())
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatal(fmt.Errorf("got non-2XX status code %d: %s", res.StatusCode, url))
+	}
+	dec := json.NewDecoder(res.Body)
+	var records []values.IPNSRecord
+	for {
+		var r values.IPNSRecord
+		if err := dec.Decode(&r); err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	if len(records) != 1 || records[0].Sequence != 7 || records[0].Signature != values.SignatureValid {
+		t.Fatalf("unexpected IPNS records %+v", records)
+	}
+
+	url = fmt.Sprintf("http://%s/ipns/%s", listener.Addr().String(), records[0].Name)
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatal(fmt.Errorf("got non-2XX status code %d: %s", res.StatusCode, url))
+	}
+	var r values.IPNSRecord
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != "/ipfs/bafkqaaa" || r.TTL != "1m0s" {
+		t.Fatalf("unexpected IPNS record %+v", r)
+	}
+
+	url = fmt.Sprintf("http://%s/ipns/%s", listener.Addr().String(), "invalid")
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code %d, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+
+	otherSk, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := peer.IDFromPrivateKey(otherSk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url = fmt.Sprintf("http://%s/ipns/%s", listener.Addr().String(), other)
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code %d, got %d", http.StatusNotFound, res.StatusCode)
+	}
+}
@@ -4,17 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/retry"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go/aws/session"
 	ddbv1 "github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/axiomhq/hyperloglog"
@@ -23,11 +20,12 @@ import (
 	leveldb "github.com/ipfs/go-ds-leveldb"
 	"github.com/ipfs/go-libipfs/routing/http/client"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
-	"github.com/libp2p/go-libp2p/core/host"
+	record "github.com/libp2p/go-libp2p-record"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
 	hyds "github.com/libp2p/hydra-booster/datastore"
+	"github.com/libp2p/hydra-booster/denylist"
 	"github.com/libp2p/hydra-booster/head"
 	"github.com/libp2p/hydra-booster/head/opts"
 	"github.com/libp2p/hydra-booster/idgen"
@@ -35,7 +33,10 @@ import (
 	"github.com/libp2p/hydra-booster/metricstasks"
 	"github.com/libp2p/hydra-booster/periodictasks"
 	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/replication"
+	"github.com/libp2p/hydra-booster/shard"
 	"github.com/libp2p/hydra-booster/utils"
+	"github.com/libp2p/hydra-booster/values"
 	"github.com/multiformats/go-multiaddr"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
@@ -46,13 +47,33 @@ import (
 const (
 	routingTableSizeTaskInterval = 5 * time.Second
 	uniquePeersTaskInterval      = 5 * time.Second
-	ipnsRecordsTaskInterval      = 15 * time.Minute
+	valueRecordsTaskInterval     = 15 * time.Minute
+	denylistReloadInterval       = time.Minute
+	peerLimiterPruneInterval     = time.Minute
 )
 
+// defaultKeyspaceFilterK is the number of closest peers to a key a hydra must have a head among to be responsible for
+// it, when the bucket size is not set.
+const defaultKeyspaceFilterK = 20
+
 // Hydra is a container for heads and their shared belly bits.
 type Hydra struct {
 	Heads           []*head.Head
 	SharedDatastore datastore.Datastore
+	// Values stores the value records of the heads, e.g. IPNS records
+	Values *values.Store
+	// Denylist is nil if no denylist files are configured
+	Denylist *denylist.Denylist
+	// PeerLimiter is nil if provider records are not limited per peer
+	PeerLimiter *hproviders.PeerLimiter
+	// KeyspaceFilter is nil if provider records are accepted for all keys
+	KeyspaceFilter *hproviders.KeyspaceFilter
+	// PrefetchNegativeCache is nil if prefetching is disabled
+	PrefetchNegativeCache *hproviders.NegativeCache
+	// Shards is nil if provider records are not sharded
+	Shards *shard.Shards
+	// Replicator is nil if provider records are not replicated
+	Replicator *replication.Replicator
 	// SharedRoutingTable *kbucket.RoutingTable
 
 	hyperLock *sync.Mutex
@@ -65,6 +86,9 @@ type Options struct {
 	DatastorePath             string
 	PeerstorePath             string
 	ProviderStore             string
+	ProviderStoreCacheSize    int
+	ProviderStoreCacheTTL     time.Duration
+	ProviderStoreCacheMode    hproviders.WriteMode
 	DelegateTimeout           time.Duration
 	GetPort                   func() int
 	NHeads                    int
@@ -77,8 +101,27 @@ type Options struct {
 	DisableProvGC             bool
 	DisableProviders          bool
 	DisableValues             bool
+	ValueStore                string
+	ValueValidatorsFile       string
+	DisableValuePrefetch      bool
+	ValuePrefetchTimeout      time.Duration
+	ValuePrefetchWorkers      int
 	BootstrapPeers            []multiaddr.Multiaddr
 	DisablePrefetch           bool
+	PrefetchRefreshAge        time.Duration
+	PrefetchRefreshRate       float64
+	PrefetchRecordTTL         time.Duration
+	PrefetchMinRequests       int
+	PrefetchMinRequestsWindow time.Duration
+	PrefetchRate              float64
+	PrefetchTimeout           time.Duration
+	PrefetchQueueSize         int
+	PrefetchNegativeCacheTTL  time.Duration
+	PrefetchNegativeCacheSize int
+	PrefetchMinWorkers        int
+	PrefetchMaxWorkers        int
+	PrefetchRouters           []string
+	PrefetchRouterStrategy    hproviders.RouterStrategy
 	DisableProvCounts         bool
 	DisableDBCreate           bool
 	DisableResourceManager    bool
@@ -86,6 +129,21 @@ type Options struct {
 	ConnMgrHighWater          int
 	ConnMgrLowWater           int
 	ConnMgrGracePeriod        time.Duration
+	DenylistContentFiles      []string
+	DenylistPeerFiles         []string
+	ProviderRateLimit         float64
+	ProviderRateBurst         int
+	ProviderRecordQuota       int
+	ResolveProviderAddrs      bool
+	AddrResolutionWait        time.Duration
+	AddrResolutionWorkers     int
+	ProviderAddrFilter        hproviders.AddrFilterPolicy
+	KeyspaceFilter            hproviders.KeyspaceFilterMode
+	Shard                     bool
+	ShardPeers                []string
+	ReplicationPeers          []string
+	ReplicationBandwidth      float64
+	AntiEntropyInterval       time.Duration
 }
 
 // NewHydra creates a new Hydra with the passed options.
@@ -98,28 +156,36 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 		ctx = nctx
 	}
 
-	var ds datastore.Batching
-	var err error
-	if strings.HasPrefix(options.DatastorePath, "postgresql://") {
-		fmt.Fprintf(os.Stderr, "🐘 Using PostgreSQL datastore\n")
-		ds, err = hyds.NewPostgreSQLDatastore(ctx, options.DatastorePath, !options.DisableDBCreate)
-	} else if strings.HasPrefix(options.DatastorePath, "dynamodb://") {
-		optsStr := strings.TrimPrefix(options.DatastorePath, "dynamodb://")
-		table, err := parseDDBTable(optsStr)
+	ds, err := OpenDatastore(ctx, options.DatastorePath, !options.DisableDBCreate)
+	if err != nil {
+		return nil, err
+	}
+	valueStore, err := openValueStore(ctx, ds, options.ValueStore, !options.DisableDBCreate)
+	if err != nil {
+		return nil, err
+	}
+	if !options.DisableValues {
+		periodictasks.RunTasks(ctx, []periodictasks.PeriodicTask{metricstasks.NewValueRecordsTask(valueStore, valueRecordsTaskInterval)})
+	}
+	var valuePrefetcher *values.Prefetcher
+	if !options.DisableValues && !options.DisableValuePrefetch {
+		valuePrefetcher, err = newValuePrefetcher(ctx, options)
 		if err != nil {
 			return nil, err
 		}
-		fmt.Fprintf(os.Stderr, "Using DynamoDB datastore with table '%s'\n", table)
-		ddbClient := ddbv1.New(session.Must(session.NewSession()))
-		ddbDS := ddbds.New(ddbClient, table, ddbds.WithScanParallelism(5))
-		ds = ddbDS
-		periodictasks.RunTasks(ctx, []periodictasks.PeriodicTask{metricstasks.NewIPNSRecordsTask(ddbDS, ipnsRecordsTaskInterval)})
-	} else {
-		fmt.Fprintf(os.Stderr, "🥞 Using LevelDB datastore\n")
-		ds, err = leveldb.NewDatastore(options.DatastorePath, nil)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to create datastore: %w", err)
+	var validators map[string]record.Validator
+	if options.ValueValidatorsFile != "" {
+		validators, err = values.LoadValidators(options.ValueValidatorsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load value validators: %w", err)
+		}
+		namespaces := make([]string, 0, len(validators))
+		for ns := range validators {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+		fmt.Fprintf(os.Stderr, "🔏 Using validators of custom value namespaces: %s\n", strings.Join(namespaces, ", "))
 	}
 
 	var hds []*head.Head
@@ -157,8 +223,118 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 		return nil, err
 	}
 
-	providersFinder := hproviders.NewAsyncProvidersFinder(5*time.Second, 1000, 1*time.Hour)
-	providersFinder.Run(ctx, 1000)
+	var dl *denylist.Denylist
+	if len(options.DenylistContentFiles) > 0 || len(options.DenylistPeerFiles) > 0 {
+		dl, err = denylist.New(options.DenylistContentFiles, options.DenylistPeerFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load denylists: %w", err)
+		}
+		status := dl.Status()
+		fmt.Fprintf(os.Stderr, "🚫 Using denylists with %d content entries and %d peers\n", status.ContentEntries, len(status.Peers))
+	}
+
+	var peerLimiter *hproviders.PeerLimiter
+	if options.ProviderRateLimit > 0 || options.ProviderRecordQuota > 0 {
+		if options.ProviderRateLimit > 0 && options.ProviderRateBurst < 1 {
+			return nil, errors.New("the provider rate limit burst must be at least 1")
+		}
+		peerLimiter = hproviders.NewPeerLimiter(hproviders.PeerLimits{
+			Rate:       options.ProviderRateLimit,
+			Burst:      options.ProviderRateBurst,
+			MaxRecords: options.ProviderRecordQuota,
+			RecordTTL:  providers.ProvideValidity,
+		})
+		go peerLimiter.Run(ctx, peerLimiterPruneInterval)
+		fmt.Fprintf(os.Stderr, "🚦 Limiting provider records per peer with rate=%g/s, burst=%d, quota=%d\n", options.ProviderRateLimit, options.ProviderRateBurst, options.ProviderRecordQuota)
+	}
+
+	var keyspaceFilter *hproviders.KeyspaceFilter
+	if options.KeyspaceFilter != "" && options.KeyspaceFilter != hproviders.KeyspaceFilterNone {
+		k := options.BucketSize
+		if k == 0 {
+			k = defaultKeyspaceFilterK
+		}
+		keyspaceFilter = hproviders.NewKeyspaceFilter(options.KeyspaceFilter, k)
+		fmt.Fprintf(os.Stderr, "🧭 Filtering provider records by keyspace responsibility with mode=%s, k=%d\n", options.KeyspaceFilter, k)
+	}
+
+	var addrResolver *hproviders.AddrResolver
+	if options.ResolveProviderAddrs {
+		workers := options.AddrResolutionWorkers
+		if workers <= 0 {
+			workers = hproviders.DefaultAddrResolverWorkers
+		}
+		addrResolver, err = hproviders.NewAddrResolver(hproviders.DefaultAddrResolverQueueSize, hproviders.DefaultAddrResolverCacheSize, hproviders.DefaultAddrResolverCacheTTL, hproviders.DefaultAddrResolverTimeout)
+		if err != nil {
+			return nil, err
+		}
+		addrResolver.Run(ctx, workers)
+		fmt.Fprintf(os.Stderr, "📇 Resolving the addresses of providers with workers=%d, wait=%s\n", workers, options.AddrResolutionWait)
+	}
+
+	var shards *shard.Shards
+	if options.Shard {
+		seeds := make([]string, len(options.ShardPeers))
+		for i, p := range options.ShardPeers {
+			seeds[i] = "http://" + p
+		}
+		shards = shard.New(delegateHTTPClient, seeds)
+		fmt.Fprintf(os.Stderr, "🍰 Sharding provider records with the hydras at %s\n", strings.Join(options.ShardPeers, ", "))
+	}
+
+	var replicator *replication.Replicator
+	if len(options.ReplicationPeers) > 0 {
+		policy := replication.DefaultPolicy()
+		policy.Bandwidth = options.ReplicationBandwidth
+		policy.AntiEntropyInterval = options.AntiEntropyInterval
+		replicator, err = replication.New(delegateHTTPClient, options.ReplicationPeers, policy)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "🪞 Replicating provider records to the hydras at %s\n", strings.Join(options.ReplicationPeers, ", "))
+	}
+
+	prefetchConfig, err := newPrefetchConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	negativeCache, err := hproviders.NewNegativeCache(prefetchConfig.PrefetchNegativeCacheSize, prefetchConfig.PrefetchNegativeCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	providersFinder := hproviders.NewAsyncProvidersFinder(prefetchConfig.PrefetchTimeout, prefetchConfig.PrefetchQueueSize, negativeCache)
+	admission, err := newPrefetchAdmission(options)
+	if err != nil {
+		return nil, err
+	}
+	if len(admission) > 0 {
+		providersFinder.Admission = admission
+	}
+	providersFinder.Run(ctx, prefetchConfig.PrefetchMinWorkers, prefetchConfig.PrefetchMaxWorkers)
+
+	prefetchSources, err := newPrefetchSources(delegateHTTPClient, options.PrefetchRouters)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefetchSources) > 0 && !options.DisablePrefetch {
+		fmt.Fprintf(os.Stderr, "🔭 Prefetching providers from %s with strategy=%s\n", strings.Join(options.PrefetchRouters, ", "), options.PrefetchRouterStrategy)
+	}
+
+	var revalidator *hproviders.Revalidator
+	if !options.DisablePrefetch && options.PrefetchRefreshAge > 0 {
+		if options.PrefetchRefreshRate <= 0 {
+			return nil, errors.New("the prefetch refresh rate must be positive")
+		}
+		revalidator, err = hproviders.NewRevalidator(hproviders.RevalidatePolicy{
+			MaxAge: options.PrefetchRefreshAge,
+			Rate:   options.PrefetchRefreshRate,
+			Burst:  int(math.Ceil(options.PrefetchRefreshRate)),
+		}, hproviders.DefaultRevalidateTrackedKeys)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "🔁 Refreshing prefetched provider records older than %s, at most %g/s\n", options.PrefetchRefreshAge, options.PrefetchRefreshRate)
+	}
 
 	// Reuse the HTTP client across all the heads.
 	for i := 0; i < options.NHeads; i++ {
@@ -174,6 +350,7 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 		}
 		hdOpts := []opts.Option{
 			opts.Datastore(ds),
+			opts.ValueStore(valueStore),
 			opts.ProviderStoreBuilder(providerStoreBuilder),
 			opts.Addrs([]multiaddr.Multiaddr{tcpAddr, quicAddr}),
 			opts.ProtocolPrefix(options.ProtocolPrefix),
@@ -197,6 +374,12 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 		if options.DisableValues {
 			hdOpts = append(hdOpts, opts.DisableValues())
 		}
+		for ns, v := range validators {
+			hdOpts = append(hdOpts, opts.Validator(ns, v))
+		}
+		if valuePrefetcher != nil {
+			hdOpts = append(hdOpts, opts.ValuePrefetcher(valuePrefetcher))
+		}
 		if options.DisableProvGC || i > 0 {
 			// the first head GCs, if it's enabled
 			hdOpts = append(hdOpts, opts.DisableProvGC())
@@ -208,6 +391,36 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 		if !options.DisablePrefetch {
 			hdOpts = append(hdOpts, opts.ProvidersFinder(providersFinder))
 		}
+		if revalidator != nil {
+			hdOpts = append(hdOpts, opts.Revalidator(revalidator))
+		}
+		if options.PrefetchRecordTTL > 0 {
+			hdOpts = append(hdOpts, opts.PrefetchedRecordTTL(options.PrefetchRecordTTL))
+		}
+		if len(prefetchSources) > 0 {
+			hdOpts = append(hdOpts, opts.PrefetchSources(options.PrefetchRouterStrategy, prefetchSources...))
+		}
+		if dl != nil {
+			hdOpts = append(hdOpts, opts.Denylist(dl))
+		}
+		if peerLimiter != nil {
+			hdOpts = append(hdOpts, opts.PeerLimiter(peerLimiter))
+		}
+		if keyspaceFilter != nil {
+			hdOpts = append(hdOpts, opts.KeyspaceFilter(keyspaceFilter))
+		}
+		if addrResolver != nil {
+			hdOpts = append(hdOpts, opts.AddrResolver(addrResolver, options.AddrResolutionWait))
+		}
+		if options.ProviderAddrFilter != "" {
+			hdOpts = append(hdOpts, opts.AddrFilter(options.ProviderAddrFilter))
+		}
+		if shards != nil {
+			hdOpts = append(hdOpts, opts.Shards(shards))
+		}
+		if replicator != nil {
+			hdOpts = append(hdOpts, opts.Replicator(replicator))
+		}
 		if options.PeerstorePath != "" {
 			pstoreDs, err := leveldb.NewDatastore(fmt.Sprintf("%s/head-%d", options.PeerstorePath, i), nil)
 			if err != nil {
@@ -260,79 +473,93 @@ func NewHydra(ctx context.Context, options Options) (*Hydra, error) {
 	hydra := Hydra{
 		Heads:           hds,
 		SharedDatastore: ds,
+		Values:          valueStore,
+		Denylist:        dl,
+		PeerLimiter:     peerLimiter,
+		KeyspaceFilter:  keyspaceFilter,
+		Shards:          shards,
+		Replicator:      replicator,
 		hyperLock:       &hyperLock,
 		hyperlog:        hyperlog,
 	}
+	if !options.DisablePrefetch {
+		hydra.PrefetchNegativeCache = negativeCache
+	}
 
 	tasks := []periodictasks.PeriodicTask{
 		metricstasks.NewRoutingTableSizeTask(hydra.GetRoutingTableSize, routingTableSizeTaskInterval),
 		metricstasks.NewUniquePeersTask(hydra.GetUniquePeersCount, uniquePeersTaskInterval),
 	}
+	if dl != nil {
+		tasks = append(tasks, denylist.NewReloadTask(dl, denylistReloadInterval))
+	}
 
 	periodictasks.RunTasks(ctx, tasks)
 
 	return &hydra, nil
 }
 
-func newProviderStoreBuilder(ctx context.Context, httpClient *http.Client, options Options) (opts.ProviderStoreBuilderFunc, error) {
-	if options.ProviderStore == "none" {
-		return func(opts opts.Options, host host.Host) (providers.ProviderStore, error) {
-			return &hproviders.NoopProviderStore{}, nil
-		}, nil
-	}
-	if strings.HasPrefix(options.ProviderStore, "https://") {
-		return func(opts opts.Options, host host.Host) (providers.ProviderStore, error) {
-			fmt.Printf("Using HTTP provider store\n")
-			return hproviders.NewHTTPProviderStore(httpClient, options.ProviderStore)
-		}, nil
-	}
-	if strings.HasPrefix(options.ProviderStore, "dynamodb://") {
-		// dynamodb,table=<table>,ttl=<ttl>,queryLimit=<queryLimit>
-		ddbOpts, err := utils.ParseOptsString(strings.TrimPrefix(options.ProviderStore, "dynamodb://"))
-		if err != nil {
-			return nil, fmt.Errorf("parsing DynamoDB config string: %w", err)
-		}
-		table := ddbOpts["table"]
-		if table == "" {
-			return nil, errors.New("DynamoDB table must be specified")
-		}
-		ttlStr := ddbOpts["ttl"]
-		if ttlStr == "" {
-			return nil, errors.New("DynamoDB TTL must be specified")
-		}
-		ttl, err := time.ParseDuration(ttlStr)
-		if err != nil {
-			return nil, fmt.Errorf("parsing DynamoDB TTL: %w", err)
-		}
-
-		queryLimitStr := ddbOpts["queryLimit"]
-		if queryLimitStr == "" {
-			return nil, errors.New("DynamoDB query limit must be specified")
-		}
-		queryLimit64, err := strconv.ParseInt(queryLimitStr, 10, 32)
-		if err != nil {
-			return nil, fmt.Errorf("parsing DynamoDB query limit: %w", err)
-		}
-		queryLimit := int32(queryLimit64)
-
-		fmt.Fprintf(os.Stderr, "🥞 Using DynamoDB providerstore with table=%s, ttl=%s, queryLimit=%d\n", table, ttl, queryLimit)
-		awsCfg, err := config.LoadDefaultConfig(ctx,
-			config.WithRetryer(func() aws.Retryer {
-				return retry.NewStandard(func(so *retry.StandardOptions) { so.MaxAttempts = 1 })
-			}))
+// OpenDatastore opens the datastore at the given path, a LevelDB directory, a postgresql:// connection URI or dynamodb://table=<table>.
+func OpenDatastore(ctx context.Context, path string, createDB bool) (datastore.Batching, error) {
+	var ds datastore.Batching
+	var err error
+	if strings.HasPrefix(path, "postgresql://") {
+		fmt.Fprintf(os.Stderr, "🐘 Using PostgreSQL datastore\n")
+		ds, err = hyds.NewPostgreSQLDatastore(ctx, path, createDB)
+	} else if strings.HasPrefix(path, "dynamodb://") {
+		optsStr := strings.TrimPrefix(path, "dynamodb://")
+		table, err := parseDDBTable(optsStr)
 		if err != nil {
-			return nil, fmt.Errorf("loading AWS config: %w", err)
+			return nil, err
 		}
-		awsCfg.APIOptions = append(awsCfg.APIOptions, metrics.AddAWSSDKMiddleware)
+		fmt.Fprintf(os.Stderr, "Using DynamoDB datastore with table '%s'\n", table)
+		ddbClient := ddbv1.New(session.Must(session.NewSession()))
+		ds = ddbds.New(ddbClient, table, ddbds.WithScanParallelism(5))
+	} else {
+		fmt.Fprintf(os.Stderr, "🥞 Using LevelDB datastore\n")
+		ds, err = leveldb.NewDatastore(path, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create datastore: %w", err)
+	}
+	return ds, nil
+}
 
-		// reuse the client across all the heads
-		ddbClient := dynamodb.NewFromConfig(awsCfg)
+// openValueStore opens the store of the value records: either the shared datastore, given as "", "datastore" or
+// "datastore://", or another datastore given as "datastore://<path>", where the path is given like the -db option.
+func openValueStore(ctx context.Context, shared datastore.Batching, uri string, createDB bool) (*values.Store, error) {
+	if uri == "" || uri == "datastore" || uri == "datastore://" {
+		return values.NewStore(shared, true), nil
+	}
+	if !strings.HasPrefix(uri, "datastore://") {
+		return nil, fmt.Errorf("unknown value store %q", uri)
+	}
+	ds, err := OpenDatastore(ctx, strings.TrimPrefix(uri, "datastore://"), createDB)
+	if err != nil {
+		return nil, fmt.Errorf("opening value store: %w", err)
+	}
+	return values.NewStore(ds, false), nil
+}
 
-		return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
-			return hproviders.NewDynamoDBProviderStore(h.ID(), h.Peerstore(), ddbClient, table, ttl, queryLimit), nil
-		}, nil
+// newValuePrefetcher creates the prefetcher of the IPNS records missing from the value store, shared by all the heads.
+func newValuePrefetcher(ctx context.Context, options Options) (*values.Prefetcher, error) {
+	timeout := options.ValuePrefetchTimeout
+	if timeout == 0 {
+		timeout = values.DefaultPrefetchTimeout
+	}
+	workers := options.ValuePrefetchWorkers
+	if workers == 0 {
+		workers = values.DefaultPrefetchWorkers
+	}
+	if timeout < 0 || workers < 0 {
+		return nil, errors.New("the value prefetch timeout and workers must be positive")
+	}
+	negativeCache, err := hproviders.NewNegativeCache(hproviders.DefaultPrefetchNegativeCacheSize, values.DefaultPrefetchNegativeCacheTTL)
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	fmt.Fprintf(os.Stderr, "🔭 Prefetching missing IPNS records with timeout=%s, workers=%d\n", timeout, workers)
+	return values.NewPrefetcher(ctx, timeout, values.DefaultPrefetchQueueSize, workers, negativeCache), nil
 }
 
 func handleBootstrapStatus(ctx context.Context, ch chan head.BootstrapStatus) {
@@ -0,0 +1,118 @@
+package hydra
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	hproviders "github.com/libp2p/hydra-booster/providers"
+)
+
+// newPrefetchSources parses the content routers to prefetch providers from. Each is either "dht" for the DHT of the head,
+// a delegated routing "https://" endpoint, or "hydra://<host>:<port>" for the HTTP API of another Hydra.
+func newPrefetchSources(httpClient *http.Client, specs []string) ([]hproviders.PrefetchSource, error) {
+	var sources []hproviders.PrefetchSource
+	for _, spec := range specs {
+		if spec == "dht" {
+			// the router is filled in by each head
+			sources = append(sources, hproviders.PrefetchSource{Name: "dht"})
+			continue
+		}
+
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid prefetch router %q, expected \"dht\", \"https://<endpoint>\" or \"hydra://<host>:<port>\"", spec)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			router, err := hproviders.NewHTTPContentRouter(httpClient, spec)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, hproviders.PrefetchSource{Name: u.Host, Router: router})
+		case "hydra":
+			router, err := hproviders.NewHydraContentRouter(httpClient, "http://"+strings.TrimPrefix(spec, "hydra://"))
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, hproviders.PrefetchSource{Name: "hydra-" + u.Host, Router: router})
+		default:
+			return nil, fmt.Errorf("invalid prefetch router %q, expected \"dht\", \"https://<endpoint>\" or \"hydra://<host>:<port>\"", spec)
+		}
+	}
+	return sources, nil
+}
+
+// newPrefetchAdmission creates the admission policies of the prefetches, checking the request frequency before the rate,
+// so that rejected prefetches don't use up the rate.
+func newPrefetchAdmission(options Options) (hproviders.AdmissionPolicies, error) {
+	var policies hproviders.AdmissionPolicies
+	if options.DisablePrefetch {
+		return policies, nil
+	}
+	if options.PrefetchMinRequests > 1 {
+		a, err := hproviders.NewMinRequestsAdmission(options.PrefetchMinRequests, options.PrefetchMinRequestsWindow, hproviders.DefaultAdmissionSketchWidth)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, a)
+		fmt.Fprintf(os.Stderr, "🎫 Prefetching keys requested at least %d times within %s\n", options.PrefetchMinRequests, options.PrefetchMinRequestsWindow)
+	}
+	if options.PrefetchRate > 0 {
+		a, err := hproviders.NewRateAdmission(options.PrefetchRate, int(math.Ceil(options.PrefetchRate)))
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, a)
+		fmt.Fprintf(os.Stderr, "🎫 Prefetching at most %g keys/s\n", options.PrefetchRate)
+	}
+	return policies, nil
+}
+
+// newPrefetchConfig returns the options with the default prefetch timeout, queue size, negative cache TTL and size, and number of workers
+// for the ones that are not set. Negative values are rejected.
+func newPrefetchConfig(options Options) (Options, error) {
+	for _, o := range []struct {
+		name     string
+		negative bool
+	}{
+		{"prefetch timeout", options.PrefetchTimeout < 0},
+		{"prefetch queue size", options.PrefetchQueueSize < 0},
+		{"prefetch negative cache TTL", options.PrefetchNegativeCacheTTL < 0},
+		{"prefetch negative cache size", options.PrefetchNegativeCacheSize < 0},
+		{"minimum prefetch workers", options.PrefetchMinWorkers < 0},
+		{"maximum prefetch workers", options.PrefetchMaxWorkers < 0},
+	} {
+		if o.negative {
+			return options, fmt.Errorf("the %s must not be negative", o.name)
+		}
+	}
+	if options.PrefetchTimeout == 0 {
+		options.PrefetchTimeout = hproviders.DefaultPrefetchTimeout
+	}
+	if options.PrefetchQueueSize == 0 {
+		options.PrefetchQueueSize = hproviders.DefaultPrefetchQueueSize
+	}
+	if options.PrefetchNegativeCacheTTL == 0 {
+		options.PrefetchNegativeCacheTTL = hproviders.DefaultPrefetchNegativeCacheTTL
+	}
+	if options.PrefetchNegativeCacheSize == 0 {
+		options.PrefetchNegativeCacheSize = hproviders.DefaultPrefetchNegativeCacheSize
+	}
+	if options.PrefetchMaxWorkers == 0 {
+		options.PrefetchMaxWorkers = hproviders.DefaultPrefetchMaxWorkers
+	}
+	if options.PrefetchMinWorkers == 0 {
+		// the default minimum is lowered to a smaller maximum, only an explicit minimum above it is an error
+		options.PrefetchMinWorkers = hproviders.DefaultPrefetchMinWorkers
+		if options.PrefetchMinWorkers > options.PrefetchMaxWorkers {
+			options.PrefetchMinWorkers = options.PrefetchMaxWorkers
+		}
+	} else if options.PrefetchMinWorkers > options.PrefetchMaxWorkers {
+		return options, fmt.Errorf("the minimum number of prefetch workers must be at most the maximum %d, got %d", options.PrefetchMaxWorkers, options.PrefetchMinWorkers)
+	}
+	return options, nil
+}
@@ -0,0 +1,81 @@
+package hydra
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPrefetchSources(t *testing.T) {
+	sources, err := newPrefetchSources(http.DefaultClient, []string{"dht", "https://cid.contact", "hydra://127.0.0.1:7779"})
+	assert.NoError(t, err)
+	if assert.Len(t, sources, 3) {
+		assert.Equal(t, "dht", sources[0].Name)
+		assert.Nil(t, sources[0].Router)
+		assert.Equal(t, "cid.contact", sources[1].Name)
+		assert.NotNil(t, sources[1].Router)
+		assert.Equal(t, "hydra-127.0.0.1:7779", sources[2].Name)
+		assert.NotNil(t, sources[2].Router)
+	}
+
+	for _, spec := range []string{"ipni", "ftp://example.com", "https://"} {
+		_, err := newPrefetchSources(http.DefaultClient, []string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNewPrefetchAdmission(t *testing.T) {
+	policies, err := newPrefetchAdmission(Options{PrefetchMinRequests: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+
+	policies, err = newPrefetchAdmission(Options{PrefetchMinRequests: 2, PrefetchMinRequestsWindow: time.Minute, PrefetchRate: 0.5})
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 2) {
+		assert.IsType(t, &hproviders.MinRequestsAdmission{}, policies[0])
+		assert.Equal(t, 1, policies[1].(*hproviders.RateAdmission).Burst)
+	}
+
+	policies, err = newPrefetchAdmission(Options{PrefetchMinRequests: 2, PrefetchRate: 10, DisablePrefetch: true})
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+
+	_, err = newPrefetchAdmission(Options{PrefetchMinRequests: 2})
+	assert.Error(t, err)
+}
+
+func TestNewPrefetchConfig(t *testing.T) {
+	config, err := newPrefetchConfig(Options{PrefetchQueueSize: 10, PrefetchMaxWorkers: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, hproviders.DefaultPrefetchTimeout, config.PrefetchTimeout)
+	assert.Equal(t, 10, config.PrefetchQueueSize)
+	assert.Equal(t, hproviders.DefaultPrefetchNegativeCacheTTL, config.PrefetchNegativeCacheTTL)
+	assert.Equal(t, hproviders.DefaultPrefetchNegativeCacheSize, config.PrefetchNegativeCacheSize)
+	assert.Equal(t, hproviders.DefaultPrefetchMinWorkers, config.PrefetchMinWorkers)
+	assert.Equal(t, 20, config.PrefetchMaxWorkers)
+
+	config, err = newPrefetchConfig(Options{PrefetchMaxWorkers: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, config.PrefetchMinWorkers)
+	assert.Equal(t, 4, config.PrefetchMaxWorkers)
+
+	config, err = newPrefetchConfig(Options{PrefetchMinWorkers: 2, PrefetchMaxWorkers: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.PrefetchMinWorkers)
+
+	for name, options := range map[string]Options{
+		"min workers above max":        {PrefetchMinWorkers: 20, PrefetchMaxWorkers: 10},
+		"negative min workers":         {PrefetchMinWorkers: -1},
+		"negative max workers":         {PrefetchMaxWorkers: -1},
+		"negative timeout":             {PrefetchTimeout: -time.Second},
+		"negative queue size":          {PrefetchQueueSize: -1},
+		"negative negative cache TTL":  {PrefetchNegativeCacheTTL: -time.Minute},
+		"negative negative cache size": {PrefetchNegativeCacheSize: -1},
+	} {
+		_, err := newPrefetchConfig(options)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,283 @@
+package hydra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/hydra-booster/head/opts"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+)
+
+// Provider stores are configured with an expression that is either a URI of a registered scheme,
+// e.g. "dynamodb://table=providers,ttl=24h,queryLimit=100", or a call of a registered wrapper, e.g.
+// "combine(strategy=first, dynamodb://..., readonly(https://...))". The arguments of a wrapper are
+// key=value parameters followed by provider store expressions.
+
+// ProviderStoreEnv holds the hydra-wide resources available to provider store parsers.
+type ProviderStoreEnv struct {
+	HTTPClient *http.Client
+	Options    Options
+}
+
+// ProviderStoreNode is a parsed provider store expression.
+type ProviderStoreNode struct {
+	// Name identifies the provider store in metrics.
+	Name    string
+	Builder opts.ProviderStoreBuilderFunc
+	// URI is the URI of a provider store of a registered scheme, and is empty for wrappers.
+	URI string
+}
+
+// ProviderStoreSchemeParser parses a provider store URI, such as "dynamodb://table=providers,ttl=24h,queryLimit=100".
+type ProviderStoreSchemeParser func(ctx context.Context, env ProviderStoreEnv, uri string) (opts.ProviderStoreBuilderFunc, error)
+
+// ProviderStoreWrapperParser builds a provider store from its parameters and the provider stores it wraps.
+// It returns the name of the resulting provider store alongside its builder.
+type ProviderStoreWrapperParser func(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error)
+
+var (
+	providerStoreSchemes  = map[string]ProviderStoreSchemeParser{}
+	providerStoreWrappers = map[string]ProviderStoreWrapperParser{}
+)
+
+// RegisterProviderStoreScheme registers a parser for provider store URIs with the given scheme.
+// A URI of the scheme may also be given without "://" if it has no other content, e.g. "none".
+// Registration is not thread safe and should be done from an init function.
+func RegisterProviderStoreScheme(scheme string, parser ProviderStoreSchemeParser) {
+	if _, ok := providerStoreSchemes[scheme]; ok {
+		panic(fmt.Sprintf("provider store scheme %q registered twice", scheme))
+	}
+	providerStoreSchemes[scheme] = parser
+}
+
+// RegisterProviderStoreWrapper registers a parser for provider store wrapper calls with the given name.
+// Registration is not thread safe and should be done from an init function.
+func RegisterProviderStoreWrapper(name string, parser ProviderStoreWrapperParser) {
+	if _, ok := providerStoreWrappers[name]; ok {
+		panic(fmt.Sprintf("provider store wrapper %q registered twice", name))
+	}
+	providerStoreWrappers[name] = parser
+}
+
+// newProviderStoreBuilder builds the provider store builder configured by the options.
+// It returns a nil builder if the default provider store should be used.
+func newProviderStoreBuilder(ctx context.Context, httpClient *http.Client, options Options) (opts.ProviderStoreBuilderFunc, error) {
+	if options.ProviderStore == "" {
+		if options.ProviderStoreCacheSize > 0 {
+			return nil, errors.New("a provider store cache can only be used with a non-default provider store")
+		}
+		return nil, nil
+	}
+
+	env := ProviderStoreEnv{HTTPClient: httpClient, Options: options}
+	node, err := parseProviderStore(ctx, env, options.ProviderStore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider store %q: %w", options.ProviderStore, err)
+	}
+	if options.ProviderStoreCacheSize <= 0 {
+		return node.Builder, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "🔥 Using in-memory provider cache with size=%d, ttl=%s, mode=%s\n", options.ProviderStoreCacheSize, options.ProviderStoreCacheTTL, options.ProviderStoreCacheMode)
+	return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+		ps, err := node.Builder(opts, h)
+		if err != nil {
+			return nil, err
+		}
+		return hproviders.NewTieredProviderStore(ctx, ps, node.Name, options.ProviderStoreCacheSize, options.ProviderStoreCacheTTL, options.ProviderStoreCacheMode)
+	}, nil
+}
+
+// NewProviderStore builds the provider store configured by the options for the given host, for use outside of a head.
+// It returns a nil provider store if the default provider store is configured.
+func NewProviderStore(ctx context.Context, options Options, h host.Host) (providers.ProviderStore, error) {
+	builder, err := newProviderStoreBuilder(ctx, &http.Client{Timeout: options.DelegateTimeout}, options)
+	if err != nil || builder == nil {
+		return nil, err
+	}
+	return builder(opts.Options{}, h)
+}
+
+// parseProviderStore parses a provider store expression into a tree of provider store builders.
+func parseProviderStore(ctx context.Context, env ProviderStoreEnv, expr string) (ProviderStoreNode, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return ProviderStoreNode{}, errors.New("empty provider store")
+	}
+
+	schemeEnd := strings.Index(expr, "://")
+	callStart := strings.Index(expr, "(")
+	if callStart > 0 && (schemeEnd < 0 || callStart < schemeEnd) {
+		name := strings.TrimSpace(expr[:callStart])
+		parser, ok := providerStoreWrappers[name]
+		if !ok {
+			return ProviderStoreNode{}, fmt.Errorf("unknown provider store wrapper %q, expected one of %s", name, knownProviderStores())
+		}
+		args, err := parseProviderStoreCall(expr, name)
+		if err != nil {
+			return ProviderStoreNode{}, err
+		}
+		specs, params, err := splitProviderStoreParams(args)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("%s: %w", name, err)
+		}
+		children := make([]ProviderStoreNode, len(specs))
+		for i, spec := range specs {
+			children[i], err = parseProviderStore(ctx, env, spec)
+			if err != nil {
+				return ProviderStoreNode{}, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		node, err := parser(ctx, env, params, children)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("%s: %w", name, err)
+		}
+		// wrappers passing on the name of a child, such as readonly, are already instrumented by the child
+		for _, child := range children {
+			if child.Name == node.Name {
+				return node, nil
+			}
+		}
+		node.Builder = instrumentProviderStore(node.Name, node.Builder)
+		return node, nil
+	}
+
+	scheme := expr
+	if schemeEnd >= 0 {
+		scheme = expr[:schemeEnd]
+	}
+	parser, ok := providerStoreSchemes[scheme]
+	if !ok {
+		return ProviderStoreNode{}, fmt.Errorf("unknown provider store %q, expected one of %s", expr, knownProviderStores())
+	}
+	builder, err := parser(ctx, env, expr)
+	if err != nil {
+		return ProviderStoreNode{}, fmt.Errorf("%s: %w", scheme, err)
+	}
+	return ProviderStoreNode{Name: scheme, Builder: instrumentProviderStore(scheme, builder), URI: expr}, nil
+}
+
+// instrumentProviderStore wraps the provider stores built by the builder in an InstrumentedProviderStore,
+// so that metrics are recorded for each backend of each head.
+func instrumentProviderStore(backend string, builder opts.ProviderStoreBuilderFunc) opts.ProviderStoreBuilderFunc {
+	return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+		ps, err := builder(opts, h)
+		if err != nil {
+			return nil, err
+		}
+		return hproviders.NewInstrumentedProviderStore(ps, backend, h.ID()), nil
+	}
+}
+
+// knownProviderStores lists the registered schemes and wrappers, for use in error messages.
+func knownProviderStores() string {
+	var known []string
+	for scheme := range providerStoreSchemes {
+		known = append(known, scheme+"://")
+	}
+	for name := range providerStoreWrappers {
+		known = append(known, name+"(...)")
+	}
+	sort.Strings(known)
+	return strings.Join(known, ", ")
+}
+
+// parseProviderStoreCall parses a provider store spec of the form "<name>(<arg>, <arg>, ...)" and returns its arguments.
+func parseProviderStoreCall(spec string, name string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.HasPrefix(spec, name+"(") || !strings.HasSuffix(spec, ")") {
+		return nil, fmt.Errorf("expected %q to be of the form %s(...)", spec, name)
+	}
+	return splitProviderStoreArgs(spec[len(name)+1 : len(spec)-1])
+}
+
+// splitProviderStoreArgs splits a comma separated list of arguments, ignoring commas inside parentheses.
+func splitProviderStoreArgs(s string) ([]string, error) {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in %q", s)
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(args) > 0 {
+		args = append(args, last)
+	}
+	return args, nil
+}
+
+// splitProviderStoreParams separates key=value parameters from provider store specs in a list of arguments.
+// Parameters must come before the provider stores, since key=value arguments following a URI are part of the URI
+// (e.g. "dynamodb://table=<table>,ttl=<ttl>,queryLimit=<queryLimit>").
+func splitProviderStoreParams(args []string) ([]string, map[string]string, error) {
+	var specs []string
+	params := map[string]string{}
+	for _, arg := range args {
+		isParam := strings.Contains(arg, "=") && !strings.Contains(arg, "://") && !strings.Contains(arg, "(")
+		if !isParam {
+			specs = append(specs, arg)
+			continue
+		}
+		if len(specs) == 0 {
+			kv := strings.SplitN(arg, "=", 2)
+			params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			continue
+		}
+		last := specs[len(specs)-1]
+		if !strings.Contains(last, "://") {
+			return nil, nil, fmt.Errorf("parameter %q must come before the provider stores", arg)
+		}
+		specs[len(specs)-1] = last + "," + arg
+	}
+	return specs, params, nil
+}
+
+// checkProviderStoreParams returns an error if there are parameters other than the allowed ones.
+func checkProviderStoreParams(params map[string]string, allowed ...string) error {
+	for k := range params {
+		found := false
+		for _, a := range allowed {
+			if k == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown parameter %q, expected one of %s", k, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// checkProviderStoreChildren returns an error if the number of wrapped provider stores is not within [min, max].
+// A negative max means there is no upper bound.
+func checkProviderStoreChildren(children []ProviderStoreNode, min, max int) error {
+	if len(children) < min {
+		return fmt.Errorf("expected at least %d provider store(s), got %d", min, len(children))
+	}
+	if max >= 0 && len(children) > max {
+		return fmt.Errorf("expected at most %d provider store(s), got %d", max, len(children))
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package hydra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/hydra-booster/head"
+	"github.com/libp2p/hydra-booster/head/opts"
+	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/libp2p/hydra-booster/utils"
+)
+
+func init() {
+	RegisterProviderStoreScheme("none", parseNoopProviderStore)
+	RegisterProviderStoreScheme("https", parseHTTPProviderStore)
+	RegisterProviderStoreScheme("dynamodb", parseDynamoDBProviderStore)
+	RegisterProviderStoreScheme("datastore", parseDatastoreProviderStore)
+
+	RegisterProviderStoreWrapper("combine", parseCombinedProviderStore)
+	RegisterProviderStoreWrapper("readonly", parseReadOnlyProviderStore)
+	RegisterProviderStoreWrapper("cache", parseTieredProviderStore)
+	RegisterProviderStoreWrapper("migrate", parseMigratingProviderStore)
+	RegisterProviderStoreWrapper("endpoints", parseHTTPEndpointsProviderStore)
+}
+
+// "none" or "none://"
+func parseNoopProviderStore(ctx context.Context, env ProviderStoreEnv, uri string) (opts.ProviderStoreBuilderFunc, error) {
+	if uri != "none" && uri != "none://" {
+		return nil, errors.New("does not take any options")
+	}
+	return func(opts opts.Options, host host.Host) (providers.ProviderStore, error) {
+		return &hproviders.NoopProviderStore{}, nil
+	}, nil
+}
+
+// "https://<delegated-routing-endpoint>"
+func parseHTTPProviderStore(ctx context.Context, env ProviderStoreEnv, uri string) (opts.ProviderStoreBuilderFunc, error) {
+	if _, err := url.Parse(uri); err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	return newHTTPProviderStoreBuilder(ctx, env, []string{uri}, hproviders.DefaultHTTPEndpointPolicy()), nil
+}
+
+// newHTTPProviderStoreBuilder returns a builder of HTTP provider stores using the given endpoints.
+// Nothing is built until the builder is first called, since the "https://" provider stores wrapped by "endpoints(...)"
+// are only parsed for their URI.
+func newHTTPProviderStoreBuilder(ctx context.Context, env ProviderStoreEnv, uris []string, policy hproviders.HTTPEndpointPolicy) opts.ProviderStoreBuilderFunc {
+	endpoints := strings.Join(uris, ", ")
+	// the HTTP provider store holds no per-head state, so it is shared by all the heads
+	var once sync.Once
+	var ps providers.ProviderStore
+	var err error
+	return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+		once.Do(func() {
+			ps, err = hproviders.NewHTTPProviderStore(ctx, env.HTTPClient, uris, policy)
+			if err == nil {
+				fmt.Fprintf(os.Stderr, "🌐 Using HTTP provider store with endpoints=%s\n", endpoints)
+			}
+		})
+		return ps, err
+	}
+}
+
+// "endpoints(hedgeDelay=<duration>, failures=<int>, openDuration=<duration>, https://<delegated-routing-endpoint>, ...)"
+func parseHTTPEndpointsProviderStore(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error) {
+	if err := checkProviderStoreParams(params, "hedgeDelay", "failures", "openDuration"); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	if err := checkProviderStoreChildren(children, 1, -1); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	uris := make([]string, len(children))
+	for i, child := range children {
+		if child.Name != "https" {
+			return ProviderStoreNode{}, fmt.Errorf("expected \"https://\" endpoints, got %s", child.Name)
+		}
+		uris[i] = child.URI
+	}
+
+	policy := hproviders.DefaultHTTPEndpointPolicy()
+	var err error
+	if s, ok := params["hedgeDelay"]; ok {
+		policy.HedgeDelay, err = time.ParseDuration(s)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("parsing hedge delay: %w", err)
+		}
+	}
+	if s, ok := params["failures"]; ok {
+		policy.Breaker.FailureThreshold, err = strconv.Atoi(s)
+		if err != nil || policy.Breaker.FailureThreshold < 1 {
+			return ProviderStoreNode{}, fmt.Errorf("failures must be a positive integer, got %q", s)
+		}
+	}
+	if s, ok := params["openDuration"]; ok {
+		policy.Breaker.OpenDuration, err = time.ParseDuration(s)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("parsing open duration: %w", err)
+		}
+	}
+
+	return ProviderStoreNode{
+		Name:    "endpoints",
+		Builder: newHTTPProviderStoreBuilder(ctx, env, uris, policy),
+	}, nil
+}
+
+// "dynamodb://table=<table>,ttl=<ttl>,queryLimit=<queryLimit>"
+func parseDynamoDBProviderStore(ctx context.Context, env ProviderStoreEnv, uri string) (opts.ProviderStoreBuilderFunc, error) {
+	ddbOpts, err := utils.ParseOptsString(strings.TrimPrefix(uri, "dynamodb://"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing DynamoDB config string: %w", err)
+	}
+	table := ddbOpts["table"]
+	if table == "" {
+		return nil, errors.New("DynamoDB table must be specified")
+	}
+	ttlStr := ddbOpts["ttl"]
+	if ttlStr == "" {
+		return nil, errors.New("DynamoDB TTL must be specified")
+	}
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DynamoDB TTL: %w", err)
+	}
+
+	queryLimitStr := ddbOpts["queryLimit"]
+	if queryLimitStr == "" {
+		return nil, errors.New("DynamoDB query limit must be specified")
+	}
+	queryLimit64, err := strconv.ParseInt(queryLimitStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DynamoDB query limit: %w", err)
+	}
+	queryLimit := int32(queryLimit64)
+
+	fmt.Fprintf(os.Stderr, "🥞 Using DynamoDB providerstore with table=%s, ttl=%s, queryLimit=%d\n", table, ttl, queryLimit)
+	awsCfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(so *retry.StandardOptions) { so.MaxAttempts = 1 })
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	awsCfg.APIOptions = append(awsCfg.APIOptions, metrics.AddAWSSDKMiddleware)
+
+	// reuse the client across all the heads
+	ddbClient := dynamodb.NewFromConfig(awsCfg)
+
+	return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+		return hproviders.NewDynamoDBProviderStore(h.ID(), h.Peerstore(), ddbClient, table, ttl, queryLimit), nil
+	}, nil
+}
+
+// "datastore" or "datastore://<datastore path>"
+func parseDatastoreProviderStore(ctx context.Context, env ProviderStoreEnv, uri string) (opts.ProviderStoreBuilderFunc, error) {
+	if uri == "datastore" || uri == "datastore://" {
+		// the default provider store, in the datastore of the head
+		return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+			if opts.Datastore == nil {
+				return nil, errors.New("no datastore configured")
+			}
+			return head.NewDefaultProviderStore(ctx, opts, h)
+		}, nil
+	}
+
+	// another datastore, shared by all the heads like the datastore of the Hydra
+	ds, err := OpenDatastore(ctx, strings.TrimPrefix(uri, "datastore://"), !env.Options.DisableDBCreate)
+	if err != nil {
+		return nil, fmt.Errorf("opening datastore: %w", err)
+	}
+	return func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+		opts.Datastore = ds
+		return head.NewDefaultProviderStore(ctx, opts, h)
+	}, nil
+}
+
+// "combine(strategy=<all|first|hedged|fallback>, hedgeDelay=<duration>, timeout=<duration>, <provider store>, ...)"
+func parseCombinedProviderStore(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error) {
+	if err := checkProviderStoreParams(params, "strategy", "hedgeDelay", "timeout"); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	if err := checkProviderStoreChildren(children, 1, -1); err != nil {
+		return ProviderStoreNode{}, err
+	}
+
+	strategy, err := hproviders.ParseCombineStrategy(params["strategy"])
+	if err != nil {
+		return ProviderStoreNode{}, err
+	}
+	var hedgeDelay, timeout time.Duration
+	if s, ok := params["hedgeDelay"]; ok {
+		hedgeDelay, err = time.ParseDuration(s)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("parsing hedge delay: %w", err)
+		}
+	}
+	if strategy == hproviders.CombineHedged && hedgeDelay <= 0 {
+		return ProviderStoreNode{}, errors.New("the hedged strategy requires a positive hedgeDelay")
+	}
+	if s, ok := params["timeout"]; ok {
+		timeout, err = time.ParseDuration(s)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("parsing timeout: %w", err)
+		}
+	}
+
+	// disambiguate the names of backends of the same type, so their metrics can be told apart
+	names := make([]string, len(children))
+	nameCounts := map[string]int{}
+	for _, child := range children {
+		nameCounts[child.Name]++
+	}
+	for i, child := range children {
+		names[i] = child.Name
+		if nameCounts[child.Name] > 1 {
+			names[i] = fmt.Sprintf("%s-%d", child.Name, i)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "🔀 Combining %d provider stores with strategy=%s\n", len(children), strategy)
+	return ProviderStoreNode{
+		Name: "combine",
+		Builder: func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+			backends := make([]hproviders.CombinedBackend, len(children))
+			for i, child := range children {
+				ps, err := child.Builder(opts, h)
+				if err != nil {
+					return nil, err
+				}
+				backends[i] = hproviders.CombinedBackend{Name: names[i], Store: ps, Timeout: timeout}
+			}
+			ps, err := hproviders.NewCombinedProviderStore(strategy, hedgeDelay, backends...)
+			if err != nil {
+				return nil, err
+			}
+			return ps, nil
+		},
+	}, nil
+}
+
+// "readonly(<provider store>)"
+func parseReadOnlyProviderStore(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error) {
+	if err := checkProviderStoreParams(params); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	if err := checkProviderStoreChildren(children, 1, 1); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	child := children[0]
+	return ProviderStoreNode{
+		Name: child.Name,
+		Builder: func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+			ps, err := child.Builder(opts, h)
+			if err != nil {
+				return nil, err
+			}
+			return hproviders.AddProviderNotSupported(ps), nil
+		},
+	}, nil
+}
+
+// "cache(size=<int>, ttl=<duration>, mode=<through|behind>, <provider store>)"
+func parseTieredProviderStore(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error) {
+	if err := checkProviderStoreParams(params, "size", "ttl", "mode"); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	if err := checkProviderStoreChildren(children, 1, 1); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	size, err := strconv.Atoi(params["size"])
+	if err != nil || size <= 0 {
+		return ProviderStoreNode{}, fmt.Errorf("size must be a positive integer, got %q", params["size"])
+	}
+	ttl, err := time.ParseDuration(params["ttl"])
+	if err != nil {
+		return ProviderStoreNode{}, fmt.Errorf("parsing ttl: %w", err)
+	}
+	mode, err := hproviders.ParseWriteMode(params["mode"])
+	if err != nil {
+		return ProviderStoreNode{}, err
+	}
+	child := children[0]
+	return ProviderStoreNode{
+		Name: child.Name,
+		Builder: func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+			ps, err := child.Builder(opts, h)
+			if err != nil {
+				return nil, err
+			}
+			return hproviders.NewTieredProviderStore(ctx, ps, child.Name, size, ttl, mode)
+		},
+	}, nil
+}
+
+// "migrate(switchReads=<bool>, <source provider store>, <target provider store>)"
+func parseMigratingProviderStore(ctx context.Context, env ProviderStoreEnv, params map[string]string, children []ProviderStoreNode) (ProviderStoreNode, error) {
+	if err := checkProviderStoreParams(params, "switchReads"); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	if err := checkProviderStoreChildren(children, 2, 2); err != nil {
+		return ProviderStoreNode{}, err
+	}
+	switchReads := false
+	if s, ok := params["switchReads"]; ok {
+		var err error
+		switchReads, err = strconv.ParseBool(s)
+		if err != nil {
+			return ProviderStoreNode{}, fmt.Errorf("parsing switchReads: %w", err)
+		}
+	}
+	source, target := children[0], children[1]
+
+	fmt.Fprintf(os.Stderr, "🚚 Migrating provider records from %s to %s with switchReads=%t\n", source.Name, target.Name, switchReads)
+	// the migration is shared by all the heads, so that the records are backfilled once
+	migration := hproviders.NewMigration(ctx, switchReads)
+	return ProviderStoreNode{
+		Name: "migrate",
+		Builder: func(opts opts.Options, h host.Host) (providers.ProviderStore, error) {
+			sourcePS, err := source.Builder(opts, h)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := hproviders.FindProviderRecordIterator(sourcePS); !ok {
+				return nil, fmt.Errorf("the source provider store %s cannot enumerate its provider records", source.Name)
+			}
+			targetPS, err := target.Builder(opts, h)
+			if err != nil {
+				return nil, err
+			}
+			return hproviders.NewMigratingProviderStore(ctx, migration, sourcePS, targetPS), nil
+		},
+	}, nil
+}
@@ -0,0 +1,108 @@
+package hydra
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitProviderStoreArgs(t *testing.T) {
+	cases := []struct {
+		name    string
+		args    string
+		expArgs []string
+		expErr  bool
+	}{
+		{name: "empty", args: "", expArgs: nil},
+		{name: "single", args: "none", expArgs: []string{"none"}},
+		{name: "multiple", args: "none, https://example.com", expArgs: []string{"none", "https://example.com"}},
+		{name: "nested", args: "combine(none, none), none", expArgs: []string{"combine(none, none)", "none"}},
+		{name: "unbalanced", args: "combine(none, none", expErr: true},
+		{name: "unbalanced close", args: "none), none", expErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := splitProviderStoreArgs(c.args)
+			if c.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expArgs, args)
+		})
+	}
+}
+
+func TestSplitProviderStoreParams(t *testing.T) {
+	specs, params, err := splitProviderStoreParams([]string{
+		"strategy=first",
+		"timeout=1s",
+		"dynamodb://table=t",
+		"ttl=24h",
+		"queryLimit=10",
+		"combine(none, none)",
+		"https://example.com",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dynamodb://table=t,ttl=24h,queryLimit=10", "combine(none, none)", "https://example.com"}, specs)
+	assert.Equal(t, map[string]string{"strategy": "first", "timeout": "1s"}, params)
+
+	_, _, err = splitProviderStoreParams([]string{"none", "strategy=first"})
+	assert.Error(t, err)
+}
+
+func TestNewProviderStoreBuilder(t *testing.T) {
+	cases := []struct {
+		name   string
+		spec   string
+		expErr string
+	}{
+		{name: "none", spec: "none"},
+		{name: "https", spec: "https://example.com"},
+		{name: "combine", spec: "combine(none, https://example.com)"},
+		{name: "combine with options", spec: "combine(strategy=hedged, hedgeDelay=50ms, timeout=1s, none, https://example.com)"},
+		{name: "nested wrappers", spec: "combine(strategy=fallback, cache(size=10, ttl=1m, none), readonly(https://example.com))"},
+		{name: "endpoints", spec: "endpoints(hedgeDelay=50ms, failures=3, openDuration=10s, https://a.example.com, https://b.example.com)"},
+		{name: "endpoints of another provider store", spec: "endpoints(https://example.com, none)", expErr: `expected "https://" endpoints, got none`},
+		{name: "invalid failures", spec: "endpoints(failures=0, https://example.com)", expErr: "failures must be a positive integer"},
+		{name: "migrate", spec: "migrate(switchReads=true, datastore, dynamodb://table=providers,ttl=24h,queryLimit=10)"},
+		{name: "migrate without target", spec: "migrate(datastore)", expErr: "migrate: expected at least 2 provider store(s), got 1"},
+		{name: "invalid switchReads", spec: "migrate(switchReads=maybe, datastore, none)", expErr: "parsing switchReads"},
+		{name: "invalid datastore options", spec: "datastore?", expErr: `unknown provider store "datastore?"`},
+		{name: "unknown scheme", spec: "foo://bar", expErr: `unknown provider store "foo://bar"`},
+		{name: "unknown wrapper", spec: "foo(none)", expErr: `unknown provider store wrapper "foo"`},
+		{name: "unknown nested provider store", spec: "combine(none, foo)", expErr: `combine: unknown provider store "foo"`},
+		{name: "unknown parameter", spec: "readonly(foo=bar, none)", expErr: `readonly: unknown parameter "foo"`},
+		{name: "parameter after provider store", spec: "combine(none, strategy=first)", expErr: `parameter "strategy=first" must come before the provider stores`},
+		{name: "no provider stores", spec: "combine(strategy=first)", expErr: "combine: expected at least 1 provider store(s), got 0"},
+		{name: "too many provider stores", spec: "readonly(none, none)", expErr: "readonly: expected at most 1 provider store(s), got 2"},
+		{name: "unknown strategy", spec: "combine(strategy=random, none)", expErr: `unknown combine strategy "random"`},
+		{name: "hedged without delay", spec: "combine(strategy=hedged, none)", expErr: "the hedged strategy requires a positive hedgeDelay"},
+		{name: "hedged with zero delay", spec: "combine(strategy=hedged, hedgeDelay=0s, none)", expErr: "the hedged strategy requires a positive hedgeDelay"},
+		{name: "invalid cache size", spec: "cache(size=0, ttl=1m, none)", expErr: "size must be a positive integer"},
+		{name: "invalid dynamodb options", spec: "dynamodb://table=foo", expErr: "DynamoDB TTL must be specified"},
+		{name: "unbalanced parentheses", spec: "combine(none, readonly(none)", expErr: "unbalanced parentheses"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			builder, err := newProviderStoreBuilder(context.Background(), http.DefaultClient, Options{ProviderStore: c.spec})
+			if c.expErr != "" {
+				assert.ErrorContains(t, err, c.expErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, builder)
+		})
+	}
+}
+
+func TestNewProviderStoreBuilderDefault(t *testing.T) {
+	builder, err := newProviderStoreBuilder(context.Background(), http.DefaultClient, Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, builder)
+
+	_, err = newProviderStoreBuilder(context.Background(), http.DefaultClient, Options{ProviderStoreCacheSize: 10})
+	assert.Error(t, err)
+}
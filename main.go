@@ -21,29 +21,61 @@ import (
 	"github.com/libp2p/hydra-booster/hydra"
 	"github.com/libp2p/hydra-booster/idgen"
 	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
 	hyui "github.com/libp2p/hydra-booster/ui"
 	uiopts "github.com/libp2p/hydra-booster/ui/opts"
 	"github.com/libp2p/hydra-booster/utils"
+	"github.com/libp2p/hydra-booster/values"
 	"github.com/multiformats/go-multiaddr"
 )
 
 const (
-	defaultBucketSize         = 20
-	defaultMetricsAddr        = "127.0.0.1:9758"
-	defaultHTTPAPIAddr        = "127.0.0.1:7779"
-	defaultConnMgrHighWater   = 1800
-	defaultConnMgrLowWater    = 1200
-	defaultConnMgrGracePeriod = "60s"
+	defaultBucketSize          = 20
+	defaultMetricsAddr         = "127.0.0.1:9758"
+	defaultHTTPAPIAddr         = "127.0.0.1:7779"
+	defaultConnMgrHighWater    = 1800
+	defaultConnMgrLowWater     = 1200
+	defaultConnMgrGracePeriod  = "60s"
+	defaultProviderCacheTTL    = time.Minute
+	defaultProviderRateBurst   = 100
+	defaultPrefetchRefreshRate = 10
+	defaultPrefetchRecordTTL   = 24 * time.Hour
+	defaultPrefetchMinRequests = 1
+	defaultPrefetchWindow      = time.Minute
+	defaultAntiEntropyInterval = 10 * time.Minute
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "records" {
+		recordsMain(os.Args[2:])
+		return
+	}
+
 	start := time.Now()
 	nheads := flag.Int("nheads", -1, "Specify the number of Hydra heads to create.")
 	randomSeed := flag.String("random-seed", "", "Seed to use to generate IDs (useful if you want to have persistent IDs). Should be Base64 encoded and 256bits")
 	idOffset := flag.Int("id-offset", -1, "What offset in the sequence of keys generated from random-seed to start from")
 	dbpath := flag.String("db", "", "Datastore directory (for LevelDB store) or postgresql:// connection URI (for PostgreSQL store) or 'dynamodb://table=<string>'")
 	pstorePath := flag.String("pstore", "", "Peerstore directory for LevelDB store (defaults to in-memory store)")
-	providerStore := flag.String("provider-store", "", "A non-default provider store to use, either \"none\" or \"dynamodb://table=<string>,ttl=<ttl-in-seconds>,queryLimit=<int>\"")
+	providerStore := flag.String("provider-store", "", "A non-default provider store to use, either \"none\", \"https://<delegated-routing-endpoint>\", \"dynamodb://table=<string>,ttl=<ttl-in-seconds>,queryLimit=<int>\" or a provider store expression such as \"combine(<options>, <provider-store>, ...)\"")
+	providerStoreCacheSize := flag.Int("provider-store-cache-size", 0, "Number of keys to cache in memory in front of the provider store, 0 disables the cache (default 0).")
+	providerStoreCacheTTL := flag.Duration("provider-store-cache-ttl", defaultProviderCacheTTL, "Maximum time to serve providers from the in-memory provider store cache.")
+	providerStoreCacheMode := flag.String("provider-store-cache-mode", string(hproviders.WriteThrough), "How the provider store cache writes new provider records to the provider store, \"through\" or \"behind\".")
+	denylistContent := flag.String("denylist-content", "", "A CSV list of files of denied CIDs, in the \"badbits\" format, whose provider records are neither stored nor returned. Reloaded when changed.")
+	denylistPeers := flag.String("denylist-peers", "", "A CSV list of files of denied peer IDs, whose provider records are neither stored nor returned. Reloaded when changed.")
+	providerRateLimit := flag.Float64("provider-rate-limit", 0, "Number of provider records per second each peer can add, across all heads, once its burst is used up. 0 disables rate limiting (default 0).")
+	providerRateBurst := flag.Int("provider-rate-burst", defaultProviderRateBurst, "Number of provider records each peer can add at once when rate limited.")
+	providerAddrFilter := flag.String("provider-addr-filter", string(hproviders.AddrFilterPublic), "Which addresses of providers are stored and returned: \"public\", \"private\" to also keep private network addresses, or \"none\" to keep all the addresses.")
+	providerKeyspaceFilter := flag.String("provider-keyspace-filter", string(hproviders.KeyspaceFilterNone), "Whether provider records are only accepted for keys one of the heads is among the closest peers to: \"none\" accepts all keys, \"enforce\" drops the other records, and \"dry-run\" only counts them.")
+	resolveProviderAddrs := flag.Bool("resolve-provider-addrs", false, "Resolve the addresses of providers returned without addresses with the DHT, in the background (default false).")
+	resolveProviderAddrsWait := flag.Duration("resolve-provider-addrs-wait", 0, "Maximum time to wait for the addresses of providers returned without addresses to be resolved. 0 doesn't wait (default 0).")
+	resolveProviderAddrsWorkers := flag.Int("resolve-provider-addrs-workers", hproviders.DefaultAddrResolverWorkers, "Maximum number of providers whose addresses are resolved at once, across all heads.")
+	shardProviders := flag.Bool("shard", false, "Split the keyspace of provider records between the Hydras sharding it, forwarding the requests for keys owned by other Hydras to them (default false).")
+	shardPeers := flag.String("shard-peers", "", "A CSV list of the HTTP APIs of other Hydras sharding the keyspace, as <host>:<port>, to discover the Hydras sharing it from.")
+	replicationPeers := flag.String("replication-peers", "", "A CSV list of the HTTP APIs of other Hydras to replicate provider records to and from, as <host>:<port>.")
+	replicationBandwidth := flag.Float64("replication-bandwidth", 0, "Maximum number of bytes per second of provider records sent to the replicas. 0 is unlimited (default 0).")
+	antiEntropyInterval := flag.Duration("replication-anti-entropy-interval", defaultAntiEntropyInterval, "Time between the repairs of the provider records missing from each replica. 0 disables repairs.")
+	providerRecordQuota := flag.Int("provider-record-quota", 0, "Maximum number of live provider records per peer, across all heads. 0 disables the quota (default 0).")
 	httpAPIAddr := flag.String("httpapi-addr", defaultHTTPAPIAddr, "Specify an IP and port to run the HTTP API server on")
 	delegateTimeout := flag.Int("delegate-timeout", 0, "Timeout for delegated routing in milliseconds")
 	inmem := flag.Bool("mem", false, "Use an in-memory database. This overrides the -db option")
@@ -61,7 +93,26 @@ func main() {
 	disableProvGC := flag.Bool("disable-prov-gc", false, "Disable provider record garbage collection (default false).")
 	disableProviders := flag.Bool("disable-providers", false, "Disable storing and retrieving provider records, note that for some protocols, like \"/ipfs\", it MUST be false (default false).")
 	disableValues := flag.Bool("disable-values", false, "Disable storing and retrieving value records, note that for some protocols, like \"/ipfs\", it MUST be false (default false).")
+	valueStore := flag.String("value-store", "", "Where the value records, e.g. IPNS records, are stored: \"datastore\" for the datastore of the Hydra, or \"datastore://<path>\" for another datastore, given like -db (defaults to the datastore of the Hydra).")
+	valueValidators := flag.String("value-validators", "", "A JSON file configuring the validators of custom value namespaces, e.g. {\"myapp\": {\"Validator\": \"signed-json\", \"Keys\": [\"<peer ID>\"]}}, for DHTs with application specific value records.")
+	disableValuePrefetch := flag.Bool("disable-value-prefetch", false, "Disables prefetching the IPNS records missing from the value store when they are requested (default false).")
+	valuePrefetchTimeout := flag.Duration("value-prefetch-timeout", values.DefaultPrefetchTimeout, "Timeout of the lookup of an IPNS record when prefetching.")
+	valuePrefetchWorkers := flag.Int("value-prefetch-workers", values.DefaultPrefetchWorkers, "Number of workers prefetching IPNS records, across all heads.")
 	disablePrefetch := flag.Bool("disable-prefetch", false, "Disables pre-fetching of discovered provider records (default false).")
+	prefetchRouters := flag.String("prefetch-routers", "", "A CSV list of content routers to prefetch provider records from: \"dht\", \"https://<delegated-routing-endpoint>\" or \"hydra://<host>:<port>\" for the HTTP API of another Hydra (defaults to the DHT).")
+	prefetchRouterStrategy := flag.String("prefetch-router-strategy", string(hproviders.RouterParallel), "How the prefetch routers are queried, \"parallel\" or \"ordered\".")
+	prefetchRefreshAge := flag.Duration("prefetch-refresh-age", 0, "Age after which prefetched provider records are refreshed in the background when requested, while still being served. 0 disables refreshing (default 0).")
+	prefetchRefreshRate := flag.Float64("prefetch-refresh-rate", defaultPrefetchRefreshRate, "Maximum number of background refreshes of prefetched provider records per second, across all heads.")
+	prefetchRecordTTL := flag.Duration("prefetch-record-ttl", defaultPrefetchRecordTTL, "How long provider records found by prefetching are kept, announced records are kept for 48h. 0 keeps them as long as announced records.")
+	prefetchMinRequests := flag.Int("prefetch-min-requests", defaultPrefetchMinRequests, "Only prefetch the providers of keys requested at least this many times within -prefetch-min-requests-window, by any peers.")
+	prefetchMinRequestsWindow := flag.Duration("prefetch-min-requests-window", defaultPrefetchWindow, "Window within which keys must be requested -prefetch-min-requests times to be prefetched.")
+	prefetchRate := flag.Float64("prefetch-rate", 0, "Maximum number of keys prefetched per second, across all heads and requesting peers. 0 disables the limit (default 0).")
+	prefetchTimeout := flag.Duration("prefetch-timeout", hproviders.DefaultPrefetchTimeout, "Timeout of the lookup of the providers of a key when prefetching.")
+	prefetchQueueSize := flag.Int("prefetch-queue-size", hproviders.DefaultPrefetchQueueSize, "Maximum number of queued prefetches, across all heads.")
+	prefetchNegativeCacheTTL := flag.Duration("prefetch-negative-cache-ttl", hproviders.DefaultPrefetchNegativeCacheTTL, "How long keys whose prefetch found no providers are not prefetched again.")
+	prefetchNegativeCacheSize := flag.Int("prefetch-negative-cache-size", hproviders.DefaultPrefetchNegativeCacheSize, "Maximum number of keys whose prefetch found no providers that are remembered, across all heads.")
+	prefetchMinWorkers := flag.Int("prefetch-min-workers", 0, "Minimum number of workers prefetching providers, across all heads. 0 uses 10, or -prefetch-max-workers if lower (default 0).")
+	prefetchMaxWorkers := flag.Int("prefetch-max-workers", hproviders.DefaultPrefetchMaxWorkers, "Maximum number of workers prefetching providers, across all heads.")
 	disableProvCounts := flag.Bool("disable-prov-counts", false, "Disable counting provider records for metrics reporting (default false).")
 	disableDBCreate := flag.Bool("disable-db-create", false, "Don't create table and index in the target database (default false).")
 	disableResourceManager := flag.Bool("disable-rcmgr", false, "Disable libp2p Resource Manager by configuring it with infinite limits (default false).")
@@ -109,6 +160,54 @@ func main() {
 	if !*disablePrefetch {
 		*disablePrefetch = mustGetEnvBool("HYDRA_DISABLE_PREFETCH", false)
 	}
+	if *prefetchRouters == "" {
+		*prefetchRouters = os.Getenv("HYDRA_PREFETCH_ROUTERS")
+	}
+	if *prefetchRouterStrategy == string(hproviders.RouterParallel) {
+		if envVal := os.Getenv("HYDRA_PREFETCH_ROUTER_STRATEGY"); envVal != "" {
+			*prefetchRouterStrategy = envVal
+		}
+	}
+	routerStrategy, err := hproviders.ParseRouterStrategy(*prefetchRouterStrategy)
+	if err != nil {
+		log.Fatalf("parsing prefetch router strategy: %s", err)
+	}
+	if *prefetchRefreshAge == 0 {
+		*prefetchRefreshAge = mustGetEnvDuration("HYDRA_PREFETCH_REFRESH_AGE", 0)
+	}
+	if *prefetchRefreshRate == defaultPrefetchRefreshRate {
+		*prefetchRefreshRate = mustGetEnvFloat("HYDRA_PREFETCH_REFRESH_RATE", defaultPrefetchRefreshRate)
+	}
+	if *prefetchRecordTTL == defaultPrefetchRecordTTL {
+		*prefetchRecordTTL = mustGetEnvDuration("HYDRA_PREFETCH_RECORD_TTL", defaultPrefetchRecordTTL)
+	}
+	if *prefetchMinRequests == defaultPrefetchMinRequests {
+		*prefetchMinRequests = mustGetEnvInt("HYDRA_PREFETCH_MIN_REQUESTS", defaultPrefetchMinRequests)
+	}
+	if *prefetchMinRequestsWindow == defaultPrefetchWindow {
+		*prefetchMinRequestsWindow = mustGetEnvDuration("HYDRA_PREFETCH_MIN_REQUESTS_WINDOW", defaultPrefetchWindow)
+	}
+	if *prefetchRate == 0 {
+		*prefetchRate = mustGetEnvFloat("HYDRA_PREFETCH_RATE", 0)
+	}
+	if *prefetchTimeout == hproviders.DefaultPrefetchTimeout {
+		*prefetchTimeout = mustGetEnvDuration("HYDRA_PREFETCH_TIMEOUT", hproviders.DefaultPrefetchTimeout)
+	}
+	if *prefetchQueueSize == hproviders.DefaultPrefetchQueueSize {
+		*prefetchQueueSize = mustGetEnvInt("HYDRA_PREFETCH_QUEUE_SIZE", hproviders.DefaultPrefetchQueueSize)
+	}
+	if *prefetchNegativeCacheTTL == hproviders.DefaultPrefetchNegativeCacheTTL {
+		*prefetchNegativeCacheTTL = mustGetEnvDuration("HYDRA_PREFETCH_NEGATIVE_CACHE_TTL", hproviders.DefaultPrefetchNegativeCacheTTL)
+	}
+	if *prefetchNegativeCacheSize == hproviders.DefaultPrefetchNegativeCacheSize {
+		*prefetchNegativeCacheSize = mustGetEnvInt("HYDRA_PREFETCH_NEGATIVE_CACHE_SIZE", hproviders.DefaultPrefetchNegativeCacheSize)
+	}
+	if *prefetchMinWorkers == 0 {
+		*prefetchMinWorkers = mustGetEnvInt("HYDRA_PREFETCH_MIN_WORKERS", 0)
+	}
+	if *prefetchMaxWorkers == hproviders.DefaultPrefetchMaxWorkers {
+		*prefetchMaxWorkers = mustGetEnvInt("HYDRA_PREFETCH_MAX_WORKERS", hproviders.DefaultPrefetchMaxWorkers)
+	}
 	if !*disableDBCreate {
 		*disableDBCreate = mustGetEnvBool("HYDRA_DISABLE_DBCREATE", false)
 	}
@@ -121,6 +220,96 @@ func main() {
 	if *providerStore == "" {
 		*providerStore = os.Getenv("HYDRA_PROVIDER_STORE")
 	}
+	if *valueStore == "" {
+		*valueStore = os.Getenv("HYDRA_VALUE_STORE")
+	}
+	if *valueValidators == "" {
+		*valueValidators = os.Getenv("HYDRA_VALUE_VALIDATORS")
+	}
+	if !*disableValuePrefetch {
+		*disableValuePrefetch = mustGetEnvBool("HYDRA_DISABLE_VALUE_PREFETCH", false)
+	}
+	if *valuePrefetchTimeout == values.DefaultPrefetchTimeout {
+		*valuePrefetchTimeout = mustGetEnvDuration("HYDRA_VALUE_PREFETCH_TIMEOUT", values.DefaultPrefetchTimeout)
+	}
+	if *valuePrefetchWorkers == values.DefaultPrefetchWorkers {
+		*valuePrefetchWorkers = mustGetEnvInt("HYDRA_VALUE_PREFETCH_WORKERS", values.DefaultPrefetchWorkers)
+	}
+	if *providerStoreCacheSize == 0 {
+		*providerStoreCacheSize = mustGetEnvInt("HYDRA_PROVIDER_STORE_CACHE_SIZE", 0)
+	}
+	if *providerStoreCacheTTL == defaultProviderCacheTTL {
+		*providerStoreCacheTTL = mustGetEnvDuration("HYDRA_PROVIDER_STORE_CACHE_TTL", defaultProviderCacheTTL)
+	}
+	if *providerStoreCacheMode == string(hproviders.WriteThrough) {
+		if envVal := os.Getenv("HYDRA_PROVIDER_STORE_CACHE_MODE"); envVal != "" {
+			*providerStoreCacheMode = envVal
+		}
+	}
+	cacheMode, err := hproviders.ParseWriteMode(*providerStoreCacheMode)
+	if err != nil {
+		log.Fatalf("parsing provider store cache mode: %s", err)
+	}
+	if *denylistContent == "" {
+		*denylistContent = os.Getenv("HYDRA_DENYLIST_CONTENT")
+	}
+	if *denylistPeers == "" {
+		*denylistPeers = os.Getenv("HYDRA_DENYLIST_PEERS")
+	}
+	if *providerRateLimit == 0 {
+		*providerRateLimit = mustGetEnvFloat("HYDRA_PROVIDER_RATE_LIMIT", 0)
+	}
+	if *providerRateBurst == defaultProviderRateBurst {
+		*providerRateBurst = mustGetEnvInt("HYDRA_PROVIDER_RATE_BURST", defaultProviderRateBurst)
+	}
+	if *providerRecordQuota == 0 {
+		*providerRecordQuota = mustGetEnvInt("HYDRA_PROVIDER_RECORD_QUOTA", 0)
+	}
+	if *providerAddrFilter == string(hproviders.AddrFilterPublic) {
+		if envVal := os.Getenv("HYDRA_PROVIDER_ADDR_FILTER"); envVal != "" {
+			*providerAddrFilter = envVal
+		}
+	}
+	addrFilter, err := hproviders.ParseAddrFilterPolicy(*providerAddrFilter)
+	if err != nil {
+		log.Fatalf("parsing provider address filter: %s", err)
+	}
+	if *providerKeyspaceFilter == string(hproviders.KeyspaceFilterNone) {
+		if envVal := os.Getenv("HYDRA_PROVIDER_KEYSPACE_FILTER"); envVal != "" {
+			*providerKeyspaceFilter = envVal
+		}
+	}
+	keyspaceFilter, err := hproviders.ParseKeyspaceFilterMode(*providerKeyspaceFilter)
+	if err != nil {
+		log.Fatalf("parsing provider keyspace filter: %s", err)
+	}
+	if !*resolveProviderAddrs {
+		*resolveProviderAddrs = mustGetEnvBool("HYDRA_RESOLVE_PROVIDER_ADDRS", false)
+	}
+	if *resolveProviderAddrsWait == 0 {
+		*resolveProviderAddrsWait = mustGetEnvDuration("HYDRA_RESOLVE_PROVIDER_ADDRS_WAIT", 0)
+	}
+	if *resolveProviderAddrsWorkers == hproviders.DefaultAddrResolverWorkers {
+		*resolveProviderAddrsWorkers = mustGetEnvInt("HYDRA_RESOLVE_PROVIDER_ADDRS_WORKERS", hproviders.DefaultAddrResolverWorkers)
+	}
+	if !*shardProviders {
+		*shardProviders = mustGetEnvBool("HYDRA_SHARD", false)
+	}
+	if *shardPeers == "" {
+		*shardPeers = os.Getenv("HYDRA_SHARD_PEERS")
+	}
+	if *shardPeers != "" && !*shardProviders {
+		log.Fatalln("-shard-peers requires -shard")
+	}
+	if *replicationPeers == "" {
+		*replicationPeers = os.Getenv("HYDRA_REPLICATION_PEERS")
+	}
+	if *replicationBandwidth == 0 {
+		*replicationBandwidth = mustGetEnvFloat("HYDRA_REPLICATION_BANDWIDTH", 0)
+	}
+	if *antiEntropyInterval == defaultAntiEntropyInterval {
+		*antiEntropyInterval = mustGetEnvDuration("HYDRA_REPLICATION_ANTI_ENTROPY_INTERVAL", defaultAntiEntropyInterval)
+	}
 	if *delegateTimeout == 0 {
 		*delegateTimeout = mustGetEnvInt("HYDRA_DELEGATED_ROUTING_TIMEOUT", 1000)
 	}
@@ -190,6 +379,9 @@ func main() {
 		DatastorePath:             *dbpath,
 		PeerstorePath:             *pstorePath,
 		ProviderStore:             *providerStore,
+		ProviderStoreCacheSize:    *providerStoreCacheSize,
+		ProviderStoreCacheTTL:     *providerStoreCacheTTL,
+		ProviderStoreCacheMode:    cacheMode,
 		DelegateTimeout:           time.Millisecond * time.Duration(*delegateTimeout),
 		EnableRelay:               *enableRelay,
 		ProtocolPrefix:            protocol.ID(*protocolPrefix),
@@ -202,8 +394,42 @@ func main() {
 		DisableProvGC:             *disableProvGC,
 		DisableProviders:          *disableProviders,
 		DisableValues:             *disableValues,
+		ValueStore:                *valueStore,
+		ValueValidatorsFile:       *valueValidators,
+		DisableValuePrefetch:      *disableValuePrefetch,
+		ValuePrefetchTimeout:      *valuePrefetchTimeout,
+		ValuePrefetchWorkers:      *valuePrefetchWorkers,
 		BootstrapPeers:            mustConvertToMultiaddr(*bootstrapPeers),
+		DenylistContentFiles:      splitCSV(*denylistContent),
+		DenylistPeerFiles:         splitCSV(*denylistPeers),
+		ProviderRateLimit:         *providerRateLimit,
+		ProviderRateBurst:         *providerRateBurst,
+		ProviderRecordQuota:       *providerRecordQuota,
+		ProviderAddrFilter:        addrFilter,
+		KeyspaceFilter:            keyspaceFilter,
+		ResolveProviderAddrs:      *resolveProviderAddrs,
+		AddrResolutionWait:        *resolveProviderAddrsWait,
+		AddrResolutionWorkers:     *resolveProviderAddrsWorkers,
+		Shard:                     *shardProviders,
+		ShardPeers:                splitCSV(*shardPeers),
+		ReplicationPeers:          splitCSV(*replicationPeers),
+		ReplicationBandwidth:      *replicationBandwidth,
+		AntiEntropyInterval:       *antiEntropyInterval,
 		DisablePrefetch:           *disablePrefetch,
+		PrefetchRouters:           splitCSV(*prefetchRouters),
+		PrefetchRouterStrategy:    routerStrategy,
+		PrefetchRefreshAge:        *prefetchRefreshAge,
+		PrefetchRefreshRate:       *prefetchRefreshRate,
+		PrefetchRecordTTL:         *prefetchRecordTTL,
+		PrefetchMinRequests:       *prefetchMinRequests,
+		PrefetchMinRequestsWindow: *prefetchMinRequestsWindow,
+		PrefetchRate:              *prefetchRate,
+		PrefetchTimeout:           *prefetchTimeout,
+		PrefetchQueueSize:         *prefetchQueueSize,
+		PrefetchNegativeCacheTTL:  *prefetchNegativeCacheTTL,
+		PrefetchNegativeCacheSize: *prefetchNegativeCacheSize,
+		PrefetchMinWorkers:        *prefetchMinWorkers,
+		PrefetchMaxWorkers:        *prefetchMaxWorkers,
 		DisableProvCounts:         *disableProvCounts,
 		DisableDBCreate:           *disableDBCreate,
 		DisableResourceManager:    *disableResourceManager,
@@ -272,6 +498,17 @@ func mustGetEnvInt(key string, def int) int {
 	return val
 }
 
+func mustGetEnvFloat(key string, def float64) float64 {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	val, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		log.Fatalln(fmt.Errorf("invalid %s env value: %w", key, err))
+	}
+	return val
+}
+
 func mustGetEnvBool(key string, def bool) bool {
 	if os.Getenv(key) == "" {
 		return def
@@ -283,6 +520,24 @@ func mustGetEnvBool(key string, def bool) bool {
 	return val
 }
 
+func mustGetEnvDuration(key string, def time.Duration) time.Duration {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	val, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		log.Fatalln(fmt.Errorf("invalid %s env value: %w", key, err))
+	}
+	return val
+}
+
+func splitCSV(csv string) []string {
+	if csv == "" {
+		return nil
+	}
+	return strings.Split(csv, ",")
+}
+
 func mustConvertToMultiaddr(csv string) []multiaddr.Multiaddr {
 	var peers []multiaddr.Multiaddr
 	if csv != "" {
@@ -13,7 +13,12 @@ var (
 	defaultMillisecondsDistribution = view.Distribution(0.01, 0.05, 0.1, 0.3, 0.6, 0.8, 1, 2, 3, 4, 5, 6, 8, 10, 13, 16, 20, 25, 30, 40, 50, 65, 80, 100, 130, 160, 200, 250, 300, 400, 500, 650, 800, 1000, 2000, 5000, 10000, 20000, 50000, 100000)
 	// a coarser-grained milliseconds distribution for metrics with higher cardinality and where we don't need a more fine-grained distribution
 	coarseMillisecondsDistribution = view.Distribution(0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000)
+	prefetchPriorityDistribution   = view.Distribution(1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 4096, 16384)
 	defaultProvidersDistribution   = view.Distribution(0.5, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000)
+	// replication lags range from milliseconds to the time a replica was unreachable for
+	replicationLagDistribution = view.Distribution(1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 30000, 60000, 300000, 600000, 1800000, 3600000)
+	// IPNS records are typically valid for 1 to 48 hours
+	ipnsValidityDistribution = view.Distribution(60, 300, 900, 1800, 3600, 7200, 14400, 43200, 86400, 172800, 604800, 2592000)
 )
 
 // Keys
@@ -24,6 +29,13 @@ var (
 	KeyHTTPCode, _  = tag.NewKey("http_code")
 	KeyOperation, _ = tag.NewKey("operation")
 	KeyErrorCode, _ = tag.NewKey("err_code")
+	KeyBackend, _   = tag.NewKey("backend")
+	KeyKind, _      = tag.NewKey("kind")
+	KeyReason, _    = tag.NewKey("reason")
+	KeySource, _    = tag.NewKey("source")
+	KeyOrigin, _    = tag.NewKey("origin")
+	KeyEndpoint, _  = tag.NewKey("endpoint")
+	KeyReplica, _   = tag.NewKey("replica")
 
 	// Resource Manager Keys
 	KeyDirection, _ = tag.NewKey("direction")
@@ -34,21 +46,41 @@ var (
 
 // Measures
 var (
-	Heads                 = stats.Int64("heads", "Heads launched by Hydra", stats.UnitDimensionless)
-	BootstrappedHeads     = stats.Int64("bootstrapped_heads", "Bootstrapped heads", stats.UnitDimensionless)
-	ConnectedPeers        = stats.Int64("connected_peers", "Peers connected to all heads", stats.UnitDimensionless)
-	UniquePeers           = stats.Int64("unique_peers_total", "Total unique peers seen across all heads", stats.UnitDimensionless)
-	RoutingTableSize      = stats.Int64("routing_table_size", "Number of peers in the routing table", stats.UnitDimensionless)
-	IPNSRecords           = stats.Int64("ipns_records", "Number of IPNS records in the IPNS datastore", stats.UnitDimensionless)
+	Heads             = stats.Int64("heads", "Heads launched by Hydra", stats.UnitDimensionless)
+	BootstrappedHeads = stats.Int64("bootstrapped_heads", "Bootstrapped heads", stats.UnitDimensionless)
+	ConnectedPeers    = stats.Int64("connected_peers", "Peers connected to all heads", stats.UnitDimensionless)
+	UniquePeers       = stats.Int64("unique_peers_total", "Total unique peers seen across all heads", stats.UnitDimensionless)
+	RoutingTableSize  = stats.Int64("routing_table_size", "Number of peers in the routing table", stats.UnitDimensionless)
+	IPNSRecords       = stats.Int64("ipns_records", "Number of IPNS records in the value store", stats.UnitDimensionless)
+	PublicKeyRecords  = stats.Int64("pk_records", "Number of public key records in the value store", stats.UnitDimensionless)
+	// Augmented with "status" label: "succeeded", "not-found", "failed", "invalid", "expired", "present", "outdated", "negative-cached" or "discarded"
+	IPNSPrefetches        = stats.Int64("ipns_prefetches", "Total IPNS record prefetch attempts on GET_VALUE misses", stats.UnitDimensionless)
+	IPNSPrefetchDuration  = stats.Float64("ipns_prefetch_duration", "The time it took IPNS record prefetches to succeed or fail", stats.UnitMilliseconds)
+	IPNSPrefetchValidity  = stats.Int64("ipns_prefetch_validity", "Remaining validity of the IPNS records stored by prefetches, in seconds", stats.UnitSeconds)
+	IPNSPrefetchesPending = stats.Int64("ipns_prefetch_pending", "Number of IPNS record prefetches queued or in progress", stats.UnitDimensionless)
 	ProviderRecords       = stats.Int64("provider_records", "Number of provider records in the datastore shared by all heads", stats.UnitDimensionless)
-	ProviderRecordsPerKey = stats.Int64("provider_records_per_key", "Number of provider records returned per key", stats.UnitDimensionless)
+	// Augmented with "origin" label: "announced", "prefetched", "imported" or "replicated".
+	// Only counted from the datastore of the default provider store, and less often than ProviderRecords since the
+	// origin of a record is in its value
+	ProviderRecordsByOrigin = stats.Int64("provider_records_by_origin", "Number of provider records in the datastore shared by all heads, by origin", stats.UnitDimensionless)
+	ProviderRecordsPerKey   = stats.Int64("provider_records_per_key", "Number of provider records returned per key", stats.UnitDimensionless)
 	// Augmented with "status" label:
 	// "local" (found locally)
 	// "succeeded" (found at least 1 provider on the network)
 	// "failed" (not found any providers on the network)
 	// "failed-cached" (no providers found locally, and did not attempt to try network due to negative cache)
-	// "discarded" (not local and queue was full)
-	Prefetches = stats.Int64("prov_prefetches", "Total find provider prefetch attempts that were found locally, or not found locally and succeeded, failed or were discarded", stats.UnitDimensionless)
+	// "discarded" (not local and queue was full of more requested keys)
+	// "evicted" (queued, then pushed out of the full queue by a more requested key)
+	// "rejected-infrequent" or "rejected-rate-limited" (not local and rejected by the admission policy)
+	Prefetches = stats.Int64("prov_prefetches", "Total find provider prefetch attempts that were found locally, or not found locally and succeeded, failed, were discarded, were evicted or were rejected", stats.UnitDimensionless)
+	// Augmented with "source" label and "status" label: "succeeded" (found at least 1 provider) or "failed"
+	PrefetchSourceLookups = stats.Int64("prov_prefetch_source_lookups", "Number of provider prefetch lookups by content routing source", stats.UnitDimensionless)
+	// Augmented with "source" label and "status" label
+	PrefetchSourceProviders = stats.Int64("prov_prefetch_source_providers", "Number of providers found by provider prefetch lookups, by content routing source", stats.UnitDimensionless)
+	// Augmented with "status" label: "scheduled" or "throttled" (the refresh budget was used up)
+	PrefetchRefreshes = stats.Int64("prov_prefetch_refreshes", "Number of background refreshes of stale prefetched provider records", stats.UnitDimensionless)
+	// Augmented with "status" label: "started", "discarded" or "evicted"
+	PrefetchPriority = stats.Int64("prov_prefetch_priority", "Number of recent requests for the keys of provider prefetches, which is their priority in the prefetch queue", stats.UnitDimensionless)
 	// Augmented with "status" label:
 	// "succeeded" (found at least 1 provider on the network)
 	// "failed" (not found any providers on the network)
@@ -59,6 +91,8 @@ var (
 	PrefetchFailedToCache           = stats.Int64("prov_prefetch_failed_to_cache", "Number of times the provider prefetcher failed to cache a result", stats.UnitDimensionless)
 	PrefetchesPending               = stats.Int64("prov_prefetch_pending", "Total number of async provider prefetches pending (queued or in progress)", stats.UnitDimensionless)
 	PrefetchesPendingLimit          = stats.Int64("prov_prefetch_pending_limit", "The limit of the number of pending prefetches", stats.UnitDimensionless)
+	PrefetchWorkers                 = stats.Int64("prov_prefetch_workers", "Number of workers prefetching providers", stats.UnitDimensionless)
+	PrefetchWorkerUtilization       = stats.Float64("prov_prefetch_worker_utilization", "Average fraction of the prefetch workers busy prefetching providers", stats.UnitDimensionless)
 
 	// Augmented with "status" label:
 	// "succeeded" if a response with no error was received from the source.
@@ -76,6 +110,87 @@ var (
 	AWSRequestRetries        = stats.Int64("aws_retries", "Retried requests to AWS", stats.UnitDimensionless)
 	ProviderDDBCollisions    = stats.Int64("prov_ddb_collisions", "Number of key collisions when writing provider records into DynamoDB", stats.UnitDimensionless)
 
+	// Augmented with "backend" label and "status" label:
+	// "hit" (providers were served from the in-memory cache)
+	// "miss" (providers were fetched from the backend)
+	ProviderCacheLookups     = stats.Int64("prov_cache_lookups", "Total provider lookups served by the in-memory provider cache, by hit or miss", stats.UnitDimensionless)
+	ProviderCacheWriteErrors = stats.Int64("prov_cache_write_errors", "Number of asynchronous (write-behind) provider writes that failed", stats.UnitDimensionless)
+
+	// Augmented with "backend", "operation" and "status" labels:
+	// "succeeded" (the backend returned without error)
+	// "failed" (the backend returned an error)
+	// "timeout" (the backend did not return within its timeout)
+	// "canceled" (the request was canceled, e.g. because another backend already returned providers)
+	CombinedBackendRequests        = stats.Int64("prov_combined_backend_reqs", "Total requests made to the backends of a combined provider store", stats.UnitDimensionless)
+	CombinedBackendRequestDuration = stats.Float64("prov_combined_backend_req_duration", "The time it took a backend of a combined provider store to respond", stats.UnitMilliseconds)
+
+	// Augmented with "backend", "peer_id" (of the head), "operation" and "status" labels:
+	// "succeeded" (the provider store returned without error)
+	// "failed" (the provider store returned an error)
+	ProviderStoreRequests        = stats.Int64("prov_store_reqs", "Total calls to a provider store", stats.UnitDimensionless)
+	ProviderStoreRequestDuration = stats.Float64("prov_store_req_duration", "The time it took a provider store to return", stats.UnitMilliseconds)
+	// Augmented with "backend" and "peer_id" (of the head) labels
+	ProviderStoreResultSize = stats.Int64("prov_store_result_size", "Number of providers returned by successful lookups of a provider store", stats.UnitDimensionless)
+
+	// Augmented with "endpoint" label and "status" label:
+	// "succeeded" (the endpoint answered without error)
+	// "failed" (the endpoint returned an error or timed out)
+	// "slow" (the request was canceled because an endpoint queried after its hedge delay answered first)
+	// "canceled" (the request was canceled otherwise, e.g. because the caller gave up)
+	// "rejected" (the circuit breaker of the endpoint is open, so no request was sent)
+	HTTPEndpointRequests        = stats.Int64("prov_http_endpoint_reqs", "Total find provider requests to the delegated routing endpoints of the HTTP provider store", stats.UnitDimensionless)
+	HTTPEndpointRequestDuration = stats.Float64("prov_http_endpoint_req_duration", "The time it took a delegated routing endpoint of the HTTP provider store to respond", stats.UnitMilliseconds)
+	// Augmented with "endpoint" label
+	HTTPEndpointBreakerState = stats.Int64("prov_http_endpoint_breaker_state", "State of the circuit breaker of a delegated routing endpoint: 0 closed, 1 half open, 2 open", stats.UnitDimensionless)
+
+	// Augmented with "kind" label: "content" or "peer"
+	DenylistEntries = stats.Int64("denylist_entries", "Number of entries in the loaded denylists", stats.UnitDimensionless)
+	// Augmented with "operation" and "kind" labels
+	DenylistBlocked = stats.Int64("denylist_blocked", "Number of provider records blocked by the denylists", stats.UnitDimensionless)
+
+	// Augmented with "status" label:
+	// "queued" (the provider had no addresses and was queued to be resolved)
+	// "dropped" (the resolution queue was full)
+	// "cached" (the provider was recently resolved)
+	// "succeeded" or "failed" (the provider was resolved, or its lookup failed)
+	ProviderAddrResolutions = stats.Int64("prov_addr_resolutions", "Number of resolutions of the addresses of providers returned without addresses", stats.UnitDimensionless)
+
+	// Augmented with "operation" label and "reason" label:
+	// "malformed", "loopback", "unspecified", "link-local", "private" or "unroutable"
+	ProviderAddrsFiltered = stats.Int64("prov_addrs_filtered", "Number of addresses removed from provider records by the address filter", stats.UnitDimensionless)
+
+	// Augmented with "operation" and "status" labels: "succeeded" or "failed"
+	ShardForwards = stats.Int64("prov_shard_forwards", "Number of provider requests forwarded to the hydra owning their key", stats.UnitDimensionless)
+	// Augmented with "status" label: "succeeded" or "failed"
+	ShardHeartbeats = stats.Int64("prov_shard_heartbeats", "Number of heartbeats sent to the other hydras sharing the keyspace", stats.UnitDimensionless)
+	ShardMembers    = stats.Int64("prov_shard_members", "Number of hydras owning a share of the keyspace, including this one", stats.UnitDimensionless)
+	// Augmented with "peer_id" label, of the hydra owning the share
+	ShardKeyspaceShare = stats.Float64("prov_shard_keyspace_share", "Estimated fraction of the keyspace owned by a hydra", stats.UnitDimensionless)
+
+	// Augmented with "replica", "kind" ("push" or "repair") and "status" labels: "succeeded", "failed" or "dropped"
+	ReplicationSent = stats.Int64("prov_replication_sent", "Number of provider records sent to replicas", stats.UnitDimensionless)
+	// Augmented with "status" label: "added", "expired" or "failed"
+	ReplicationReceived = stats.Int64("prov_replication_received", "Number of provider records received from replicas", stats.UnitDimensionless)
+	// Augmented with "replica" label
+	ReplicationLag        = stats.Float64("prov_replication_lag", "Time between the announcement of a provider record and its replication", stats.UnitMilliseconds)
+	ReplicationQueue      = stats.Int64("prov_replication_queue", "Number of provider records waiting to be replicated", stats.UnitDimensionless)
+	ReplicationBytes      = stats.Int64("prov_replication_bytes", "Bytes of provider records sent to replicas", stats.UnitBytes)
+	ReplicationDiffRanges = stats.Int64("prov_replication_diff_ranges", "Number of keyspace ranges whose records differ from a replica at the last anti-entropy repair", stats.UnitDimensionless)
+
+	// Augmented with "kind" label, the filter mode: "enforce" or "dry-run", and "status" label: "accepted" or "rejected"
+	ProviderKeyspaceFiltered = stats.Int64("prov_keyspace_filtered", "Number of provider records checked by the keyspace responsibility filter", stats.UnitDimensionless)
+
+	// Augmented with "reason" label: "rate_limited" or "quota_exceeded"
+	ProviderRecordsThrottled = stats.Int64("prov_throttled", "Number of provider records dropped because their peer exceeded its limits", stats.UnitDimensionless)
+	ThrottledPeers           = stats.Int64("prov_throttled_peers", "Number of peers whose provider records were recently throttled", stats.UnitDimensionless)
+
+	// Augmented with "status" label: "succeeded", "failed" or "expired"
+	MigrationBackfilled = stats.Int64("prov_migration_backfilled", "Number of provider records copied from the source to the target provider store by a migration backfill", stats.UnitDimensionless)
+	MigrationLag        = stats.Int64("prov_migration_lag", "Number of provider records waiting to be written to the target provider store of a migration", stats.UnitDimensionless)
+	// Augmented with "operation" label: "AddProvider", "GetProviders" or "Backfill"
+	MigrationErrors = stats.Int64("prov_migration_errors", "Number of failed operations on the target provider store of a migration", stats.UnitDimensionless)
+	MigrationPhase  = stats.Int64("prov_migration_phase", "Phase of a provider store migration: 0 backfilling, 1 catching up, 2 caught up, 3 switched, 4 failed", stats.UnitDimensionless)
+
 	// libp2p Resource Manager
 	RcmgrConnsAllowed         = stats.Int64("libp2p_rcmgr_conns_allowed_total", "Total number of connections allowed by Resource Manager", stats.UnitDimensionless)
 	RcmgrConnsBlocked         = stats.Int64("libp2p_rcmgr_conns_blocked_total", "Total number of connections blocked by Resource Manager", stats.UnitDimensionless)
@@ -125,11 +240,41 @@ var (
 		TagKeys:     []tag.Key{KeyName},
 		Aggregation: view.LastValue(),
 	}
+	PublicKeyRecordsView = &view.View{
+		Measure:     PublicKeyRecords,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
+	IPNSPrefetchesView = &view.View{
+		Measure:     IPNSPrefetches,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	IPNSPrefetchDurationView = &view.View{
+		Measure:     IPNSPrefetchDuration,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: coarseMillisecondsDistribution,
+	}
+	IPNSPrefetchValidityView = &view.View{
+		Measure:     IPNSPrefetchValidity,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: ipnsValidityDistribution,
+	}
+	IPNSPrefetchesPendingView = &view.View{
+		Measure:     IPNSPrefetchesPending,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
 	ProviderRecordsView = &view.View{
 		Measure:     ProviderRecords,
 		TagKeys:     []tag.Key{KeyName},
 		Aggregation: view.LastValue(),
 	}
+	ProviderRecordsByOriginView = &view.View{
+		Measure:     ProviderRecordsByOrigin,
+		TagKeys:     []tag.Key{KeyName, KeyOrigin},
+		Aggregation: view.LastValue(),
+	}
 	ProviderRecordsPerKeyView = &view.View{
 		Measure:     ProviderRecordsPerKey,
 		TagKeys:     []tag.Key{KeyName},
@@ -140,6 +285,26 @@ var (
 		TagKeys:     []tag.Key{KeyName, KeyStatus},
 		Aggregation: view.Sum(),
 	}
+	PrefetchSourceLookupsView = &view.View{
+		Measure:     PrefetchSourceLookups,
+		TagKeys:     []tag.Key{KeyName, KeySource, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	PrefetchSourceProvidersView = &view.View{
+		Measure:     PrefetchSourceProviders,
+		TagKeys:     []tag.Key{KeyName, KeySource},
+		Aggregation: view.Sum(),
+	}
+	PrefetchRefreshesView = &view.View{
+		Measure:     PrefetchRefreshes,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	PrefetchPriorityView = &view.View{
+		Measure:     PrefetchPriority,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: prefetchPriorityDistribution,
+	}
 	PrefetchDurationMillisView = &view.View{
 		Measure:     PrefetchDuration,
 		TagKeys:     []tag.Key{KeyName, KeyStatus},
@@ -174,6 +339,16 @@ var (
 		TagKeys:     []tag.Key{KeyName},
 		Aggregation: view.LastValue(),
 	}
+	PrefetchWorkersView = &view.View{
+		Measure:     PrefetchWorkers,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
+	PrefetchWorkerUtilizationView = &view.View{
+		Measure:     PrefetchWorkerUtilization,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
 	AWSRequestsView = &view.View{
 		Measure:     AWSRequests,
 		TagKeys:     []tag.Key{KeyName, KeyOperation, KeyHTTPCode, KeyErrorCode},
@@ -194,6 +369,161 @@ var (
 		TagKeys:     []tag.Key{KeyName},
 		Aggregation: view.Sum(),
 	}
+	ProviderCacheLookupsView = &view.View{
+		Measure:     ProviderCacheLookups,
+		TagKeys:     []tag.Key{KeyName, KeyBackend, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ProviderCacheWriteErrorsView = &view.View{
+		Measure:     ProviderCacheWriteErrors,
+		TagKeys:     []tag.Key{KeyName, KeyBackend},
+		Aggregation: view.Sum(),
+	}
+	CombinedBackendRequestsView = &view.View{
+		Measure:     CombinedBackendRequests,
+		TagKeys:     []tag.Key{KeyName, KeyBackend, KeyOperation, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	CombinedBackendRequestDurationView = &view.View{
+		Measure:     CombinedBackendRequestDuration,
+		TagKeys:     []tag.Key{KeyName, KeyBackend, KeyOperation, KeyStatus},
+		Aggregation: coarseMillisecondsDistribution,
+	}
+	ProviderStoreRequestsView = &view.View{
+		Measure:     ProviderStoreRequests,
+		TagKeys:     []tag.Key{KeyName, KeyPeerID, KeyBackend, KeyOperation, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ProviderStoreRequestDurationView = &view.View{
+		Measure:     ProviderStoreRequestDuration,
+		TagKeys:     []tag.Key{KeyName, KeyPeerID, KeyBackend, KeyOperation, KeyStatus},
+		Aggregation: coarseMillisecondsDistribution,
+	}
+	ProviderStoreResultSizeView = &view.View{
+		Measure:     ProviderStoreResultSize,
+		TagKeys:     []tag.Key{KeyName, KeyPeerID, KeyBackend},
+		Aggregation: defaultProvidersDistribution,
+	}
+	HTTPEndpointRequestsView = &view.View{
+		Measure:     HTTPEndpointRequests,
+		TagKeys:     []tag.Key{KeyName, KeyEndpoint, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	HTTPEndpointRequestDurationView = &view.View{
+		Measure:     HTTPEndpointRequestDuration,
+		TagKeys:     []tag.Key{KeyName, KeyEndpoint, KeyStatus},
+		Aggregation: coarseMillisecondsDistribution,
+	}
+	ProviderAddrsFilteredView = &view.View{
+		Measure:     ProviderAddrsFiltered,
+		TagKeys:     []tag.Key{KeyName, KeyOperation, KeyReason},
+		Aggregation: view.Sum(),
+	}
+	ShardForwardsView = &view.View{
+		Measure:     ShardForwards,
+		TagKeys:     []tag.Key{KeyName, KeyOperation, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ShardHeartbeatsView = &view.View{
+		Measure:     ShardHeartbeats,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ShardMembersView = &view.View{
+		Measure:     ShardMembers,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
+	ShardKeyspaceShareView = &view.View{
+		Measure:     ShardKeyspaceShare,
+		TagKeys:     []tag.Key{KeyName, KeyPeerID},
+		Aggregation: view.LastValue(),
+	}
+	ReplicationSentView = &view.View{
+		Measure:     ReplicationSent,
+		TagKeys:     []tag.Key{KeyName, KeyReplica, KeyKind, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ReplicationReceivedView = &view.View{
+		Measure:     ReplicationReceived,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ReplicationLagView = &view.View{
+		Measure:     ReplicationLag,
+		TagKeys:     []tag.Key{KeyName, KeyReplica},
+		Aggregation: replicationLagDistribution,
+	}
+	ReplicationQueueView = &view.View{
+		Measure:     ReplicationQueue,
+		TagKeys:     []tag.Key{KeyName, KeyReplica},
+		Aggregation: view.LastValue(),
+	}
+	ReplicationBytesView = &view.View{
+		Measure:     ReplicationBytes,
+		TagKeys:     []tag.Key{KeyName, KeyReplica},
+		Aggregation: view.Sum(),
+	}
+	ReplicationDiffRangesView = &view.View{
+		Measure:     ReplicationDiffRanges,
+		TagKeys:     []tag.Key{KeyName, KeyReplica},
+		Aggregation: view.LastValue(),
+	}
+	ProviderKeyspaceFilteredView = &view.View{
+		Measure:     ProviderKeyspaceFiltered,
+		TagKeys:     []tag.Key{KeyName, KeyKind, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	ProviderAddrResolutionsView = &view.View{
+		Measure:     ProviderAddrResolutions,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	HTTPEndpointBreakerStateView = &view.View{
+		Measure:     HTTPEndpointBreakerState,
+		TagKeys:     []tag.Key{KeyName, KeyEndpoint},
+		Aggregation: view.LastValue(),
+	}
+	DenylistEntriesView = &view.View{
+		Measure:     DenylistEntries,
+		TagKeys:     []tag.Key{KeyName, KeyKind},
+		Aggregation: view.LastValue(),
+	}
+	DenylistBlockedView = &view.View{
+		Measure:     DenylistBlocked,
+		TagKeys:     []tag.Key{KeyName, KeyOperation, KeyKind},
+		Aggregation: view.Sum(),
+	}
+	ProviderRecordsThrottledView = &view.View{
+		Measure:     ProviderRecordsThrottled,
+		TagKeys:     []tag.Key{KeyName, KeyReason},
+		Aggregation: view.Sum(),
+	}
+	ThrottledPeersView = &view.View{
+		Measure:     ThrottledPeers,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
+	MigrationBackfilledView = &view.View{
+		Measure:     MigrationBackfilled,
+		TagKeys:     []tag.Key{KeyName, KeyStatus},
+		Aggregation: view.Sum(),
+	}
+	MigrationLagView = &view.View{
+		Measure:     MigrationLag,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
+	MigrationErrorsView = &view.View{
+		Measure:     MigrationErrors,
+		TagKeys:     []tag.Key{KeyName, KeyOperation},
+		Aggregation: view.Sum(),
+	}
+	MigrationPhaseView = &view.View{
+		Measure:     MigrationPhase,
+		TagKeys:     []tag.Key{KeyName},
+		Aggregation: view.LastValue(),
+	}
 	STIFindProvsView = &view.View{
 		Measure:     STIFindProvs,
 		TagKeys:     []tag.Key{KeyName, KeyStatus},
@@ -346,13 +676,23 @@ var DefaultViews = []*view.View{
 	UniquePeersView,
 	RoutingTableSizeView,
 	IPNSRecordsView,
+	PublicKeyRecordsView,
+	IPNSPrefetchesView,
+	IPNSPrefetchDurationView,
+	IPNSPrefetchValidityView,
+	IPNSPrefetchesPendingView,
 	ProviderRecordsView,
+	ProviderRecordsByOriginView,
 	STIFindProvsView,
 	STIFindProvsDurationView,
 	STIFindProvsLengthView,
 	STIFindProvsEmptyView,
 	ProviderRecordsPerKeyView,
 	PrefetchesView,
+	PrefetchSourceLookupsView,
+	PrefetchSourceProvidersView,
+	PrefetchRefreshesView,
+	PrefetchPriorityView,
 	PrefetchDurationMillisView,
 	PrefetchNegativeCacheHitsView,
 	PrefetchNegativeCacheSizeView,
@@ -360,10 +700,43 @@ var DefaultViews = []*view.View{
 	PrefetchFailedToCacheView,
 	PrefetchesPendingView,
 	PrefetchesPendingLimitView,
+	PrefetchWorkersView,
+	PrefetchWorkerUtilizationView,
 	AWSRequestsView,
 	AWSRequestsDurationView,
 	AWSRequestRetriesView,
 	ProviderDDBCollisionsView,
+	ProviderCacheLookupsView,
+	ProviderCacheWriteErrorsView,
+	CombinedBackendRequestsView,
+	CombinedBackendRequestDurationView,
+	ProviderStoreRequestsView,
+	ProviderStoreRequestDurationView,
+	ProviderStoreResultSizeView,
+	HTTPEndpointRequestsView,
+	HTTPEndpointRequestDurationView,
+	HTTPEndpointBreakerStateView,
+	ProviderAddrResolutionsView,
+	ProviderAddrsFilteredView,
+	ShardForwardsView,
+	ShardHeartbeatsView,
+	ShardMembersView,
+	ShardKeyspaceShareView,
+	ReplicationSentView,
+	ReplicationReceivedView,
+	ReplicationLagView,
+	ReplicationQueueView,
+	ReplicationBytesView,
+	ReplicationDiffRangesView,
+	ProviderKeyspaceFilteredView,
+	DenylistEntriesView,
+	DenylistBlockedView,
+	ProviderRecordsThrottledView,
+	ThrottledPeersView,
+	MigrationBackfilledView,
+	MigrationLagView,
+	MigrationErrorsView,
+	MigrationPhaseView,
 	// DHT views
 	ReceivedMessagesView,
 	ReceivedMessageErrorsView,
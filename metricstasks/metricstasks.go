@@ -13,28 +13,46 @@ import (
 
 	"github.com/libp2p/hydra-booster/metrics"
 	"github.com/libp2p/hydra-booster/periodictasks"
+	hproviders "github.com/libp2p/hydra-booster/providers"
 	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
-func countProviderRecordsExactly(ctx context.Context, datastore ds.Datastore) error {
+// originCountRuns is the number of runs of the provider records task between two counts of the provider records by
+// origin, which read the values of all the records instead of only their keys.
+const originCountRuns = 12
+
+// countProviderRecordsExactly counts the provider records in the datastore, and by origin if byOrigin is set.
+func countProviderRecordsExactly(ctx context.Context, datastore ds.Datastore, byOrigin bool) error {
 	fmt.Println("counting provider records")
-	prs, err := datastore.Query(ctx, query.Query{Prefix: "/providers", KeysOnly: true})
+	prs, err := datastore.Query(ctx, query.Query{Prefix: "/providers", KeysOnly: !byOrigin})
 	if err != nil {
 		return err
 	}
 	defer prs.Close()
 
 	// TODO: make fast https://github.com/libp2p/go-libp2p-kad-dht/issues/487
-	var provRecords int
+	var provRecords int64
+	origins := map[hproviders.RecordOrigin]int64{}
 	for {
 		select {
 		case r, ok := <-prs.Next():
 			if !ok {
-				stats.Record(ctx, metrics.ProviderRecords.M(int64(provRecords)))
+				stats.Record(ctx, metrics.ProviderRecords.M(provRecords))
+				if byOrigin {
+					for _, origin := range hproviders.RecordOrigins {
+						stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyOrigin, origin.String())}, metrics.ProviderRecordsByOrigin.M(origins[origin]))
+					}
+				}
 				return nil
 			}
 			if r.Error == nil {
 				provRecords++
+				if byOrigin {
+					// records whose value cannot be parsed are counted as announced
+					_, origin, _ := hproviders.DecodeDatastoreValue(r.Value)
+					origins[origin]++
+				}
 			}
 		case <-ctx.Done():
 			return nil
@@ -74,14 +92,39 @@ func recordFromProviderRecordCounter(ctx context.Context, c providerRecordCounte
 	return nil
 }
 
+// providerStoreWrapper is implemented by provider stores that wrap another provider store.
+type providerStoreWrapper interface {
+	Unwrap() providers.ProviderStore
+}
+
+// findProviderRecordCounter finds a providerRecordCounter in the given chain of wrapped provider stores.
+func findProviderRecordCounter(providerstore providers.ProviderStore) (providerRecordCounter, bool) {
+	for {
+		if counter, ok := providerstore.(providerRecordCounter); ok {
+			return counter, true
+		}
+		wrapper, ok := providerstore.(providerStoreWrapper)
+		if !ok {
+			return nil, false
+		}
+		providerstore = wrapper.Unwrap()
+	}
+}
+
 func NewProviderRecordsTask(datastore ds.Datastore, providerstore providers.ProviderStore, d time.Duration) periodictasks.PeriodicTask {
 	var task func(ctx context.Context) error
 	if pgBackend, ok := datastore.(hydrads.WithPgxPool); ok {
 		task = func(ctx context.Context) error { return countProviderRecordsApproximately(ctx, pgBackend.PgxPool()) }
-	} else if counter, ok := providerstore.(providerRecordCounter); ok {
+	} else if counter, ok := findProviderRecordCounter(providerstore); ok {
 		task = func(ctx context.Context) error { return recordFromProviderRecordCounter(ctx, counter) }
 	} else {
-		task = func(ctx context.Context) error { return countProviderRecordsExactly(ctx, datastore) }
+		// the records are counted by origin on the first run, then every originCountRuns runs
+		var runs int
+		task = func(ctx context.Context) error {
+			byOrigin := runs%originCountRuns == 0
+			runs++
+			return countProviderRecordsExactly(ctx, datastore, byOrigin)
+		}
 	}
 	return periodictasks.PeriodicTask{
 		Interval: d,
@@ -109,19 +152,20 @@ func NewUniquePeersTask(getUniquePeersCount func() uint64, d time.Duration) peri
 	}
 }
 
-type entryCounter interface {
-	EntryCount(context.Context) (uint64, error)
+type valueRecordCounter interface {
+	CountRecords(context.Context) (map[string]int64, error)
 }
 
-func NewIPNSRecordsTask(c entryCounter, d time.Duration) periodictasks.PeriodicTask {
+// NewValueRecordsTask counts the IPNS and public key records of the value store.
+func NewValueRecordsTask(c valueRecordCounter, d time.Duration) periodictasks.PeriodicTask {
 	return periodictasks.PeriodicTask{
 		Interval: d,
 		Run: func(ctx context.Context) error {
-			count, err := c.EntryCount(ctx)
+			counts, err := c.CountRecords(ctx)
 			if err != nil {
 				return err
 			}
-			stats.Record(ctx, metrics.IPNSRecords.M(int64(count)))
+			stats.Record(ctx, metrics.IPNSRecords.M(counts["ipns"]), metrics.PublicKeyRecords.M(counts["pk"]))
 			return nil
 		},
 	}
@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
 	"go.opencensus.io/stats/view"
 )
 
@@ -28,17 +30,25 @@ func TestNewProviderRecordsTask(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
+	prefetched := rand.Intn(100) + 1
+	for i := 0; i < prefetched; i++ {
+		err := ds.Put(ctx, datastore.NewKey(fmt.Sprintf("/providers/prefetched-%d", i)), hproviders.EncodeDatastoreValue(time.Now(), hproviders.OriginPrefetched))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	pt := NewProviderRecordsTask(ds, nil, time.Second)
+	qds := &queryRecordingDatastore{Datastore: ds}
+	pt := NewProviderRecordsTask(qds, nil, time.Second)
 
 	if pt.Interval != time.Second {
 		t.Fatal("invalid interval")
 	}
 
-	if err := view.Register(metrics.ProviderRecordsView); err != nil {
+	if err := view.Register(metrics.ProviderRecordsView, metrics.ProviderRecordsByOriginView); err != nil {
 		t.Fatal(err)
 	}
-	defer view.Unregister(metrics.ProviderRecordsView)
+	defer view.Unregister(metrics.ProviderRecordsView, metrics.ProviderRecordsByOriginView)
 
 	err := pt.Run(ctx)
 	if err != nil {
@@ -54,15 +64,60 @@ func TestNewProviderRecordsTask(t *testing.T) {
 		t.Fatal("no data was recorded")
 	}
 
-	data := rows[0].Data
-	dis, ok := data.(*view.LastValueData)
+	dis, ok := rows[0].Data.(*view.LastValueData)
 	if !ok {
-		t.Fatalf("want LastValueData, got %+v\n", data)
+		t.Fatalf("want LastValueData, got %+v\n", rows[0].Data)
 	}
-
-	if int(dis.Value) != count {
+	if int(dis.Value) != count+prefetched {
 		t.Fatal("incorrect value recorded")
 	}
+
+	// the records are counted by origin on the first run
+	rows, err = view.RetrieveData(metrics.ProviderRecordsByOriginView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		hproviders.OriginAnnounced.String():  count,
+		hproviders.OriginPrefetched.String(): prefetched,
+		hproviders.OriginImported.String():   0,
+		hproviders.OriginReplicated.String(): 0,
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("want %d rows, got %d", len(want), len(rows))
+	}
+	for _, row := range rows {
+		dis, ok := row.Data.(*view.LastValueData)
+		if !ok {
+			t.Fatalf("want LastValueData, got %+v\n", row.Data)
+		}
+		origin := row.Tags[0].Value
+		if int(dis.Value) != want[origin] {
+			t.Fatalf("incorrect value recorded for origin %q", origin)
+		}
+	}
+	if qds.keysOnly {
+		t.Fatal("want the values read to count the records by origin")
+	}
+
+	// and only their keys are read on the following runs
+	if err := pt.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !qds.keysOnly {
+		t.Fatal("want only the keys read")
+	}
+}
+
+// queryRecordingDatastore records whether the last query only read keys.
+type queryRecordingDatastore struct {
+	datastore.Datastore
+	keysOnly bool
+}
+
+func (d *queryRecordingDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	d.keysOnly = q.KeysOnly
+	return d.Datastore.Query(ctx, q)
 }
 
 func TestNewRoutingTableSizeTask(t *testing.T) {
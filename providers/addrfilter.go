@@ -0,0 +1,176 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// AddrFilterPolicy determines which addresses of providers an AddrFilterProviderStore keeps.
+type AddrFilterPolicy string
+
+const (
+	// AddrFilterPublic only keeps publicly routable IP addresses and DNS addresses.
+	AddrFilterPublic AddrFilterPolicy = "public"
+	// AddrFilterPrivate also keeps private, link-local and other unroutable addresses, for use in private networks.
+	// Loopback, unspecified and malformed addresses are still removed.
+	AddrFilterPrivate AddrFilterPolicy = "private"
+	// AddrFilterNone keeps all the addresses.
+	AddrFilterNone AddrFilterPolicy = "none"
+)
+
+// Reasons an address is filtered.
+const (
+	addrMalformed   = "malformed"
+	addrLoopback    = "loopback"
+	addrUnspecified = "unspecified"
+	addrLinkLocal   = "link-local"
+	addrPrivate     = "private"
+	addrUnroutable  = "unroutable"
+)
+
+// ParseAddrFilterPolicy parses an address filter policy string, as accepted on the command line.
+func ParseAddrFilterPolicy(s string) (AddrFilterPolicy, error) {
+	switch AddrFilterPolicy(s) {
+	case AddrFilterPublic, AddrFilterPrivate, AddrFilterNone:
+		return AddrFilterPolicy(s), nil
+	case "":
+		return AddrFilterNone, nil
+	}
+	return "", fmt.Errorf("unknown address filter policy %q, expected %q, %q or %q", s, AddrFilterPublic, AddrFilterPrivate, AddrFilterNone)
+}
+
+// filterReason returns why the policy removes the address, or an empty string if the address is kept.
+func (p AddrFilterPolicy) filterReason(a multiaddr.Multiaddr) string {
+	if p == AddrFilterNone {
+		return ""
+	}
+	reason := classifyAddr(a)
+	switch reason {
+	case addrLinkLocal, addrPrivate, addrUnroutable:
+		if p == AddrFilterPrivate {
+			return ""
+		}
+	}
+	return reason
+}
+
+// classifyAddr returns why the address is not a public address, or an empty string if it is public.
+func classifyAddr(a multiaddr.Multiaddr) string {
+	if a == nil {
+		return addrMalformed
+	}
+	if _, err := multiaddr.NewMultiaddrBytes(a.Bytes()); err != nil {
+		return addrMalformed
+	}
+	first, _ := multiaddr.SplitFirst(a)
+	if first == nil {
+		return addrMalformed
+	}
+	switch first.Protocol().Code {
+	case multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_IP6ZONE:
+		switch {
+		case manet.IsIPLoopback(a):
+			return addrLoopback
+		case manet.IsIPUnspecified(a):
+			return addrUnspecified
+		case manet.IsIP6LinkLocal(a) || first.Protocol().Code == multiaddr.P_IP6ZONE:
+			return addrLinkLocal
+		case manet.IsPrivateAddr(a):
+			return addrPrivate
+		case !manet.IsPublicAddr(a):
+			return addrUnroutable
+		}
+		return ""
+	case multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6, multiaddr.P_DNSADDR:
+		host := strings.ToLower(strings.TrimSuffix(first.Value(), "."))
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return addrLoopback
+		}
+		return ""
+	}
+	// addresses that are not reachable over IP, such as unix sockets
+	return addrUnroutable
+}
+
+// AddrFilterProviderStore is a provider store removing the addresses of providers that its Policy doesn't keep,
+// both from the provider records added to it and from the providers it returns.
+// Providers are kept even if all their addresses are removed.
+type AddrFilterProviderStore struct {
+	Delegate providers.ProviderStore
+	Policy   AddrFilterPolicy
+}
+
+func NewAddrFilterProviderStore(delegate providers.ProviderStore, policy AddrFilterPolicy) *AddrFilterProviderStore {
+	return &AddrFilterProviderStore{Delegate: delegate, Policy: policy}
+}
+
+func (s *AddrFilterProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if addrs, ok := s.filterAddrs(ctx, "AddProvider", prov.Addrs); !ok {
+		prov = peer.AddrInfo{ID: prov.ID, Addrs: addrs}
+	}
+	return s.Delegate.AddProvider(ctx, key, prov)
+}
+
+func (s *AddrFilterProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	provs, err := s.Delegate.GetProviders(ctx, key)
+	if err != nil {
+		return provs, err
+	}
+	var filtered []peer.AddrInfo
+	for i, p := range provs {
+		addrs, ok := s.filterAddrs(ctx, "GetProviders", p.Addrs)
+		if ok {
+			continue
+		}
+		if filtered == nil {
+			// don't filter in place, the slice may be shared with a cache
+			filtered = make([]peer.AddrInfo, len(provs))
+			copy(filtered, provs)
+		}
+		filtered[i] = peer.AddrInfo{ID: p.ID, Addrs: addrs}
+	}
+	if filtered == nil {
+		return provs, nil
+	}
+	return filtered, nil
+}
+
+// filterAddrs returns the addresses kept by the policy, and true if all the addresses are kept.
+func (s *AddrFilterProviderStore) filterAddrs(ctx context.Context, operation string, addrs []multiaddr.Multiaddr) ([]multiaddr.Multiaddr, bool) {
+	var kept []multiaddr.Multiaddr
+	removed := false
+	for i, a := range addrs {
+		reason := s.Policy.filterReason(a)
+		if reason == "" {
+			if removed {
+				kept = append(kept, a)
+			}
+			continue
+		}
+		if !removed {
+			kept = append(kept, addrs[:i]...)
+			removed = true
+		}
+		stats.RecordWithTags(ctx,
+			[]tag.Mutator{tag.Upsert(metrics.KeyOperation, operation), tag.Upsert(metrics.KeyReason, reason)},
+			metrics.ProviderAddrsFiltered.M(1),
+		)
+	}
+	if !removed {
+		return addrs, true
+	}
+	return kept, false
+}
+
+func (s *AddrFilterProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
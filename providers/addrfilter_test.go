@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyAddr(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":                   "",
+		"/ip6/2001:4860:4860::8888/udp/4001/quic": "",
+		"/dns4/example.com/tcp/443/wss":           "",
+		"/dnsaddr/bootstrap.libp2p.io":            "",
+		"/ip4/127.0.0.1/tcp/4001":                 addrLoopback,
+		"/ip6/::1/tcp/4001":                       addrLoopback,
+		"/dns/localhost/tcp/4001":                 addrLoopback,
+		"/ip4/0.0.0.0/tcp/4001":                   addrUnspecified,
+		"/ip6/fe80::1/tcp/4001":                   addrLinkLocal,
+		"/ip4/192.168.1.10/tcp/4001":              addrPrivate,
+		"/ip4/10.0.0.1/udp/4001/quic":             addrPrivate,
+		"/ip4/192.0.2.1/tcp/4001":                 addrUnroutable,
+		"/unix/tmp/hydra.sock":                    addrUnroutable,
+	}
+	for addr, reason := range cases {
+		assert.Equal(t, reason, classifyAddr(multiaddr.StringCast(addr)), addr)
+	}
+	assert.Equal(t, addrMalformed, classifyAddr(nil))
+}
+
+func TestAddrFilterProviderStore(t *testing.T) {
+	ctx := context.Background()
+	public := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	private := multiaddr.StringCast("/ip4/192.168.1.10/tcp/4001")
+	loopback := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	all := []multiaddr.Multiaddr{loopback, public, private}
+
+	cases := []struct {
+		policy   AddrFilterPolicy
+		expAddrs []multiaddr.Multiaddr
+	}{
+		{policy: AddrFilterPublic, expAddrs: []multiaddr.Multiaddr{public}},
+		{policy: AddrFilterPrivate, expAddrs: []multiaddr.Multiaddr{public, private}},
+		{policy: AddrFilterNone, expAddrs: all},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			delegate := &mockProviderStore{}
+			s := NewAddrFilterProviderStore(delegate, c.policy)
+
+			// addresses are filtered on write
+			assert.NoError(t, s.AddProvider(ctx, []byte("key"), peer.AddrInfo{ID: "peer", Addrs: all}))
+			assert.Equal(t, []peer.AddrInfo{{ID: "peer", Addrs: c.expAddrs}}, delegate.providers["key"])
+
+			// and on read, without modifying the providers of the delegate
+			delegate.providers["stored"] = []peer.AddrInfo{{ID: "peer", Addrs: all}, {ID: "loopback-only", Addrs: []multiaddr.Multiaddr{loopback}}}
+			provs, err := s.GetProviders(ctx, []byte("stored"))
+			assert.NoError(t, err)
+			assert.Equal(t, c.expAddrs, provs[0].Addrs)
+			assert.Equal(t, peer.ID("loopback-only"), provs[1].ID)
+			assert.Equal(t, all, delegate.providers["stored"][0].Addrs)
+		})
+	}
+
+	_, err := ParseAddrFilterPolicy("everything")
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+const (
+	// AdmissionInfrequent is the reason a prefetch is rejected when its key was not requested often enough.
+	AdmissionInfrequent = "rejected-infrequent"
+	// AdmissionRateLimited is the reason a prefetch is rejected when the prefetch rate of the hydra is exceeded.
+	AdmissionRateLimited = "rejected-rate-limited"
+
+	// DefaultAdmissionSketchWidth is the number of counters per row of the sketches counting requests for admission.
+	DefaultAdmissionSketchWidth = 1 << 16
+)
+
+// AdmissionPolicy decides whether the providers of a key that were not found locally are prefetched.
+// Policies cannot tell which peer requested the key, since the DHT doesn't pass it to the provider store.
+type AdmissionPolicy interface {
+	// Admit returns whether the key may be prefetched, and the reason if it may not.
+	// It is called for every request of a key that is not already being prefetched.
+	Admit(ctx context.Context, key []byte) (bool, string)
+}
+
+// AdmissionPolicies admits prefetches admitted by all of its policies, which are checked in order until one rejects the prefetch.
+// Policies consuming a budget, such as rate limits, should come last so that rejected prefetches don't use up their budget.
+type AdmissionPolicies []AdmissionPolicy
+
+func (p AdmissionPolicies) Admit(ctx context.Context, key []byte) (bool, string) {
+	for _, policy := range p {
+		if ok, reason := policy.Admit(ctx, key); !ok {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+// tokenBucket is a token bucket rate limit, which is not thread safe.
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// take refills the bucket and takes a token from it, returning false if the bucket is empty.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.lastRefill).Seconds()*rate)
+	b.lastRefill = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// MinRequestsAdmission admits the prefetches of keys that were requested at least MinRequests times within the last Window,
+// so that keys requested once, such as by scanning traffic, are not prefetched.
+//
+// Requests are counted with two count-min sketches of bounded size: one for the current window and one for the previous
+// window, whose count is weighted by how much of it overlaps the last Window. Counts may be overestimated because of
+// collisions, admitting some keys requested fewer times.
+type MinRequestsAdmission struct {
+	MinRequests int
+	Window      time.Duration
+
+	mut         sync.Mutex
+	width       int
+	current     *frequencySketch
+	previous    *frequencySketch
+	windowStart time.Time
+	clock       clock.Clock
+}
+
+// NewMinRequestsAdmission creates a MinRequestsAdmission whose sketches have rows of the given width.
+func NewMinRequestsAdmission(minRequests int, window time.Duration, width int) (*MinRequestsAdmission, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("the admission window must be positive, got %s", window)
+	}
+	clk := clock.New()
+	return &MinRequestsAdmission{
+		MinRequests: minRequests,
+		Window:      window,
+		width:       width,
+		current:     newWindowSketch(width),
+		previous:    newWindowSketch(width),
+		windowStart: clk.Now(),
+		clock:       clk,
+	}, nil
+}
+
+// newWindowSketch creates a sketch counting requests for a whole window, without halving its counters.
+func newWindowSketch(width int) *frequencySketch {
+	s := newFrequencySketch(width)
+	s.decayAt = math.MaxInt
+	return s
+}
+
+func (a *MinRequestsAdmission) Admit(ctx context.Context, key []byte) (bool, string) {
+	now := a.clock.Now()
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if elapsed := now.Sub(a.windowStart); elapsed >= 2*a.Window {
+		a.previous = newWindowSketch(a.width)
+		a.current = newWindowSketch(a.width)
+		a.windowStart = now
+	} else if elapsed >= a.Window {
+		a.previous = a.current
+		a.current = newWindowSketch(a.width)
+		a.windowStart = a.windowStart.Add(a.Window)
+	}
+
+	ks := string(key)
+	count := float64(a.current.Increment(ks))
+	previousWeight := 1 - float64(now.Sub(a.windowStart))/float64(a.Window)
+	count += float64(a.previous.Estimate(ks)) * previousWeight
+	if count < float64(a.MinRequests) {
+		return false, AdmissionInfrequent
+	}
+	return true, ""
+}
+
+// RateAdmission caps the rate of prefetches, using a token bucket. A single RateAdmission is meant to be shared by all
+// the heads of a hydra, so that the cap applies hydra-wide.
+type RateAdmission struct {
+	Rate  float64
+	Burst int
+
+	mut    sync.Mutex
+	bucket tokenBucket
+	clock  clock.Clock
+}
+
+func NewRateAdmission(rate float64, burst int) (*RateAdmission, error) {
+	if burst < 1 {
+		return nil, fmt.Errorf("the prefetch burst must be at least 1, got %d", burst)
+	}
+	clk := clock.New()
+	return &RateAdmission{
+		Rate:   rate,
+		Burst:  burst,
+		bucket: tokenBucket{tokens: float64(burst), lastRefill: clk.Now()},
+		clock:  clk,
+	}, nil
+}
+
+func (a *RateAdmission) Admit(ctx context.Context, key []byte) (bool, string) {
+	now := a.clock.Now()
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if !a.bucket.take(now, a.Rate, a.Burst) {
+		return false, AdmissionRateLimited
+	}
+	return true, ""
+}
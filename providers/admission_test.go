@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestMinRequestsAdmission(t *testing.T) {
+	ctx := context.Background()
+	a, err := NewMinRequestsAdmission(3, time.Minute, 1024)
+	assert.NoError(t, err)
+	clk := clock.NewMock()
+	a.clock = clk
+	a.windowStart = clk.Now()
+
+	assertAdmit := func(key string, want bool) {
+		t.Helper()
+		ok, reason := a.Admit(ctx, []byte(key))
+		assert.Equal(t, want, ok)
+		if !want {
+			assert.Equal(t, AdmissionInfrequent, reason)
+		}
+	}
+
+	assertAdmit("key", false)
+	assertAdmit("key", false)
+	assertAdmit("key", true)
+	assertAdmit("other", false)
+
+	// requests of the previous window count for the part of it within the last window
+	clk.Add(90 * time.Second)
+	// 3 previous requests weighted by 1/2, plus this one
+	assertAdmit("key", false)
+	assertAdmit("key", true)
+
+	// requests older than two windows are forgotten
+	clk.Add(3 * time.Minute)
+	assertAdmit("key", false)
+
+	_, err = NewMinRequestsAdmission(3, 0, 1024)
+	assert.Error(t, err)
+}
+
+func TestRateAdmission(t *testing.T) {
+	ctx := context.Background()
+	a, err := NewRateAdmission(2, 2)
+	assert.NoError(t, err)
+	clk := clock.NewMock()
+	a.clock = clk
+	a.bucket.lastRefill = clk.Now()
+
+	for i := 0; i < 2; i++ {
+		ok, _ := a.Admit(ctx, []byte("key"))
+		assert.True(t, ok)
+	}
+	ok, reason := a.Admit(ctx, []byte("key"))
+	assert.False(t, ok)
+	assert.Equal(t, AdmissionRateLimited, reason)
+
+	clk.Add(500 * time.Millisecond)
+	ok, _ = a.Admit(ctx, []byte("key"))
+	assert.True(t, ok)
+	ok, _ = a.Admit(ctx, []byte("key"))
+	assert.False(t, ok)
+}
+
+func TestAsyncProvidersFinder_Admission(t *testing.T) {
+	views := []*view.View{metrics.PrefetchesView}
+	view.Register(views...)
+	defer view.Unregister(views...)
+
+	ctx := context.Background()
+	// the workers are not run, so requests stay queued
+	finder := NewAsyncProvidersFinder(10*time.Second, 10, newTestNegativeCache(t, time.Hour))
+	minRequests, err := NewMinRequestsAdmission(2, time.Minute, 1024)
+	assert.NoError(t, err)
+	rate, err := NewRateAdmission(1, 1)
+	assert.NoError(t, err)
+	finder.Admission = AdmissionPolicies{minRequests, rate}
+	router := &mockRouter{}
+	onProvider := func(peer.AddrInfo) {}
+
+	assert.NoError(t, finder.Find(ctx, router, []byte("key1"), onProvider))
+	assert.NoError(t, finder.Find(ctx, router, []byte("key1"), onProvider))
+	assert.NoError(t, finder.Find(ctx, router, []byte("key2"), onProvider))
+	assert.NoError(t, finder.Find(ctx, router, []byte("key2"), onProvider))
+
+	assert.Equal(t, map[string]bool{"key1": true}, finder.pending)
+
+	rows, err := view.RetrieveData(metrics.Prefetches.Name())
+	assert.NoError(t, err)
+	assert.True(t, subsetRowVals([]view.Row{
+		{Data: &view.SumData{Value: 2}, Tags: []tag.Tag{{Key: metrics.KeyStatus, Value: AdmissionInfrequent}}},
+		{Data: &view.SumData{Value: 1}, Tags: []tag.Tag{{Key: metrics.KeyStatus, Value: AdmissionRateLimited}}},
+	}, rows))
+}
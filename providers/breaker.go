@@ -0,0 +1,163 @@
+package providers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+const (
+	// DefaultBreakerFailureThreshold is the default number of consecutive failures that open a circuit breaker.
+	DefaultBreakerFailureThreshold = 5
+	// DefaultBreakerOpenDuration is the default time a circuit breaker stays open before probing.
+	DefaultBreakerOpenDuration = 30 * time.Second
+	// DefaultBreakerHalfOpenProbes is the default number of concurrent probe requests of a half-open circuit breaker.
+	DefaultBreakerHalfOpenProbes = 1
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets a limited number of probe requests through, to find out whether the failures are over.
+	BreakerHalfOpen
+	// BreakerOpen rejects all requests.
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerPolicy configures a CircuitBreaker.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that open the breaker.
+	FailureThreshold int
+	// OpenDuration is how long the breaker rejects requests before letting probe requests through.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of probe requests let through at once while half open.
+	HalfOpenProbes int
+}
+
+// DefaultBreakerPolicy returns the default circuit breaker policy.
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		FailureThreshold: DefaultBreakerFailureThreshold,
+		OpenDuration:     DefaultBreakerOpenDuration,
+		HalfOpenProbes:   DefaultBreakerHalfOpenProbes,
+	}
+}
+
+// CircuitBreaker stops sending requests to a failing backend, so that callers fail fast instead of waiting for it to time out.
+// It opens after FailureThreshold consecutive failures, and after OpenDuration lets HalfOpenProbes probe requests through:
+// the breaker closes if a probe succeeds and opens again if it fails.
+//
+// Callers ask Allow before each request, and report its outcome with Success, Failure or Abandon. It is thread safe.
+type CircuitBreaker struct {
+	Policy BreakerPolicy
+
+	mut      sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	clock    clock.Clock
+	// onStateChange is called with the new state when the state changes, while holding the lock.
+	onStateChange func(BreakerState)
+}
+
+func NewCircuitBreaker(policy BreakerPolicy) (*CircuitBreaker, error) {
+	if policy.FailureThreshold < 1 {
+		return nil, fmt.Errorf("the circuit breaker failure threshold must be at least 1, got %d", policy.FailureThreshold)
+	}
+	if policy.HalfOpenProbes < 1 {
+		return nil, fmt.Errorf("the circuit breaker must allow at least 1 half-open probe, got %d", policy.HalfOpenProbes)
+	}
+	return &CircuitBreaker{Policy: policy, clock: clock.New()}, nil
+}
+
+// State returns the state of the breaker. An open breaker whose OpenDuration elapsed is reported half open.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.state == BreakerOpen && !b.clock.Now().Before(b.openedAt.Add(b.Policy.OpenDuration)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Allow returns whether a request may be sent. Requests that were allowed must report their outcome.
+func (b *CircuitBreaker) Allow() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.clock.Now().Before(b.openedAt.Add(b.Policy.OpenDuration)) {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.Policy.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Success reports that an allowed request succeeded.
+func (b *CircuitBreaker) Success() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.setState(BreakerClosed)
+	}
+}
+
+// Failure reports that an allowed request failed.
+func (b *CircuitBreaker) Failure() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.Policy.FailureThreshold) {
+		b.openedAt = b.clock.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// Abandon reports that an allowed request was canceled before its outcome was known, e.g. because the caller gave up.
+func (b *CircuitBreaker) Abandon() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *CircuitBreaker) setState(s BreakerState) {
+	if s == b.state {
+		return
+	}
+	b.state = s
+	b.probes = 0
+	if s == BreakerClosed {
+		b.failures = 0
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(s)
+	}
+}
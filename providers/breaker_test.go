@@ -0,0 +1,54 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b, err := NewCircuitBreaker(BreakerPolicy{FailureThreshold: 2, OpenDuration: time.Minute, HalfOpenProbes: 1})
+	assert.NoError(t, err)
+	clk := clock.NewMock()
+	b.clock = clk
+	var states []BreakerState
+	b.onStateChange = func(s BreakerState) { states = append(states, s) }
+
+	// a success resets the consecutive failures
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+
+	assert.True(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// once open for long enough, a single probe is let through, and its failure opens the breaker again
+	clk.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+
+	// an abandoned probe lets another probe through, whose success closes the breaker
+	clk.Add(time.Minute)
+	assert.True(t, b.Allow())
+	b.Abandon()
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.True(t, b.Allow())
+
+	assert.Equal(t, []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+
+	_, err = NewCircuitBreaker(BreakerPolicy{FailureThreshold: 0, HalfOpenProbes: 1})
+	assert.Error(t, err)
+}
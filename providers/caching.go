@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"time"
 
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
@@ -12,11 +13,15 @@ import (
 
 // CachingProviderStore checks the ReadProviderStore for providers. If no providers are returned,
 // then the Finder is used to find providers, which are then added to the WriteProviderStore.
+// If a Revalidator is set, stale prefetched providers are returned and refreshed in the background.
+// Prefetched providers are added as prefetched records, expiring after PrefetchedTTL if set.
 type CachingProviderStore struct {
 	ReadProviderStore  providers.ProviderStore
 	WriteProviderStore providers.ProviderStore
 	Finder             ProvidersFinder
 	Router             ReadContentRouting
+	Revalidator        *Revalidator
+	PrefetchedTTL      time.Duration
 	log                logging.EventLogger
 }
 
@@ -30,12 +35,54 @@ func NewCachingProviderStore(getDelegate providers.ProviderStore, addDelegate pr
 	}
 }
 
+// AddProvider adds the provider record to the WriteProviderStore. The key now has a provider, so if the Finder remembers
+// that the key has no providers, it forgets it.
 func (s *CachingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
-	return s.WriteProviderStore.AddProvider(ctx, key, prov)
+	if err := s.WriteProviderStore.AddProvider(ctx, key, prov); err != nil {
+		return err
+	}
+	invalidate(s.Finder, key)
+	return nil
+}
+
+func invalidate(finder ProvidersFinder, key []byte) {
+	if f, ok := finder.(invalidatingProvidersFinder); ok {
+		f.Invalidate(key)
+	}
+}
+
+// InvalidatingProviderStore adds provider records to the Delegate, and makes the Finder forget that their keys have no providers.
+// It is used for the provider records that reach the hydra without going through the CachingProviderStore, such as the
+// records forwarded or replicated by other hydras.
+type InvalidatingProviderStore struct {
+	Delegate providers.ProviderStore
+	Finder   ProvidersFinder
+}
+
+func NewInvalidatingProviderStore(delegate providers.ProviderStore, finder ProvidersFinder) *InvalidatingProviderStore {
+	return &InvalidatingProviderStore{Delegate: delegate, Finder: finder}
+}
+
+func (s *InvalidatingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if err := s.Delegate.AddProvider(ctx, key, prov); err != nil {
+		return err
+	}
+	invalidate(s.Finder, key)
+	return nil
+}
+
+func (s *InvalidatingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	return s.Delegate.GetProviders(ctx, key)
+}
+
+// Unwrap returns the wrapped provider store.
+func (s *InvalidatingProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
 }
 
 // GetProviders gets providers for the given key from the providerstore.
 // If the providerstore does not have providers for the key, then the ProvidersFinder is queried and the results are cached.
+// If the providers were prefetched and are stale, they are returned and the ProvidersFinder is queried to refresh them.
 func (d *CachingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
 	addrInfos, err := d.ReadProviderStore.GetProviders(ctx, key)
 	if err != nil {
@@ -43,14 +90,28 @@ func (d *CachingProviderStore) GetProviders(ctx context.Context, key []byte) ([]
 	}
 
 	if len(addrInfos) > 0 {
+		if d.Revalidator != nil && d.Revalidator.ShouldRefresh(ctx, key) {
+			if err := d.find(ctx, key); err != nil {
+				d.log.Errorf("failed to refresh providers: %s", err)
+			}
+		}
 		return addrInfos, nil
 	}
 
-	return nil, d.Finder.Find(ctx, d.Router, key, func(ai peer.AddrInfo) {
-		err := d.WriteProviderStore.AddProvider(ctx, key, ai)
+	return nil, d.find(ctx, key)
+}
+
+// find queries the ProvidersFinder for the providers of the key, and adds them to the WriteProviderStore.
+func (d *CachingProviderStore) find(ctx context.Context, key []byte) error {
+	return d.Finder.Find(ctx, d.Router, key, func(ai peer.AddrInfo) {
+		err := d.WriteProviderStore.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, d.PrefetchedTTL), key, ai)
 		if err != nil {
 			d.log.Errorf("failed to add provider to providerstore: %s", err)
 			stats.Record(ctx, metrics.PrefetchFailedToCache.M(1))
+			return
+		}
+		if d.Revalidator != nil {
+			d.Revalidator.Fetched(key)
 		}
 	})
 }
@@ -2,45 +2,130 @@ package providers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
 	"github.com/multiformats/go-multiaddr"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
 var log = logging.Logger("hydra/providers")
 
+// CombineStrategy determines how a CombinedProviderStore combines the providers returned by its backends.
+type CombineStrategy string
+
+const (
+	// CombineAll queries all backends in parallel, waits for all of them and merges their results.
+	CombineAll CombineStrategy = "all"
+	// CombineFirst queries all backends in parallel and returns the first non-empty result.
+	CombineFirst CombineStrategy = "first"
+	// CombineHedged queries the backends in order, additionally querying the next backend whenever
+	// no non-empty result has been returned within the hedge delay. The first non-empty result is returned.
+	CombineHedged CombineStrategy = "hedged"
+	// CombineFallback queries the backends in order, only querying the next backend if the previous one failed or returned nothing.
+	CombineFallback CombineStrategy = "fallback"
+)
+
+// ParseCombineStrategy parses a combine strategy string, as accepted on the command line.
+func ParseCombineStrategy(s string) (CombineStrategy, error) {
+	switch CombineStrategy(s) {
+	case CombineAll, CombineFirst, CombineHedged, CombineFallback:
+		return CombineStrategy(s), nil
+	case "":
+		return CombineAll, nil
+	}
+	return "", fmt.Errorf("unknown combine strategy %q", s)
+}
+
+// CombinedBackend is a provider store combined with others by a CombinedProviderStore.
+type CombinedBackend struct {
+	// Name identifies the backend in metrics.
+	Name  string
+	Store providers.ProviderStore
+	// Timeout is the maximum duration of a single call to the backend, no timeout is applied if zero.
+	Timeout time.Duration
+}
+
+// CombineProviders combines the given provider stores, waiting for all of them and merging their results.
 func CombineProviders(backend ...providers.ProviderStore) providers.ProviderStore {
-	return &CombinedProviderStore{backends: backend}
+	backends := make([]CombinedBackend, len(backend))
+	for i, b := range backend {
+		backends[i] = CombinedBackend{Name: fmt.Sprintf("backend-%d", i), Store: b}
+	}
+	return &CombinedProviderStore{Strategy: CombineAll, backends: backends}
 }
 
+// NewCombinedProviderStore combines the given backends using the given strategy.
+// The hedge delay is only used by the CombineHedged strategy, which requires it to be positive.
+func NewCombinedProviderStore(strategy CombineStrategy, hedgeDelay time.Duration, backends ...CombinedBackend) (*CombinedProviderStore, error) {
+	if strategy == CombineHedged && hedgeDelay <= 0 {
+		return nil, fmt.Errorf("the hedged strategy requires a positive hedge delay, got %s", hedgeDelay)
+	}
+	return &CombinedProviderStore{
+		Strategy:   strategy,
+		HedgeDelay: hedgeDelay,
+		backends:   backends,
+	}, nil
+}
+
+// CombinedProviderStore is a provider store backed by multiple provider stores.
+// Providers are always added to all the backends, and GetProviders combines the backends according to the Strategy.
+// An error is only returned if all the queried backends fail.
 type CombinedProviderStore struct {
-	backends []providers.ProviderStore
+	Strategy   CombineStrategy
+	HedgeDelay time.Duration
+	backends   []CombinedBackend
 }
 
 func (s *CombinedProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
 	ch := make(chan error, len(s.backends))
 	for _, b := range s.backends {
-		go func(backend providers.ProviderStore) {
-			ch <- backend.AddProvider(ctx, key, prov)
+		go func(backend CombinedBackend) {
+			ch <- s.callBackend(ctx, backend, "AddProvider", func(ctx context.Context) error {
+				return backend.Store.AddProvider(ctx, key, prov)
+			})
 		}(b)
 	}
 	var errs *multierror.Error
 	for range s.backends {
 		if e := <-ch; e != nil {
-			multierror.Append(errs, e)
+			errs = multierror.Append(errs, e)
 		}
 	}
 	if len(errs.WrappedErrors()) > 0 {
 		log.Errorf("some providers returned errors (%v)", errs)
 	}
 	if len(errs.WrappedErrors()) == len(s.backends) {
-		return errs
-	} else {
-		return nil
+		return errs.ErrorOrNil()
 	}
+	return nil
+}
+
+// IterateProviderRecords enumerates the provider records of the backends that can enumerate them.
+// Records held by several backends are enumerated once per backend.
+func (s *CombinedProviderStore) IterateProviderRecords(ctx context.Context, fn func(ProviderRecord) error) error {
+	found := false
+	for _, b := range s.backends {
+		it, ok := FindProviderRecordIterator(b.Store)
+		if !ok {
+			continue
+		}
+		found = true
+		if err := it.IterateProviderRecords(ctx, fn); err != nil {
+			return fmt.Errorf("%s: %w", b.Name, err)
+		}
+	}
+	if !found {
+		return errors.New("none of the combined provider stores can enumerate their provider records")
+	}
+	return nil
 }
 
 type findProvidersAsyncResult struct {
@@ -49,11 +134,23 @@ type findProvidersAsyncResult struct {
 }
 
 func (s *CombinedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	switch s.Strategy {
+	case CombineFirst:
+		return s.getFirst(ctx, key, 0)
+	case CombineHedged:
+		return s.getFirst(ctx, key, s.HedgeDelay)
+	case CombineFallback:
+		return s.getFirst(ctx, key, -1)
+	default:
+		return s.getAll(ctx, key)
+	}
+}
+
+func (s *CombinedProviderStore) getAll(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
 	ch := make(chan findProvidersAsyncResult, len(s.backends))
 	for _, b := range s.backends {
-		go func(backend providers.ProviderStore) {
-			infos, err := backend.GetProviders(ctx, key)
-			ch <- findProvidersAsyncResult{AddrInfo: infos, Err: err}
+		go func(backend CombinedBackend) {
+			ch <- s.getFromBackend(ctx, backend, key)
 		}(b)
 	}
 	infos := []peer.AddrInfo{}
@@ -63,7 +160,7 @@ func (s *CombinedProviderStore) GetProviders(ctx context.Context, key []byte) ([
 		if r.Err == nil {
 			infos = append(infos, r.AddrInfo...)
 		} else {
-			multierror.Append(errs, r.Err)
+			errs = multierror.Append(errs, r.Err)
 		}
 	}
 	infos = mergeAddrInfos(infos)
@@ -71,10 +168,129 @@ func (s *CombinedProviderStore) GetProviders(ctx context.Context, key []byte) ([
 		log.Errorf("some providers returned errors (%v)", errs)
 	}
 	if len(errs.WrappedErrors()) == len(s.backends) {
-		return infos, errs
-	} else {
-		return infos, nil
+		return infos, errs.ErrorOrNil()
+	}
+	return infos, nil
+}
+
+// getFirst queries the backends in order and returns the first non-empty result.
+// A backend is queried when the previous one returned nothing, or when no result has been returned for hedgeDelay.
+// A zero hedge delay queries all the backends at once, and a negative hedge delay never queries backends concurrently.
+func (s *CombinedProviderStore) getFirst(ctx context.Context, key []byte, hedgeDelay time.Duration) ([]peer.AddrInfo, error) {
+	if len(s.backends) == 0 {
+		return nil, nil
+	}
+
+	// cancel the backends that are still running once we have a result
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan findProvidersAsyncResult, len(s.backends))
+	next, pending := 0, 0
+	var hedge *time.Timer
+	var hedgeC <-chan time.Time
+	defer func() {
+		if hedge != nil {
+			hedge.Stop()
+		}
+	}()
+	queryNext := func() {
+		backend := s.backends[next]
+		next++
+		pending++
+		go func() { ch <- s.getFromBackend(ctx, backend, key) }()
+
+		if hedge != nil {
+			hedge.Stop()
+		}
+		hedgeC = nil
+		if hedgeDelay > 0 && next < len(s.backends) {
+			hedge = time.NewTimer(hedgeDelay)
+			hedgeC = hedge.C
+		}
+	}
+
+	queryNext()
+	for hedgeDelay == 0 && next < len(s.backends) {
+		queryNext()
+	}
+
+	var errs *multierror.Error
+	for pending > 0 {
+		select {
+		case r := <-ch:
+			pending--
+			if r.Err != nil {
+				errs = multierror.Append(errs, r.Err)
+			} else if len(r.AddrInfo) > 0 {
+				return r.AddrInfo, nil
+			}
+			if next < len(s.backends) {
+				queryNext()
+			}
+		case <-hedgeC:
+			queryNext()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if len(errs.WrappedErrors()) > 0 {
+		log.Errorf("some providers returned errors (%v)", errs)
+	}
+	if len(errs.WrappedErrors()) == len(s.backends) {
+		return nil, errs.ErrorOrNil()
+	}
+	return nil, nil
+}
+
+func (s *CombinedProviderStore) getFromBackend(ctx context.Context, backend CombinedBackend, key []byte) findProvidersAsyncResult {
+	var infos []peer.AddrInfo
+	err := s.callBackend(ctx, backend, "GetProviders", func(ctx context.Context) error {
+		var err error
+		infos, err = backend.Store.GetProviders(ctx, key)
+		return err
+	})
+	return findProvidersAsyncResult{AddrInfo: infos, Err: err}
+}
+
+// callBackend calls the backend with its timeout applied, and records metrics about the call.
+func (s *CombinedProviderStore) callBackend(ctx context.Context, backend CombinedBackend, operation string, call func(ctx context.Context) error) error {
+	callCtx := ctx
+	if backend.Timeout > 0 {
+		var stop context.CancelFunc
+		callCtx, stop = context.WithTimeout(ctx, backend.Timeout)
+		defer stop()
+	}
+
+	start := time.Now()
+	err := call(callCtx)
+	duration := time.Since(start)
+
+	status := "succeeded"
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			// canceled by the caller, or by the combined store because another backend already returned a result
+			status = "canceled"
+		case errors.Is(err, context.DeadlineExceeded):
+			status = "timeout"
+		default:
+			status = "failed"
+		}
+		err = fmt.Errorf("%s: %w", backend.Name, err)
 	}
+	stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{
+			tag.Upsert(metrics.KeyBackend, backend.Name),
+			tag.Upsert(metrics.KeyOperation, operation),
+			tag.Upsert(metrics.KeyStatus, status),
+		},
+		metrics.CombinedBackendRequests.M(1),
+		metrics.CombinedBackendRequestDuration.M(float64(duration.Milliseconds())),
+	)
+	return err
 }
 
 func mergeAddrInfos(infos []peer.AddrInfo) []peer.AddrInfo {
@@ -0,0 +1,238 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// delayedProviderStore returns its providers after a delay, unless the context is done first.
+type delayedProviderStore struct {
+	delay     time.Duration
+	providers []peer.AddrInfo
+	err       error
+	calls     int32
+}
+
+func (d *delayedProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	atomic.AddInt32(&d.calls, 1)
+	return d.err
+}
+
+func (d *delayedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	atomic.AddInt32(&d.calls, 1)
+	select {
+	case <-time.After(d.delay):
+		return d.providers, d.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *delayedProviderStore) numCalls() int {
+	return int(atomic.LoadInt32(&d.calls))
+}
+
+func TestCombinedProviderStore_GetProviders(t *testing.T) {
+	peer1 := peer.AddrInfo{ID: peer.ID("peer1")}
+	peer2 := peer.AddrInfo{ID: peer.ID("peer2")}
+
+	cases := []struct {
+		name       string
+		strategy   CombineStrategy
+		hedgeDelay time.Duration
+		timeout    time.Duration
+		backends   []*delayedProviderStore
+
+		expProviders []peer.AddrInfo
+		expErr       bool
+		expCalls     []int
+	}{
+		{
+			name:     "all merges results from all backends",
+			strategy: CombineAll,
+			backends: []*delayedProviderStore{
+				{providers: []peer.AddrInfo{peer1}},
+				{delay: 50 * time.Millisecond, providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer1, peer2},
+			expCalls:     []int{1, 1},
+		},
+		{
+			name:     "all ignores failing backends",
+			strategy: CombineAll,
+			backends: []*delayedProviderStore{
+				{providers: []peer.AddrInfo{peer1}},
+				{err: errors.New("boom")},
+			},
+			expProviders: []peer.AddrInfo{peer1},
+			expCalls:     []int{1, 1},
+		},
+		{
+			name:     "all fails when all backends fail",
+			strategy: CombineAll,
+			backends: []*delayedProviderStore{
+				{err: errors.New("boom")},
+				{err: errors.New("bang")},
+			},
+			expErr:   true,
+			expCalls: []int{1, 1},
+		},
+		{
+			name:     "all applies per-backend timeouts",
+			strategy: CombineAll,
+			timeout:  50 * time.Millisecond,
+			backends: []*delayedProviderStore{
+				{providers: []peer.AddrInfo{peer1}},
+				{delay: time.Second, providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer1},
+			expCalls:     []int{1, 1},
+		},
+		{
+			name:     "first returns the fastest non-empty result",
+			strategy: CombineFirst,
+			backends: []*delayedProviderStore{
+				{delay: time.Second, providers: []peer.AddrInfo{peer1}},
+				{},
+				{delay: 10 * time.Millisecond, providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer2},
+			expCalls:     []int{1, 1, 1},
+		},
+		{
+			name:     "first returns nothing when all backends return nothing",
+			strategy: CombineFirst,
+			backends: []*delayedProviderStore{
+				{},
+				{err: errors.New("boom")},
+			},
+			expCalls: []int{1, 1},
+		},
+		{
+			name:       "hedged does not query the next backend if the first one is fast",
+			strategy:   CombineHedged,
+			hedgeDelay: time.Second,
+			backends: []*delayedProviderStore{
+				{providers: []peer.AddrInfo{peer1}},
+				{providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer1},
+			expCalls:     []int{1, 0},
+		},
+		{
+			name:       "hedged queries the next backend after the hedge delay",
+			strategy:   CombineHedged,
+			hedgeDelay: 10 * time.Millisecond,
+			backends: []*delayedProviderStore{
+				{delay: time.Second, providers: []peer.AddrInfo{peer1}},
+				{providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer2},
+			expCalls:     []int{1, 1},
+		},
+		{
+			name:       "hedged queries the next backend immediately on failure",
+			strategy:   CombineHedged,
+			hedgeDelay: time.Second,
+			backends: []*delayedProviderStore{
+				{err: errors.New("boom")},
+				{providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer2},
+			expCalls:     []int{1, 1},
+		},
+		{
+			name:     "fallback only queries the next backend when the previous one returned nothing",
+			strategy: CombineFallback,
+			backends: []*delayedProviderStore{
+				{err: errors.New("boom")},
+				{},
+				{delay: 50 * time.Millisecond, providers: []peer.AddrInfo{peer1}},
+				{providers: []peer.AddrInfo{peer2}},
+			},
+			expProviders: []peer.AddrInfo{peer1},
+			expCalls:     []int{1, 1, 1, 0},
+		},
+		{
+			name:     "fallback fails when all backends fail",
+			strategy: CombineFallback,
+			backends: []*delayedProviderStore{
+				{err: errors.New("boom")},
+				{err: errors.New("bang")},
+			},
+			expErr:   true,
+			expCalls: []int{1, 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+			defer stop()
+
+			var backends []CombinedBackend
+			for _, b := range c.backends {
+				backends = append(backends, CombinedBackend{Name: "mock", Store: b, Timeout: c.timeout})
+			}
+			ps, err := NewCombinedProviderStore(c.strategy, c.hedgeDelay, backends...)
+			assert.NoError(t, err)
+
+			provs, err := ps.GetProviders(ctx, []byte("mh"))
+			if c.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.ElementsMatch(t, c.expProviders, provs)
+
+			for i, b := range c.backends {
+				assert.Equal(t, c.expCalls[i], b.numCalls(), "calls to backend %d", i)
+			}
+		})
+	}
+}
+
+func TestCombinedProviderStore_AddProvider(t *testing.T) {
+	ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+
+	ok := &delayedProviderStore{}
+	failing := &delayedProviderStore{err: errors.New("boom")}
+
+	ps := CombineProviders(ok, failing)
+	err := ps.AddProvider(ctx, []byte("mh"), peer.AddrInfo{ID: peer.ID("peer1")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ok.numCalls())
+	assert.Equal(t, 1, failing.numCalls())
+
+	ps = CombineProviders(failing, failing)
+	err = ps.AddProvider(ctx, []byte("mh"), peer.AddrInfo{ID: peer.ID("peer1")})
+	assert.Error(t, err)
+}
+
+func TestCombinedProviderStore_NoBackends(t *testing.T) {
+	ctx := context.Background()
+	for _, strategy := range []CombineStrategy{CombineAll, CombineFirst, CombineHedged, CombineFallback} {
+		ps, err := NewCombinedProviderStore(strategy, time.Second)
+		assert.NoError(t, err)
+		// a nil *multierror.Error would be a non-nil error
+		assert.Nil(t, ps.AddProvider(ctx, []byte("mh"), peer.AddrInfo{ID: peer.ID("peer1")}), strategy)
+		_, err = ps.GetProviders(ctx, []byte("mh"))
+		assert.Nil(t, err, strategy)
+	}
+}
+
+func TestNewCombinedProviderStore_HedgeDelay(t *testing.T) {
+	_, err := NewCombinedProviderStore(CombineHedged, 0)
+	assert.Error(t, err)
+	_, err = NewCombinedProviderStore(CombineHedged, -time.Second)
+	assert.Error(t, err)
+	_, err = NewCombinedProviderStore(CombineFirst, 0)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,267 @@
+package providers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnlq715/golang-lru/simplelru"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-base32"
+)
+
+// DatastoreProviderStore is the default provider store of the DHT, keeping provider records in a datastore,
+// extended to enumerate its provider records and to track their origin.
+//
+// The value of a provider record in the datastore is the time it was added, followed by its origin unless it was announced.
+// The DHT ignores the origin when reading the time, so that records stay compatible with the DHT provider store.
+type DatastoreProviderStore struct {
+	*providers.ProviderManager
+	Datastore ds.Batching
+	self      peer.ID
+	peerstore peerstore.Peerstore
+	cache     *lockedProviderCache
+}
+
+// the default number of provider sets cached by the DHT provider store
+const defaultProviderManagerCacheSize = 256
+
+// ProviderManagerCache is the cache of provider sets of the DHT provider store.
+type ProviderManagerCache interface {
+	Add(key, value interface{}) bool
+	Get(key interface{}) (value interface{}, ok bool)
+	Contains(key interface{}) (ok bool)
+	Peek(key interface{}) (value interface{}, ok bool)
+	Remove(key interface{}) bool
+	RemoveOldest() (interface{}, interface{}, bool)
+	GetOldest() (interface{}, interface{}, bool)
+	Keys() []interface{}
+	Len() int
+	Purge()
+	Resize(int) int
+}
+
+// NewDatastoreProviderStore creates a DatastoreProviderStore caching provider sets in the given cache,
+// or in an LRU cache of the default size if it is nil.
+func NewDatastoreProviderStore(ctx context.Context, self peer.ID, ps peerstore.Peerstore, dstore ds.Batching, cache ProviderManagerCache, opts ...providers.Option) (*DatastoreProviderStore, error) {
+	if cache == nil {
+		c, err := simplelru.NewLRU(defaultProviderManagerCacheSize, nil)
+		if err != nil {
+			return nil, err
+		}
+		cache = c
+	}
+	// records which were not announced are written to the datastore directly, which invalidates their cached provider set
+	// from outside of the DHT provider store, so the cache must be thread safe
+	locked := &lockedProviderCache{cache: cache}
+	pm, err := providers.NewProviderManager(ctx, self, ps, dstore, append(opts, providers.Cache(locked))...)
+	if err != nil {
+		return nil, err
+	}
+	return &DatastoreProviderStore{ProviderManager: pm, Datastore: dstore, self: self, peerstore: ps, cache: locked}, nil
+}
+
+// AddProvider adds a provider record with the origin of the context. Announced records are added by the DHT provider store.
+// Other records are written to the datastore directly, dated so that they expire after their TTL and followed by their origin.
+// They never replace a live announced record, nor a record expiring later.
+func (s *DatastoreProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	origin, ttl := RecordOriginFromContext(ctx)
+	if origin == OriginAnnounced {
+		return s.ProviderManager.AddProvider(ctx, key, prov)
+	}
+	if prov.ID != s.self {
+		s.peerstore.AddAddrs(prov.ID, prov.Addrs, providers.ProviderAddrTTL)
+	}
+	t := time.Now()
+	if ttl > 0 && ttl < providers.ProvideValidity {
+		t = t.Add(ttl - providers.ProvideValidity)
+	}
+	dsKey := DatastoreProviderKey(key, prov.ID)
+	v, err := s.Datastore.Get(ctx, dsKey)
+	if err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	if err == nil && KeepDatastoreValue(v, t) {
+		return nil
+	}
+	if err := s.Datastore.Put(ctx, dsKey, EncodeDatastoreValue(t, origin)); err != nil {
+		return err
+	}
+	// the DHT provider store reloads the provider set from the datastore the next time it is read
+	s.cache.Remove(string(key))
+	return nil
+}
+
+// IterateProviderRecords enumerates the provider records in the datastore. The datastore doesn't hold the addresses of the providers,
+// so the records have none.
+func (s *DatastoreProviderStore) IterateProviderRecords(ctx context.Context, fn func(ProviderRecord) error) error {
+	return IterateDatastoreProviderRecords(ctx, s.Datastore, fn)
+}
+
+// IterateDatastoreProviderRecords enumerates the provider records stored in a datastore by the default provider store.
+func IterateDatastoreProviderRecords(ctx context.Context, d ds.Datastore, fn func(ProviderRecord) error) error {
+	results, err := d.Query(ctx, dsq.Query{Prefix: providers.ProvidersKeyPrefix})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for result := range results.Next() {
+		if result.Error != nil {
+			return result.Error
+		}
+		r, err := parseDatastoreEntry(result.Entry)
+		if err != nil {
+			return fmt.Errorf("parsing provider record %s: %w", result.Key, err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DatastoreProviderKey returns the datastore key of the provider record of the peer for the key.
+func DatastoreProviderKey(key []byte, p peer.ID) ds.Key {
+	return ds.NewKey(providers.ProvidersKeyPrefix + base32.RawStdEncoding.EncodeToString(key) + "/" + base32.RawStdEncoding.EncodeToString([]byte(p)))
+}
+
+// EncodeDatastoreValue encodes the value of a provider record in the datastore, dated t.
+func EncodeDatastoreValue(t time.Time, origin RecordOrigin) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+	n := binary.PutVarint(buf, t.UnixNano())
+	if origin != OriginAnnounced {
+		buf[n] = byte(origin)
+		n++
+	}
+	return buf[:n]
+}
+
+// KeepDatastoreValue returns true if the value of a provider record in the datastore is kept rather than replaced by
+// a record that was not announced, dated t: live announced records, and records expiring after it, are kept.
+func KeepDatastoreValue(v []byte, t time.Time) bool {
+	// records expire ProvideValidity after their date, so the record dated last expires last
+	existing, origin, err := DecodeDatastoreValue(v)
+	live := err == nil && time.Since(existing) < providers.ProvideValidity
+	return live && (origin == OriginAnnounced || !existing.Before(t))
+}
+
+// DecodeDatastoreValue decodes the date and origin of a provider record in the datastore.
+func DecodeDatastoreValue(v []byte) (time.Time, RecordOrigin, error) {
+	nsec, n := binary.Varint(v)
+	if n <= 0 {
+		return time.Time{}, OriginAnnounced, errors.New("failed to parse time")
+	}
+	origin := OriginAnnounced
+	if len(v) > n {
+		origin = RecordOrigin(v[n])
+	}
+	return time.Unix(0, nsec), origin, nil
+}
+
+// parseDatastoreEntry parses a "/providers/<base32 key>/<base32 peer ID>" entry, whose value is the time the record was added
+// and its origin.
+func parseDatastoreEntry(e dsq.Entry) (ProviderRecord, error) {
+	parts := strings.Split(strings.TrimPrefix(e.Key, providers.ProvidersKeyPrefix), "/")
+	if len(parts) != 2 {
+		return ProviderRecord{}, errors.New("unexpected key")
+	}
+	key, err := base32.RawStdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ProviderRecord{}, fmt.Errorf("decoding key: %w", err)
+	}
+	pid, err := base32.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ProviderRecord{}, fmt.Errorf("decoding peer ID: %w", err)
+	}
+	t, origin, err := DecodeDatastoreValue(e.Value)
+	if err != nil {
+		return ProviderRecord{}, err
+	}
+	return ProviderRecord{
+		Key:      key,
+		Provider: peer.AddrInfo{ID: peer.ID(pid)},
+		Expires:  t.Add(providers.ProvideValidity),
+		Origin:   origin,
+	}, nil
+}
+
+// lockedProviderCache makes a ProviderManagerCache thread safe.
+type lockedProviderCache struct {
+	mut   sync.Mutex
+	cache ProviderManagerCache
+}
+
+func (c *lockedProviderCache) Add(key, value interface{}) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Add(key, value)
+}
+
+func (c *lockedProviderCache) Get(key interface{}) (interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Get(key)
+}
+
+func (c *lockedProviderCache) Contains(key interface{}) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Contains(key)
+}
+
+func (c *lockedProviderCache) Peek(key interface{}) (interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Peek(key)
+}
+
+func (c *lockedProviderCache) Remove(key interface{}) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Remove(key)
+}
+
+func (c *lockedProviderCache) RemoveOldest() (interface{}, interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.RemoveOldest()
+}
+
+func (c *lockedProviderCache) GetOldest() (interface{}, interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.GetOldest()
+}
+
+func (c *lockedProviderCache) Keys() []interface{} {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Keys()
+}
+
+func (c *lockedProviderCache) Len() int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Len()
+}
+
+func (c *lockedProviderCache) Purge() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.cache.Purge()
+}
+
+func (c *lockedProviderCache) Resize(size int) int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.cache.Resize(size)
+}
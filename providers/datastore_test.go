@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatastoreValue(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	for _, origin := range RecordOrigins {
+		v, o, err := DecodeDatastoreValue(EncodeDatastoreValue(now, origin))
+		assert.NoError(t, err)
+		assert.Equal(t, origin, o)
+		assert.True(t, now.Equal(v))
+	}
+	_, _, err := DecodeDatastoreValue(nil)
+	assert.Error(t, err)
+}
+
+func TestDatastoreProviderStore_Origins(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := newTestDatastoreProviderStore(t, ctx)
+
+	assert.NoError(t, s.AddProvider(ctx, []byte("announced"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, time.Hour), []byte("prefetched"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginImported, 0), []byte("imported"), peer.AddrInfo{ID: "peer"}))
+	// wait for the announced record to be written
+	_, err := s.GetProviders(ctx, []byte("announced"))
+	assert.NoError(t, err)
+
+	records := map[string]ProviderRecord{}
+	err = s.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		records[string(r.Key)] = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+
+	assert.Equal(t, OriginAnnounced, records["announced"].Origin)
+	assert.WithinDuration(t, time.Now().Add(providers.ProvideValidity), records["announced"].Expires, time.Minute)
+	assert.Equal(t, OriginPrefetched, records["prefetched"].Origin)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), records["prefetched"].Expires, time.Minute)
+	assert.Equal(t, OriginImported, records["imported"].Origin)
+	assert.WithinDuration(t, time.Now().Add(providers.ProvideValidity), records["imported"].Expires, time.Minute)
+
+	// the provider store still serves the records
+	provs, err := s.GetProviders(ctx, []byte("prefetched"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer"}, providerIDs(provs))
+
+	// announcing a prefetched record makes it announced, once the DHT provider store flushed its writes when closing
+	assert.NoError(t, s.AddProvider(ctx, []byte("prefetched"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.Process().Close())
+	err = s.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		records[string(r.Key)] = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OriginAnnounced, records["prefetched"].Origin)
+}
+
+func TestDatastoreProviderStore_KeepsAnnounced(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := newTestDatastoreProviderStore(t, ctx)
+
+	assert.NoError(t, s.AddProvider(ctx, []byte("announced"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginImported, 2*time.Hour), []byte("imported"), peer.AddrInfo{ID: "peer"}))
+	// wait for the announced record to be written
+	_, err := s.GetProviders(ctx, []byte("announced"))
+	assert.NoError(t, err)
+
+	// prefetching a key with an announced record doesn't downgrade it
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, time.Hour), []byte("announced"), peer.AddrInfo{ID: "peer"}))
+	// nor shortens the expiry of another record
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, time.Hour), []byte("imported"), peer.AddrInfo{ID: "peer"}))
+
+	records := map[string]ProviderRecord{}
+	err = s.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		records[string(r.Key)] = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OriginAnnounced, records["announced"].Origin)
+	assert.WithinDuration(t, time.Now().Add(providers.ProvideValidity), records["announced"].Expires, time.Minute)
+	assert.Equal(t, OriginImported, records["imported"].Origin)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), records["imported"].Expires, time.Minute)
+
+	// a record expiring later replaces it
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, 3*time.Hour), []byte("imported"), peer.AddrInfo{ID: "peer"}))
+	err = s.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		records[string(r.Key)] = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OriginPrefetched, records["imported"].Origin)
+	assert.WithinDuration(t, time.Now().Add(3*time.Hour), records["imported"].Expires, time.Minute)
+}
@@ -21,6 +21,7 @@ import (
 
 type ddbClient interface {
 	dynamodb.QueryAPIClient
+	dynamodb.ScanAPIClient
 	dynamodb.DescribeTableAPIClient
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 }
@@ -155,3 +156,39 @@ func (d *dynamoDBProviderStore) CountProviderRecords(ctx context.Context) (int64
 	}
 	return res.Table.ItemCount, nil
 }
+
+// IterateProviderRecords scans the whole table. The scan consumes read capacity, so it is meant for occasional exports.
+func (d *dynamoDBProviderStore) IterateProviderRecords(ctx context.Context, fn func(ProviderRecord) error) error {
+	paginator := dynamodb.NewScanPaginator(d.DDBClient, &dynamodb.ScanInput{TableName: &d.TableName})
+	for paginator.HasMorePages() {
+		res, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range res.Items {
+			key, ok := item["key"].(*types.AttributeValueMemberB)
+			if !ok {
+				return fmt.Errorf("unexpected value type of '%s' for 'key' attribute", reflect.TypeOf(item["key"]))
+			}
+			prov, ok := item["prov"].(*types.AttributeValueMemberB)
+			if !ok {
+				return fmt.Errorf("unexpected value type of '%s' for 'prov' attribute", reflect.TypeOf(item["prov"]))
+			}
+			rec := ProviderRecord{
+				Key:      key.Value,
+				Provider: d.Peerstore.PeerInfo(peer.ID(prov.Value)),
+			}
+			if ttl, ok := item["ttl"].(*types.AttributeValueMemberN); ok {
+				ttlEpoch, err := strconv.ParseInt(ttl.Value, 10, 64)
+				if err != nil {
+					return fmt.Errorf("parsing 'ttl' attribute: %w", err)
+				}
+				rec.Expires = time.Unix(ttlEpoch, 0)
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
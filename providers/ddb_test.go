@@ -205,6 +205,11 @@ func (m *mockDDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns
 	return args.Get(0).(*dynamodb.QueryOutput), args.Error(1)
 }
 
+func (m *mockDDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*dynamodb.ScanOutput), args.Error(1)
+}
+
 func (m *mockDDB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
 	args := m.Called(ctx, params, optFns)
 	return args.Get(0).(*dynamodb.DescribeTableOutput), args.Error(1)
@@ -293,3 +298,64 @@ func TestProviderStore_pagination_no_results(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 0, len(provs))
 }
+
+func TestProviderStore_IterateProviderRecords(t *testing.T) {
+	ctx, stop := context.WithTimeout(context.Background(), 1*time.Second)
+	defer stop()
+	ddbClient := &mockDDB{}
+	peerStore := &mockPeerStore{addrs: map[string][]multiaddr.Multiaddr{}}
+	provStore := &dynamoDBProviderStore{
+		Self:      "peer",
+		Peerstore: peerStore,
+		DDBClient: ddbClient,
+		TableName: tableName,
+		clock:     clock.NewMock(),
+	}
+
+	// return 2 pages to exercise pagination logic
+	ddbClient.
+		On("Scan", mock.Anything, mock.Anything, mock.Anything).
+		Return(&dynamodb.ScanOutput{
+			Items: []map[string]types.AttributeValue{
+				{
+					"key":  &types.AttributeValueMemberB{Value: []byte("key1")},
+					"prov": &types.AttributeValueMemberB{Value: []byte("1")},
+					"ttl":  &types.AttributeValueMemberN{Value: "100"},
+				},
+			},
+			LastEvaluatedKey: map[string]types.AttributeValue{
+				"key": &types.AttributeValueMemberB{Value: []byte("key1")},
+			},
+		}, nil).
+		Times(1)
+	ddbClient.
+		On("Scan", mock.Anything, mock.Anything, mock.Anything).
+		Return(&dynamodb.ScanOutput{
+			Items: []map[string]types.AttributeValue{
+				{
+					"key":  &types.AttributeValueMemberB{Value: []byte("key2")},
+					"prov": &types.AttributeValueMemberB{Value: []byte("2")},
+				},
+			},
+		}, nil).
+		Times(1)
+
+	var recs []ProviderRecord
+	err := provStore.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		recs = append(recs, r)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.Equal(t, []byte("key1"), recs[0].Key)
+	assert.EqualValues(t, "1", recs[0].Provider.ID)
+	assert.Equal(t, time.Unix(100, 0), recs[0].Expires)
+	assert.Equal(t, []byte("key2"), recs[1].Key)
+	assert.True(t, recs[1].Expires.IsZero())
+
+	tiered, err := NewTieredProviderStore(ctx, provStore, "dynamodb", 10, time.Minute, WriteThrough)
+	assert.NoError(t, err)
+	it, ok := FindProviderRecordIterator(tiered)
+	assert.True(t, ok)
+	assert.Equal(t, provStore, it)
+}
@@ -0,0 +1,74 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// Denylist decides which content and peers are denied.
+type Denylist interface {
+	// IsContentDenied returns true if the content with the given multihash is denied.
+	IsContentDenied(key []byte) bool
+	IsPeerDenied(p peer.ID) bool
+}
+
+// DenylistProviderStore is a provider store that neither stores nor returns provider records of denied content or denied peers.
+type DenylistProviderStore struct {
+	Delegate providers.ProviderStore
+	Denylist Denylist
+}
+
+func NewDenylistProviderStore(delegate providers.ProviderStore, denylist Denylist) *DenylistProviderStore {
+	return &DenylistProviderStore{Delegate: delegate, Denylist: denylist}
+}
+
+func (s *DenylistProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if s.Denylist.IsContentDenied(key) {
+		recordDenylistBlocked(ctx, "AddProvider", "content", 1)
+		return nil
+	}
+	if s.Denylist.IsPeerDenied(prov.ID) {
+		recordDenylistBlocked(ctx, "AddProvider", "peer", 1)
+		return nil
+	}
+	return s.Delegate.AddProvider(ctx, key, prov)
+}
+
+func (s *DenylistProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	if s.Denylist.IsContentDenied(key) {
+		recordDenylistBlocked(ctx, "GetProviders", "content", 1)
+		return nil, nil
+	}
+	provs, err := s.Delegate.GetProviders(ctx, key)
+	if err != nil {
+		return provs, err
+	}
+	// don't filter in place, the slice may be shared with a cache
+	allowed := make([]peer.AddrInfo, 0, len(provs))
+	for _, p := range provs {
+		if !s.Denylist.IsPeerDenied(p.ID) {
+			allowed = append(allowed, p)
+		}
+	}
+	if blocked := len(provs) - len(allowed); blocked > 0 {
+		recordDenylistBlocked(ctx, "GetProviders", "peer", blocked)
+	}
+	return allowed, nil
+}
+
+func (s *DenylistProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
+
+func recordDenylistBlocked(ctx context.Context, operation string, kind string, n int) {
+	stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyOperation, operation), tag.Upsert(metrics.KeyKind, kind)},
+		metrics.DenylistBlocked.M(int64(n)),
+	)
+}
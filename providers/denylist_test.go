@@ -0,0 +1,49 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDenylist struct {
+	content map[string]bool
+	peers   map[peer.ID]bool
+}
+
+func (m *mockDenylist) IsContentDenied(key []byte) bool { return m.content[string(key)] }
+func (m *mockDenylist) IsPeerDenied(p peer.ID) bool     { return m.peers[p] }
+
+func TestDenylistProviderStore(t *testing.T) {
+	ctx := context.Background()
+	allowedPeer := peer.AddrInfo{ID: peer.ID("allowed")}
+	deniedPeer := peer.AddrInfo{ID: peer.ID("denied")}
+
+	delegate := &mockProviderStore{providers: map[string][]peer.AddrInfo{
+		"allowed": {allowedPeer, deniedPeer},
+		"denied":  {allowedPeer},
+	}}
+	dl := &mockDenylist{
+		content: map[string]bool{"denied": true},
+		peers:   map[peer.ID]bool{deniedPeer.ID: true},
+	}
+	ps := NewDenylistProviderStore(delegate, dl)
+
+	provs, err := ps.GetProviders(ctx, []byte("allowed"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{allowedPeer}, provs)
+	// the delegate's providers are not modified
+	assert.Equal(t, []peer.AddrInfo{allowedPeer, deniedPeer}, delegate.providers["allowed"])
+
+	provs, err = ps.GetProviders(ctx, []byte("denied"))
+	assert.NoError(t, err)
+	assert.Empty(t, provs)
+
+	assert.NoError(t, ps.AddProvider(ctx, []byte("denied"), allowedPeer))
+	assert.NoError(t, ps.AddProvider(ctx, []byte("new"), deniedPeer))
+	assert.NoError(t, ps.AddProvider(ctx, []byte("new"), allowedPeer))
+	assert.Equal(t, []peer.AddrInfo{allowedPeer}, delegate.providers["new"])
+	assert.Equal(t, []peer.AddrInfo{allowedPeer}, delegate.providers["denied"])
+}
@@ -2,7 +2,9 @@ package providers
 
 import (
 	"context"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/benbjohnson/clock"
@@ -12,13 +14,20 @@ import (
 	"github.com/libp2p/hydra-booster/metrics"
 	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
-	"github.com/whyrusleeping/timecache"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 )
 
 const (
 	metricsPublishingInterval = 10 * time.Second
+	// how often the number of workers is adjusted
+	workerScalingInterval = time.Second
+
+	DefaultPrefetchTimeout          = 5 * time.Second
+	DefaultPrefetchQueueSize        = 1000
+	DefaultPrefetchNegativeCacheTTL = time.Hour
+	DefaultPrefetchMinWorkers       = 10
+	DefaultPrefetchMaxWorkers       = 1000
 )
 
 // ProvidersFinder finds providers for the given key using the given content router, passing each to the callback.
@@ -26,24 +35,39 @@ type ProvidersFinder interface {
 	Find(ctx context.Context, router ReadContentRouting, key []byte, onProvider onProviderFunc) error
 }
 
-func NewAsyncProvidersFinder(timeout time.Duration, queueSize int, negativeCacheTTL time.Duration) *asyncProvidersFinder {
+// NewAsyncProvidersFinder creates a ProvidersFinder remembering the keys whose prefetch found no providers in the negative cache.
+func NewAsyncProvidersFinder(timeout time.Duration, queueSize int, negativeCache *NegativeCache) *asyncProvidersFinder {
 	clock := clock.New()
 	return &asyncProvidersFinder{
 		log:                logging.Logger("hydra/prefetch"),
 		clock:              clock,
 		metricsTicker:      clock.Ticker(metricsPublishingInterval),
+		scalingTicker:      clock.Ticker(workerScalingInterval),
 		workQueueSize:      queueSize,
-		workQueue:          make(chan findRequest, queueSize),
+		workQueue:          newPrefetchQueue(queueSize),
+		sketch:             newFrequencySketch(sketchWidth(queueSize)),
 		pending:            map[string]bool{},
 		timeout:            timeout,
-		negativeCacheTTL:   negativeCacheTTL,
-		negativeCache:      &idempotentTimeCache{cache: timecache.NewTimeCache(negativeCacheTTL)},
+		negativeCache:      negativeCache,
 		onReqDone:          func(r findRequest) {},
 		onMetricsPublished: func() {},
 	}
 
 }
 
+func sketchWidth(queueSize int) int {
+	if w := queueSize * sketchWidthFactor; w > minSketchWidth {
+		return w
+	}
+	return minSketchWidth
+}
+
+// invalidatingProvidersFinder is implemented by ProvidersFinders remembering which keys have no providers.
+type invalidatingProvidersFinder interface {
+	// Invalidate forgets that the key has no providers.
+	Invalidate(key []byte)
+}
+
 type ReadContentRouting interface {
 	FindProvidersAsync(ctx context.Context, cid cid.Cid, numResults int) <-chan peer.AddrInfo
 }
@@ -58,18 +82,45 @@ type findRequest struct {
 }
 
 // asyncProvidersFinder finds providers asynchronously using a bounded work queue and a bounded number of workers.
+// The work queue is prioritized by how often each key was recently requested, so that popular keys are found first,
+// and are not pushed out of the queue by keys that are only requested once.
+// If an Admission policy is set, only the requests it admits are queued.
+//
+// The number of workers is adjusted between a minimum and a maximum, so that queued requests are started within
+// the scaling interval given the observed prefetch latency, and idle workers are gradually stopped.
 type asyncProvidersFinder struct {
-	log              logging.EventLogger
-	clock            clock.Clock
-	metricsTicker    *clock.Ticker
-	workQueueSize    int
-	workQueue        chan findRequest
-	pendingMut       sync.RWMutex
-	pending          map[string]bool
-	timeout          time.Duration
-	negativeCacheTTL time.Duration
-	negativeCache    *idempotentTimeCache
-	ctx              context.Context
+	Admission AdmissionPolicy
+
+	log           logging.EventLogger
+	clock         clock.Clock
+	metricsTicker *clock.Ticker
+	scalingTicker *clock.Ticker
+	workQueueSize int
+	workQueue     *prefetchQueue
+	// guarded by pendingMut
+	sketch        *frequencySketch
+	pendingMut    sync.RWMutex
+	pending       map[string]bool
+	timeout       time.Duration
+	negativeCache *NegativeCache
+	ctx           context.Context
+
+	minWorkers int
+	maxWorkers int
+	// guarded by workersMut
+	workersMut  sync.Mutex
+	workerStops []context.CancelFunc
+	// number of workers handling a request
+	busy int64
+	// sum and number of the durations of the requests handled since the last scaling
+	durationSum   int64
+	durationCount int64
+	// moving average of the duration of the requests, only used by the scaling loop
+	latency time.Duration
+	// sum and number of the samples of the utilization of the workers since metrics were last published,
+	// only used by the scaling loop and guarded by workersMut
+	utilizationSum     float64
+	utilizationSamples int
 
 	// callbacks used for testing
 	onReqDone          func(r findRequest)
@@ -78,45 +129,64 @@ type asyncProvidersFinder struct {
 
 // Find finds the providers for a given key using the passed content router asynchronously.
 // It schedules work and returns immediately, invoking the callback concurrently as results are found.
-// If the work queue is full, this does not block--it drops the request on the floor and immediately returns.
+// If the work queue is full, this does not block--it evicts the least requested queued key to make room for the request,
+// or drops the request on the floor if the key was requested less often, and immediately returns.
+// Requests rejected by the admission policy are dropped as well.
 func (a *asyncProvidersFinder) Find(ctx context.Context, router ReadContentRouting, key []byte, onProvider onProviderFunc) error {
 	a.pendingMut.Lock()
 	defer a.pendingMut.Unlock()
 	ks := string(key)
+	priority := a.sketch.Increment(ks)
 	pending := a.pending[ks]
 	if pending {
+		a.workQueue.Update(ks, priority)
 		return nil
 	}
-	if a.negativeCache.Has(ks) {
+	if a.negativeCache.Has(key) {
 		recordPrefetches(ctx, "failed-cached")
 		return nil
 	}
-	select {
-	case a.workQueue <- findRequest{ctx: ctx, router: router, key: key, onProvider: onProvider}:
-		a.pending[ks] = true
-		return nil
-	default:
+	if a.Admission != nil {
+		if ok, reason := a.Admission.Admit(ctx, key); !ok {
+			recordPrefetches(ctx, reason)
+			return nil
+		}
+	}
+	evicted, ok := a.workQueue.Push(findRequest{ctx: ctx, router: router, key: key, onProvider: onProvider}, priority)
+	if !ok {
 		recordPrefetches(ctx, "discarded")
+		recordPrefetchPriority(ctx, "discarded", priority)
 		return nil
 	}
+	a.pending[ks] = true
+	if evicted != nil {
+		delete(a.pending, string(evicted.key))
+		recordPrefetches(evicted.ctx, "evicted")
+		recordPrefetchPriority(evicted.ctx, "evicted", a.sketch.Estimate(string(evicted.key)))
+	}
+	return nil
 }
 
-// Run runs a set of goroutine workers that process Find() calls asynchronously.
+// Run runs between minWorkers and maxWorkers goroutine workers that process Find() calls asynchronously.
 // The workers shut down gracefully when the context is canceled.
-func (a *asyncProvidersFinder) Run(ctx context.Context, numWorkers int) {
+func (a *asyncProvidersFinder) Run(ctx context.Context, minWorkers, maxWorkers int) {
 	a.ctx = ctx
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case req := <-a.workQueue:
-					a.handleRequest(ctx, req)
-				}
+	a.minWorkers = minWorkers
+	a.maxWorkers = maxWorkers
+	a.scaleTo(ctx, minWorkers)
+
+	// periodic scaling of the workers
+	go func() {
+		defer a.scalingTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.scalingTicker.C:
+				a.scale(ctx)
 			}
-		}()
-	}
+		}
+	}()
 	// periodic metric publishing
 	go func() {
 		for {
@@ -131,8 +201,17 @@ func (a *asyncProvidersFinder) Run(ctx context.Context, numWorkers int) {
 
 				stats.Record(ctx, metrics.PrefetchesPending.M(int64(pending)))
 				stats.Record(ctx, metrics.PrefetchNegativeCacheSize.M(int64(a.negativeCache.Len())))
-				stats.Record(ctx, metrics.PrefetchNegativeCacheTTLSeconds.M(int64(a.negativeCacheTTL.Seconds())))
+				stats.Record(ctx, metrics.PrefetchNegativeCacheTTLSeconds.M(int64(a.negativeCache.TTL.Seconds())))
 				stats.Record(ctx, metrics.PrefetchesPendingLimit.M(int64(a.workQueueSize)))
+				a.workersMut.Lock()
+				workers := len(a.workerStops)
+				utilization := 0.0
+				if a.utilizationSamples > 0 {
+					utilization = a.utilizationSum / float64(a.utilizationSamples)
+				}
+				a.utilizationSum, a.utilizationSamples = 0, 0
+				a.workersMut.Unlock()
+				stats.Record(ctx, metrics.PrefetchWorkers.M(int64(workers)), metrics.PrefetchWorkerUtilization.M(utilization))
 
 				a.onMetricsPublished()
 			}
@@ -140,7 +219,111 @@ func (a *asyncProvidersFinder) Run(ctx context.Context, numWorkers int) {
 	}()
 }
 
-func (a *asyncProvidersFinder) handleRequest(ctx context.Context, req findRequest) {
+// Invalidate removes the key from the negative cache, so that it is prefetched again the next time it is requested.
+func (a *asyncProvidersFinder) Invalidate(key []byte) {
+	a.negativeCache.Remove(key)
+}
+
+// NegativeCache returns the cache of keys whose prefetch found no providers.
+func (a *asyncProvidersFinder) NegativeCache() *NegativeCache {
+	return a.negativeCache
+}
+
+// Workers returns the number of workers.
+func (a *asyncProvidersFinder) Workers() int {
+	a.workersMut.Lock()
+	defer a.workersMut.Unlock()
+	return len(a.workerStops)
+}
+
+// scaleTo starts or stops workers until there are n workers. Stopped workers finish the request they are handling.
+func (a *asyncProvidersFinder) scaleTo(ctx context.Context, n int) {
+	a.workersMut.Lock()
+	defer a.workersMut.Unlock()
+	for len(a.workerStops) < n {
+		workerCtx, stop := context.WithCancel(ctx)
+		a.workerStops = append(a.workerStops, stop)
+		go a.work(ctx, workerCtx)
+	}
+	for len(a.workerStops) > n {
+		last := len(a.workerStops) - 1
+		a.workerStops[last]()
+		a.workerStops = a.workerStops[:last]
+	}
+}
+
+// work handles requests until the worker is stopped. Requests are handled with the context of the finder,
+// so that stopping the worker doesn't cancel the request it is handling.
+func (a *asyncProvidersFinder) work(ctx, workerCtx context.Context) {
+	for {
+		req, priority, ok := a.workQueue.Pop(workerCtx)
+		if !ok {
+			return
+		}
+		atomic.AddInt64(&a.busy, 1)
+		start := a.clock.Now()
+		a.handleRequest(ctx, req, priority)
+		atomic.AddInt64(&a.durationSum, int64(a.clock.Since(start)))
+		atomic.AddInt64(&a.durationCount, 1)
+		atomic.AddInt64(&a.busy, -1)
+	}
+}
+
+// scale adjusts the number of workers to the queued requests and the observed latency.
+func (a *asyncProvidersFinder) scale(ctx context.Context) {
+	sum := atomic.SwapInt64(&a.durationSum, 0)
+	count := atomic.SwapInt64(&a.durationCount, 0)
+	if count > 0 {
+		avg := time.Duration(sum / count)
+		if a.latency == 0 {
+			a.latency = avg
+		} else {
+			a.latency = (a.latency + avg) / 2
+		}
+	}
+	latency := a.latency
+	if latency == 0 {
+		// nothing was observed yet, assume requests time out
+		latency = a.timeout
+	}
+
+	busy := int(atomic.LoadInt64(&a.busy))
+	workers := a.Workers()
+	a.workersMut.Lock()
+	if workers > 0 {
+		a.utilizationSum += math.Min(1, float64(busy)/float64(workers))
+		a.utilizationSamples++
+	}
+	a.workersMut.Unlock()
+
+	a.scaleTo(ctx, desiredWorkers(workers, busy, a.workQueue.Len(), latency, workerScalingInterval, a.minWorkers, a.maxWorkers))
+}
+
+// desiredWorkers returns the number of workers needed to handle the busy workers' requests and start the queued requests
+// within the interval, if each request takes the given latency. The workers are added at once, but removed gradually by
+// removing half of the unneeded workers, so that the pool doesn't shrink because of a short lull.
+func desiredWorkers(workers, busy, queued int, latency, interval time.Duration, minWorkers, maxWorkers int) int {
+	needed := busy
+	if queued > 0 {
+		if latency <= 0 {
+			latency = interval
+		}
+		needed += int(math.Ceil(float64(queued) * float64(latency) / float64(interval)))
+	}
+	desired := needed
+	if needed < workers {
+		desired = workers - (workers-needed+1)/2
+	}
+	if desired < minWorkers {
+		desired = minWorkers
+	}
+	if desired > maxWorkers {
+		desired = maxWorkers
+	}
+	return desired
+}
+
+func (a *asyncProvidersFinder) handleRequest(ctx context.Context, req findRequest, priority uint32) {
 	defer func() {
 		a.onReqDone(req)
 		a.pendingMut.Lock()
@@ -150,6 +333,7 @@ func (a *asyncProvidersFinder) handleRequest(ctx context.Context, req findReques
 
 	// since this is async work, we don't want to use the deadline of the request's context
 	ctx = tag.NewContext(ctx, tag.FromContext(req.ctx))
+	recordPrefetchPriority(ctx, "started", priority)
 
 	mh := multihash.Multihash(req.key)
 	// hack: we're using a raw encoding here so that we can construct a CIDv1 to make the type system happy
@@ -167,7 +351,7 @@ func (a *asyncProvidersFinder) handleRequest(ctx context.Context, req findReques
 	findTime := a.clock.Since(startTime)
 
 	if !foundProviders {
-		a.negativeCache.Add(string(req.key))
+		a.negativeCache.Add(req.key)
 		recordPrefetches(ctx, "failed", metrics.PrefetchDuration.M(float64(findTime.Milliseconds())))
 		return
 	}
@@ -183,30 +367,7 @@ func recordPrefetches(ctx context.Context, status string, extraMeasures ...stats
 	)
 }
 
-// idempotentTimeCache wraps a timecache and adds thread safety and idempotency.
-type idempotentTimeCache struct {
-	mut   sync.RWMutex
-	cache *timecache.TimeCache
-}
-
-// Add adds an element to the cache.
-// If the element is already in the cache, it is left untouched (the time is not updated).
-func (c *idempotentTimeCache) Add(s string) {
-	c.mut.Lock()
-	defer c.mut.Unlock()
-	if !c.cache.Has(s) {
-		c.cache.Add(s)
-	}
-}
-
-func (c *idempotentTimeCache) Len() int {
-	c.mut.RLock()
-	defer c.mut.RUnlock()
-	return len(c.cache.M)
-}
-
-func (c *idempotentTimeCache) Has(s string) bool {
-	c.mut.RLock()
-	defer c.mut.RUnlock()
-	return c.cache.Has(s)
+// recordPrefetchPriority records the estimated number of recent requests for a key when its prefetch is started, discarded or evicted.
+func recordPrefetchPriority(ctx context.Context, status string, priority uint32) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.PrefetchPriority.M(int64(priority)))
 }
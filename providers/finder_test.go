@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -112,7 +113,7 @@ func TestAsyncProvidersFinder_Find(t *testing.T) {
 			view.Register(views...)
 			defer view.Unregister(views...)
 
-			finder := NewAsyncProvidersFinder(10*time.Second, queueSize, ttl)
+			finder := NewAsyncProvidersFinder(10*time.Second, queueSize, newTestNegativeCache(t, ttl))
 
 			// set a mock clock so we can control the timing of things
 			clock := clock.NewMock()
@@ -136,7 +137,7 @@ func TestAsyncProvidersFinder_Find(t *testing.T) {
 			metricsWG.Add(1)
 			finder.onMetricsPublished = func() { metricsWG.Done() }
 
-			finder.Run(ctx, 10)
+			finder.Run(ctx, 10, 10)
 			err := finder.Find(ctx, router, []byte(c.key), func(ai peer.AddrInfo) {
 				ais = append(ais, ai)
 				aiWG.Done()
@@ -152,7 +153,7 @@ func TestAsyncProvidersFinder_Find(t *testing.T) {
 			}
 
 			for _, k := range c.expCached {
-				assert.True(t, finder.negativeCache.Has(k))
+				assert.True(t, finder.negativeCache.Has([]byte(k)))
 			}
 
 			// trigger metric publishing and verify
@@ -225,3 +226,107 @@ func subsetRowVals(subset []view.Row, rows []*view.Row) bool {
 	}
 	return true
 }
+
+func TestAsyncProvidersFinder_FindEvictsLeastRequested(t *testing.T) {
+	views := []*view.View{metrics.PrefetchesView}
+	view.Register(views...)
+	defer view.Unregister(views...)
+
+	ctx := context.Background()
+	// the workers are not run, so requests stay queued
+	finder := NewAsyncProvidersFinder(10*time.Second, 1, newTestNegativeCache(t, time.Hour))
+	router := &mockRouter{}
+	onProvider := func(peer.AddrInfo) {}
+
+	assert.NoError(t, finder.Find(ctx, router, []byte("once"), onProvider))
+	// requested as often as the queued key, so it is discarded
+	assert.NoError(t, finder.Find(ctx, router, []byte("popular"), onProvider))
+	// requested more often than the queued key, so it evicts it
+	assert.NoError(t, finder.Find(ctx, router, []byte("popular"), onProvider))
+
+	assert.Equal(t, map[string]bool{"popular": true}, finder.pending)
+	assert.Equal(t, 1, finder.workQueue.Len())
+
+	rows, err := view.RetrieveData(metrics.Prefetches.Name())
+	assert.NoError(t, err)
+	assert.True(t, subsetRowVals([]view.Row{
+		{Data: &view.SumData{Value: 1}, Tags: []tag.Tag{{Key: metrics.KeyStatus, Value: "discarded"}}},
+		{Data: &view.SumData{Value: 1}, Tags: []tag.Tag{{Key: metrics.KeyStatus, Value: "evicted"}}},
+	}, rows))
+}
+
+func TestDesiredWorkers(t *testing.T) {
+	cases := []struct {
+		name                  string
+		workers, busy, queued int
+		latency               time.Duration
+		expWorkers            int
+	}{
+		{name: "idle at the minimum", workers: 2, expWorkers: 2},
+		{name: "grows to start the queued requests", workers: 2, busy: 2, queued: 4, latency: 500 * time.Millisecond, expWorkers: 4},
+		{name: "grows more for slower requests", workers: 2, busy: 2, queued: 4, latency: 2 * time.Second, expWorkers: 10},
+		{name: "grows up to the maximum", workers: 2, busy: 2, queued: 100, latency: time.Second, expWorkers: 20},
+		{name: "shrinks gradually", workers: 12, busy: 4, expWorkers: 8},
+		{name: "shrinks by at least one", workers: 5, busy: 4, expWorkers: 4},
+		{name: "shrinks down to the minimum", workers: 3, expWorkers: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expWorkers, desiredWorkers(c.workers, c.busy, c.queued, c.latency, time.Second, 2, 20))
+		})
+	}
+}
+
+// blockingRouter finds no providers once it is released.
+type blockingRouter struct {
+	release chan struct{}
+}
+
+func (r *blockingRouter) FindProvidersAsync(ctx context.Context, cid cid.Cid, results int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	go func() {
+		defer close(ch)
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+		}
+	}()
+	return ch
+}
+
+func TestAsyncProvidersFinder_Scaling(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	finder := NewAsyncProvidersFinder(10*time.Second, 10, newTestNegativeCache(t, time.Hour))
+	clk := clock.NewMock()
+	finder.clock = clk
+	finder.metricsTicker = clk.Ticker(metricsPublishingInterval)
+	finder.scalingTicker = clk.Ticker(workerScalingInterval)
+	router := &blockingRouter{release: make(chan struct{})}
+
+	finder.Run(ctx, 1, 5)
+	assert.Equal(t, 1, finder.Workers())
+
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		assert.NoError(t, finder.Find(ctx, router, []byte(key), func(peer.AddrInfo) {}))
+	}
+	// the only worker is busy and requests are queued, so workers are added, up to the maximum
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&finder.busy) == 1
+	}, time.Second, time.Millisecond)
+	clk.Add(workerScalingInterval)
+	assert.Eventually(t, func() bool {
+		return finder.Workers() == 5 && atomic.LoadInt64(&finder.busy) == 4
+	}, time.Second, time.Millisecond)
+
+	// once the requests are done, the idle workers are gradually stopped
+	close(router.release)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&finder.busy) == 0 && finder.workQueue.Len() == 0
+	}, time.Second, time.Millisecond)
+	clk.Add(workerScalingInterval)
+	assert.Eventually(t, func() bool { return finder.Workers() == 2 }, time.Second, time.Millisecond)
+	clk.Add(workerScalingInterval)
+	assert.Eventually(t, func() bool { return finder.Workers() == 1 }, time.Second, time.Millisecond)
+}
@@ -2,57 +2,327 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/ipfs/go-cid"
 	drc "github.com/ipfs/go-libipfs/routing/http/client"
-	"github.com/ipfs/go-libipfs/routing/http/contentrouter"
+	"github.com/ipfs/go-libipfs/routing/http/types"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
-type readContentRouter interface {
-	FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo
+// endpointLatencyWeight is the weight of the latest request in the moving average latency of an endpoint.
+const endpointLatencyWeight = 0.2
+
+type providerFinder interface {
+	FindProviders(ctx context.Context, key cid.Cid) ([]types.ProviderResponse, error)
+}
+
+// HTTPEndpointPolicy configures how the HTTP provider store uses its endpoints.
+type HTTPEndpointPolicy struct {
+	// HedgeDelay is how long to wait for an endpoint before also querying the next one.
+	// If zero, the next endpoint is only queried when the previous one failed.
+	HedgeDelay time.Duration
+	Breaker    BreakerPolicy
+}
+
+// DefaultHTTPEndpointPolicy returns the default policy, which doesn't hedge requests.
+func DefaultHTTPEndpointPolicy() HTTPEndpointPolicy {
+	return HTTPEndpointPolicy{Breaker: DefaultBreakerPolicy()}
+}
+
+// NewHTTPProviderStore creates a provider store finding providers with delegated routing endpoints.
+// The endpoints are queried one at a time, healthiest first, failing over to the next endpoint when one fails
+// and, if the policy has a hedge delay, when one is slow. Each endpoint has a circuit breaker, so that an endpoint that
+// keeps failing is skipped instead of delaying every request until it times out.
+// The state of the circuit breakers is recorded with the tags of the given context.
+func NewHTTPProviderStore(ctx context.Context, httpClient *http.Client, endpointURLs []string, policy HTTPEndpointPolicy) (*httpProvider, error) {
+	return newHTTPProvider(endpointURLs, policy, func(endpointURL string) (*httpEndpoint, error) {
+		drClient, err := drc.New(endpointURL, drc.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, fmt.Errorf("building delegated routing HTTP client: %w", err)
+		}
+		return newHTTPEndpoint(ctx, endpointURL, drClient, policy.Breaker)
+	})
+}
+
+func newHTTPProvider(endpointURLs []string, policy HTTPEndpointPolicy, newEndpoint func(string) (*httpEndpoint, error)) (*httpProvider, error) {
+	if len(endpointURLs) == 0 {
+		return nil, errors.New("no delegated routing endpoints")
+	}
+	p := &httpProvider{hedgeDelay: policy.HedgeDelay}
+	for _, u := range endpointURLs {
+		e, err := newEndpoint(u)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, e)
+	}
+	return p, nil
+}
+
+type httpProvider struct {
+	endpoints  []*httpEndpoint
+	hedgeDelay time.Duration
+}
+
+// httpEndpoint is a delegated routing endpoint of the HTTP provider store, and its health.
+type httpEndpoint struct {
+	// ctx carries the tags the breaker state is recorded with.
+	ctx context.Context
+	// name identifies the endpoint in metrics.
+	name    string
+	finder  providerFinder
+	breaker *CircuitBreaker
+	clock   clock.Clock
+
+	mut sync.Mutex
+	// latency is the moving average latency of the successful requests, zero until a request succeeded.
+	latency time.Duration
 }
 
-func NewHTTPProviderStore(httpClient *http.Client, endpointURL string) (*httpProvider, error) {
-	drClient, err := drc.New(endpointURL, drc.WithHTTPClient(httpClient))
+func newHTTPEndpoint(ctx context.Context, endpointURL string, finder providerFinder, policy BreakerPolicy) (*httpEndpoint, error) {
+	u, err := url.Parse(endpointURL)
 	if err != nil {
-		return nil, fmt.Errorf("building delegated routing HTTP client: %w", err)
+		return nil, fmt.Errorf("invalid delegated routing endpoint %q: %w", endpointURL, err)
 	}
-	cr := contentrouter.NewContentRoutingClient(drClient)
+	breaker, err := NewCircuitBreaker(policy)
+	if err != nil {
+		return nil, err
+	}
+	e := &httpEndpoint{ctx: ctx, name: u.Host, finder: finder, breaker: breaker, clock: clock.New()}
+	breaker.onStateChange = e.recordBreakerState
+	e.recordBreakerState(BreakerClosed)
+	return e, nil
+}
 
-	return &httpProvider{
-		cr: cr,
-	}, nil
+func (e *httpEndpoint) recordBreakerState(s BreakerState) {
+	if s != BreakerClosed {
+		log.Warnf("circuit breaker of delegated routing endpoint %s is %s", e.name, s)
+	}
+	stats.RecordWithTags(e.ctx, []tag.Mutator{tag.Upsert(metrics.KeyEndpoint, e.name)}, metrics.HTTPEndpointBreakerState.M(int64(s)))
 }
 
-type httpProvider struct {
-	cr readContentRouter
+func (e *httpEndpoint) averageLatency() time.Duration {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.latency
+}
+
+// endpointRequest is a request sent to an endpoint by GetProviders.
+type endpointRequest struct {
+	// seq is the order the request was sent in
+	seq int
+
+	mut sync.Mutex
+	// hedged is true once the next endpoint was queried because the request didn't answer within the hedge delay
+	hedged bool
+	// lost is true if the request was hedged and a later request answered first
+	lost bool
+}
+
+func (r *endpointRequest) setHedged() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.hedged = true
 }
 
+// lostTo marks the request as lost if it was hedged, and the given later request answered first.
+func (r *endpointRequest) lostTo(winner *endpointRequest) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.hedged && r.seq < winner.seq {
+		r.lost = true
+	}
+}
+
+func (r *endpointRequest) lostToHedge() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.lost
+}
+
+type endpointResult struct {
+	req *endpointRequest
+	findProvidersAsyncResult
+}
+
+// findProviders queries the endpoint, reporting the outcome to its circuit breaker. Requests canceled because a request
+// hedged after them answered first are reported as failures, since the endpoint is too slow, while requests canceled
+// otherwise, e.g. by the caller, say nothing about the health of the endpoint.
+func (e *httpEndpoint) findProviders(ctx context.Context, c cid.Cid, req *endpointRequest) findProvidersAsyncResult {
+	start := e.clock.Now()
+	resps, err := e.finder.FindProviders(ctx, c)
+	latency := e.clock.Since(start)
+
+	status := "succeeded"
+	switch {
+	case err != nil && ctx.Err() != nil && req.lostToHedge():
+		// the request was canceled because the request hedged after it answered first
+		status = "slow"
+		e.breaker.Failure()
+	case err != nil && ctx.Err() != nil:
+		// the request was canceled, which says nothing about the health of the endpoint
+		status = "canceled"
+		e.breaker.Abandon()
+	case err != nil:
+		status = "failed"
+		e.breaker.Failure()
+	default:
+		e.breaker.Success()
+		e.mut.Lock()
+		if e.latency == 0 {
+			e.latency = latency
+		} else {
+			e.latency = time.Duration(endpointLatencyWeight*float64(latency) + (1-endpointLatencyWeight)*float64(e.latency))
+		}
+		e.mut.Unlock()
+	}
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyEndpoint, e.name), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.HTTPEndpointRequests.M(1),
+		metrics.HTTPEndpointRequestDuration.M(float64(latency.Milliseconds())),
+	)
+	if err != nil {
+		return findProvidersAsyncResult{Err: fmt.Errorf("%s: %w", e.name, err)}
+	}
+
+	var provs []peer.AddrInfo
+	for _, r := range resps {
+		if r.GetSchema() != types.SchemaBitswap {
+			continue
+		}
+		result, ok := r.(*types.ReadBitswapProviderRecord)
+		if !ok || result.ID == nil {
+			continue
+		}
+		var addrs []multiaddr.Multiaddr
+		for _, a := range result.Addrs {
+			addrs = append(addrs, a.Multiaddr)
+		}
+		provs = append(provs, peer.AddrInfo{ID: *result.ID, Addrs: addrs})
+	}
+	return findProvidersAsyncResult{AddrInfo: provs}
+}
+
+// AddProvider drops the provider record. The write API of delegated routing only accepts records signed by the provider
+// they name, so a record received by the hydra cannot be forwarded on behalf of its provider.
 func (p *httpProvider) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
 	return nil
 }
 
+// rankEndpoints orders the endpoints from the healthiest to the least healthy: endpoints whose circuit breaker is closed
+// come first, fastest first, and endpoints that are probed or rejected last.
+func (p *httpProvider) rankEndpoints() []*httpEndpoint {
+	type rankedEndpoint struct {
+		endpoint *httpEndpoint
+		state    BreakerState
+		latency  time.Duration
+	}
+	ranked := make([]rankedEndpoint, len(p.endpoints))
+	for i, e := range p.endpoints {
+		ranked[i] = rankedEndpoint{endpoint: e, state: e.breaker.State(), latency: e.averageLatency()}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].state != ranked[j].state {
+			return ranked[i].state < ranked[j].state
+		}
+		return ranked[i].latency < ranked[j].latency
+	})
+	endpoints := make([]*httpEndpoint, len(ranked))
+	for i, r := range ranked {
+		endpoints[i] = r.endpoint
+	}
+	return endpoints
+}
+
+// GetProviders returns the providers found by the first endpoint that answers without error.
+// It returns no providers rather than an error if no endpoint answered, so that the DHT still answers with closer peers. No request is sent if the circuit breakers of all the endpoints are open.
 func (p *httpProvider) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
 	mh, err := multihash.Cast(key)
 	if err != nil {
 		return nil, err
 	}
 	c := cid.NewCidV1(cid.Raw, mh)
-	provChan := p.cr.FindProvidersAsync(ctx, c, 100)
-	var provs []peer.AddrInfo
-	for {
+
+	// cancel the endpoints that are still running once we have a result
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	endpoints := p.rankEndpoints()
+	ch := make(chan endpointResult, len(endpoints))
+	var sent []*endpointRequest
+	next, pending := 0, 0
+	var hedge *time.Timer
+	var hedgeC <-chan time.Time
+	defer func() {
+		if hedge != nil {
+			hedge.Stop()
+		}
+	}()
+	// queryNext queries the next endpoint whose circuit breaker allows it, and returns false if there is none
+	queryNext := func() bool {
+		for next < len(endpoints) {
+			e := endpoints[next]
+			next++
+			if !e.breaker.Allow() {
+				stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyEndpoint, e.name), tag.Upsert(metrics.KeyStatus, "rejected")}, metrics.HTTPEndpointRequests.M(1))
+				continue
+			}
+			pending++
+			req := &endpointRequest{seq: len(sent)}
+			sent = append(sent, req)
+			go func() { ch <- endpointResult{req: req, findProvidersAsyncResult: e.findProviders(ctx, c, req)} }()
+
+			if hedge != nil {
+				hedge.Stop()
+			}
+			hedgeC = nil
+			if p.hedgeDelay > 0 && next < len(endpoints) {
+				hedge = time.NewTimer(p.hedgeDelay)
+				hedgeC = hedge.C
+			}
+			return true
+		}
+		return false
+	}
+
+	if !queryNext() {
+		return nil, nil
+	}
+	for pending > 0 {
 		select {
-		case <-ctx.Done():
-			return provs, ctx.Err()
-		case prov, ok := <-provChan:
-			if !ok {
-				return provs, nil
+		case r := <-ch:
+			pending--
+			if r.Err == nil {
+				// the hedged requests still running lost, which is reported before they are canceled
+				for _, req := range sent {
+					req.lostTo(r.req)
+				}
+				return r.AddrInfo, nil
 			}
-			provs = append(provs, prov)
+			log.Warnf("error finding providers with delegated routing: %s", r.Err)
+			queryNext()
+		case <-hedgeC:
+			hedgeC = nil
+			last := sent[len(sent)-1]
+			if queryNext() {
+				last.setHedged()
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
+	return nil, nil
 }
@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-libipfs/routing/http/types"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockProviderFinder struct {
+	mut   sync.Mutex
+	calls int
+	delay time.Duration
+	err   error
+	provs []peer.ID
+}
+
+func (m *mockProviderFinder) FindProviders(ctx context.Context, key cid.Cid) ([]types.ProviderResponse, error) {
+	m.mut.Lock()
+	m.calls++
+	m.mut.Unlock()
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	var resps []types.ProviderResponse
+	for _, p := range m.provs {
+		p := p
+		resps = append(resps, &types.ReadBitswapProviderRecord{Schema: types.SchemaBitswap, ID: &p})
+	}
+	return resps, nil
+}
+
+func (m *mockProviderFinder) numCalls() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.calls
+}
+
+func testCid(t *testing.T, s string) cid.Cid {
+	mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func newTestHTTPProvider(t *testing.T, policy HTTPEndpointPolicy, finders ...*mockProviderFinder) *httpProvider {
+	urls := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}[:len(finders)]
+	i := 0
+	p, err := newHTTPProvider(urls, policy, func(endpointURL string) (*httpEndpoint, error) {
+		e, err := newHTTPEndpoint(context.Background(), endpointURL, finders[i], policy.Breaker)
+		i++
+		return e, err
+	})
+	assert.NoError(t, err)
+	return p
+}
+
+func TestHTTPProvider_Failover(t *testing.T) {
+	ctx := context.Background()
+	failing := &mockProviderFinder{err: errors.New("boom")}
+	healthy := &mockProviderFinder{provs: []peer.ID{"peer"}}
+	policy := DefaultHTTPEndpointPolicy()
+	policy.Breaker.FailureThreshold = 2
+	policy.Breaker.OpenDuration = time.Hour
+	p := newTestHTTPProvider(t, policy, failing, healthy)
+
+	for i := 0; i < 3; i++ {
+		provs, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+		assert.NoError(t, err)
+		assert.Equal(t, []peer.ID{"peer"}, providerIDs(provs))
+	}
+	// the failing endpoint is skipped once its breaker opened
+	assert.Equal(t, 2, failing.numCalls())
+	assert.Equal(t, 3, healthy.numCalls())
+	assert.Equal(t, BreakerOpen, p.endpoints[0].breaker.State())
+
+	// no request is sent, and no providers returned, when all the breakers are open
+	healthy.err = errors.New("boom")
+	for i := 0; i < 2; i++ {
+		provs, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+		assert.NoError(t, err)
+		assert.Empty(t, provs)
+	}
+	assert.Equal(t, BreakerOpen, p.endpoints[1].breaker.State())
+	provs, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+	assert.NoError(t, err)
+	assert.Empty(t, provs)
+	assert.Equal(t, 2, failing.numCalls())
+	assert.Equal(t, 5, healthy.numCalls())
+}
+
+func TestHTTPProvider_Hedged(t *testing.T) {
+	ctx := context.Background()
+	slow := &mockProviderFinder{delay: 10 * time.Second, provs: []peer.ID{"slow"}}
+	fast := &mockProviderFinder{provs: []peer.ID{"fast"}}
+	policy := DefaultHTTPEndpointPolicy()
+	policy.HedgeDelay = 10 * time.Millisecond
+	policy.Breaker.FailureThreshold = 2
+	policy.Breaker.OpenDuration = time.Hour
+	p := newTestHTTPProvider(t, policy, slow, fast)
+
+	for i := 0; i < 2; i++ {
+		provs, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+		assert.NoError(t, err)
+		assert.Equal(t, []peer.ID{"fast"}, providerIDs(provs))
+	}
+	assert.Equal(t, 2, slow.numCalls())
+	assert.Equal(t, 2, fast.numCalls())
+
+	// the requests to the slow endpoint, canceled after the hedge delay, count as failures, so it is then skipped
+	require.Eventually(t, func() bool { return p.endpoints[0].breaker.State() == BreakerOpen }, 5*time.Second, 10*time.Millisecond)
+	provs, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"fast"}, providerIDs(provs))
+	assert.Equal(t, 2, slow.numCalls())
+}
+
+func TestHTTPProvider_Canceled(t *testing.T) {
+	slow := &mockProviderFinder{delay: 10 * time.Second}
+	other := &mockProviderFinder{delay: 10 * time.Second}
+	policy := DefaultHTTPEndpointPolicy()
+	policy.HedgeDelay = 10 * time.Millisecond
+	policy.Breaker.FailureThreshold = 1
+	p := newTestHTTPProvider(t, policy, slow, other)
+
+	// requests canceled by the caller don't count as failures, even after the hedge delay
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := p.GetProviders(ctx, testCid(t, "key").Hash())
+	assert.Error(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, other.numCalls())
+	assert.Equal(t, BreakerClosed, p.endpoints[0].breaker.State())
+	assert.Equal(t, BreakerClosed, p.endpoints[1].breaker.State())
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// InstrumentedProviderStore is a provider store recording the number, duration and outcome of the calls to the provider
+// store it wraps, and the number of providers it returns, so that provider stores of any kind can be compared.
+type InstrumentedProviderStore struct {
+	Delegate providers.ProviderStore
+	// Backend identifies the wrapped provider store in metrics.
+	Backend string
+	// Head identifies the head using the provider store in metrics.
+	Head peer.ID
+
+	clock clock.Clock
+}
+
+func NewInstrumentedProviderStore(delegate providers.ProviderStore, backend string, head peer.ID) *InstrumentedProviderStore {
+	return &InstrumentedProviderStore{Delegate: delegate, Backend: backend, Head: head, clock: clock.New()}
+}
+
+func (s *InstrumentedProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	start := s.clock.Now()
+	err := s.Delegate.AddProvider(ctx, key, prov)
+	s.record(ctx, "AddProvider", s.clock.Since(start), err)
+	return err
+}
+
+func (s *InstrumentedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	start := s.clock.Now()
+	provs, err := s.Delegate.GetProviders(ctx, key)
+	s.record(ctx, "GetProviders", s.clock.Since(start), err, metrics.ProviderStoreResultSize.M(int64(len(provs))))
+	return provs, err
+}
+
+// record records a call to the wrapped provider store, and the given measurements if it succeeded.
+func (s *InstrumentedProviderStore) record(ctx context.Context, operation string, d time.Duration, err error, ms ...stats.Measurement) {
+	status := "succeeded"
+	if err != nil {
+		status = "failed"
+		ms = nil
+	}
+	ms = append(ms, metrics.ProviderStoreRequests.M(1), metrics.ProviderStoreRequestDuration.M(float64(d)/float64(time.Millisecond)))
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{
+			tag.Upsert(metrics.KeyBackend, s.Backend),
+			tag.Upsert(metrics.KeyPeerID, s.Head.String()),
+			tag.Upsert(metrics.KeyOperation, operation),
+			tag.Upsert(metrics.KeyStatus, status),
+		},
+		ms...,
+	)
+}
+
+func (s *InstrumentedProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestInstrumentedProviderStore(t *testing.T) {
+	views := []*view.View{metrics.ProviderStoreRequestsView, metrics.ProviderStoreResultSizeView}
+	view.Register(views...)
+	defer view.Unregister(views...)
+
+	ctx := context.Background()
+	delegate := &mockProviderStore{}
+	s := NewInstrumentedProviderStore(delegate, "mock", "head")
+
+	assert.NoError(t, s.AddProvider(ctx, []byte("key"), peer.AddrInfo{ID: "peer1"}))
+	assert.NoError(t, s.AddProvider(ctx, []byte("key"), peer.AddrInfo{ID: "peer2"}))
+	provs, err := s.GetProviders(ctx, []byte("key"))
+	assert.NoError(t, err)
+	assert.Len(t, provs, 2)
+
+	delegate.err = errors.New("boom")
+	_, err = s.GetProviders(ctx, []byte("key"))
+	assert.ErrorIs(t, err, delegate.err)
+
+	tags := func(operation, status string) []tag.Tag {
+		return []tag.Tag{
+			{Key: metrics.KeyBackend, Value: "mock"},
+			{Key: metrics.KeyOperation, Value: operation},
+			{Key: metrics.KeyPeerID, Value: peer.ID("head").String()},
+			{Key: metrics.KeyStatus, Value: status},
+		}
+	}
+	rows, err := view.RetrieveData(metrics.ProviderStoreRequests.Name())
+	assert.NoError(t, err)
+	assert.True(t, subsetRowVals([]view.Row{
+		{Data: &view.SumData{Value: 2}, Tags: tags("AddProvider", "succeeded")},
+		{Data: &view.SumData{Value: 1}, Tags: tags("GetProviders", "succeeded")},
+		{Data: &view.SumData{Value: 1}, Tags: tags("GetProviders", "failed")},
+	}, rows))
+
+	// only the providers returned by successful lookups are counted
+	rows, err = view.RetrieveData(metrics.ProviderStoreResultSize.Name())
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	dist := rows[0].Data.(*view.DistributionData)
+	assert.Equal(t, int64(1), dist.Count)
+	assert.Equal(t, float64(2), dist.Mean)
+}
@@ -0,0 +1,44 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ProviderRecord is a provider record held by a provider store.
+type ProviderRecord struct {
+	Key      []byte
+	Provider peer.AddrInfo
+	// Expires is when the record expires, it is the zero time if unknown.
+	Expires time.Time
+	// Origin is how the record was obtained, records of provider stores that don't track it are announced.
+	Origin RecordOrigin
+}
+
+// ProviderRecordIterator is implemented by provider stores whose provider records can be enumerated.
+type ProviderRecordIterator interface {
+	// IterateProviderRecords calls fn for each provider record, stopping at the first error.
+	IterateProviderRecords(ctx context.Context, fn func(ProviderRecord) error) error
+}
+
+type providerStoreWrapper interface {
+	Unwrap() providers.ProviderStore
+}
+
+// FindProviderRecordIterator returns the first provider store in the chain of wrapped provider stores that can enumerate its provider records.
+func FindProviderRecordIterator(ps providers.ProviderStore) (ProviderRecordIterator, bool) {
+	for ps != nil {
+		if it, ok := ps.(ProviderRecordIterator); ok {
+			return it, true
+		}
+		w, ok := ps.(providerStoreWrapper)
+		if !ok {
+			return nil, false
+		}
+		ps = w.Unwrap()
+	}
+	return nil, false
+}
@@ -0,0 +1,180 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// KeyspaceFilterMode determines what a KeyspaceFilteredProviderStore does with the provider records of keys its hydra
+// is not responsible for.
+type KeyspaceFilterMode string
+
+const (
+	// KeyspaceFilterNone accepts the provider records of all keys.
+	KeyspaceFilterNone KeyspaceFilterMode = "none"
+	// KeyspaceFilterEnforce drops the provider records of keys the hydra is not responsible for.
+	KeyspaceFilterEnforce KeyspaceFilterMode = "enforce"
+	// KeyspaceFilterDryRun counts the provider records of keys the hydra is not responsible for, but still stores them.
+	KeyspaceFilterDryRun KeyspaceFilterMode = "dry-run"
+)
+
+// ParseKeyspaceFilterMode parses a keyspace filter mode string, as accepted on the command line.
+func ParseKeyspaceFilterMode(s string) (KeyspaceFilterMode, error) {
+	switch KeyspaceFilterMode(s) {
+	case KeyspaceFilterNone, KeyspaceFilterEnforce, KeyspaceFilterDryRun:
+		return KeyspaceFilterMode(s), nil
+	case "":
+		return KeyspaceFilterNone, nil
+	}
+	return "", fmt.Errorf("unknown keyspace filter mode %q, expected %q, %q or %q", s, KeyspaceFilterNone, KeyspaceFilterEnforce, KeyspaceFilterDryRun)
+}
+
+// KeyspaceFilterStats are the provider records checked by a KeyspaceFilter since it was created.
+type KeyspaceFilterStats struct {
+	Mode     KeyspaceFilterMode
+	K        int
+	Heads    int
+	Accepted int64
+	// Rejected are the records of keys the hydra is not responsible for, they are still stored in dry-run mode.
+	Rejected int64
+	// RejectedRatio is the fraction of the records that are, or would be, rejected.
+	RejectedRatio float64
+}
+
+// KeyspaceFilter decides whether a hydra is responsible for keys: a hydra is responsible for a key if one of its heads
+// is among the K closest peers to the key known by its heads' routing tables, as only then would peers looking for the
+// key query the hydra. A single KeyspaceFilter is meant to be shared by all the heads of a hydra.
+type KeyspaceFilter struct {
+	Mode KeyspaceFilterMode
+	K    int
+
+	mut   sync.RWMutex
+	heads map[peer.ID]*kbucket.RoutingTable
+
+	accepted int64
+	rejected int64
+}
+
+func NewKeyspaceFilter(mode KeyspaceFilterMode, k int) *KeyspaceFilter {
+	return &KeyspaceFilter{
+		Mode:  mode,
+		K:     k,
+		heads: map[peer.ID]*kbucket.RoutingTable{},
+	}
+}
+
+// AddHead adds a head of the hydra, with its routing table.
+func (f *KeyspaceFilter) AddHead(id peer.ID, rt *kbucket.RoutingTable) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.heads[id] = rt
+}
+
+// Responsible returns true if one of the heads is among the K closest known peers to the key.
+// The hydra is responsible for all keys until it has heads.
+func (f *KeyspaceFilter) Responsible(key []byte) bool {
+	target := kbucket.ConvertKey(string(key))
+
+	f.mut.RLock()
+	defer f.mut.RUnlock()
+	if len(f.heads) == 0 {
+		return true
+	}
+	seen := map[peer.ID]struct{}{}
+	var candidates []peer.ID
+	for id, rt := range f.heads {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			candidates = append(candidates, id)
+		}
+		if rt == nil {
+			continue
+		}
+		for _, p := range rt.NearestPeers(target, f.K) {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				candidates = append(candidates, p)
+			}
+		}
+	}
+
+	closest := kbucket.SortClosestPeers(candidates, target)
+	if len(closest) > f.K {
+		closest = closest[:f.K]
+	}
+	for _, p := range closest {
+		if _, ok := f.heads[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow returns whether a provider record for the key is stored, and counts it as accepted or rejected.
+func (f *KeyspaceFilter) Allow(ctx context.Context, key []byte) bool {
+	status := "accepted"
+	if f.Responsible(key) {
+		atomic.AddInt64(&f.accepted, 1)
+	} else {
+		atomic.AddInt64(&f.rejected, 1)
+		status = "rejected"
+	}
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyStatus, status), tag.Upsert(metrics.KeyKind, string(f.Mode))},
+		metrics.ProviderKeyspaceFiltered.M(1),
+	)
+	return status == "accepted" || f.Mode != KeyspaceFilterEnforce
+}
+
+// Stats returns the provider records checked by the filter.
+func (f *KeyspaceFilter) Stats() KeyspaceFilterStats {
+	f.mut.RLock()
+	heads := len(f.heads)
+	f.mut.RUnlock()
+	s := KeyspaceFilterStats{
+		Mode:     f.Mode,
+		K:        f.K,
+		Heads:    heads,
+		Accepted: atomic.LoadInt64(&f.accepted),
+		Rejected: atomic.LoadInt64(&f.rejected),
+	}
+	if total := s.Accepted + s.Rejected; total > 0 {
+		s.RejectedRatio = float64(s.Rejected) / float64(total)
+	}
+	return s
+}
+
+// KeyspaceFilteredProviderStore drops the provider records of keys its Filter's hydra is not responsible for.
+// In dry-run mode, the records are only counted.
+type KeyspaceFilteredProviderStore struct {
+	Delegate providers.ProviderStore
+	Filter   *KeyspaceFilter
+}
+
+func NewKeyspaceFilteredProviderStore(delegate providers.ProviderStore, filter *KeyspaceFilter) *KeyspaceFilteredProviderStore {
+	return &KeyspaceFilteredProviderStore{Delegate: delegate, Filter: filter}
+}
+
+func (s *KeyspaceFilteredProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if !s.Filter.Allow(ctx, key) {
+		return nil
+	}
+	return s.Delegate.AddProvider(ctx, key, prov)
+}
+
+func (s *KeyspaceFilteredProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	return s.Delegate.GetProviders(ctx, key)
+}
+
+func (s *KeyspaceFilteredProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
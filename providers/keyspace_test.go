@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	pstore "github.com/libp2p/go-libp2p/p2p/host/peerstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRoutingTable returns the routing table of a head knowing random peers, and the peers it knows.
+func newTestRoutingTable(t *testing.T, local peer.ID) (*kbucket.RoutingTable, []peer.ID) {
+	rt, err := kbucket.NewRoutingTable(20, kbucket.ConvertPeerID(local), time.Hour, pstore.NewMetrics(), time.Hour, nil)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		// peers are rejected once their bucket is full
+		rt.TryAddPeer(test.RandPeerIDFatal(t), true, false)
+	}
+	return rt, rt.ListPeers()
+}
+
+func TestKeyspaceFilterResponsible(t *testing.T) {
+	f := NewKeyspaceFilter(KeyspaceFilterEnforce, 3)
+	// the hydra is responsible for all keys until it has heads
+	assert.True(t, f.Responsible([]byte("key")))
+
+	head := test.RandPeerIDFatal(t)
+	rt, known := newTestRoutingTable(t, head)
+	f.AddHead(head, rt)
+
+	responsible := 0
+	for i := 0; i < 200; i++ {
+		key := sha256.Sum256([]byte(fmt.Sprint(i)))
+		closest := kbucket.SortClosestPeers(append([]peer.ID{head}, known...), kbucket.ConvertKey(string(key[:])))
+		want := false
+		for _, p := range closest[:3] {
+			want = want || p == head
+		}
+		assert.Equal(t, want, f.Responsible(key[:]))
+		if want {
+			responsible++
+		}
+	}
+	// the head is only among the 3 closest peers to a fraction of the keys
+	assert.Greater(t, responsible, 0)
+	assert.Less(t, responsible, 200)
+}
+
+func TestKeyspaceFilteredProviderStore(t *testing.T) {
+	ctx := context.Background()
+	head := test.RandPeerIDFatal(t)
+	rt, known := newTestRoutingTable(t, head)
+
+	var near, far []byte
+	for i := 0; near == nil || far == nil; i++ {
+		key := sha256.Sum256([]byte(fmt.Sprint(i)))
+		if kbucket.SortClosestPeers(append([]peer.ID{head}, known...), kbucket.ConvertKey(string(key[:])))[0] == head {
+			near = key[:]
+		} else if kbucket.Closer(known[0], head, string(key[:])) {
+			far = key[:]
+		}
+	}
+
+	for _, mode := range []KeyspaceFilterMode{KeyspaceFilterEnforce, KeyspaceFilterDryRun} {
+		t.Run(string(mode), func(t *testing.T) {
+			f := NewKeyspaceFilter(mode, 1)
+			f.AddHead(head, rt)
+			delegate := &mockProviderStore{}
+			s := NewKeyspaceFilteredProviderStore(delegate, f)
+
+			assert.NoError(t, s.AddProvider(ctx, near, peer.AddrInfo{ID: "peer"}))
+			assert.NoError(t, s.AddProvider(ctx, far, peer.AddrInfo{ID: "peer"}))
+			assert.Contains(t, delegate.providers, string(near))
+			// records are only dropped when the filter is enforced
+			if mode == KeyspaceFilterEnforce {
+				assert.NotContains(t, delegate.providers, string(far))
+			} else {
+				assert.Contains(t, delegate.providers, string(far))
+			}
+			assert.Equal(t, KeyspaceFilterStats{Mode: mode, K: 1, Heads: 1, Accepted: 1, Rejected: 1, RejectedRatio: 0.5}, f.Stats())
+		})
+	}
+}
+
+func TestParseKeyspaceFilterMode(t *testing.T) {
+	mode, err := ParseKeyspaceFilterMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyspaceFilterNone, mode)
+	mode, err = ParseKeyspaceFilterMode("dry-run")
+	assert.NoError(t, err)
+	assert.Equal(t, KeyspaceFilterDryRun, mode)
+	_, err = ParseKeyspaceFilterMode("strict")
+	assert.Error(t, err)
+}
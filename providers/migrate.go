@@ -0,0 +1,263 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// MigrationPhase is the progress of a migration between provider stores.
+type MigrationPhase int32
+
+const (
+	// MigrationBackfilling means the existing records of the source are being copied to the target.
+	MigrationBackfilling MigrationPhase = iota
+	// MigrationCatchingUp means the backfill is done, and the dual-writes queued during it are being written to the target.
+	MigrationCatchingUp
+	// MigrationCaughtUp means the target holds all the records of the source, but reads are not switched over.
+	MigrationCaughtUp
+	// MigrationSwitched means the target holds all the records of the source, and reads are served by the target.
+	MigrationSwitched
+	// MigrationFailed means the backfill could not enumerate the records of the source. New records are still dual-written.
+	MigrationFailed
+)
+
+func (p MigrationPhase) String() string {
+	switch p {
+	case MigrationBackfilling:
+		return "backfilling"
+	case MigrationCatchingUp:
+		return "catching_up"
+	case MigrationCaughtUp:
+		return "caught_up"
+	case MigrationSwitched:
+		return "switched"
+	case MigrationFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("MigrationPhase(%d)", int32(p))
+}
+
+const (
+	// DefaultMigrationQueueSize is the number of dual-writes that can be waiting to be written to the target.
+	DefaultMigrationQueueSize = 10000
+	// DefaultMigrationWorkers is the number of concurrent writes to the target.
+	DefaultMigrationWorkers = 8
+
+	migrationStatusInterval = 10 * time.Second
+)
+
+// Values of the "status" label of the backfilled records metric.
+const (
+	backfillSucceeded = "succeeded"
+	backfillFailed    = "failed"
+	backfillExpired   = "expired"
+)
+
+type migrationWrite struct {
+	target providers.ProviderStore
+	key    []byte
+	prov   peer.AddrInfo
+}
+
+// Migration is the state of a migration between two provider stores, shared by the migrating provider stores of all the heads.
+//
+// New records are written to the source and queued to be written to the target. The existing records of the source are
+// backfilled to the target once, using the provider stores of the first head. Once the backfill is done and the queue of
+// dual-writes is drained, the target has caught up, and reads are switched over to the target if SwitchReads is set.
+type Migration struct {
+	SwitchReads bool
+
+	queue        chan migrationWrite
+	phase        int32
+	backfillOnce sync.Once
+	backfilled   int64
+	errors       int64
+}
+
+// NewMigration creates a migration and starts the workers writing queued dual-writes to the target until the context is done.
+func NewMigration(ctx context.Context, switchReads bool) *Migration {
+	m := &Migration{
+		SwitchReads: switchReads,
+		queue:       make(chan migrationWrite, DefaultMigrationQueueSize),
+	}
+	for i := 0; i < DefaultMigrationWorkers; i++ {
+		go m.runWriter(ctx)
+	}
+	go m.runStatus(ctx)
+	return m
+}
+
+// Phase returns the current phase of the migration.
+func (m *Migration) Phase() MigrationPhase {
+	return MigrationPhase(atomic.LoadInt32(&m.phase))
+}
+
+func (m *Migration) setPhase(ctx context.Context, p MigrationPhase) {
+	atomic.StoreInt32(&m.phase, int32(p))
+	m.reportPhase(ctx, p)
+}
+
+func (m *Migration) reportPhase(ctx context.Context, p MigrationPhase) {
+	stats.Record(ctx, metrics.MigrationPhase.M(int64(p)))
+	fmt.Fprintf(os.Stderr, "🚚 Provider store migration %s (%d records backfilled, %d errors)\n", p, atomic.LoadInt64(&m.backfilled), atomic.LoadInt64(&m.errors))
+}
+
+// Lag returns the number of dual-writes waiting to be written to the target.
+func (m *Migration) Lag() int {
+	return len(m.queue)
+}
+
+func (m *Migration) recordError(ctx context.Context, operation string) {
+	atomic.AddInt64(&m.errors, 1)
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyOperation, operation)}, metrics.MigrationErrors.M(1))
+}
+
+// enqueue queues a dual-write to the target. If the queue is full, the record is written synchronously,
+// so that the target never misses a record that was added to the source.
+func (m *Migration) enqueue(ctx context.Context, w migrationWrite) {
+	select {
+	case m.queue <- w:
+	default:
+		m.write(ctx, w)
+	}
+}
+
+func (m *Migration) write(ctx context.Context, w migrationWrite) {
+	if err := w.target.AddProvider(ctx, w.key, w.prov); err != nil {
+		m.recordError(ctx, "AddProvider")
+	}
+}
+
+func (m *Migration) runWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case w := <-m.queue:
+			m.write(ctx, w)
+		}
+	}
+}
+
+// runStatus records the lag and phase, and switches phase once the target caught up.
+func (m *Migration) runStatus(ctx context.Context) {
+	ticker := time.NewTicker(migrationStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lag := m.Lag()
+			stats.Record(ctx, metrics.MigrationLag.M(int64(lag)), metrics.MigrationPhase.M(int64(m.Phase())))
+			if m.Phase() == MigrationCatchingUp && lag == 0 {
+				m.catchUp(ctx)
+			}
+		}
+	}
+}
+
+// catchUp moves on from the catching up phase, if the migration is still in it.
+func (m *Migration) catchUp(ctx context.Context) {
+	next := MigrationCaughtUp
+	if m.SwitchReads {
+		next = MigrationSwitched
+	}
+	if atomic.CompareAndSwapInt32(&m.phase, int32(MigrationCatchingUp), int32(next)) {
+		m.reportPhase(ctx, next)
+	}
+}
+
+// backfill copies the unexpired records of the source to the target.
+func (m *Migration) backfill(ctx context.Context, source, target providers.ProviderStore) {
+	it, ok := FindProviderRecordIterator(source)
+	if !ok {
+		m.recordError(ctx, "Backfill")
+		m.setPhase(ctx, MigrationFailed)
+		return
+	}
+	err := it.IterateProviderRecords(ctx, func(r ProviderRecord) error {
+		status := backfillSucceeded
+		if !r.Expires.IsZero() && r.Expires.Before(time.Now()) {
+			status = backfillExpired
+		} else if err := target.AddProvider(ctx, r.Key, r.Provider); err != nil {
+			status = backfillFailed
+			m.recordError(ctx, "Backfill")
+		} else {
+			atomic.AddInt64(&m.backfilled, 1)
+		}
+		stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.MigrationBackfilled.M(1))
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			m.recordError(ctx, "Backfill")
+			fmt.Fprintf(os.Stderr, "🚚 Provider store migration backfill failed: %s\n", err)
+		}
+		m.setPhase(ctx, MigrationFailed)
+		return
+	}
+	m.setPhase(ctx, MigrationCatchingUp)
+	if m.Lag() == 0 {
+		m.catchUp(ctx)
+	}
+}
+
+// MigratingProviderStore migrates provider records from a source to a target provider store, see Migration.
+type MigratingProviderStore struct {
+	Source    providers.ProviderStore
+	Target    providers.ProviderStore
+	Migration *Migration
+}
+
+// NewMigratingProviderStore creates a provider store migrating records from source to target.
+// The first migrating provider store created for a migration starts the backfill in the background.
+func NewMigratingProviderStore(ctx context.Context, m *Migration, source, target providers.ProviderStore) *MigratingProviderStore {
+	m.backfillOnce.Do(func() {
+		go m.backfill(ctx, source, target)
+	})
+	return &MigratingProviderStore{Source: source, Target: target, Migration: m}
+}
+
+// AddProvider adds the provider record to the source, and queues it to be written to the target.
+func (s *MigratingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if err := s.Source.AddProvider(ctx, key, prov); err != nil {
+		return err
+	}
+	// the dual-write outlives the request
+	s.Migration.enqueue(context.Background(), migrationWrite{target: s.Target, key: key, prov: prov})
+	return nil
+}
+
+// GetProviders reads from the source until reads are switched over to the target.
+// After that, the source is only read if the target fails.
+func (s *MigratingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	if s.Migration.Phase() != MigrationSwitched {
+		return s.Source.GetProviders(ctx, key)
+	}
+	provs, err := s.Target.GetProviders(ctx, key)
+	if err != nil {
+		s.Migration.recordError(ctx, "GetProviders")
+		return s.Source.GetProviders(ctx, key)
+	}
+	return provs, nil
+}
+
+// Unwrap returns the provider store currently serving reads.
+func (s *MigratingProviderStore) Unwrap() providers.ProviderStore {
+	if s.Migration.Phase() == MigrationSwitched {
+		return s.Target
+	}
+	return s.Source
+}
@@ -0,0 +1,128 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDatastoreProviderStore(t *testing.T, ctx context.Context) *DatastoreProviderStore {
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewDatastoreProviderStore(ctx, "self", ps, dssync.MutexWrap(ds.NewMapDatastore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Process().Close() })
+	return s
+}
+
+func providerIDs(provs []peer.AddrInfo) []peer.ID {
+	var ids []peer.ID
+	for _, p := range provs {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestMigratingProviderStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := newTestDatastoreProviderStore(t, ctx)
+	target := newTestDatastoreProviderStore(t, ctx)
+	assert.NoError(t, source.AddProvider(ctx, []byte("existing"), peer.AddrInfo{ID: "peer1"}))
+	// the provider manager adds records asynchronously, wait for the record to be stored
+	_, err := source.GetProviders(ctx, []byte("existing"))
+	assert.NoError(t, err)
+
+	m := NewMigration(ctx, true)
+	ps := NewMigratingProviderStore(ctx, m, source, target)
+	assert.Eventually(t, func() bool { return m.Phase() == MigrationSwitched }, 5*time.Second, 10*time.Millisecond)
+
+	// the existing record was backfilled
+	provs, err := target.GetProviders(ctx, []byte("existing"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer1"}, providerIDs(provs))
+
+	// new records are dual-written
+	assert.NoError(t, ps.AddProvider(ctx, []byte("new"), peer.AddrInfo{ID: "peer2"}))
+	provs, err = source.GetProviders(ctx, []byte("new"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer2"}, providerIDs(provs))
+	assert.Eventually(t, func() bool {
+		provs, err := target.GetProviders(ctx, []byte("new"))
+		return err == nil && len(provs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// reads are served by the target
+	assert.NoError(t, target.AddProvider(ctx, []byte("target only"), peer.AddrInfo{ID: "peer3"}))
+	provs, err = ps.GetProviders(ctx, []byte("target only"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer3"}, providerIDs(provs))
+	assert.Equal(t, target, ps.Unwrap())
+}
+
+func TestMigratingProviderStore_NoSwitch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := newTestDatastoreProviderStore(t, ctx)
+	target := newTestDatastoreProviderStore(t, ctx)
+
+	m := NewMigration(ctx, false)
+	ps := NewMigratingProviderStore(ctx, m, source, target)
+	assert.Eventually(t, func() bool { return m.Phase() == MigrationCaughtUp }, 5*time.Second, 10*time.Millisecond)
+
+	// reads are still served by the source
+	assert.NoError(t, source.AddProvider(ctx, []byte("source only"), peer.AddrInfo{ID: "peer1"}))
+	provs, err := ps.GetProviders(ctx, []byte("source only"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer1"}, providerIDs(provs))
+	assert.Equal(t, source, ps.Unwrap())
+}
+
+func TestMigratingProviderStore_TargetErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := newTestDatastoreProviderStore(t, ctx)
+	target := &mockProviderStore{err: errors.New("boom")}
+	assert.NoError(t, source.AddProvider(ctx, []byte("existing"), peer.AddrInfo{ID: "peer1"}))
+	// the provider manager adds records asynchronously, wait for the record to be stored
+	_, err := source.GetProviders(ctx, []byte("existing"))
+	assert.NoError(t, err)
+
+	m := NewMigration(ctx, true)
+	ps := NewMigratingProviderStore(ctx, m, source, target)
+	assert.Eventually(t, func() bool { return m.Phase() == MigrationSwitched }, 5*time.Second, 10*time.Millisecond)
+
+	// reads fall back to the source when the target fails
+	provs, err := ps.GetProviders(ctx, []byte("existing"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer1"}, providerIDs(provs))
+}
+
+func TestMigratingProviderStore_SourceNotIterable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMigration(ctx, true)
+	ps := NewMigratingProviderStore(ctx, m, &mockProviderStore{}, &mockProviderStore{})
+	assert.Eventually(t, func() bool { return m.Phase() == MigrationFailed }, 5*time.Second, 10*time.Millisecond)
+
+	// new records are still added to the source
+	assert.NoError(t, ps.AddProvider(ctx, []byte("new"), peer.AddrInfo{ID: "peer1"}))
+	provs, err := ps.GetProviders(ctx, []byte("new"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.ID{"peer1"}, providerIDs(provs))
+}
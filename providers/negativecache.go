@@ -0,0 +1,96 @@
+package providers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	lru "github.com/hnlq715/golang-lru"
+)
+
+// DefaultPrefetchNegativeCacheSize is the default number of keys remembered by the prefetch negative cache.
+const DefaultPrefetchNegativeCacheSize = 100000
+
+// NegativeCacheEntry is a key whose prefetch found no providers.
+type NegativeCacheEntry struct {
+	Key []byte
+	// Expires is when the key can be prefetched again.
+	Expires time.Time
+}
+
+// NegativeCache remembers the keys whose prefetch found no providers, so that they are not prefetched again until their
+// entry expires. It holds up to a bounded number of keys, evicting the least recently added or checked key when full.
+// It is thread safe.
+type NegativeCache struct {
+	TTL time.Duration
+
+	cache *lru.Cache
+	clock clock.Clock
+}
+
+func NewNegativeCache(size int, ttl time.Duration) (*NegativeCache, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("creating negative cache: %w", err)
+	}
+	return &NegativeCache{TTL: ttl, cache: cache, clock: clock.New()}, nil
+}
+
+// Add adds the key, or extends its expiry if it is already cached.
+func (c *NegativeCache) Add(key []byte) {
+	c.cache.Add(string(key), c.clock.Now().Add(c.TTL))
+}
+
+// Has returns true if the key is cached and its entry has not expired.
+func (c *NegativeCache) Has(key []byte) bool {
+	_, ok := c.Get(key)
+	return ok
+}
+
+// Get returns the entry of the key, if it is cached and has not expired. Expired entries are removed.
+func (c *NegativeCache) Get(key []byte) (NegativeCacheEntry, bool) {
+	v, ok := c.cache.Get(string(key))
+	if !ok {
+		return NegativeCacheEntry{}, false
+	}
+	expires := v.(time.Time)
+	if !c.clock.Now().Before(expires) {
+		c.cache.Remove(string(key))
+		return NegativeCacheEntry{}, false
+	}
+	return NegativeCacheEntry{Key: key, Expires: expires}, true
+}
+
+// Remove removes the key, and returns true if it was cached and had not expired.
+func (c *NegativeCache) Remove(key []byte) bool {
+	ok := c.Has(key)
+	c.cache.Remove(string(key))
+	return ok
+}
+
+// Purge removes all the keys.
+func (c *NegativeCache) Purge() {
+	c.cache.Purge()
+}
+
+// Len returns the number of cached keys, including expired keys that were not removed yet.
+func (c *NegativeCache) Len() int {
+	return c.cache.Len()
+}
+
+// Entries returns the entries that have not expired, from the least to the most recently added or checked.
+func (c *NegativeCache) Entries() []NegativeCacheEntry {
+	now := c.clock.Now()
+	var entries []NegativeCacheEntry
+	for _, k := range c.cache.Keys() {
+		// peek, so that listing the entries doesn't change which are evicted first
+		v, ok := c.cache.Peek(k)
+		if !ok {
+			continue
+		}
+		if expires := v.(time.Time); now.Before(expires) {
+			entries = append(entries, NegativeCacheEntry{Key: []byte(k.(string)), Expires: expires})
+		}
+	}
+	return entries
+}
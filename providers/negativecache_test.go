@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNegativeCache(t *testing.T, ttl time.Duration) *NegativeCache {
+	c, err := NewNegativeCache(100, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNegativeCache(t *testing.T) {
+	c, err := NewNegativeCache(2, time.Minute)
+	assert.NoError(t, err)
+	clk := clock.NewMock()
+	c.clock = clk
+
+	c.Add([]byte("a"))
+	clk.Add(time.Second)
+	c.Add([]byte("b"))
+	assert.True(t, c.Has([]byte("a")))
+
+	// the least recently added or checked key is evicted
+	c.Add([]byte("c"))
+	assert.False(t, c.Has([]byte("b")))
+	assert.True(t, c.Has([]byte("a")))
+	assert.Equal(t, 2, c.Len())
+
+	e, ok := c.Get([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, clk.Now().Add(-time.Second).Add(time.Minute), e.Expires)
+
+	entries := c.Entries()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "c", string(entries[0].Key))
+		assert.Equal(t, "a", string(entries[1].Key))
+	}
+
+	// expired entries are not returned
+	clk.Add(59 * time.Second)
+	assert.False(t, c.Has([]byte("a")))
+	entries = c.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "c", string(entries[0].Key))
+	}
+
+	assert.True(t, c.Remove([]byte("c")))
+	assert.False(t, c.Remove([]byte("c")))
+	c.Add([]byte("d"))
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+
+	_, err = NewNegativeCache(0, time.Minute)
+	assert.Error(t, err)
+}
+
+func TestCachingProviderStore_AddProviderInvalidatesNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	finder := NewAsyncProvidersFinder(10*time.Second, 10, newTestNegativeCache(t, time.Hour))
+	finder.NegativeCache().Add([]byte("key"))
+
+	ps := NewCachingProviderStore(&mockProviderStore{}, &mockProviderStore{}, finder, &mockRouter{})
+	assert.NoError(t, ps.AddProvider(ctx, []byte("key"), peer.AddrInfo{ID: "peer"}))
+	assert.False(t, finder.NegativeCache().Has([]byte("key")))
+}
+
+func TestInvalidatingProviderStore(t *testing.T) {
+	ctx := context.Background()
+	finder := NewAsyncProvidersFinder(10*time.Second, 10, newTestNegativeCache(t, time.Hour))
+	finder.NegativeCache().Add([]byte("key"))
+	finder.NegativeCache().Add([]byte("other"))
+
+	delegate := &mockProviderStore{}
+	ps := NewInvalidatingProviderStore(delegate, finder)
+	assert.NoError(t, ps.AddProvider(ctx, []byte("key"), peer.AddrInfo{ID: "peer"}))
+	assert.False(t, finder.NegativeCache().Has([]byte("key")))
+	assert.True(t, finder.NegativeCache().Has([]byte("other")))
+	assert.Len(t, delegate.providers["key"], 1)
+
+	// the key still has no providers if the delegate failed to add the record
+	delegate.err = errors.New("boom")
+	assert.Error(t, ps.AddProvider(ctx, []byte("other"), peer.AddrInfo{ID: "peer"}))
+	assert.True(t, finder.NegativeCache().Has([]byte("other")))
+}
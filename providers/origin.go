@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RecordOrigin tells how a provider record was obtained.
+type RecordOrigin byte
+
+const (
+	// OriginAnnounced records were added by their provider.
+	OriginAnnounced RecordOrigin = iota
+	// OriginPrefetched records were found on the network by prefetching.
+	OriginPrefetched
+	// OriginImported records were imported from an export.
+	OriginImported
+	// OriginReplicated records were replicated from another hydra.
+	OriginReplicated
+)
+
+// RecordOrigins lists all the record origins.
+var RecordOrigins = []RecordOrigin{OriginAnnounced, OriginPrefetched, OriginImported, OriginReplicated}
+
+func (o RecordOrigin) String() string {
+	switch o {
+	case OriginAnnounced:
+		return "announced"
+	case OriginPrefetched:
+		return "prefetched"
+	case OriginImported:
+		return "imported"
+	case OriginReplicated:
+		return "replicated"
+	}
+	return fmt.Sprintf("RecordOrigin(%d)", byte(o))
+}
+
+func (o RecordOrigin) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *RecordOrigin) UnmarshalText(b []byte) error {
+	for _, origin := range RecordOrigins {
+		if origin.String() == string(b) {
+			*o = origin
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown record origin %q", b)
+}
+
+type recordOriginKey struct{}
+
+type recordOrigin struct {
+	origin RecordOrigin
+	ttl    time.Duration
+}
+
+// WithRecordOrigin returns a context telling the provider store that the provider records added with it have the given origin,
+// and should expire after the given TTL instead of the default TTL of the provider store. A zero TTL keeps the default TTL.
+// Provider stores that cannot track the origin of their records ignore it.
+func WithRecordOrigin(ctx context.Context, origin RecordOrigin, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, recordOriginKey{}, recordOrigin{origin: origin, ttl: ttl})
+}
+
+// RecordOriginFromContext returns the origin and TTL of the provider records added with the context,
+// records are announced by default.
+func RecordOriginFromContext(ctx context.Context) (RecordOrigin, time.Duration) {
+	o, ok := ctx.Value(recordOriginKey{}).(recordOrigin)
+	if !ok {
+		return OriginAnnounced, 0
+	}
+	return o.origin, o.ttl
+}
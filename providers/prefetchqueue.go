@@ -0,0 +1,255 @@
+package providers
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+const (
+	// number of rows of the request frequency sketch
+	sketchDepth = 4
+	// the counters of the sketch are halved after this many increments per counter of a row,
+	// so that the sketch tracks recent request frequency
+	sketchDecayFactor = 10
+	// number of counters per row of the sketch for each request the queue can hold
+	sketchWidthFactor = 4
+	minSketchWidth    = 256
+)
+
+// frequencySketch estimates how often keys were recently requested, using a count-min sketch of bounded size.
+// Estimates are never lower than the true counts since the last decay, but may be higher because of collisions.
+// It is not thread safe.
+type frequencySketch struct {
+	rows      [sketchDepth][]uint32
+	mask      uint64
+	additions int
+	decayAt   int
+}
+
+// newFrequencySketch creates a sketch with rows of at least the given width, rounded up to a power of two.
+func newFrequencySketch(width int) *frequencySketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	s := &frequencySketch{mask: uint64(w - 1), decayAt: w * sketchDecayFactor}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, w)
+	}
+	return s
+}
+
+// index returns the counter of the key in the given row, using double hashing to derive the hash of each row.
+func (s *frequencySketch) index(h1, h2 uint64, row int) uint64 {
+	return (h1 + uint64(row)*h2) & s.mask
+}
+
+func sketchHashes(key string) (uint64, uint64) {
+	h1 := hashKey([]byte(key))
+	// an odd second hash visits different counters in each row
+	h2 := (h1>>32 | h1<<32) | 1
+	return h1, h2
+}
+
+// Increment counts a request for the key, and returns the estimated number of recent requests for it.
+func (s *frequencySketch) Increment(key string) uint32 {
+	h1, h2 := sketchHashes(key)
+	est := ^uint32(0)
+	for i := range s.rows {
+		c := &s.rows[i][s.index(h1, h2, i)]
+		if *c < ^uint32(0) {
+			*c++
+		}
+		if *c < est {
+			est = *c
+		}
+	}
+	s.additions++
+	if s.additions >= s.decayAt {
+		s.decay()
+	}
+	return est
+}
+
+// Estimate returns the estimated number of recent requests for the key.
+func (s *frequencySketch) Estimate(key string) uint32 {
+	h1, h2 := sketchHashes(key)
+	est := ^uint32(0)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h1, h2, i)]; c < est {
+			est = c
+		}
+	}
+	return est
+}
+
+// decay halves all the counters, so that old requests count less than recent ones.
+func (s *frequencySketch) decay() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+type prefetchItem struct {
+	req      findRequest
+	priority uint32
+	// seq orders items of the same priority by arrival
+	seq      uint64
+	maxIndex int
+	minIndex int
+}
+
+// maxPrefetchHeap orders items by decreasing priority, then by arrival.
+type maxPrefetchHeap []*prefetchItem
+
+func (h maxPrefetchHeap) Len() int { return len(h) }
+func (h maxPrefetchHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h maxPrefetchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].maxIndex = i
+	h[j].maxIndex = j
+}
+func (h *maxPrefetchHeap) Push(x interface{}) {
+	it := x.(*prefetchItem)
+	it.maxIndex = len(*h)
+	*h = append(*h, it)
+}
+func (h *maxPrefetchHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
+
+// minPrefetchHeap orders items by increasing priority, then by arrival.
+type minPrefetchHeap []*prefetchItem
+
+func (h minPrefetchHeap) Len() int { return len(h) }
+func (h minPrefetchHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h minPrefetchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].minIndex = i
+	h[j].minIndex = j
+}
+func (h *minPrefetchHeap) Push(x interface{}) {
+	it := x.(*prefetchItem)
+	it.minIndex = len(*h)
+	*h = append(*h, it)
+}
+func (h *minPrefetchHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
+
+// prefetchQueue is a bounded priority queue of find requests. The highest priority request is processed first,
+// and the lowest priority request is evicted when a higher priority request is pushed to a full queue.
+type prefetchQueue struct {
+	mut   sync.Mutex
+	size  int
+	seq   uint64
+	max   maxPrefetchHeap
+	min   minPrefetchHeap
+	items map[string]*prefetchItem
+	// ready holds a token for each queued item, so that workers can wait for items
+	ready chan struct{}
+}
+
+func newPrefetchQueue(size int) *prefetchQueue {
+	return &prefetchQueue{
+		size:  size,
+		items: map[string]*prefetchItem{},
+		ready: make(chan struct{}, size),
+	}
+}
+
+func (q *prefetchQueue) Len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	return len(q.max)
+}
+
+// Push queues the request with the given priority. If the queue is full, the lowest priority request is evicted to make
+// room for it and returned, unless the request doesn't have a higher priority, in which case it is not queued.
+func (q *prefetchQueue) Push(req findRequest, priority uint32) (evicted *findRequest, ok bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.max) >= q.size {
+		if q.size == 0 {
+			return nil, false
+		}
+		lowest := q.min[0]
+		if lowest.priority >= priority {
+			return nil, false
+		}
+		q.remove(lowest)
+		evicted = &lowest.req
+	}
+
+	q.seq++
+	it := &prefetchItem{req: req, priority: priority, seq: q.seq}
+	heap.Push(&q.max, it)
+	heap.Push(&q.min, it)
+	q.items[string(req.key)] = it
+	if evicted == nil {
+		// an evicted item's token is taken over by the new item
+		q.ready <- struct{}{}
+	}
+	return evicted, true
+}
+
+// Update raises the priority of a queued request, and returns false if the request is not queued.
+func (q *prefetchQueue) Update(key string, priority uint32) bool {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	it, ok := q.items[key]
+	if !ok {
+		return false
+	}
+	if priority > it.priority {
+		it.priority = priority
+		heap.Fix(&q.max, it.maxIndex)
+		heap.Fix(&q.min, it.minIndex)
+	}
+	return true
+}
+
+// Pop waits for a request and returns the highest priority request, or false if the context is done first.
+func (q *prefetchQueue) Pop(ctx context.Context) (findRequest, uint32, bool) {
+	select {
+	case <-ctx.Done():
+		return findRequest{}, 0, false
+	case <-q.ready:
+	}
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	it := q.max[0]
+	q.remove(it)
+	return it.req, it.priority, true
+}
+
+func (q *prefetchQueue) remove(it *prefetchItem) {
+	heap.Remove(&q.max, it.maxIndex)
+	heap.Remove(&q.min, it.minIndex)
+	delete(q.items, string(it.req.key))
+}
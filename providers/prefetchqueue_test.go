@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrequencySketch(t *testing.T) {
+	s := newFrequencySketch(1024)
+	for i := 0; i < 100; i++ {
+		s.Increment("popular")
+	}
+	for i := 0; i < 500; i++ {
+		s.Increment(fmt.Sprintf("key-%d", i))
+	}
+	assert.GreaterOrEqual(t, s.Estimate("popular"), uint32(100))
+	assert.GreaterOrEqual(t, s.Estimate("key-1"), uint32(1))
+	assert.Less(t, s.Estimate("key-1"), uint32(100))
+
+	// counters are halved after enough increments
+	for i := 0; i < s.decayAt; i++ {
+		s.Increment(fmt.Sprintf("other-%d", i))
+	}
+	assert.Less(t, s.Estimate("popular"), uint32(100))
+	assert.GreaterOrEqual(t, s.Estimate("popular"), uint32(25))
+}
+
+func findRequestFor(key string) findRequest {
+	return findRequest{ctx: context.Background(), key: []byte(key)}
+}
+
+func TestPrefetchQueue_Priority(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	q := newPrefetchQueue(10)
+	for _, k := range []struct {
+		key      string
+		priority uint32
+	}{{"low", 1}, {"high", 10}, {"medium", 5}, {"low2", 1}} {
+		_, ok := q.Push(findRequestFor(k.key), k.priority)
+		assert.True(t, ok)
+	}
+	assert.True(t, q.Update("low2", 7))
+	assert.False(t, q.Update("unknown", 7))
+
+	var keys []string
+	for q.Len() > 0 {
+		req, _, ok := q.Pop(ctx)
+		assert.True(t, ok)
+		keys = append(keys, string(req.key))
+	}
+	assert.Equal(t, []string{"high", "low2", "medium", "low"}, keys)
+
+	// popping an empty queue waits for the context
+	cancel()
+	_, _, ok := q.Pop(ctx)
+	assert.False(t, ok)
+}
+
+func TestPrefetchQueue_Eviction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	q := newPrefetchQueue(2)
+	_, ok := q.Push(findRequestFor("a"), 2)
+	assert.True(t, ok)
+	_, ok = q.Push(findRequestFor("b"), 1)
+	assert.True(t, ok)
+
+	// a request of no higher priority than the lowest queued one is not queued
+	evicted, ok := q.Push(findRequestFor("c"), 1)
+	assert.False(t, ok)
+	assert.Nil(t, evicted)
+
+	// a request of higher priority evicts the lowest queued one
+	evicted, ok = q.Push(findRequestFor("d"), 3)
+	assert.True(t, ok)
+	assert.Equal(t, "b", string(evicted.key))
+	assert.Equal(t, 2, q.Len())
+
+	req, priority, ok := q.Pop(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "d", string(req.key))
+	assert.Equal(t, uint32(3), priority)
+	req, _, ok = q.Pop(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(req.key))
+	assert.Equal(t, 0, q.Len())
+}
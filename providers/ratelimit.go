@@ -0,0 +1,231 @@
+package providers
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	// ThrottleRateLimited is the reason a provider record is dropped when its peer exceeded its rate limit.
+	ThrottleRateLimited = "rate_limited"
+	// ThrottleQuotaExceeded is the reason a provider record is dropped when its peer has too many live records.
+	ThrottleQuotaExceeded = "quota_exceeded"
+
+	// how long a peer is reported as an offender after it was last throttled
+	offenderRetention = time.Hour
+)
+
+// PeerLimits are the limits applied to the provider records added by each peer.
+type PeerLimits struct {
+	// Rate is the number of provider records per second a peer can add, once its burst is used up. Zero disables rate limiting.
+	Rate float64
+	// Burst is the number of provider records a peer can add at once.
+	Burst int
+	// MaxRecords is the maximum number of live provider records of a peer. Zero disables the quota.
+	MaxRecords int
+	// RecordTTL is how long a provider record counts towards the quota after it was last added.
+	RecordTTL time.Duration
+}
+
+// PeerOffender describes a peer whose provider records were throttled.
+type PeerOffender struct {
+	Peer          peer.ID
+	RateLimited   int64
+	QuotaExceeded int64
+	LiveRecords   int
+	LastThrottled time.Time
+}
+
+type peerLimitState struct {
+	tokens     float64
+	lastRefill time.Time
+	// expiry of the peer's live records, by key hash
+	records map[uint64]time.Time
+
+	rateLimited   int64
+	quotaExceeded int64
+	lastThrottled time.Time
+}
+
+// PeerLimiter applies token bucket rate limits and live record quotas to the provider records of each peer.
+// A single PeerLimiter is meant to be shared by all the heads of a hydra, so that the limits apply hydra-wide.
+type PeerLimiter struct {
+	Limits PeerLimits
+
+	mut   sync.Mutex
+	peers map[peer.ID]*peerLimitState
+	clock clock.Clock
+}
+
+func NewPeerLimiter(limits PeerLimits) *PeerLimiter {
+	return &PeerLimiter{
+		Limits: limits,
+		peers:  map[peer.ID]*peerLimitState{},
+		clock:  clock.New(),
+	}
+}
+
+// Allow returns whether the peer may add a provider record for the key, and the reason if it may not.
+// Adding a record the peer already has refreshes it without counting towards the quota.
+func (l *PeerLimiter) Allow(p peer.ID, key []byte) (bool, string) {
+	now := l.clock.Now()
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	st, ok := l.peers[p]
+	if !ok {
+		st = &peerLimitState{tokens: float64(l.Limits.Burst), lastRefill: now, records: map[uint64]time.Time{}}
+		l.peers[p] = st
+	}
+
+	if l.Limits.Rate > 0 {
+		st.tokens += now.Sub(st.lastRefill).Seconds() * l.Limits.Rate
+		if st.tokens > float64(l.Limits.Burst) {
+			st.tokens = float64(l.Limits.Burst)
+		}
+		st.lastRefill = now
+		if st.tokens < 1 {
+			st.rateLimited++
+			st.lastThrottled = now
+			return false, ThrottleRateLimited
+		}
+	}
+
+	if l.Limits.MaxRecords > 0 {
+		h := hashKey(key)
+		if _, ok := st.records[h]; !ok && len(st.records) >= l.Limits.MaxRecords {
+			st.expireRecords(now)
+			if len(st.records) >= l.Limits.MaxRecords {
+				st.quotaExceeded++
+				st.lastThrottled = now
+				return false, ThrottleQuotaExceeded
+			}
+		}
+		st.records[h] = now.Add(l.Limits.RecordTTL)
+	}
+
+	if l.Limits.Rate > 0 {
+		st.tokens--
+	}
+	return true, ""
+}
+
+func (st *peerLimitState) expireRecords(now time.Time) {
+	for h, exp := range st.records {
+		if !now.Before(exp) {
+			delete(st.records, h)
+		}
+	}
+}
+
+// Run periodically forgets the state of peers that are no longer limited, and records the number of throttled peers.
+func (l *PeerLimiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := l.clock.Ticker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats.Record(ctx, metrics.ThrottledPeers.M(int64(l.prune(interval))))
+		}
+	}
+}
+
+// prune forgets the state of idle peers, and returns the number of peers throttled within the given period.
+func (l *PeerLimiter) prune(period time.Duration) int {
+	now := l.clock.Now()
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	throttled := 0
+	for p, st := range l.peers {
+		if now.Sub(st.lastThrottled) < period {
+			throttled++
+		}
+		st.expireRecords(now)
+		refilled := l.Limits.Rate <= 0 || st.tokens+now.Sub(st.lastRefill).Seconds()*l.Limits.Rate >= float64(l.Limits.Burst)
+		if refilled && len(st.records) == 0 && now.Sub(st.lastThrottled) >= offenderRetention {
+			delete(l.peers, p)
+		}
+	}
+	return throttled
+}
+
+// TopOffenders returns up to n of the peers that were most throttled within the last hour, most throttled first.
+func (l *PeerLimiter) TopOffenders(n int) []PeerOffender {
+	now := l.clock.Now()
+
+	l.mut.Lock()
+	var offenders []PeerOffender
+	for p, st := range l.peers {
+		if st.rateLimited+st.quotaExceeded == 0 || now.Sub(st.lastThrottled) >= offenderRetention {
+			continue
+		}
+		offenders = append(offenders, PeerOffender{
+			Peer:          p,
+			RateLimited:   st.rateLimited,
+			QuotaExceeded: st.quotaExceeded,
+			LiveRecords:   len(st.records),
+			LastThrottled: st.lastThrottled,
+		})
+	}
+	l.mut.Unlock()
+
+	sort.Slice(offenders, func(i, j int) bool {
+		ti := offenders[i].RateLimited + offenders[i].QuotaExceeded
+		tj := offenders[j].RateLimited + offenders[j].QuotaExceeded
+		if ti != tj {
+			return ti > tj
+		}
+		return offenders[i].Peer < offenders[j].Peer
+	})
+	if n >= 0 && len(offenders) > n {
+		offenders = offenders[:n]
+	}
+	return offenders
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// RateLimitedProviderStore drops the provider records of peers that exceed their limits.
+type RateLimitedProviderStore struct {
+	Delegate providers.ProviderStore
+	Limiter  *PeerLimiter
+}
+
+func NewRateLimitedProviderStore(delegate providers.ProviderStore, limiter *PeerLimiter) *RateLimitedProviderStore {
+	return &RateLimitedProviderStore{Delegate: delegate, Limiter: limiter}
+}
+
+func (s *RateLimitedProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if ok, reason := s.Limiter.Allow(prov.ID, key); !ok {
+		stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyReason, reason)}, metrics.ProviderRecordsThrottled.M(1))
+		return nil
+	}
+	return s.Delegate.AddProvider(ctx, key, prov)
+}
+
+func (s *RateLimitedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	return s.Delegate.GetProviders(ctx, key)
+}
+
+func (s *RateLimitedProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
@@ -0,0 +1,118 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPeerLimiter(limits PeerLimits) (*PeerLimiter, *clock.Mock) {
+	l := NewPeerLimiter(limits)
+	clk := clock.NewMock()
+	l.clock = clk
+	return l, clk
+}
+
+func TestPeerLimiter_Rate(t *testing.T) {
+	l, clk := newTestPeerLimiter(PeerLimits{Rate: 1, Burst: 2})
+	p1, p2 := peer.ID("peer1"), peer.ID("peer2")
+
+	allowed := func(p peer.ID) bool {
+		ok, _ := l.Allow(p, []byte("key"))
+		return ok
+	}
+
+	assert.True(t, allowed(p1))
+	assert.True(t, allowed(p1))
+	ok, reason := l.Allow(p1, []byte("key"))
+	assert.False(t, ok)
+	assert.Equal(t, ThrottleRateLimited, reason)
+
+	// other peers have their own bucket
+	assert.True(t, allowed(p2))
+
+	clk.Add(time.Second)
+	assert.True(t, allowed(p1))
+	assert.False(t, allowed(p1))
+
+	// the bucket doesn't fill beyond the burst
+	clk.Add(time.Hour)
+	assert.True(t, allowed(p1))
+	assert.True(t, allowed(p1))
+	assert.False(t, allowed(p1))
+}
+
+func TestPeerLimiter_Quota(t *testing.T) {
+	l, clk := newTestPeerLimiter(PeerLimits{MaxRecords: 2, RecordTTL: time.Hour})
+	p := peer.ID("peer1")
+
+	allowed := func(key string) bool {
+		ok, _ := l.Allow(p, []byte(key))
+		return ok
+	}
+
+	assert.True(t, allowed("a"))
+	assert.True(t, allowed("b"))
+	// refreshing an existing record doesn't count towards the quota
+	assert.True(t, allowed("a"))
+	ok, reason := l.Allow(p, []byte("c"))
+	assert.False(t, ok)
+	assert.Equal(t, ThrottleQuotaExceeded, reason)
+
+	clk.Add(30 * time.Minute)
+	assert.True(t, allowed("a"))
+	// "b" expires, but "a" was refreshed
+	clk.Add(31 * time.Minute)
+	assert.True(t, allowed("c"))
+	assert.False(t, allowed("d"))
+}
+
+func TestPeerLimiter_TopOffenders(t *testing.T) {
+	l, clk := newTestPeerLimiter(PeerLimits{Rate: 1, Burst: 1})
+	p1, p2, p3 := peer.ID("peer1"), peer.ID("peer2"), peer.ID("peer3")
+
+	for i := 0; i < 3; i++ {
+		l.Allow(p1, []byte("key"))
+	}
+	for i := 0; i < 4; i++ {
+		l.Allow(p2, []byte("key"))
+	}
+	l.Allow(p3, []byte("key"))
+
+	offenders := l.TopOffenders(10)
+	assert.Len(t, offenders, 2)
+	assert.Equal(t, p2, offenders[0].Peer)
+	assert.Equal(t, int64(3), offenders[0].RateLimited)
+	assert.Equal(t, p1, offenders[1].Peer)
+	assert.Len(t, l.TopOffenders(1), 1)
+
+	assert.Equal(t, 2, l.prune(time.Minute))
+
+	// idle peers are eventually forgotten
+	clk.Add(offenderRetention)
+	assert.Empty(t, l.TopOffenders(10))
+	assert.Equal(t, 0, l.prune(time.Minute))
+	assert.Empty(t, l.peers)
+}
+
+func TestRateLimitedProviderStore(t *testing.T) {
+	ctx := context.Background()
+	delegate := &mockProviderStore{}
+	l, _ := newTestPeerLimiter(PeerLimits{Rate: 1, Burst: 1})
+	ps := NewRateLimitedProviderStore(delegate, l)
+
+	prov := peer.AddrInfo{ID: peer.ID("peer1")}
+	assert.NoError(t, ps.AddProvider(ctx, []byte("a"), prov))
+	assert.NoError(t, ps.AddProvider(ctx, []byte("b"), prov))
+
+	provs, err := ps.GetProviders(ctx, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{prov}, provs)
+	provs, err = ps.GetProviders(ctx, []byte("b"))
+	assert.NoError(t, err)
+	assert.Empty(t, provs)
+}
@@ -0,0 +1,44 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Replicator replicates provider records to other hydras.
+type Replicator interface {
+	// Replicate queues the replication of a provider record, without blocking.
+	Replicate(ctx context.Context, key []byte, prov peer.AddrInfo)
+}
+
+// ReplicatingProviderStore is a provider store replicating the provider records announced to it to other hydras, once
+// they were added to the Delegate.
+type ReplicatingProviderStore struct {
+	Delegate   providers.ProviderStore
+	Replicator Replicator
+}
+
+func NewReplicatingProviderStore(delegate providers.ProviderStore, replicator Replicator) *ReplicatingProviderStore {
+	return &ReplicatingProviderStore{Delegate: delegate, Replicator: replicator}
+}
+
+func (s *ReplicatingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if err := s.Delegate.AddProvider(ctx, key, prov); err != nil {
+		return err
+	}
+	// other hydras prefetch or import records themselves
+	if origin, _ := RecordOriginFromContext(ctx); origin == OriginAnnounced {
+		s.Replicator.Replicate(ctx, key, prov)
+	}
+	return nil
+}
+
+func (s *ReplicatingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	return s.Delegate.GetProviders(ctx, key)
+}
+
+func (s *ReplicatingProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
@@ -0,0 +1,36 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockReplicator struct {
+	replicated map[string][]peer.AddrInfo
+}
+
+func (m *mockReplicator) Replicate(ctx context.Context, key []byte, prov peer.AddrInfo) {
+	m.replicated[string(key)] = append(m.replicated[string(key)], prov)
+}
+
+func TestReplicatingProviderStore(t *testing.T) {
+	ctx := context.Background()
+	delegate := &mockProviderStore{}
+	replicator := &mockReplicator{replicated: map[string][]peer.AddrInfo{}}
+	s := NewReplicatingProviderStore(delegate, replicator)
+
+	assert.NoError(t, s.AddProvider(ctx, []byte("announced"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.AddProvider(WithRecordOrigin(ctx, OriginPrefetched, time.Hour), []byte("prefetched"), peer.AddrInfo{ID: "peer"}))
+	assert.Equal(t, map[string][]peer.AddrInfo{"announced": {{ID: "peer"}}}, replicator.replicated)
+	assert.Len(t, delegate.providers, 2)
+
+	// records that could not be added are not replicated
+	delegate.err = errors.New("boom")
+	assert.Error(t, s.AddProvider(ctx, []byte("failed"), peer.AddrInfo{ID: "peer"}))
+	assert.NotContains(t, replicator.replicated, "failed")
+}
@@ -0,0 +1,266 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	lru "github.com/hnlq715/golang-lru"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/multiformats/go-multiaddr"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	DefaultAddrResolverWorkers   = 16
+	DefaultAddrResolverQueueSize = 1000
+	DefaultAddrResolverTimeout   = 10 * time.Second
+	// DefaultAddrResolverCacheSize is the default number of recently resolved peers whose addresses are remembered.
+	DefaultAddrResolverCacheSize = 10000
+	// DefaultAddrResolverCacheTTL is the default time a peer is not resolved again after it was resolved.
+	DefaultAddrResolverCacheTTL = 10 * time.Minute
+)
+
+// PeerRouting finds the addresses of peers, such as the DHT.
+type PeerRouting interface {
+	FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error)
+}
+
+// Resolution is the resolution of the addresses of a peer.
+type Resolution struct {
+	done  chan struct{}
+	addrs []multiaddr.Multiaddr
+}
+
+// Done returns a channel that is closed once the peer is resolved.
+func (r *Resolution) Done() <-chan struct{} {
+	return r.done
+}
+
+// Addrs returns the addresses that were found, possibly none. It must only be called once the peer is resolved.
+func (r *Resolution) Addrs() []multiaddr.Multiaddr {
+	return r.addrs
+}
+
+type resolveRequest struct {
+	router    PeerRouting
+	peerstore peerStore
+	id        peer.ID
+	res       *Resolution
+}
+
+// resolvedAddrs are the addresses found for a recently resolved peer, which may be none.
+type resolvedAddrs struct {
+	addrs   []multiaddr.Multiaddr
+	expires time.Time
+}
+
+// AddrResolver resolves the addresses of peers in the background, with a bounded number of workers, and adds them to
+// the peerstore they were requested for. It is meant to be shared by all the heads of a hydra.
+//
+// Peers queued or being resolved are resolved once, and resolved peers are not resolved again until their cache entry
+// expires, even if no addresses were found. Peers requested while the queue is full are dropped.
+type AddrResolver struct {
+	Timeout time.Duration
+
+	log     logging.EventLogger
+	queue   chan resolveRequest
+	mut     sync.Mutex
+	pending map[peer.ID]*Resolution
+	cache   *lru.Cache
+	ttl     time.Duration
+	clock   clock.Clock
+}
+
+func NewAddrResolver(queueSize int, cacheSize int, cacheTTL time.Duration, timeout time.Duration) (*AddrResolver, error) {
+	cache, err := lru.New(cacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("creating address resolver cache: %w", err)
+	}
+	return &AddrResolver{
+		Timeout: timeout,
+		log:     logging.Logger("hydra/resolver"),
+		queue:   make(chan resolveRequest, queueSize),
+		pending: map[peer.ID]*Resolution{},
+		cache:   cache,
+		ttl:     cacheTTL,
+		clock:   clock.New(),
+	}, nil
+}
+
+// Run starts the given number of workers, which resolve queued peers until the context is done.
+func (r *AddrResolver) Run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case req := <-r.queue:
+					r.resolve(ctx, req)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Resolve queues the resolution of the addresses of the peer with the router, unless it is already queued.
+// It returns nil if the resolution was dropped because the queue is full. Recently resolved peers are resolved at once,
+// and their addresses added to the peerstore again.
+func (r *AddrResolver) Resolve(ctx context.Context, router PeerRouting, ps peerStore, id peer.ID) *Resolution {
+	r.mut.Lock()
+	if v, ok := r.cache.Get(id); ok {
+		cached := v.(resolvedAddrs)
+		if r.clock.Now().Before(cached.expires) {
+			r.mut.Unlock()
+			recordAddrResolution(ctx, "cached")
+			if len(cached.addrs) > 0 {
+				ps.AddAddrs(id, cached.addrs, providers.ProviderAddrTTL)
+			}
+			res := &Resolution{done: make(chan struct{}), addrs: cached.addrs}
+			close(res.done)
+			return res
+		}
+		r.cache.Remove(id)
+	}
+	res, ok := r.pending[id]
+	if !ok {
+		res = &Resolution{done: make(chan struct{})}
+		select {
+		case r.queue <- resolveRequest{router: router, peerstore: ps, id: id, res: res}:
+			r.pending[id] = res
+			recordAddrResolution(ctx, "queued")
+		default:
+			r.mut.Unlock()
+			recordAddrResolution(ctx, "dropped")
+			return nil
+		}
+	}
+	r.mut.Unlock()
+	return res
+}
+
+func (r *AddrResolver) resolve(ctx context.Context, req resolveRequest) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	ai, err := req.router.FindPeer(ctx, req.id)
+	status := "succeeded"
+	if err != nil {
+		r.log.Debugf("failed to resolve the addresses of %s: %s", req.id, err)
+		status = "failed"
+	} else if len(ai.Addrs) > 0 {
+		req.peerstore.AddAddrs(req.id, ai.Addrs, providers.ProviderAddrTTL)
+	}
+	recordAddrResolution(ctx, status)
+
+	r.mut.Lock()
+	delete(r.pending, req.id)
+	r.cache.Add(req.id, resolvedAddrs{addrs: ai.Addrs, expires: r.clock.Now().Add(r.ttl)})
+	r.mut.Unlock()
+
+	req.res.addrs = ai.Addrs
+	close(req.res.done)
+}
+
+func recordAddrResolution(ctx context.Context, status string) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.ProviderAddrResolutions.M(1))
+}
+
+// AddrResolvingProviderStore is a provider store resolving the addresses of the providers returned without addresses.
+// Their addresses are looked up in the peerstore, and if it has none they are resolved in the background with the
+// Router, waiting at most Wait for them to be resolved. Providers that are not resolved in time are returned without
+// addresses, and their resolved addresses are found in the peerstore by later requests.
+type AddrResolvingProviderStore struct {
+	Delegate  providers.ProviderStore
+	Resolver  *AddrResolver
+	Peerstore peerStore
+	// Router resolves the addresses of peers, no addresses are resolved while it is nil.
+	Router PeerRouting
+	Wait   time.Duration
+
+	clock clock.Clock
+}
+
+func NewAddrResolvingProviderStore(delegate providers.ProviderStore, resolver *AddrResolver, ps peerStore, wait time.Duration) *AddrResolvingProviderStore {
+	return &AddrResolvingProviderStore{
+		Delegate:  delegate,
+		Resolver:  resolver,
+		Peerstore: ps,
+		Wait:      wait,
+		clock:     clock.New(),
+	}
+}
+
+func (s *AddrResolvingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	return s.Delegate.AddProvider(ctx, key, prov)
+}
+
+func (s *AddrResolvingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	provs, err := s.Delegate.GetProviders(ctx, key)
+	if err != nil || s.Router == nil {
+		return provs, err
+	}
+
+	var resolved []peer.AddrInfo
+	resolutions := map[int]*Resolution{}
+	for i, p := range provs {
+		if len(p.Addrs) > 0 {
+			continue
+		}
+		if resolved == nil {
+			// don't modify the providers in place, the slice may be shared with a cache
+			resolved = make([]peer.AddrInfo, len(provs))
+			copy(resolved, provs)
+		}
+		if addrs := s.Peerstore.PeerInfo(p.ID).Addrs; len(addrs) > 0 {
+			resolved[i].Addrs = addrs
+			continue
+		}
+		if res := s.Resolver.Resolve(ctx, s.Router, s.Peerstore, p.ID); res != nil {
+			resolutions[i] = res
+		}
+	}
+	if resolved == nil {
+		return provs, nil
+	}
+	if s.Wait <= 0 || len(resolutions) == 0 {
+		return resolved, nil
+	}
+
+	timer := s.clock.Timer(s.Wait)
+	defer timer.Stop()
+	for i, res := range resolutions {
+		select {
+		case <-res.Done():
+			resolved[i].Addrs = res.Addrs()
+		case <-timer.C:
+			return s.resolvedSoFar(resolved, resolutions), nil
+		case <-ctx.Done():
+			return s.resolvedSoFar(resolved, resolutions), nil
+		}
+	}
+	return resolved, nil
+}
+
+// resolvedSoFar fills in the addresses of the providers that were resolved while waiting for others.
+func (s *AddrResolvingProviderStore) resolvedSoFar(resolved []peer.AddrInfo, resolutions map[int]*Resolution) []peer.AddrInfo {
+	for i, res := range resolutions {
+		select {
+		case <-res.Done():
+			resolved[i].Addrs = res.Addrs()
+		default:
+		}
+	}
+	return resolved
+}
+
+func (s *AddrResolvingProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
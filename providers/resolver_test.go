@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPeerRouting struct {
+	mut     sync.Mutex
+	addrs   map[peer.ID][]multiaddr.Multiaddr
+	lookups map[peer.ID]int
+	// release blocks the lookups until it is closed, if not nil
+	release chan struct{}
+}
+
+func (m *mockPeerRouting) FindPeer(ctx context.Context, id peer.ID) (peer.AddrInfo, error) {
+	if m.release != nil {
+		<-m.release
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.lookups[id]++
+	addrs, ok := m.addrs[id]
+	if !ok {
+		return peer.AddrInfo{}, errors.New("not found")
+	}
+	return peer.AddrInfo{ID: id, Addrs: addrs}, nil
+}
+
+func (m *mockPeerRouting) numLookups(id peer.ID) int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.lookups[id]
+}
+
+// lockedPeerStore is a mockPeerStore safe for use by the resolver workers.
+type lockedPeerStore struct {
+	mut sync.Mutex
+	ps  *mockPeerStore
+}
+
+func (l *lockedPeerStore) PeerInfo(id peer.ID) peer.AddrInfo {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.ps.PeerInfo(id)
+}
+
+func (l *lockedPeerStore) AddAddrs(p peer.ID, addrs []multiaddr.Multiaddr, ttl time.Duration) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.ps.AddAddrs(p, addrs, ttl)
+}
+
+func newLockedPeerStore() *lockedPeerStore {
+	return &lockedPeerStore{ps: &mockPeerStore{addrs: map[string][]multiaddr.Multiaddr{}}}
+}
+
+func TestAddrResolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	router := &mockPeerRouting{
+		addrs:   map[peer.ID][]multiaddr.Multiaddr{"peer": {addr}},
+		lookups: map[peer.ID]int{},
+		release: make(chan struct{}),
+	}
+	ps := newLockedPeerStore()
+	r, err := NewAddrResolver(1, 10, time.Hour, time.Second)
+	assert.NoError(t, err)
+	r.Run(ctx, 1)
+
+	// the worker takes the first resolution and blocks, the second is queued, and the queue is then full
+	res1 := r.Resolve(ctx, router, ps, "peer")
+	assert.NotNil(t, res1)
+	assert.Eventually(t, func() bool { return len(r.queue) == 0 }, time.Second, time.Millisecond)
+	res2 := r.Resolve(ctx, router, ps, "unknown")
+	assert.NotNil(t, res2)
+	assert.Nil(t, r.Resolve(ctx, router, ps, "dropped"))
+	// a peer being resolved is not queued again
+	assert.Equal(t, res1, r.Resolve(ctx, router, ps, "peer"))
+
+	close(router.release)
+	<-res1.Done()
+	<-res2.Done()
+	assert.Equal(t, []multiaddr.Multiaddr{addr}, res1.Addrs())
+	assert.Empty(t, res2.Addrs())
+	assert.Equal(t, []multiaddr.Multiaddr{addr}, ps.PeerInfo("peer").Addrs)
+
+	// recently resolved peers are not looked up again, even if no addresses were found,
+	// and their cached addresses are added to the peerstore they are resolved for
+	other := newLockedPeerStore()
+	res := r.Resolve(ctx, router, other, "peer")
+	<-res.Done()
+	assert.Equal(t, []multiaddr.Multiaddr{addr}, res.Addrs())
+	assert.Equal(t, []multiaddr.Multiaddr{addr}, other.PeerInfo("peer").Addrs)
+	<-r.Resolve(ctx, router, ps, "unknown").Done()
+	assert.Equal(t, 1, router.numLookups("peer"))
+	assert.Equal(t, 1, router.numLookups("unknown"))
+}
+
+func TestAddrResolvingProviderStore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	known := multiaddr.StringCast("/ip4/1.1.1.1/tcp/4001")
+	resolvedAddr := multiaddr.StringCast("/ip4/2.2.2.2/tcp/4001")
+	stored := []peer.AddrInfo{
+		{ID: "with-addrs", Addrs: []multiaddr.Multiaddr{known}},
+		{ID: "in-peerstore"},
+		{ID: "resolved"},
+	}
+	delegate := &mockProviderStore{providers: map[string][]peer.AddrInfo{"key": stored}}
+	router := &mockPeerRouting{
+		addrs:   map[peer.ID][]multiaddr.Multiaddr{"resolved": {resolvedAddr}},
+		lookups: map[peer.ID]int{},
+	}
+	ps := newLockedPeerStore()
+	ps.AddAddrs("in-peerstore", []multiaddr.Multiaddr{known}, time.Hour)
+	r, err := NewAddrResolver(10, 10, time.Hour, time.Second)
+	assert.NoError(t, err)
+	r.Run(ctx, 1)
+
+	s := NewAddrResolvingProviderStore(delegate, r, ps, 5*time.Second)
+	// nothing is resolved until the router is set
+	provs, err := s.GetProviders(ctx, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, stored, provs)
+
+	s.Router = router
+	provs, err = s.GetProviders(ctx, []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{
+		{ID: "with-addrs", Addrs: []multiaddr.Multiaddr{known}},
+		{ID: "in-peerstore", Addrs: []multiaddr.Multiaddr{known}},
+		{ID: "resolved", Addrs: []multiaddr.Multiaddr{resolvedAddr}},
+	}, provs)
+	assert.Equal(t, 0, router.numLookups("in-peerstore"))
+	// the providers of the delegate are not modified
+	assert.Empty(t, stored[2].Addrs)
+
+	// providers that are not resolved in time are returned without addresses
+	router.release = make(chan struct{})
+	defer close(router.release)
+	delegate.providers["slow"] = []peer.AddrInfo{{ID: "slow"}}
+	s.Wait = 10 * time.Millisecond
+	provs, err = s.GetProviders(ctx, []byte("slow"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{{ID: "slow"}}, provs)
+}
@@ -0,0 +1,102 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	lru "github.com/hnlq715/golang-lru"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// DefaultRevalidateTrackedKeys is the number of recently prefetched keys whose prefetch time is remembered.
+const DefaultRevalidateTrackedKeys = 100000
+
+// RevalidatePolicy configures when prefetched provider records are refreshed.
+type RevalidatePolicy struct {
+	// MaxAge is how old prefetched provider records can be before they are refreshed when requested.
+	MaxAge time.Duration
+	// Rate is the number of refreshes per second that can be scheduled, once the burst is used up.
+	Rate float64
+	// Burst is the number of refreshes that can be scheduled at once.
+	Burst int
+}
+
+// Revalidator decides when to refresh the prefetched provider records of a key, serving stale records while they
+// are revalidated in the background. It is shared by the caching provider stores of all the heads, since they share
+// their provider records.
+//
+// Only prefetched records are refreshed, records added by their providers are kept up to date by the providers.
+type Revalidator struct {
+	Policy RevalidatePolicy
+
+	mut        sync.Mutex
+	fetched    *lru.Cache
+	tokens     float64
+	lastRefill time.Time
+	clock      clock.Clock
+}
+
+// NewRevalidator creates a Revalidator remembering the prefetch time of up to size keys.
+func NewRevalidator(policy RevalidatePolicy, size int) (*Revalidator, error) {
+	if policy.Burst < 1 {
+		return nil, fmt.Errorf("the refresh burst must be at least 1, got %d", policy.Burst)
+	}
+	fetched, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("creating prefetch time cache: %w", err)
+	}
+	clk := clock.New()
+	return &Revalidator{
+		Policy:     policy,
+		fetched:    fetched,
+		tokens:     float64(policy.Burst),
+		lastRefill: clk.Now(),
+		clock:      clk,
+	}, nil
+}
+
+// Fetched records that provider records were just prefetched for the key.
+func (r *Revalidator) Fetched(key []byte) {
+	r.fetched.Add(string(key), r.clock.Now())
+}
+
+// ShouldRefresh returns true if the prefetched provider records of the key are stale and should be refreshed.
+// Refreshes are limited by the refresh budget, the records of a key whose refresh is not allowed are served as is.
+func (r *Revalidator) ShouldRefresh(ctx context.Context, key []byte) bool {
+	v, ok := r.fetched.Get(string(key))
+	if !ok {
+		return false
+	}
+	now := r.clock.Now()
+	if now.Sub(v.(time.Time)) < r.Policy.MaxAge {
+		return false
+	}
+
+	r.mut.Lock()
+	r.tokens = math.Min(float64(r.Policy.Burst), r.tokens+now.Sub(r.lastRefill).Seconds()*r.Policy.Rate)
+	r.lastRefill = now
+	allowed := r.tokens >= 1
+	if allowed {
+		r.tokens--
+	}
+	r.mut.Unlock()
+
+	if !allowed {
+		recordRefresh(ctx, "throttled")
+		return false
+	}
+	// don't schedule another refresh for the key until the records are stale again
+	r.fetched.Add(string(key), now)
+	recordRefresh(ctx, "scheduled")
+	return true
+}
+
+func recordRefresh(ctx context.Context, status string) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.PrefetchRefreshes.M(1))
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRevalidator(t *testing.T, policy RevalidatePolicy) (*Revalidator, *clock.Mock) {
+	r, err := NewRevalidator(policy, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clock.NewMock()
+	r.clock = clk
+	r.lastRefill = clk.Now()
+	return r, clk
+}
+
+func TestRevalidator_ShouldRefresh(t *testing.T) {
+	ctx := context.Background()
+	r, clk := newTestRevalidator(t, RevalidatePolicy{MaxAge: time.Minute, Rate: 1, Burst: 1})
+
+	// records that weren't prefetched are never refreshed
+	assert.False(t, r.ShouldRefresh(ctx, []byte("added")))
+
+	r.Fetched([]byte("prefetched"))
+	assert.False(t, r.ShouldRefresh(ctx, []byte("prefetched")))
+
+	clk.Add(time.Minute)
+	assert.True(t, r.ShouldRefresh(ctx, []byte("prefetched")))
+	// the key is not refreshed again until it is stale again
+	assert.False(t, r.ShouldRefresh(ctx, []byte("prefetched")))
+}
+
+func TestRevalidator_Budget(t *testing.T) {
+	ctx := context.Background()
+	r, clk := newTestRevalidator(t, RevalidatePolicy{MaxAge: time.Minute, Rate: 0.5, Burst: 2})
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	for _, k := range keys {
+		r.Fetched(k)
+	}
+	clk.Add(time.Minute)
+
+	assert.True(t, r.ShouldRefresh(ctx, keys[0]))
+	assert.True(t, r.ShouldRefresh(ctx, keys[1]))
+	// the burst is used up
+	assert.False(t, r.ShouldRefresh(ctx, keys[2]))
+
+	// a refresh is allowed every 2 seconds
+	clk.Add(time.Second)
+	assert.False(t, r.ShouldRefresh(ctx, keys[2]))
+	clk.Add(time.Second)
+	assert.True(t, r.ShouldRefresh(ctx, keys[2]))
+}
+
+func TestNewRevalidator_InvalidBurst(t *testing.T) {
+	_, err := NewRevalidator(RevalidatePolicy{MaxAge: time.Minute, Rate: 1}, 10)
+	assert.Error(t, err)
+}
+
+func TestCachingProviderStore_Revalidate(t *testing.T) {
+	ctx := context.Background()
+	r, clk := newTestRevalidator(t, RevalidatePolicy{MaxAge: time.Minute, Rate: 1, Burst: 1})
+
+	store := &mockProviderStore{}
+	finder := &mockFinder{providers: map[string][]peer.AddrInfo{
+		"mh1": {{ID: peer.ID("peer1")}},
+	}}
+	ps := NewCachingProviderStore(store, store, finder, nil)
+	ps.Revalidator = r
+
+	// the first request prefetches the providers
+	provs, err := ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+	assert.Empty(t, provs)
+	assert.Len(t, store.providers["mh1"], 1)
+
+	// fresh providers are served without refreshing them
+	finder.providers["mh1"] = append(finder.providers["mh1"], peer.AddrInfo{ID: peer.ID("peer2")})
+	provs, err = ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+	assert.Len(t, provs, 1)
+	assert.Len(t, store.providers["mh1"], 1)
+
+	// stale providers are served and refreshed, the mock provider store doesn't deduplicate providers
+	clk.Add(time.Minute)
+	provs, err = ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+	assert.Len(t, provs, 1)
+	assert.Equal(t, []peer.ID{"peer1", "peer1", "peer2"}, providerIDs(store.providers["mh1"]))
+}
@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	drc "github.com/ipfs/go-libipfs/routing/http/client"
+	"github.com/ipfs/go-libipfs/routing/http/contentrouter"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// RouterStrategy determines how a MultiContentRouter queries its sources.
+type RouterStrategy string
+
+const (
+	// RouterParallel queries all the sources in parallel, and merges their results.
+	RouterParallel RouterStrategy = "parallel"
+	// RouterOrdered queries the sources in order, only querying the next source if the previous ones
+	// found fewer providers than requested.
+	RouterOrdered RouterStrategy = "ordered"
+)
+
+// ParseRouterStrategy parses a router strategy string, as accepted on the command line.
+func ParseRouterStrategy(s string) (RouterStrategy, error) {
+	switch RouterStrategy(s) {
+	case RouterParallel, RouterOrdered:
+		return RouterStrategy(s), nil
+	case "":
+		return RouterParallel, nil
+	}
+	return "", fmt.Errorf("unknown router strategy %q, expected %q or %q", s, RouterParallel, RouterOrdered)
+}
+
+// PrefetchSource is a named content router that providers are prefetched from.
+type PrefetchSource struct {
+	// Name identifies the source in metrics.
+	Name   string
+	Router ReadContentRouting
+}
+
+// MultiContentRouter finds providers using several sources, deduplicating the providers they find.
+type MultiContentRouter struct {
+	Strategy RouterStrategy
+	Sources  []PrefetchSource
+}
+
+func NewMultiContentRouter(strategy RouterStrategy, sources ...PrefetchSource) *MultiContentRouter {
+	return &MultiContentRouter{Strategy: strategy, Sources: sources}
+}
+
+// FindProvidersAsync asks each source for up to numResults providers, so more than numResults providers may be returned
+// when using the parallel strategy.
+func (m *MultiContentRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, numResults int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		var mut sync.Mutex
+		seen := map[peer.ID]bool{}
+		// send forwards a provider unless it was already found by another source, and returns false if the context is done
+		send := func(ai peer.AddrInfo) bool {
+			mut.Lock()
+			dup := seen[ai.ID]
+			seen[ai.ID] = true
+			mut.Unlock()
+			if dup {
+				return true
+			}
+			select {
+			case out <- ai:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if m.Strategy == RouterOrdered {
+			for _, src := range m.Sources {
+				if m.findFromSource(ctx, src, c, numResults, send) >= numResults && numResults > 0 {
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, src := range m.Sources {
+			wg.Add(1)
+			go func(src PrefetchSource) {
+				defer wg.Done()
+				m.findFromSource(ctx, src, c, numResults, send)
+			}(src)
+		}
+		wg.Wait()
+	}()
+	return out
+}
+
+// findFromSource forwards the providers found by the source, and returns how many were found.
+func (m *MultiContentRouter) findFromSource(ctx context.Context, src PrefetchSource, c cid.Cid, numResults int, send func(peer.AddrInfo) bool) int {
+	found := 0
+	ch := src.Router.FindProvidersAsync(ctx, c, numResults)
+	for ai := range ch {
+		found++
+		if !send(ai) {
+			// the context is done, drain the source so it can stop
+			for range ch {
+			}
+			break
+		}
+	}
+	status := "succeeded"
+	if found == 0 {
+		status = "failed"
+	}
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeySource, src.Name), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.PrefetchSourceLookups.M(1),
+		metrics.PrefetchSourceProviders.M(int64(found)),
+	)
+	return found
+}
+
+// NewHTTPContentRouter creates a content router using a delegated routing HTTP endpoint (/routing/v1).
+func NewHTTPContentRouter(httpClient *http.Client, endpointURL string) (ReadContentRouting, error) {
+	drClient, err := drc.New(endpointURL, drc.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("building delegated routing HTTP client: %w", err)
+	}
+	return contentrouter.NewContentRoutingClient(drClient), nil
+}
+
+// HydraContentRouter finds providers using the HTTP API of another Hydra, which looks them up on the DHT.
+type HydraContentRouter struct {
+	HTTPClient *http.Client
+	// BaseURL is the URL of the HTTP API of the Hydra, e.g. "http://127.0.0.1:7779".
+	BaseURL string
+}
+
+func NewHydraContentRouter(httpClient *http.Client, baseURL string) (*HydraContentRouter, error) {
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid Hydra HTTP API URL: %w", err)
+	}
+	return &HydraContentRouter{HTTPClient: httpClient, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (r *HydraContentRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, numResults int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		if err := r.findProviders(ctx, c, numResults, out); err != nil {
+			log.Debugf("finding providers using Hydra %s: %s", r.BaseURL, err)
+		}
+	}()
+	return out
+}
+
+func (r *HydraContentRouter) findProviders(ctx context.Context, c cid.Cid, numResults int, out chan<- peer.AddrInfo) error {
+	u := fmt.Sprintf("%s/records/fetch/%s?nProviders=%s", r.BaseURL, c, strconv.Itoa(numResults))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ai peer.AddrInfo
+		err := dec.Decode(&ai)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case out <- ai:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/hydra-booster/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// countingRouter is a mockRouter that counts its lookups
+type countingRouter struct {
+	mockRouter
+	lookups int
+}
+
+func (r *countingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, numResults int) <-chan peer.AddrInfo {
+	r.lookups++
+	return r.mockRouter.FindProvidersAsync(ctx, c, numResults)
+}
+
+func collectIDs(ch <-chan peer.AddrInfo) []peer.ID {
+	var ids []peer.ID
+	for ai := range ch {
+		ids = append(ids, ai.ID)
+	}
+	return ids
+}
+
+func TestMultiContentRouter_Parallel(t *testing.T) {
+	views := []*view.View{metrics.PrefetchSourceLookupsView, metrics.PrefetchSourceProvidersView}
+	view.Register(views...)
+	defer view.Unregister(views...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := testCid(t, "foo")
+	key := string(c.Hash())
+
+	dht := &mockRouter{addrInfos: map[string][]peer.AddrInfo{key: {{ID: "peer1"}, {ID: "peer2"}}}}
+	httpRouter := &mockRouter{addrInfos: map[string][]peer.AddrInfo{key: {{ID: "peer2"}, {ID: "peer3"}}}}
+	empty := &mockRouter{}
+	router := NewMultiContentRouter(RouterParallel,
+		PrefetchSource{Name: "dht", Router: dht},
+		PrefetchSource{Name: "http", Router: httpRouter},
+		PrefetchSource{Name: "empty", Router: empty},
+	)
+
+	ids := collectIDs(router.FindProvidersAsync(ctx, c, 2))
+	assert.ElementsMatch(t, []peer.ID{"peer1", "peer2", "peer3"}, ids)
+
+	rows, err := view.RetrieveData(metrics.PrefetchSourceLookups.Name())
+	assert.NoError(t, err)
+	assert.True(t, subsetRowVals([]view.Row{
+		{Data: &view.SumData{Value: 1}, Tags: []tag.Tag{{Key: metrics.KeySource, Value: "dht"}, {Key: metrics.KeyStatus, Value: "succeeded"}}},
+		{Data: &view.SumData{Value: 1}, Tags: []tag.Tag{{Key: metrics.KeySource, Value: "empty"}, {Key: metrics.KeyStatus, Value: "failed"}}},
+	}, rows))
+	rows, err = view.RetrieveData(metrics.PrefetchSourceProviders.Name())
+	assert.NoError(t, err)
+	assert.True(t, subsetRowVals([]view.Row{
+		{Data: &view.SumData{Value: 2}, Tags: []tag.Tag{{Key: metrics.KeySource, Value: "http"}}},
+	}, rows))
+}
+
+func TestMultiContentRouter_Ordered(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := testCid(t, "foo")
+	key := string(c.Hash())
+
+	empty := &countingRouter{}
+	first := &countingRouter{mockRouter: mockRouter{addrInfos: map[string][]peer.AddrInfo{key: {{ID: "peer1"}}}}}
+	second := &countingRouter{mockRouter: mockRouter{addrInfos: map[string][]peer.AddrInfo{key: {{ID: "peer2"}}}}}
+	router := NewMultiContentRouter(RouterOrdered,
+		PrefetchSource{Name: "empty", Router: empty},
+		PrefetchSource{Name: "first", Router: first},
+		PrefetchSource{Name: "second", Router: second},
+	)
+
+	// the next source is only queried if the previous ones didn't find enough providers
+	assert.Equal(t, []peer.ID{"peer1"}, collectIDs(router.FindProvidersAsync(ctx, c, 1)))
+	assert.Equal(t, 1, empty.lookups)
+	assert.Equal(t, 1, first.lookups)
+	assert.Equal(t, 0, second.lookups)
+
+	assert.Equal(t, []peer.ID{"peer1", "peer2"}, collectIDs(router.FindProvidersAsync(ctx, c, 2)))
+	assert.Equal(t, 1, second.lookups)
+}
+
+func TestHydraContentRouter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	found := testCid(t, "found")
+	// provider IDs are encoded, so they must be valid
+	peer1, peer2 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	var nProviders string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nProviders = r.FormValue("nProviders")
+		if r.URL.Path != "/records/fetch/"+found.String() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(peer.AddrInfo{ID: peer1})
+		enc.Encode(peer.AddrInfo{ID: peer2})
+	}))
+	defer srv.Close()
+
+	router, err := NewHydraContentRouter(srv.Client(), srv.URL+"/")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []peer.ID{peer1, peer2}, collectIDs(router.FindProvidersAsync(ctx, found, 2)))
+	assert.Equal(t, "2", nProviders)
+	assert.Empty(t, collectIDs(router.FindProvidersAsync(ctx, testCid(t, "missing"), 1)))
+}
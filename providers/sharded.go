@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// ShardForwarder forwards provider requests for keys owned by other hydras.
+type ShardForwarder interface {
+	// Owner returns the hydra owning the key, and true if it is owned by this hydra.
+	Owner(key []byte) (peer.ID, bool)
+	AddProvider(ctx context.Context, owner peer.ID, key []byte, prov peer.AddrInfo) error
+	GetProviders(ctx context.Context, owner peer.ID, key []byte) ([]peer.AddrInfo, error)
+}
+
+// ShardedProviderStore is a provider store only storing the provider records of the keys owned by this hydra, and
+// forwarding the requests for other keys to the hydras owning them. Requests that cannot be forwarded, and lookups for
+// which the owner has no providers, are served by the Delegate instead.
+type ShardedProviderStore struct {
+	Delegate  providers.ProviderStore
+	Forwarder ShardForwarder
+
+	log logging.EventLogger
+}
+
+func NewShardedProviderStore(delegate providers.ProviderStore, forwarder ShardForwarder) *ShardedProviderStore {
+	return &ShardedProviderStore{Delegate: delegate, Forwarder: forwarder, log: logging.Logger("hydra/shard")}
+}
+
+func (s *ShardedProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	owner, local := s.Forwarder.Owner(key)
+	if local {
+		return s.Delegate.AddProvider(ctx, key, prov)
+	}
+	err := s.Forwarder.AddProvider(ctx, owner, key, prov)
+	recordShardForward(ctx, "AddProvider", err)
+	if err != nil {
+		s.log.Debugf("failed to forward provider record to %s, storing it locally: %s", owner, err)
+		return s.Delegate.AddProvider(ctx, key, prov)
+	}
+	return nil
+}
+
+func (s *ShardedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	owner, local := s.Forwarder.Owner(key)
+	if local {
+		return s.Delegate.GetProviders(ctx, key)
+	}
+	provs, err := s.Forwarder.GetProviders(ctx, owner, key)
+	recordShardForward(ctx, "GetProviders", err)
+	if err != nil {
+		s.log.Debugf("failed to forward provider lookup to %s, looking up locally: %s", owner, err)
+		return s.Delegate.GetProviders(ctx, key)
+	}
+	if len(provs) == 0 {
+		// the records may still only be held locally, if the key was owned by this hydra before the owner joined, or if
+		// they were stored locally because the owner was unreachable
+		return s.Delegate.GetProviders(ctx, key)
+	}
+	return provs, nil
+}
+
+func (s *ShardedProviderStore) Unwrap() providers.ProviderStore {
+	return s.Delegate
+}
+
+func recordShardForward(ctx context.Context, operation string, err error) {
+	status := "succeeded"
+	if err != nil {
+		status = "failed"
+	}
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyOperation, operation), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.ShardForwards.M(1),
+	)
+}
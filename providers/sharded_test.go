@@ -0,0 +1,70 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockShardForwarder struct {
+	owners map[string]peer.ID
+	remote map[peer.ID]*mockProviderStore
+	err    error
+}
+
+func (m *mockShardForwarder) Owner(key []byte) (peer.ID, bool) {
+	owner, ok := m.owners[string(key)]
+	return owner, !ok
+}
+
+func (m *mockShardForwarder) AddProvider(ctx context.Context, owner peer.ID, key []byte, prov peer.AddrInfo) error {
+	if m.err != nil {
+		return m.err
+	}
+	return m.remote[owner].AddProvider(ctx, key, prov)
+}
+
+func (m *mockShardForwarder) GetProviders(ctx context.Context, owner peer.ID, key []byte) ([]peer.AddrInfo, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.remote[owner].GetProviders(ctx, key)
+}
+
+func TestShardedProviderStore(t *testing.T) {
+	ctx := context.Background()
+	local := &mockProviderStore{}
+	remote := &mockProviderStore{}
+	forwarder := &mockShardForwarder{
+		owners: map[string]peer.ID{"remote": "other"},
+		remote: map[peer.ID]*mockProviderStore{"other": remote},
+	}
+	s := NewShardedProviderStore(local, forwarder)
+
+	assert.NoError(t, s.AddProvider(ctx, []byte("local"), peer.AddrInfo{ID: "peer"}))
+	assert.NoError(t, s.AddProvider(ctx, []byte("remote"), peer.AddrInfo{ID: "peer"}))
+	assert.Equal(t, map[string][]peer.AddrInfo{"local": {{ID: "peer"}}}, local.providers)
+	assert.Equal(t, map[string][]peer.AddrInfo{"remote": {{ID: "peer"}}}, remote.providers)
+
+	provs, err := s.GetProviders(ctx, []byte("remote"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{{ID: "peer"}}, provs)
+
+	// the records of keys owned by other hydras that are only held locally are still found
+	require.NoError(t, local.AddProvider(ctx, []byte("moved"), peer.AddrInfo{ID: "peer"}))
+	forwarder.owners["moved"] = "other"
+	provs, err = s.GetProviders(ctx, []byte("moved"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{{ID: "peer"}}, provs)
+
+	// requests that cannot be forwarded are served locally
+	forwarder.err = errors.New("unreachable")
+	assert.NoError(t, s.AddProvider(ctx, []byte("remote"), peer.AddrInfo{ID: "other-peer"}))
+	provs, err = s.GetProviders(ctx, []byte("remote"))
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{{ID: "other-peer"}}, provs)
+}
@@ -0,0 +1,210 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	lru "github.com/hnlq715/golang-lru"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// WriteMode determines how a TieredProviderStore propagates AddProvider calls to its backend.
+type WriteMode string
+
+const (
+	// WriteThrough writes to the backend synchronously, and only updates the cache once the backend write succeeded.
+	WriteThrough WriteMode = "through"
+	// WriteBehind updates the cache immediately and writes to the backend asynchronously.
+	// If the write queue is full, the write falls back to being synchronous.
+	WriteBehind WriteMode = "behind"
+)
+
+const writeBehindQueueSize = 1000
+
+// ParseWriteMode parses a write mode string, as accepted on the command line.
+func ParseWriteMode(s string) (WriteMode, error) {
+	switch WriteMode(s) {
+	case WriteThrough, WriteBehind:
+		return WriteMode(s), nil
+	case "":
+		return WriteThrough, nil
+	}
+	return "", fmt.Errorf("unknown write mode %q, expected %q or %q", s, WriteThrough, WriteBehind)
+}
+
+type tieredCacheEntry struct {
+	addrInfos []peer.AddrInfo
+	expiresAt time.Time
+}
+
+type writeBehindRequest struct {
+	ctx  context.Context
+	key  []byte
+	prov peer.AddrInfo
+}
+
+// TieredProviderStore is an in-memory, size-bounded hot tier in front of a (typically remote) provider store.
+// Results from the backend are cached for at most TTL. Empty results are not cached, so that records added
+// by other hydras sharing the backend are picked up as soon as they exist.
+type TieredProviderStore struct {
+	Backend     providers.ProviderStore
+	BackendName string
+	TTL         time.Duration
+	WriteMode   WriteMode
+
+	cache *lru.Cache
+	// mut serializes the updates of cache entries, which are read, merged and written back
+	mut        sync.Mutex
+	writeQueue chan writeBehindRequest
+	clock      clock.Clock
+}
+
+// NewTieredProviderStore creates a new TieredProviderStore caching up to size keys from the backend.
+// When using WriteBehind, background writes are stopped when the passed context is canceled.
+func NewTieredProviderStore(ctx context.Context, backend providers.ProviderStore, backendName string, size int, ttl time.Duration, mode WriteMode) (*TieredProviderStore, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, fmt.Errorf("creating provider cache: %w", err)
+	}
+	s := &TieredProviderStore{
+		Backend:     backend,
+		BackendName: backendName,
+		TTL:         ttl,
+		WriteMode:   mode,
+		cache:       cache,
+		clock:       clock.New(),
+	}
+	if mode == WriteBehind {
+		s.writeQueue = make(chan writeBehindRequest, writeBehindQueueSize)
+		go s.runWriteBehind(ctx)
+	}
+	return s, nil
+}
+
+func (s *TieredProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	if s.WriteMode == WriteBehind {
+		s.addToCache(key, prov)
+		// the request context is likely to be canceled before the write happens, so detach from it
+		wctx := tag.NewContext(context.Background(), tag.FromContext(ctx))
+		select {
+		case s.writeQueue <- writeBehindRequest{ctx: wctx, key: key, prov: prov}:
+			return nil
+		default:
+			return s.Backend.AddProvider(ctx, key, prov)
+		}
+	}
+
+	err := s.Backend.AddProvider(ctx, key, prov)
+	if err != nil {
+		return err
+	}
+	s.addToCache(key, prov)
+	return nil
+}
+
+// GetProviders returns the cached providers for the key, if any, otherwise it queries the backend and caches the result.
+func (s *TieredProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	if v, ok := s.cache.Get(string(key)); ok {
+		entry := v.(*tieredCacheEntry)
+		if s.clock.Now().Before(entry.expiresAt) {
+			s.recordLookup(ctx, "hit")
+			return append([]peer.AddrInfo{}, entry.addrInfos...), nil
+		}
+		s.removeExpired(key)
+	}
+	s.recordLookup(ctx, "miss")
+
+	addrInfos, err := s.Backend.GetProviders(ctx, key)
+	if err != nil {
+		return addrInfos, err
+	}
+	if len(addrInfos) > 0 {
+		s.refillCache(key, addrInfos)
+	}
+	return addrInfos, nil
+}
+
+// removeExpired removes the cache entry of the key if it expired, unless it was refilled meanwhile.
+func (s *TieredProviderStore) removeExpired(key []byte) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if v, ok := s.cache.Peek(string(key)); ok && !s.clock.Now().Before(v.(*tieredCacheEntry).expiresAt) {
+		s.cache.Remove(string(key))
+	}
+}
+
+// refillCache caches the providers returned by the backend for the key. If the key was cached meanwhile, e.g. by a
+// concurrent lookup that providers were then added to, the providers are merged into its entry instead of replacing it.
+func (s *TieredProviderStore) refillCache(key []byte, addrInfos []peer.AddrInfo) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	entry := &tieredCacheEntry{
+		addrInfos: append([]peer.AddrInfo{}, addrInfos...),
+		expiresAt: s.clock.Now().Add(s.TTL),
+	}
+	if v, ok := s.cache.Peek(string(key)); ok {
+		if cached := v.(*tieredCacheEntry); s.clock.Now().Before(cached.expiresAt) {
+			entry = &tieredCacheEntry{
+				addrInfos: mergeAddrInfos(append(append([]peer.AddrInfo{}, cached.addrInfos...), addrInfos...)),
+				expiresAt: cached.expiresAt,
+			}
+		}
+	}
+	s.cache.Add(string(key), entry)
+}
+
+// Unwrap returns the backend provider store.
+func (s *TieredProviderStore) Unwrap() providers.ProviderStore {
+	return s.Backend
+}
+
+// addToCache adds the provider to an existing, unexpired cache entry for the key.
+// If there is no cache entry, the next GetProviders call will fetch all the providers from the backend instead.
+func (s *TieredProviderStore) addToCache(key []byte, prov peer.AddrInfo) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	v, ok := s.cache.Peek(string(key))
+	if !ok {
+		return
+	}
+	entry := v.(*tieredCacheEntry)
+	if !s.clock.Now().Before(entry.expiresAt) {
+		s.cache.Remove(string(key))
+		return
+	}
+	// entries are never mutated in place, since they may be concurrently read
+	s.cache.Add(string(key), &tieredCacheEntry{
+		addrInfos: mergeAddrInfos(append(append([]peer.AddrInfo{}, entry.addrInfos...), prov)),
+		expiresAt: entry.expiresAt,
+	})
+}
+
+func (s *TieredProviderStore) runWriteBehind(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.writeQueue:
+			err := s.Backend.AddProvider(req.ctx, req.key, req.prov)
+			if err != nil {
+				log.Errorf("failed to write provider to %s providerstore: %s", s.BackendName, err)
+				stats.RecordWithTags(req.ctx, []tag.Mutator{tag.Upsert(metrics.KeyBackend, s.BackendName)}, metrics.ProviderCacheWriteErrors.M(1))
+			}
+		}
+	}
+}
+
+func (s *TieredProviderStore) recordLookup(ctx context.Context, status string) {
+	stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyBackend, s.BackendName), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.ProviderCacheLookups.M(1),
+	)
+}
@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProviderStore counts the calls made to the wrapped provider store.
+type countingProviderStore struct {
+	mockProviderStore
+	mut  sync.Mutex
+	gets int
+	adds int
+}
+
+func (c *countingProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.adds++
+	return c.mockProviderStore.AddProvider(ctx, key, prov)
+}
+
+func (c *countingProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gets++
+	return c.mockProviderStore.GetProviders(ctx, key)
+}
+
+func (c *countingProviderStore) counts() (int, int) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.gets, c.adds
+}
+
+func TestTieredProviderStore_GetProviders(t *testing.T) {
+	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+
+	backend := &countingProviderStore{mockProviderStore: mockProviderStore{providers: map[string][]peer.AddrInfo{
+		"mh1": {{ID: peer.ID("peer1")}},
+	}}}
+	ps, err := NewTieredProviderStore(ctx, backend, "mock", 10, time.Minute, WriteThrough)
+	assert.NoError(t, err)
+	clock := clock.NewMock()
+	ps.clock = clock
+
+	for i := 0; i < 3; i++ {
+		provs, err := ps.GetProviders(ctx, []byte("mh1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []peer.AddrInfo{{ID: peer.ID("peer1")}}, provs)
+	}
+	gets, _ := backend.counts()
+	assert.Equal(t, 1, gets)
+
+	// empty results are not cached
+	for i := 0; i < 2; i++ {
+		provs, err := ps.GetProviders(ctx, []byte("mh2"))
+		assert.NoError(t, err)
+		assert.Empty(t, provs)
+	}
+	gets, _ = backend.counts()
+	assert.Equal(t, 3, gets)
+
+	// expired entries are fetched again
+	clock.Add(time.Minute)
+	_, err = ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+	gets, _ = backend.counts()
+	assert.Equal(t, 4, gets)
+}
+
+func TestTieredProviderStore_GetProvidersError(t *testing.T) {
+	ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+
+	backend := &mockProviderStore{err: errors.New("boom")}
+	ps, err := NewTieredProviderStore(ctx, backend, "mock", 10, time.Minute, WriteThrough)
+	assert.NoError(t, err)
+
+	_, err = ps.GetProviders(ctx, []byte("mh1"))
+	assert.EqualError(t, err, "boom")
+}
+
+func TestTieredProviderStore_AddProvider(t *testing.T) {
+	cases := []struct {
+		name string
+		mode WriteMode
+	}{
+		{name: "write-through", mode: WriteThrough},
+		{name: "write-behind", mode: WriteBehind},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, stop := context.WithTimeout(context.Background(), 2*time.Second)
+			defer stop()
+
+			backend := &countingProviderStore{mockProviderStore: mockProviderStore{providers: map[string][]peer.AddrInfo{
+				"mh1": {{ID: peer.ID("peer1")}},
+			}}}
+			ps, err := NewTieredProviderStore(ctx, backend, "mock", 10, time.Minute, c.mode)
+			assert.NoError(t, err)
+
+			// warm the cache
+			_, err = ps.GetProviders(ctx, []byte("mh1"))
+			assert.NoError(t, err)
+
+			err = ps.AddProvider(ctx, []byte("mh1"), peer.AddrInfo{ID: peer.ID("peer2")})
+			assert.NoError(t, err)
+
+			// the new provider is served from the cache
+			provs, err := ps.GetProviders(ctx, []byte("mh1"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []peer.AddrInfo{{ID: peer.ID("peer1")}, {ID: peer.ID("peer2")}}, provs)
+
+			// and eventually written to the backend
+			assert.Eventually(t, func() bool {
+				_, adds := backend.counts()
+				return adds == 1
+			}, time.Second, 10*time.Millisecond)
+
+			gets, _ := backend.counts()
+			assert.Equal(t, 1, gets)
+		})
+	}
+}
+
+func TestTieredProviderStore_ConcurrentAddProvider(t *testing.T) {
+	ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+
+	backend := &countingProviderStore{mockProviderStore: mockProviderStore{providers: map[string][]peer.AddrInfo{
+		"mh1": {{ID: peer.ID("peer0")}},
+	}}}
+	ps, err := NewTieredProviderStore(ctx, backend, "mock", 10, time.Minute, WriteThrough)
+	assert.NoError(t, err)
+	_, err = ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+
+	// concurrent adds and lookups of the same key don't lose providers from the cache
+	const n = 500
+	var wg sync.WaitGroup
+	want := []peer.AddrInfo{{ID: peer.ID("peer0")}}
+	for i := 1; i <= n; i++ {
+		prov := peer.AddrInfo{ID: peer.ID(fmt.Sprintf("peer%d", i))}
+		want = append(want, prov)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, ps.AddProvider(ctx, []byte("mh1"), prov))
+		}()
+		go func() {
+			defer wg.Done()
+			ps.refillCache([]byte("mh1"), []peer.AddrInfo{{ID: peer.ID("peer0")}})
+		}()
+	}
+	wg.Wait()
+
+	provs, err := ps.GetProviders(ctx, []byte("mh1"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, want, provs)
+	gets, _ := backend.counts()
+	assert.Equal(t, 1, gets)
+}
@@ -22,3 +22,8 @@ func (s *AddProviderNotSupportedProviderStore) AddProvider(ctx context.Context,
 func (s *AddProviderNotSupportedProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
 	return s.backend.GetProviders(ctx, key)
 }
+
+// Unwrap returns the wrapped provider store.
+func (s *AddProviderNotSupportedProviderStore) Unwrap() providers.ProviderStore {
+	return s.backend
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/hydra-booster/hydra"
+	"github.com/libp2p/hydra-booster/records"
+)
+
+const recordsUsage = `Usage: hydra-booster records <export|import> [flags]
+
+Export the provider records of a provider store or datastore to a file, or import them from a file.
+Run "hydra-booster records <export|import> -h" for the flags of each command.
+`
+
+// recordsMain runs the "records" subcommand.
+func recordsMain(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, recordsUsage)
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch args[0] {
+	case "export":
+		err = recordsExport(ctx, args[1:])
+	case "import":
+		err = recordsImport(ctx, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, recordsUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// recordsFlags registers the flags selecting the provider store or datastore shared by the record commands.
+func recordsFlags(fs *flag.FlagSet) (dbpath *string, providerStore *string) {
+	dbpath = fs.String("db", "", "Datastore directory (for LevelDB store) or postgresql:// connection URI (for PostgreSQL store) or 'dynamodb://table=<string>', used if no provider store is given (defaults to HYDRA_DB or \"hydra-belly\")")
+	providerStore = fs.String("provider-store", "", "A non-default provider store, as accepted by the -provider-store flag of the Hydra (defaults to HYDRA_PROVIDER_STORE)")
+	return
+}
+
+// openRecordStore opens the provider store if one is given, or the datastore otherwise.
+func openRecordStore(ctx context.Context, dbpath string, providerStore string) (providers.ProviderStore, *hydra.Options, error) {
+	if providerStore == "" {
+		providerStore = os.Getenv("HYDRA_PROVIDER_STORE")
+	}
+	if dbpath == "" {
+		dbpath = os.Getenv("HYDRA_DB")
+		if dbpath == "" {
+			dbpath = "hydra-belly"
+		}
+	}
+	options := hydra.Options{
+		DatastorePath:   dbpath,
+		ProviderStore:   providerStore,
+		DelegateTimeout: time.Minute,
+	}
+	if providerStore == "" {
+		return nil, &options, nil
+	}
+
+	// provider stores are built for a head, use a host that doesn't listen in its place
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	ps, err := hydra.NewProviderStore(ctx, options, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ps, &options, nil
+}
+
+func recordsExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("records export", flag.ExitOnError)
+	dbpath, providerStore := recordsFlags(fs)
+	out := fs.String("out", "-", "File to write the provider records to, \"-\" for stdout.")
+	formatStr := fs.String("format", string(records.FormatNDJSON), "Format of the file, \"ndjson\" or \"cbor\".")
+	fs.Parse(args)
+
+	format, err := records.ParseFormat(*formatStr)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	rw, err := records.NewWriter(bw, format)
+	if err != nil {
+		return err
+	}
+
+	ps, options, err := openRecordStore(ctx, *dbpath, *providerStore)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	write := func(r records.Record) error {
+		n++
+		return rw.Write(r)
+	}
+	if ps != nil {
+		err = records.ExportProviderStore(ctx, ps, write)
+	} else {
+		var ds datastore.Batching
+		ds, err = hydra.OpenDatastore(ctx, options.DatastorePath, false)
+		if err != nil {
+			return err
+		}
+		defer ds.Close()
+		err = records.ExportDatastore(ctx, ds, write)
+	}
+	if err != nil {
+		return fmt.Errorf("exporting provider records: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "📤 Exported %d provider records\n", n)
+	return nil
+}
+
+func recordsImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("records import", flag.ExitOnError)
+	dbpath, providerStore := recordsFlags(fs)
+	in := fs.String("in", "", "File to read the provider records from, in any of the export formats.")
+	checkpoint := fs.String("checkpoint", "", "File to save the progress of the import to, so an interrupted import can be resumed by running it again (defaults to the input file with a \".checkpoint\" suffix).")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("the -in flag is required")
+	}
+	if *checkpoint == "" {
+		*checkpoint = *in + ".checkpoint"
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := records.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	ps, options, err := openRecordStore(ctx, *dbpath, *providerStore)
+	if err != nil {
+		return err
+	}
+
+	var put func(context.Context, records.Record) error
+	if ps != nil {
+		put = func(ctx context.Context, rec records.Record) error {
+			return records.ImportProviderStore(ctx, ps, rec)
+		}
+	} else {
+		ds, err := hydra.OpenDatastore(ctx, options.DatastorePath, true)
+		if err != nil {
+			return err
+		}
+		defer ds.Close()
+		put = func(ctx context.Context, rec records.Record) error {
+			return records.ImportDatastore(ctx, ds, rec)
+		}
+	}
+
+	stats, err := records.Import(ctx, r, *checkpoint, put)
+	fmt.Fprintf(os.Stderr, "📥 Imported %d provider records, skipped %d expired and %d previously imported records\n", stats.Imported, stats.Expired, stats.Resumed)
+	if err != nil {
+		return fmt.Errorf("importing provider records (progress saved to %s): %w", *checkpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// records imported between checkpoints
+const checkpointInterval = 1000
+
+// ImportStats summarizes an import.
+type ImportStats struct {
+	// Resumed is the number of records skipped because a previous import already processed them.
+	Resumed  int
+	Imported int
+	// Expired is the number of records skipped because they already expired.
+	Expired int
+}
+
+// Import reads all the records from r and calls put for each record that hasn't expired.
+//
+// Progress is saved to the checkpoint file, if given, so that an interrupted import resumes after the last
+// checkpointed record when it is run again with the same file. The checkpoint file is removed once the import completes.
+func Import(ctx context.Context, r *Reader, checkpoint string, put func(context.Context, Record) error) (ImportStats, error) {
+	var stats ImportStats
+	resume, err := readCheckpoint(checkpoint)
+	if err != nil {
+		return stats, err
+	}
+
+	processed := 0
+	for {
+		if ctx.Err() != nil {
+			return stats, saveCheckpoint(checkpoint, processed, ctx.Err())
+		}
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, saveCheckpoint(checkpoint, processed, fmt.Errorf("reading record %d: %w", processed+1, err))
+		}
+		if processed < resume {
+			processed++
+			stats.Resumed++
+			continue
+		}
+
+		if !rec.Expires.IsZero() && rec.Expires.Before(time.Now()) {
+			stats.Expired++
+		} else {
+			if err := put(ctx, rec); err != nil {
+				return stats, saveCheckpoint(checkpoint, processed, fmt.Errorf("importing record %d: %w", processed+1, err))
+			}
+			stats.Imported++
+		}
+		processed++
+
+		if processed%checkpointInterval == 0 {
+			if err := writeCheckpoint(checkpoint, processed); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if checkpoint != "" {
+		if err := os.Remove(checkpoint); err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// readCheckpoint returns the number of records processed by a previous import, or zero if there is no checkpoint.
+func readCheckpoint(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return n, nil
+}
+
+func writeCheckpoint(path string, processed int) error {
+	if path == "" {
+		return nil
+	}
+	// write to a temporary file and rename it, so the checkpoint is never partially written
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(processed)+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveCheckpoint checkpoints the progress of an import interrupted by err, and returns err.
+func saveCheckpoint(path string, processed int, err error) error {
+	if cerr := writeCheckpoint(path, processed); cerr != nil {
+		return fmt.Errorf("%w (%s)", err, cerr)
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+package records
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/polydawn/refmt/cbor"
+	"github.com/polydawn/refmt/obj/atlas"
+)
+
+const (
+	// FileType identifies provider record files in their header.
+	FileType = "hydra-provider-records"
+	// Version is the version of the provider record file format written by this package.
+	Version = 1
+)
+
+// Format is the encoding of a provider record file.
+type Format string
+
+const (
+	// FormatNDJSON encodes the header and each record as a line of JSON.
+	FormatNDJSON Format = "ndjson"
+	// FormatCBOR encodes the header and each record as a CBOR item, one after the other.
+	FormatCBOR Format = "cbor"
+)
+
+// ParseFormat parses a provider record file format, as accepted on the command line.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatNDJSON, FormatCBOR:
+		return Format(s), nil
+	case "":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("unknown provider record format %q", s)
+}
+
+// Record is a provider record.
+type Record struct {
+	Multihash multihash.Multihash
+	Provider  peer.ID
+	Addrs     []multiaddr.Multiaddr
+	// Expires is when the record expires, it is the zero time if unknown.
+	Expires time.Time
+}
+
+// Header is the first item of a provider record file.
+type Header struct {
+	Type    string
+	Version int
+}
+
+// fileRecord is the encoded form of a Record, shared by all the formats.
+type fileRecord struct {
+	Multihash string
+	Provider  string
+	Addrs     []string `json:",omitempty"`
+	// Expires is the expiry time in seconds since the unix epoch, or zero if unknown.
+	Expires int64 `json:",omitempty"`
+}
+
+var cborAtlas = atlas.MustBuild(
+	atlas.BuildEntry(Header{}).StructMap().Autogenerate().Complete(),
+	atlas.BuildEntry(fileRecord{}).StructMap().Autogenerate().Complete(),
+)
+
+func toFileRecord(r Record) fileRecord {
+	fr := fileRecord{
+		Multihash: r.Multihash.B58String(),
+		Provider:  r.Provider.String(),
+	}
+	for _, a := range r.Addrs {
+		fr.Addrs = append(fr.Addrs, a.String())
+	}
+	if !r.Expires.IsZero() {
+		fr.Expires = r.Expires.Unix()
+	}
+	return fr
+}
+
+func fromFileRecord(fr fileRecord) (Record, error) {
+	mh, err := multihash.FromB58String(fr.Multihash)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid multihash %q: %w", fr.Multihash, err)
+	}
+	p, err := peer.Decode(fr.Provider)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid provider %q: %w", fr.Provider, err)
+	}
+	r := Record{Multihash: mh, Provider: p}
+	for _, s := range fr.Addrs {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		r.Addrs = append(r.Addrs, a)
+	}
+	if fr.Expires != 0 {
+		r.Expires = time.Unix(fr.Expires, 0)
+	}
+	return r, nil
+}
+
+type encoder interface {
+	Encode(v interface{}) error
+}
+
+type cborEncoder struct{ m *cbor.Marshaller }
+
+func (e *cborEncoder) Encode(v interface{}) error { return e.m.Marshal(v) }
+
+// Writer writes provider records to a file.
+type Writer struct {
+	enc encoder
+}
+
+// NewWriter writes the file header to w, and returns a Writer of records to w in the given format.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	var enc encoder
+	switch format {
+	case FormatNDJSON:
+		enc = json.NewEncoder(w)
+	case FormatCBOR:
+		enc = &cborEncoder{m: cbor.NewMarshallerAtlased(w, cborAtlas)}
+	default:
+		return nil, fmt.Errorf("unknown provider record format %q", format)
+	}
+	if err := enc.Encode(Header{Type: FileType, Version: Version}); err != nil {
+		return nil, fmt.Errorf("writing header: %w", err)
+	}
+	return &Writer{enc: enc}, nil
+}
+
+func (w *Writer) Write(r Record) error {
+	return w.enc.Encode(toFileRecord(r))
+}
+
+type decoder interface {
+	Decode(v interface{}) error
+}
+
+type cborDecoder struct{ u *cbor.Unmarshaller }
+
+func (d *cborDecoder) Decode(v interface{}) error { return d.u.Unmarshal(v) }
+
+// Reader reads provider records from a file.
+type Reader struct {
+	Format Format
+	dec    decoder
+}
+
+// NewReader reads the file header from r, detecting its format, and returns a Reader of the records that follow.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	rd := &Reader{}
+	if first[0] == '{' {
+		rd.Format = FormatNDJSON
+		rd.dec = json.NewDecoder(br)
+	} else {
+		rd.Format = FormatCBOR
+		rd.dec = &cborDecoder{u: cbor.NewUnmarshallerAtlased(cbor.DecodeOptions{}, br, cborAtlas)}
+	}
+
+	var h Header
+	if err := rd.dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if h.Type != FileType {
+		return nil, fmt.Errorf("not a provider record file, got type %q", h.Type)
+	}
+	if h.Version != Version {
+		return nil, fmt.Errorf("unsupported provider record file version %d", h.Version)
+	}
+	return rd, nil
+}
+
+// Next returns the next record, or io.EOF if there are no more records.
+func (r *Reader) Next() (Record, error) {
+	var fr fileRecord
+	if err := r.dec.Decode(&fr); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, err
+	}
+	return fromFileRecord(fr)
+}
@@ -0,0 +1,208 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecords(t *testing.T, n int) []Record {
+	p, err := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	var recs []Record
+	for i := 0; i < n; i++ {
+		mh, err := multihash.Sum([]byte{byte(i)}, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, Record{
+			Multihash: mh,
+			Provider:  p,
+			Addrs:     []multiaddr.Multiaddr{addr},
+			Expires:   time.Now().Add(time.Hour).Truncate(time.Second),
+		})
+	}
+	return recs
+}
+
+func writeRecords(t *testing.T, format Format, recs []Record) *bytes.Buffer {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recs {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &buf
+}
+
+func TestRoundTrip(t *testing.T) {
+	recs := testRecords(t, 3)
+	for _, format := range []Format{FormatNDJSON, FormatCBOR} {
+		t.Run(string(format), func(t *testing.T) {
+			r, err := NewReader(writeRecords(t, format, recs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, format, r.Format)
+
+			var read []Record
+			for {
+				rec, err := r.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				read = append(read, rec)
+			}
+			assert.Len(t, read, len(recs))
+			for i := range recs {
+				assert.Equal(t, recs[i].Multihash, read[i].Multihash)
+				assert.Equal(t, recs[i].Provider, read[i].Provider)
+				assert.Equal(t, recs[i].Addrs[0].String(), read[i].Addrs[0].String())
+				assert.True(t, recs[i].Expires.Equal(read[i].Expires))
+			}
+		})
+	}
+}
+
+func TestNewReaderInvalidHeader(t *testing.T) {
+	_, err := NewReader(strings.NewReader(`{"Type":"something-else","Version":1}` + "\n"))
+	assert.ErrorContains(t, err, "not a provider record file")
+	_, err = NewReader(strings.NewReader(`{"Type":"hydra-provider-records","Version":2}` + "\n"))
+	assert.ErrorContains(t, err, "unsupported provider record file version 2")
+}
+
+func TestImportResume(t *testing.T) {
+	ctx := context.Background()
+	recs := testRecords(t, 2*checkpointInterval+10)
+	recs[5].Expires = time.Now().Add(-time.Hour)
+	buf := writeRecords(t, FormatNDJSON, recs)
+	checkpoint := filepath.Join(t.TempDir(), "import.checkpoint")
+
+	// fail after the first checkpoint
+	imported := 0
+	failing := func(ctx context.Context, r Record) error {
+		if imported == checkpointInterval+5 {
+			return errors.New("boom")
+		}
+		imported++
+		return nil
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Import(ctx, r, checkpoint, failing)
+	assert.ErrorContains(t, err, "boom")
+	assert.Equal(t, 1, stats.Expired)
+	n, err := readCheckpoint(checkpoint)
+	assert.NoError(t, err)
+	// the records processed before the failure are checkpointed
+	assert.Equal(t, checkpointInterval+6, n)
+
+	imported = 0
+	r, err = NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Import(ctx, r, checkpoint, func(ctx context.Context, r Record) error {
+		imported++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, checkpointInterval+6, stats.Resumed)
+	assert.Equal(t, len(recs)-checkpointInterval-6, stats.Imported)
+	assert.Equal(t, stats.Imported, imported)
+	n, err = readCheckpoint(checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestDatastoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	recs := testRecords(t, 3)
+	for _, r := range recs {
+		assert.NoError(t, ImportDatastore(ctx, d, r))
+	}
+
+	// the records are readable by the default provider store
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := providers.NewProviderManager(ctx, "self", ps, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Process().Close()
+	provs, err := pm.GetProviders(ctx, recs[0].Multihash)
+	assert.NoError(t, err)
+	assert.Len(t, provs, 1)
+
+	var exported []Record
+	err = ExportDatastore(ctx, d, func(r Record) error {
+		exported = append(exported, r)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, exported, 3)
+	for _, r := range exported {
+		assert.Equal(t, recs[0].Provider, r.Provider)
+		assert.Empty(t, r.Addrs)
+		assert.True(t, recs[0].Expires.Equal(r.Expires))
+	}
+}
+
+func TestImportDatastoreKeepsRecords(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	recs := testRecords(t, 3)
+
+	// an announced record, a record expiring after the imported one, and one expiring before it
+	announced := time.Now().Add(-providers.ProvideValidity + time.Minute)
+	later := recs[1].Expires.Add(time.Hour).Add(-providers.ProvideValidity)
+	earlier := recs[2].Expires.Add(-time.Minute).Add(-providers.ProvideValidity)
+	existing := []time.Time{announced, later, earlier}
+	origins := []hproviders.RecordOrigin{hproviders.OriginAnnounced, hproviders.OriginPrefetched, hproviders.OriginPrefetched}
+	for i, r := range recs {
+		assert.NoError(t, d.Put(ctx, hproviders.DatastoreProviderKey(r.Multihash, r.Provider), hproviders.EncodeDatastoreValue(existing[i], origins[i])))
+	}
+
+	for _, r := range recs {
+		assert.NoError(t, ImportDatastore(ctx, d, r))
+	}
+	exported := map[string]Record{}
+	err := ExportDatastore(ctx, d, func(r Record) error {
+		exported[string(r.Multihash)] = r
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, exported[string(recs[0].Multihash)].Expires.Equal(announced.Add(providers.ProvideValidity)))
+	assert.True(t, exported[string(recs[1].Multihash)].Expires.Equal(later.Add(providers.ProvideValidity)))
+	assert.True(t, exported[string(recs[2].Multihash)].Expires.Equal(recs[2].Expires))
+}
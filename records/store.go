@@ -0,0 +1,70 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/multiformats/go-multihash"
+)
+
+// ExportDatastore calls fn for each provider record stored in the datastore by the default provider store.
+// The datastore doesn't hold the addresses of the providers, so the records have none.
+func ExportDatastore(ctx context.Context, d ds.Datastore, fn func(Record) error) error {
+	return hproviders.IterateDatastoreProviderRecords(ctx, d, exportRecord(fn))
+}
+
+// ImportDatastore stores a provider record in the datastore in the format of the default provider store.
+// The record is dated so that it expires when it did originally, records without an expiry are dated now.
+// The record is marked as imported. Like the default provider store, it doesn't replace a live announced record of the
+// provider for the key, or a record expiring after it.
+func ImportDatastore(ctx context.Context, d ds.Datastore, r Record) error {
+	t := time.Now()
+	if !r.Expires.IsZero() {
+		t = r.Expires.Add(-providers.ProvideValidity)
+	}
+	key := hproviders.DatastoreProviderKey(r.Multihash, r.Provider)
+	v, err := d.Get(ctx, key)
+	if err != nil && err != ds.ErrNotFound {
+		return err
+	}
+	if err == nil && hproviders.KeepDatastoreValue(v, t) {
+		return nil
+	}
+	return d.Put(ctx, key, hproviders.EncodeDatastoreValue(t, hproviders.OriginImported))
+}
+
+// ExportProviderStore calls fn for each provider record of the provider store, if it can enumerate its provider records.
+func ExportProviderStore(ctx context.Context, ps providers.ProviderStore, fn func(Record) error) error {
+	it, ok := hproviders.FindProviderRecordIterator(ps)
+	if !ok {
+		return errors.New("the provider store cannot enumerate its provider records")
+	}
+	return it.IterateProviderRecords(ctx, exportRecord(fn))
+}
+
+func exportRecord(fn func(Record) error) func(hproviders.ProviderRecord) error {
+	return func(pr hproviders.ProviderRecord) error {
+		mh, err := multihash.Cast(pr.Key)
+		if err != nil {
+			return fmt.Errorf("invalid provider record key: %w", err)
+		}
+		return fn(Record{Multihash: mh, Provider: pr.Provider.ID, Addrs: pr.Provider.Addrs, Expires: pr.Expires})
+	}
+}
+
+// ImportProviderStore adds a provider record to the provider store, marked as imported. Provider stores tracking the origin
+// of their records expire it when it did originally, other provider stores decide when the record expires.
+func ImportProviderStore(ctx context.Context, ps providers.ProviderStore, r Record) error {
+	var ttl time.Duration
+	if !r.Expires.IsZero() {
+		ttl = time.Until(r.Expires)
+	}
+	ctx = hproviders.WithRecordOrigin(ctx, hproviders.OriginImported, ttl)
+	return ps.AddProvider(ctx, r.Multihash, peer.AddrInfo{ID: r.Provider, Addrs: r.Addrs})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+)
+
+func TestRecordsExportDatastoreError(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	ds, err := leveldb.NewDatastore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a provider record that can't be parsed fails the export
+	if err := ds.Put(ctx, datastore.NewKey(providers.ProvidersKeyPrefix+"invalid/invalid"), []byte("invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = recordsExport(ctx, []string{"-db", dir, "-out", filepath.Join(t.TempDir(), "records.ndjson")})
+	if err == nil {
+		t.Fatal("expected the export of an invalid provider record to fail")
+	}
+}
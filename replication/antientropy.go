@@ -0,0 +1,189 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	// digestRanges is the number of ranges the keyspace is split into, by the first 12 bits of the hash of the keys.
+	digestRanges = 4096
+	// digestTTL is the time digests are reused for, since computing them enumerates all the records.
+	digestTTL = time.Minute
+)
+
+// keyRange returns the range of the keyspace a key belongs to.
+func keyRange(key []byte) int {
+	h := sha256.Sum256(key)
+	return int(binary.BigEndian.Uint16(h[:2]) >> 4)
+}
+
+// recordHash is the hash of a record in the digest of its range. Digests are the XOR of the hashes of their records,
+// so that they don't depend on the order the records are enumerated in.
+func recordHash(rec hproviders.ProviderRecord) uint64 {
+	h := sha256.New()
+	h.Write(rec.Key)
+	h.Write([]byte(rec.Provider.ID))
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
+
+// liveRecords enumerates the replicated records of the provider store that didn't expire: the announced records, and
+// the records replicated from the announced records of other hydras. Other records, e.g. prefetched records, are neither
+// pushed to the replicas nor repaired, so they are not part of the digests either.
+func liveRecords(ctx context.Context, ps providers.ProviderStore, now time.Time, fn func(hproviders.ProviderRecord) error) error {
+	it, ok := hproviders.FindProviderRecordIterator(ps)
+	if !ok {
+		return errors.New("the provider store cannot enumerate its records")
+	}
+	return it.IterateProviderRecords(ctx, func(rec hproviders.ProviderRecord) error {
+		if rec.Origin != hproviders.OriginAnnounced && rec.Origin != hproviders.OriginReplicated {
+			return nil
+		}
+		if !rec.Expires.IsZero() && !rec.Expires.After(now) {
+			return nil
+		}
+		return fn(rec)
+	})
+}
+
+// computeDigests returns the digest and the number of records of each range of the keyspace.
+func computeDigests(ctx context.Context, ps providers.ProviderStore, now time.Time) ([]uint64, []int, error) {
+	digests := make([]uint64, digestRanges)
+	counts := make([]int, digestRanges)
+	err := liveRecords(ctx, ps, now, func(rec hproviders.ProviderRecord) error {
+		i := keyRange(rec.Key)
+		digests[i] ^= recordHash(rec)
+		counts[i]++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return digests, counts, nil
+}
+
+// digestCache reuses the digests of the local records for digestTTL.
+type digestCache struct {
+	mut      sync.Mutex
+	digests  []uint64
+	counts   []int
+	computed time.Time
+	clock    clock.Clock
+}
+
+func newDigestCache(clk clock.Clock) *digestCache {
+	return &digestCache{clock: clk}
+}
+
+// get returns the digest and the number of records of each range of the keyspace.
+func (c *digestCache) get(ctx context.Context, ps providers.ProviderStore) ([]uint64, []int, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	now := c.clock.Now()
+	if c.digests != nil && now.Sub(c.computed) < digestTTL {
+		return c.digests, c.counts, nil
+	}
+	digests, counts, err := computeDigests(ctx, ps, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.digests = digests
+	c.counts = counts
+	c.computed = now
+	return digests, counts, nil
+}
+
+func (r *Replicator) repairLoop(ctx context.Context, rep *replica) {
+	ticker := r.clock.Ticker(r.Policy.AntiEntropyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.repair(ctx, rep); err != nil {
+				log.Warnf("failed to repair the records of %s: %s", rep.name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// repair sends the local records of the ranges of the keyspace whose digests differ from the digests of the replica.
+// The replica adds the records it is missing. Each repair sends up to MaxRepairRecords records, from the ranges
+// following the last range repaired by the previous repair, so that all the ranges that differ are eventually repaired.
+func (r *Replicator) repair(ctx context.Context, rep *replica) error {
+	digests, counts, err := r.digests.get(ctx, r.local)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(message{Type: typeDigests, Digests: digests})
+	if err != nil {
+		return err
+	}
+	resp, err := r.request(ctx, rep, data)
+	if err != nil {
+		return err
+	}
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyReplica, rep.name)}, metrics.ReplicationDiffRanges.M(int64(len(resp.Ranges))))
+	if len(resp.Ranges) == 0 {
+		return nil
+	}
+
+	differ := make([]bool, digestRanges)
+	for _, i := range resp.Ranges {
+		if i >= 0 && i < digestRanges {
+			differ[i] = true
+		}
+	}
+	// repair the ranges that differ from the cursor on, at least one, until they hold MaxRepairRecords records
+	repaired := make([]bool, digestRanges)
+	total, next := 0, rep.repairFrom
+	for n := 0; n < digestRanges; n++ {
+		i := (rep.repairFrom + n) % digestRanges
+		if !differ[i] {
+			continue
+		}
+		if total > 0 && total+counts[i] > r.Policy.MaxRepairRecords {
+			break
+		}
+		repaired[i] = true
+		total += counts[i]
+		next = (i + 1) % digestRanges
+	}
+	rep.repairFrom = next
+
+	var batch []record
+	err = liveRecords(ctx, r.local, r.clock.Now(), func(rec hproviders.ProviderRecord) error {
+		if !repaired[keyRange(rec.Key)] {
+			return nil
+		}
+		batch = append(batch, record{Key: rec.Key, Provider: rec.Provider, Expires: rec.Expires})
+		if len(batch) < r.Policy.BatchSize {
+			return nil
+		}
+		if err := r.push(ctx, rep, batch, "repair"); err != nil {
+			return err
+		}
+		batch = nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return r.push(ctx, rep, batch, "repair")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package replication
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// bandwidthLimiter delays sends so that at most rate bytes per second are sent on average.
+// A nil limiter doesn't limit.
+type bandwidthLimiter struct {
+	rate float64
+
+	mut sync.Mutex
+	// next is when the next send can start
+	next  time.Time
+	clock clock.Clock
+}
+
+func newBandwidthLimiter(rate float64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: rate, clock: clock.New()}
+}
+
+// wait waits until n bytes can be sent, and reserves the time it takes to send them at the rate.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mut.Lock()
+	now := l.clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mut.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := l.clock.Timer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,133 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Protocol is the libp2p protocol hydras use to replicate provider records to each other.
+const Protocol protocol.ID = "/hydra/replication/1.0.0"
+
+// maxMessageSize bounds the size of the messages read from a stream.
+const maxMessageSize = 4 << 20
+
+// Types of messages.
+const (
+	typePush    = "push"
+	typeDigests = "digests"
+)
+
+// message is a request, or the response to a request. Each stream carries a request and its response as JSON.
+type message struct {
+	Type    string   `json:",omitempty"`
+	Records []record `json:",omitempty"`
+	// Digests are the digests of the ranges of the keyspace of the sender of a digests request.
+	Digests []uint64 `json:",omitempty"`
+	// Ranges are the ranges whose digests differ, in the response to a digests request.
+	Ranges []int  `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// request sends an encoded request to the peer and returns its response.
+func request(ctx context.Context, h host.Host, to peer.AddrInfo, timeout time.Duration, data []byte) (message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(to.Addrs) > 0 {
+		h.Peerstore().AddAddrs(to.ID, to.Addrs, peerstore.TempAddrTTL)
+	}
+	str, err := h.NewStream(ctx, to.ID, Protocol)
+	if err != nil {
+		return message{}, err
+	}
+	defer str.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		str.SetDeadline(deadline)
+	}
+
+	if _, err := str.Write(data); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	if err := str.CloseWrite(); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	var resp message
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&resp); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	if resp.Error != "" {
+		return message{}, remoteError(resp.Error)
+	}
+	return resp, nil
+}
+
+// remoteError is an error returned by the peer a request was sent to.
+type remoteError string
+
+func (e remoteError) Error() string {
+	return string(e)
+}
+
+// handle serves a request from a replica.
+func (r *Replicator) handle(ctx context.Context, str network.Stream, local providers.ProviderStore) {
+	defer str.Close()
+	str.SetDeadline(time.Now().Add(r.Policy.Timeout))
+
+	ctx, cancel := context.WithTimeout(ctx, r.Policy.Timeout)
+	defer cancel()
+	from := str.Conn().RemotePeer()
+	var resp message
+	if !r.isReplica(from) {
+		log.Debugf("rejected replication request from %s, which is not a replica", from)
+		resp.Error = "not a replica"
+		json.NewEncoder(str).Encode(resp)
+		return
+	}
+
+	var req message
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&req); err != nil {
+		log.Debugf("invalid replication request from %s: %s", from, err)
+		str.Reset()
+		return
+	}
+
+	switch req.Type {
+	case typePush:
+		r.add(ctx, local, req.Records)
+	case typeDigests:
+		digests, _, err := r.digests.get(ctx, local)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		if len(req.Digests) != len(digests) {
+			resp.Error = fmt.Sprintf("expected %d digests, got %d", len(digests), len(req.Digests))
+			break
+		}
+		for i := range digests {
+			if digests[i] != req.Digests[i] {
+				resp.Ranges = append(resp.Ranges, i)
+			}
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown request type %q", req.Type)
+	}
+
+	if err := json.NewEncoder(str).Encode(resp); err != nil {
+		log.Debugf("failed to respond to %s: %s", from, err)
+		str.Reset()
+	}
+}
@@ -0,0 +1,391 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+var log = logging.Logger("hydra/replication")
+
+// Policy configures the replication of provider records.
+type Policy struct {
+	// Bandwidth is the maximum number of bytes per second sent to the replicas, across all of them. 0 is unlimited.
+	Bandwidth float64
+	// QueueSize is the number of records queued for each replica, further records are dropped until the queue drains.
+	QueueSize int
+	// BatchSize is the maximum number of records sent at once.
+	BatchSize int
+	// AntiEntropyInterval is the time between the repairs of the records missing from each replica. 0 disables repairs.
+	AntiEntropyInterval time.Duration
+	// MaxRepairRecords is the maximum number of records sent to a replica by each repair, unless a single range of the
+	// keyspace holds more.
+	MaxRepairRecords int
+	// ResolveInterval is the time between the resolutions of the heads of each replica. Replicas whose heads cannot be
+	// resolved are retried sooner, with exponential backoff.
+	ResolveInterval time.Duration
+	Timeout         time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		QueueSize:           10000,
+		BatchSize:           100,
+		AntiEntropyInterval: 10 * time.Minute,
+		MaxRepairRecords:    100000,
+		ResolveInterval:     time.Minute,
+		Timeout:             10 * time.Second,
+	}
+}
+
+// record is a replicated provider record.
+type record struct {
+	Key      []byte
+	Provider peer.AddrInfo
+	// Expires is when the record expires, it is the zero time if it was just added.
+	Expires time.Time `json:",omitempty"`
+}
+
+// minResolveBackoff is the time before resolving the heads of a replica again after a first failure.
+const minResolveBackoff = time.Second
+
+type pending struct {
+	rec   record
+	added time.Time
+}
+
+// replica is another hydra the provider records are replicated to.
+type replica struct {
+	// name identifies the replica in metrics, it is the host and port of its HTTP API
+	name  string
+	url   string
+	queue chan pending
+	// resolveNow asks the resolve loop to resolve the heads again, e.g. after a request to the replica failed
+	resolveNow chan struct{}
+	// repairFrom is the range of the keyspace the next repair starts from, it is only used by the repair loop
+	repairFrom int
+
+	mut sync.Mutex
+	// heads are the heads of the replica, the first one receives the replicated records
+	heads []peer.AddrInfo
+}
+
+func (r *replica) target() (peer.AddrInfo, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if len(r.heads) == 0 {
+		return peer.AddrInfo{}, false
+	}
+	return r.heads[0], true
+}
+
+// has returns true if the peer is one of the resolved heads of the replica.
+func (r *replica) has(id peer.ID) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, h := range r.heads {
+		if h.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh asks the resolve loop to resolve the heads of the replica again, unless it already was.
+func (r *replica) refresh() {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// Replicator replicates the provider records announced to this hydra to other hydras, the replicas, and adds the
+// records replicated by them. Records are pushed to the replicas as they are announced, and the records missed by a
+// replica, while it was down or when its queue was full, are repaired by anti-entropy: the hydras compare digests of
+// ranges of the keyspace, and the records of the ranges that differ are sent again.
+//
+// Replicas are given as the HTTP APIs of other hydras, whose heads are listed by their "/heads" API in the background.
+// Records are only accepted from the resolved heads of replicas, so hydras replicating to each other must list each other.
+type Replicator struct {
+	Policy Policy
+
+	httpClient *http.Client
+	replicas   []*replica
+	limiter    *bandwidthLimiter
+
+	mut     sync.Mutex
+	ctx     context.Context
+	host    host.Host
+	local   providers.ProviderStore
+	digests *digestCache
+	clock   clock.Clock
+}
+
+// New creates a replicator of provider records to the hydras with the given HTTP APIs, e.g. "127.0.0.1:7779".
+func New(httpClient *http.Client, replicas []string, policy Policy) (*Replicator, error) {
+	if policy.QueueSize < 1 || policy.BatchSize < 1 {
+		return nil, errors.New("the replication queue and batch sizes must be positive")
+	}
+	if policy.ResolveInterval <= 0 {
+		return nil, errors.New("the interval between the resolutions of the heads of the replicas must be positive")
+	}
+	r := &Replicator{
+		Policy:     policy,
+		httpClient: httpClient,
+		limiter:    newBandwidthLimiter(policy.Bandwidth),
+		clock:      clock.New(),
+	}
+	for _, addr := range replicas {
+		r.replicas = append(r.replicas, &replica{
+			name:       addr,
+			url:        "http://" + strings.TrimPrefix(addr, "http://"),
+			queue:      make(chan pending, policy.QueueSize),
+			resolveNow: make(chan struct{}, 1),
+		})
+	}
+	r.digests = newDigestCache(r.clock)
+	return r, nil
+}
+
+// Serve adds the records replicated to the host by other hydras to the local provider store. The first host served
+// resolves the heads of the replicas and sends them the replicated records until the context is done, and the anti-entropy repairs are computed from the records
+// of its local provider store.
+func (r *Replicator) Serve(ctx context.Context, h host.Host, local providers.ProviderStore) {
+	h.SetStreamHandler(Protocol, func(str network.Stream) { r.handle(ctx, str, local) })
+
+	r.mut.Lock()
+	first := r.host == nil
+	if first {
+		r.ctx = ctx
+		r.host = h
+		r.local = local
+	}
+	r.mut.Unlock()
+	if !first {
+		return
+	}
+	for _, rep := range r.replicas {
+		go r.resolveLoop(ctx, rep)
+		go r.send(ctx, rep)
+		if r.Policy.AntiEntropyInterval > 0 {
+			go r.repairLoop(ctx, rep)
+		}
+	}
+}
+
+// Replicate queues the replication of a provider record to all the replicas. The record is dropped for the replicas
+// whose queue is full.
+func (r *Replicator) Replicate(ctx context.Context, key []byte, prov peer.AddrInfo) {
+	p := pending{rec: record{Key: key, Provider: prov}, added: r.clock.Now()}
+	for _, rep := range r.replicas {
+		select {
+		case rep.queue <- p:
+		default:
+			recordSent(ctx, rep, "push", "dropped", 1)
+		}
+	}
+}
+
+// send pushes the queued records to the replica in batches, until the context is done.
+func (r *Replicator) send(ctx context.Context, rep *replica) {
+	for {
+		var batch []pending
+		select {
+		case p := <-rep.queue:
+			batch = append(batch, p)
+		case <-ctx.Done():
+			return
+		}
+	collect:
+		for len(batch) < r.Policy.BatchSize {
+			select {
+			case p := <-rep.queue:
+				batch = append(batch, p)
+			default:
+				break collect
+			}
+		}
+		stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyReplica, rep.name)}, metrics.ReplicationQueue.M(int64(len(rep.queue))))
+
+		recs := make([]record, len(batch))
+		for i, p := range batch {
+			recs[i] = p.rec
+		}
+		if err := r.push(ctx, rep, recs, "push"); err != nil {
+			log.Debugf("failed to replicate %d records to %s: %s", len(recs), rep.name, err)
+			continue
+		}
+		now := r.clock.Now()
+		for _, p := range batch {
+			stats.RecordWithTags(ctx,
+				[]tag.Mutator{tag.Upsert(metrics.KeyReplica, rep.name)},
+				metrics.ReplicationLag.M(float64(now.Sub(p.added))/float64(time.Millisecond)),
+			)
+		}
+	}
+}
+
+// push sends records to the replica, within the bandwidth limit.
+func (r *Replicator) push(ctx context.Context, rep *replica, recs []record, kind string) error {
+	data, err := json.Marshal(message{Type: typePush, Records: recs})
+	if err != nil {
+		return err
+	}
+	if err := r.limiter.wait(ctx, len(data)); err != nil {
+		return err
+	}
+	_, err = r.request(ctx, rep, data)
+	status := "succeeded"
+	if err != nil {
+		status = "failed"
+	} else {
+		stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyReplica, rep.name)}, metrics.ReplicationBytes.M(int64(len(data))))
+	}
+	recordSent(ctx, rep, kind, status, len(recs))
+	return err
+}
+
+// request sends an encoded request to the first head of the replica. The heads are resolved again after a failure,
+// in case the replica restarted with other heads.
+func (r *Replicator) request(ctx context.Context, rep *replica, data []byte) (message, error) {
+	to, ok := rep.target()
+	if !ok {
+		return message{}, fmt.Errorf("the heads of %s are not resolved", rep.name)
+	}
+	resp, err := request(ctx, r.host, to, r.Policy.Timeout, data)
+	var rerr remoteError
+	if err != nil && !errors.As(err, &rerr) {
+		rep.refresh()
+	}
+	return resp, err
+}
+
+// resolveLoop resolves the heads of the replica until the context is done: right away, then every resolve interval,
+// and when a request to the replica failed. Failed resolutions are retried with exponential backoff, up to the resolve
+// interval, and meanwhile the replica keeps the heads it last resolved.
+func (r *Replicator) resolveLoop(ctx context.Context, rep *replica) {
+	backoff := minResolveBackoff
+	for {
+		wait := r.Policy.ResolveInterval
+		resolveNow := rep.resolveNow
+		if err := r.resolve(ctx, rep); err != nil {
+			log.Debugf("failed to resolve the heads of %s: %s", rep.name, err)
+			if backoff < wait {
+				wait = backoff
+			}
+			backoff *= 2
+			// the failed requests to the replica don't bypass the backoff
+			resolveNow = nil
+		} else {
+			backoff = minResolveBackoff
+		}
+
+		timer := r.clock.Timer(wait)
+		select {
+		case <-timer.C:
+		case <-resolveNow:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// resolve lists the heads of the replica with its HTTP API.
+func (r *Replicator) resolve(ctx context.Context, rep *replica) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Policy.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rep.url+"/heads", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var heads []peer.AddrInfo
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ai peer.AddrInfo
+		if err := dec.Decode(&ai); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		heads = append(heads, ai)
+	}
+	if len(heads) == 0 {
+		return errors.New("no heads")
+	}
+	rep.mut.Lock()
+	rep.heads = heads
+	rep.mut.Unlock()
+	return nil
+}
+
+// isReplica returns true if the peer is a resolved head of a replica. It never resolves the heads of the replicas,
+// so that peers opening replication streams cannot make the hydra send requests to the replicas.
+func (r *Replicator) isReplica(id peer.ID) bool {
+	for _, rep := range r.replicas {
+		if rep.has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the records replicated by another hydra to the local provider store, which keeps the record expiring last
+// when it already holds the provider of a key, e.g. refreshing it when the provider announced it again.
+// Records keep their expiry, so that they are not kept longer than by the hydra they come from.
+func (r *Replicator) add(ctx context.Context, local providers.ProviderStore, recs []record) {
+	now := r.clock.Now()
+	for _, rec := range recs {
+		var ttl time.Duration
+		if !rec.Expires.IsZero() {
+			ttl = rec.Expires.Sub(now)
+			if ttl <= 0 {
+				recordReceived(ctx, "expired")
+				continue
+			}
+		}
+		if err := local.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginReplicated, ttl), rec.Key, rec.Provider); err != nil {
+			log.Debugf("failed to add replicated record: %s", err)
+			recordReceived(ctx, "failed")
+			continue
+		}
+		recordReceived(ctx, "added")
+	}
+}
+
+func recordSent(ctx context.Context, rep *replica, kind string, status string, n int) {
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyReplica, rep.name), tag.Upsert(metrics.KeyKind, kind), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.ReplicationSent.M(int64(n)),
+	)
+}
+
+func recordReceived(ctx context.Context, status string) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.ReplicationReceived.M(1))
+}
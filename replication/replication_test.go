@@ -0,0 +1,277 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	hydratesting "github.com/libp2p/hydra-booster/testing"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProvider(t *testing.T) peer.AddrInfo {
+	return peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+}
+
+// headsAPI serves the "/heads" API of a hydra with a single head.
+func headsAPI(t *testing.T, h host.Host) *httptest.Server {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func newTestReplicator(t *testing.T, h host.Host, replicas ...*httptest.Server) (*Replicator, *hydratesting.ProviderStore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var urls []string
+	for _, api := range replicas {
+		urls = append(urls, api.URL)
+	}
+	policy := DefaultPolicy()
+	policy.AntiEntropyInterval = 0
+	policy.Timeout = time.Second
+	r, err := New(http.DefaultClient, urls, policy)
+	require.NoError(t, err)
+
+	local := &hydratesting.ProviderStore{}
+	r.Serve(ctx, h, local)
+	// the heads of the replicas are resolved in the background
+	require.Eventually(t, func() bool {
+		for _, rep := range r.replicas {
+			if _, ok := rep.target(); !ok {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return r, local
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(3)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+	api1, api2 := headsAPI(t, hosts[0]), headsAPI(t, hosts[1])
+
+	r1, local1 := newTestReplicator(t, hosts[0], api2)
+	r2, local2 := newTestReplicator(t, hosts[1], api1)
+	// hy3 replicates to hy2, which doesn't list it
+	r3, _ := newTestReplicator(t, hosts[2], api2)
+
+	// announced records are pushed to the replicas
+	key, prov := hydratesting.TestKey(0), testProvider(t)
+	require.NoError(t, local1.AddProvider(ctx, key, prov))
+	r1.Replicate(ctx, key, prov)
+	assert.Eventually(t, func() bool { return local2.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+	provs, err := local2.GetProviders(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{prov}, provs)
+	assert.Equal(t, hproviders.OriginReplicated, local2.Records()[0].Origin)
+
+	// records are not added twice, and expired records are not added
+	r2.add(ctx, local2, []record{{Key: key, Provider: prov}, {Key: hydratesting.TestKey(1), Provider: prov, Expires: time.Now().Add(-time.Minute)}})
+	assert.Equal(t, 1, local2.Len())
+
+	// records announced again are refreshed, and replace prefetched records
+	expires := local2.Records()[0].Expires
+	time.Sleep(10 * time.Millisecond)
+	r1.Replicate(ctx, key, prov)
+	assert.Eventually(t, func() bool {
+		return local2.Records()[0].Expires.After(expires)
+	}, 5*time.Second, 10*time.Millisecond)
+	prefetched := testProvider(t)
+	require.NoError(t, local2.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Hour), key, prefetched))
+	r2.add(ctx, local2, []record{{Key: key, Provider: prefetched}})
+	assert.Equal(t, 2, local2.Len())
+	assert.Equal(t, hproviders.OriginReplicated, local2.Records()[1].Origin)
+
+	// records are only accepted from replicas
+	err = r3.push(ctx, r3.replicas[0], []record{{Key: hydratesting.TestKey(2), Provider: prov}}, "push")
+	assert.EqualError(t, err, "not a replica")
+	assert.Equal(t, 2, local2.Len())
+}
+
+func TestReplicatorOnlyResolvesInBackground(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(3)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	var mut sync.Mutex
+	resolved := 0
+	api2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		resolved++
+		mut.Unlock()
+		json.NewEncoder(w).Encode(peer.AddrInfo{ID: hosts[1].ID(), Addrs: hosts[1].Addrs()})
+	}))
+	defer api2.Close()
+	resolutions := func() int {
+		mut.Lock()
+		defer mut.Unlock()
+		return resolved
+	}
+
+	_, local1 := newTestReplicator(t, hosts[0], api2)
+	require.Equal(t, 1, resolutions())
+
+	// requests from peers that are not replicas are rejected without resolving the heads of the replicas again
+	r3, _ := newTestReplicator(t, hosts[2], headsAPI(t, hosts[0]))
+	for i := 0; i < 10; i++ {
+		err = r3.push(ctx, r3.replicas[0], []record{{Key: hydratesting.TestKey(i), Provider: testProvider(t)}}, "push")
+		assert.EqualError(t, err, "not a replica")
+	}
+	assert.Equal(t, 1, resolutions())
+	assert.Equal(t, 0, local1.Len())
+}
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+	api1, api2 := headsAPI(t, hosts[0]), headsAPI(t, hosts[1])
+
+	r1, local1 := newTestReplicator(t, hosts[0], api2)
+	_, local2 := newTestReplicator(t, hosts[1], api1)
+
+	// records missed by the replica
+	for i := 0; i < 250; i++ {
+		require.NoError(t, local1.AddProvider(ctx, hydratesting.TestKey(i), testProvider(t)))
+	}
+	require.NoError(t, local1.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Millisecond), hydratesting.TestKey(0), testProvider(t)))
+	time.Sleep(10 * time.Millisecond)
+
+	require.NoError(t, r1.repair(ctx, r1.replicas[0]))
+	assert.Equal(t, 250, local2.Len())
+	// the expired record is neither repaired nor part of the digests
+	d1, _, err := computeDigests(ctx, local1, time.Now())
+	require.NoError(t, err)
+	d2, _, err := computeDigests(ctx, local2, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, d1, d2)
+
+	// repairs need records that can be enumerated
+	r1.local = struct{ providers.ProviderStore }{local1}
+	r1.digests = newDigestCache(clock.New())
+	assert.Error(t, r1.repair(ctx, r1.replicas[0]))
+}
+
+func TestRepairPrefetched(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+	api1, api2 := headsAPI(t, hosts[0]), headsAPI(t, hosts[1])
+
+	r1, local1 := newTestReplicator(t, hosts[0], api2)
+	r2, local2 := newTestReplicator(t, hosts[1], api1)
+
+	// prefetched records are only on one side, and are not repaired
+	for i := 0; i < 50; i++ {
+		require.NoError(t, local1.AddProvider(ctx, hydratesting.TestKey(i), testProvider(t)))
+		require.NoError(t, local1.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Hour), hydratesting.TestKey(i), testProvider(t)))
+		require.NoError(t, local1.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Hour), hydratesting.TestKey(100+i), testProvider(t)))
+	}
+	require.NoError(t, r1.repair(ctx, r1.replicas[0]))
+	assert.Equal(t, 50, local2.Len())
+
+	// the digests converge, including the digests of the replica, whose records are replicated
+	d1, _, err := computeDigests(ctx, local1, time.Now())
+	require.NoError(t, err)
+	d2, _, err := computeDigests(ctx, local2, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, d1, d2)
+	r1.digests = newDigestCache(clock.New())
+	r2.digests = newDigestCache(clock.New())
+	require.NoError(t, r1.repair(ctx, r1.replicas[0]))
+	assert.Equal(t, 50, local2.Len())
+}
+
+func TestRepairResumes(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+	api1, api2 := headsAPI(t, hosts[0]), headsAPI(t, hosts[1])
+
+	r1, local1 := newTestReplicator(t, hosts[0], api2)
+	r1.Policy.MaxRepairRecords = 50
+	_, local2 := newTestReplicator(t, hosts[1], api1)
+
+	// the replica has records of its own in every range, so the repaired ranges still differ
+	for i := 0; i < 100; i++ {
+		require.NoError(t, local1.AddProvider(ctx, hydratesting.TestKey(i), testProvider(t)))
+		require.NoError(t, local2.AddProvider(ctx, hydratesting.TestKey(i), testProvider(t)))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r1.repair(ctx, r1.replicas[0]))
+	}
+	assert.Equal(t, 200, local2.Len())
+}
+
+func TestKeyRange(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 100000; i++ {
+		r := keyRange(hydratesting.TestKey(i))
+		require.True(t, r >= 0 && r < digestRanges)
+		seen[r] = true
+	}
+	assert.Len(t, seen, digestRanges)
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, newBandwidthLimiter(0))
+	var unlimited *bandwidthLimiter
+	assert.NoError(t, unlimited.wait(ctx, 1<<30))
+
+	clk := clock.NewMock()
+	l := newBandwidthLimiter(100)
+	l.clock = clk
+
+	// the first send doesn't wait, the next one waits for the first one to be sent at the rate
+	assert.NoError(t, l.wait(ctx, 200))
+	done := make(chan error)
+	go func() { done <- l.wait(ctx, 100) }()
+	time.Sleep(10 * time.Millisecond)
+	clk.Add(time.Second)
+	select {
+	case <-done:
+		t.Fatal("waited less than the time to send 200 bytes")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Add(time.Second)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("waited more than the time to send 200 bytes")
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, l.wait(cctx, 100), context.Canceled)
+}
@@ -0,0 +1,208 @@
+package shard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+)
+
+// Protocol is the libp2p protocol the hydras sharing a keyspace use to forward provider requests and exchange heartbeats.
+const Protocol protocol.ID = "/hydra/shard/1.0.0"
+
+// maxMessageSize bounds the size of the messages read from a stream.
+const maxMessageSize = 1 << 20
+
+// Types of messages.
+const (
+	typePing         = "ping"
+	typeLeave        = "leave"
+	typeAddProvider  = "add-provider"
+	typeGetProviders = "get-providers"
+)
+
+// message is a request, or the response to a request. Each stream carries a request and its response as JSON.
+type message struct {
+	Type string `json:",omitempty"`
+	// From is the member sending a heartbeat, or leaving.
+	From     *peer.AddrInfo `json:",omitempty"`
+	Key      []byte         `json:",omitempty"`
+	Provider *peer.AddrInfo `json:",omitempty"`
+	// Origin and TTL are the origin of a forwarded provider record, and how long it is kept if not the default TTL,
+	// so that e.g. prefetched records stay prefetched records on the hydra owning their key.
+	Origin    hproviders.RecordOrigin `json:",omitempty"`
+	TTL       time.Duration           `json:",omitempty"`
+	Providers []peer.AddrInfo         `json:",omitempty"`
+	// Members are the members known by the receiver of a heartbeat.
+	Members []peer.AddrInfo `json:",omitempty"`
+	Error   string          `json:",omitempty"`
+}
+
+// request sends a request to the peer and returns its response.
+func request(ctx context.Context, h host.Host, to peer.AddrInfo, timeout time.Duration, req message) (message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(to.Addrs) > 0 {
+		h.Peerstore().AddAddrs(to.ID, to.Addrs, peerstore.TempAddrTTL)
+	}
+	str, err := h.NewStream(ctx, to.ID, Protocol)
+	if err != nil {
+		return message{}, err
+	}
+	defer str.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		str.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(str).Encode(req); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	if err := str.CloseWrite(); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	var resp message
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&resp); err != nil {
+		str.Reset()
+		return message{}, err
+	}
+	if resp.Error != "" {
+		return message{}, remoteError(resp.Error)
+	}
+	return resp, nil
+}
+
+// remoteError is an error returned by the peer a request was sent to.
+type remoteError string
+
+func (e remoteError) Error() string {
+	return string(e)
+}
+
+// handle serves a request from another hydra. Requests are only accepted from the known members of the keyspace, so that
+// other peers can neither join it nor read or write its provider records. Provider requests are served by the local
+// provider store, even for keys this hydra doesn't own, since the hydras may briefly disagree on the owners of keys.
+func (s *Shards) handle(ctx context.Context, str network.Stream, local providers.ProviderStore) {
+	defer str.Close()
+	str.SetDeadline(time.Now().Add(s.Timeout))
+
+	var req message
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&req); err != nil {
+		log.Debugf("invalid request from %s: %s", str.Conn().RemotePeer(), err)
+		str.Reset()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	var resp message
+	var err error
+	if !s.isMember(str.Conn().RemotePeer()) {
+		err = errors.New("not a member")
+	} else {
+		switch req.Type {
+		case typePing:
+			if req.From == nil || req.From.ID != str.Conn().RemotePeer() {
+				err = errors.New("heartbeat from another peer")
+				break
+			}
+			s.mut.Lock()
+			s.reached(*req.From)
+			if self := s.self(); self != "" {
+				resp.Members = append(resp.Members, peer.AddrInfo{ID: self, Addrs: s.host.Addrs()})
+			}
+			for id, m := range s.members {
+				if m.alive {
+					resp.Members = append(resp.Members, peer.AddrInfo{ID: id, Addrs: m.addrs})
+				}
+			}
+			s.mut.Unlock()
+		case typeLeave:
+			if req.From == nil || req.From.ID != str.Conn().RemotePeer() {
+				err = errors.New("leave from another peer")
+				break
+			}
+			s.mut.Lock()
+			s.remove(req.From.ID)
+			s.mut.Unlock()
+		case typeAddProvider:
+			if req.Provider == nil {
+				err = errors.New("missing provider")
+				break
+			}
+			if req.TTL < 0 {
+				err = errors.New("negative TTL")
+				break
+			}
+			err = local.AddProvider(hproviders.WithRecordOrigin(ctx, req.Origin, req.TTL), req.Key, *req.Provider)
+		case typeGetProviders:
+			resp.Providers, err = local.GetProviders(ctx, req.Key)
+		default:
+			err = fmt.Errorf("unknown request type %q", req.Type)
+		}
+	}
+	if err != nil {
+		resp = message{Error: err.Error()}
+	}
+
+	if err := json.NewEncoder(str).Encode(resp); err != nil {
+		log.Debugf("failed to respond to %s: %s", str.Conn().RemotePeer(), err)
+		str.Reset()
+	}
+}
+
+// Forwarder forwards provider requests for keys owned by other hydras.
+type Forwarder struct {
+	shards *Shards
+}
+
+// Forwarder returns a forwarder of provider requests. Requests are forwarded from the host representing this hydra,
+// since the other hydras only accept requests from the members of the keyspace.
+func (s *Shards) Forwarder() *Forwarder {
+	return &Forwarder{shards: s}
+}
+
+// Owner returns the member owning the key, and true if it is owned by this hydra.
+func (f *Forwarder) Owner(key []byte) (peer.ID, bool) {
+	return f.shards.Owner(key)
+}
+
+// AddProvider forwards the provider record to the owner, with the origin and TTL it is added with.
+func (f *Forwarder) AddProvider(ctx context.Context, owner peer.ID, key []byte, prov peer.AddrInfo) error {
+	origin, ttl := hproviders.RecordOriginFromContext(ctx)
+	_, err := f.request(ctx, owner, message{Type: typeAddProvider, Key: key, Provider: &prov, Origin: origin, TTL: ttl})
+	return err
+}
+
+func (f *Forwarder) GetProviders(ctx context.Context, owner peer.ID, key []byte) ([]peer.AddrInfo, error) {
+	resp, err := f.request(ctx, owner, message{Type: typeGetProviders, Key: key})
+	return resp.Providers, err
+}
+
+// request forwards a request to the owner. Failing to reach the owner counts towards its removal.
+func (f *Forwarder) request(ctx context.Context, owner peer.ID, req message) (message, error) {
+	f.shards.mut.RLock()
+	h := f.shards.host
+	f.shards.mut.RUnlock()
+	if h == nil {
+		return message{}, errors.New("no host served")
+	}
+	resp, err := request(ctx, h, peer.AddrInfo{ID: owner, Addrs: f.shards.addrs(owner)}, f.shards.Timeout, req)
+	var rerr remoteError
+	if err != nil && !errors.As(err, &rerr) {
+		f.shards.failed(owner)
+	}
+	return resp, err
+}
@@ -0,0 +1,545 @@
+package shard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/multiformats/go-multiaddr"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+var log = logging.Logger("hydra/shard")
+
+const (
+	DefaultHeartbeatInterval = 10 * time.Second
+	// DefaultMaxFailures is the default number of consecutive failed heartbeats or forwarded requests after which a
+	// member is removed.
+	DefaultMaxFailures = 3
+	DefaultTimeout     = 5 * time.Second
+
+	// shareSamples is the number of keys sampled to estimate the share of the keyspace owned by each member.
+	shareSamples = 4096
+)
+
+// States of the members of the keyspace.
+const (
+	// StateJoining members were discovered but not reached yet, and don't own any keys.
+	StateJoining = "joining"
+	// StateAlive members own a share of the keyspace.
+	StateAlive = "alive"
+)
+
+type member struct {
+	addrs    []multiaddr.Multiaddr
+	alive    bool
+	failures int
+	lastSeen time.Time
+}
+
+// MemberStatus describes a member of the keyspace.
+type MemberStatus struct {
+	ID    peer.ID
+	Addrs []string
+	State string
+	// Self is true for the member representing this hydra.
+	Self bool
+	// Share is the estimated fraction of the keyspace owned by the member.
+	Share    float64
+	Failures int `json:",omitempty"`
+	LastSeen time.Time
+}
+
+// Shards splits the keyspace of provider records between the hydras sharing it, with rendezvous hashing: a key is owned
+// by the alive member with the highest hash of its peer ID and the key, so that only the keys owned by a member move when
+// it joins or leaves.
+//
+// Each hydra is a member represented by the first host it serves. Members are discovered from the HTTP APIs of the seed
+// hydras and from the heartbeats sent to the members, and only own keys once they were reached. Requests are only
+// accepted from discovered members, so hydras sharing a keyspace must list each other, directly or through other
+// members. Members failing MaxFailures consecutive heartbeats or forwarded requests are removed, as are members that
+// leave.
+//
+// Whenever the members change, the records of the local provider store whose keys are owned by other members are handed
+// off to them, so that they are found on their new owner without waiting for their providers to announce them again.
+type Shards struct {
+	HeartbeatInterval time.Duration
+	MaxFailures       int
+	Timeout           time.Duration
+
+	seeds      []string
+	httpClient *http.Client
+
+	// handoffs is signaled when the members changed, to hand off the records of the keys owned by other members.
+	handoffs chan struct{}
+
+	mut     sync.RWMutex
+	ctx     context.Context
+	host    host.Host
+	local   providers.ProviderStore
+	members map[peer.ID]*member
+	// owners are the alive members and this hydra, sorted
+	owners []peer.ID
+	shares map[peer.ID]float64
+	clock  clock.Clock
+}
+
+// New creates the keyspace of this hydra. The seeds are the base URLs of the HTTP APIs of other hydras sharing it,
+// e.g. "http://127.0.0.1:7779".
+func New(httpClient *http.Client, seeds []string) *Shards {
+	return &Shards{
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		MaxFailures:       DefaultMaxFailures,
+		Timeout:           DefaultTimeout,
+		seeds:             seeds,
+		httpClient:        httpClient,
+		handoffs:          make(chan struct{}, 1),
+		members:           map[peer.ID]*member{},
+		shares:            map[peer.ID]float64{},
+		clock:             clock.New(),
+	}
+}
+
+// Serve handles the requests forwarded to the host by other hydras with the local provider store.
+// The first host served represents this hydra, sends the heartbeats and hands off the records of its local provider
+// store until the context is done.
+func (s *Shards) Serve(ctx context.Context, h host.Host, local providers.ProviderStore) {
+	h.SetStreamHandler(Protocol, func(str network.Stream) { s.handle(ctx, str, local) })
+
+	s.mut.Lock()
+	first := s.host == nil
+	if first {
+		s.ctx = ctx
+		s.host = h
+		s.local = local
+		delete(s.members, h.ID())
+		s.update()
+	}
+	s.mut.Unlock()
+	if first {
+		go s.run(ctx)
+		go s.handOffLoop(ctx)
+	}
+}
+
+// Self returns the peer ID representing this hydra, or an empty ID if no host is served yet.
+func (s *Shards) Self() peer.ID {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.self()
+}
+
+func (s *Shards) self() peer.ID {
+	if s.host == nil {
+		return ""
+	}
+	return s.host.ID()
+}
+
+// Owner returns the member owning the key, and true if it is owned by this hydra.
+// All the keys are owned by this hydra until a host is served.
+func (s *Shards) Owner(key []byte) (peer.ID, bool) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	owner := rendezvous(s.owners, key)
+	return owner, owner == "" || owner == s.self()
+}
+
+// Status describes the members of the keyspace and their share of it, sorted by peer ID.
+func (s *Shards) Status() []MemberStatus {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	status := []MemberStatus{}
+	if self := s.self(); self != "" {
+		status = append(status, MemberStatus{
+			ID:       self,
+			Addrs:    addrStrings(s.host.Addrs()),
+			State:    StateAlive,
+			Self:     true,
+			Share:    s.shares[self],
+			LastSeen: s.clock.Now(),
+		})
+	}
+	for id, m := range s.members {
+		state := StateJoining
+		if m.alive {
+			state = StateAlive
+		}
+		status = append(status, MemberStatus{
+			ID:       id,
+			Addrs:    addrStrings(m.addrs),
+			State:    state,
+			Share:    s.shares[id],
+			Failures: m.failures,
+			LastSeen: m.lastSeen,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].ID < status[j].ID })
+	return status
+}
+
+func (s *Shards) run(ctx context.Context) {
+	ticker := s.clock.Ticker(s.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		s.discover(ctx)
+		s.heartbeat(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.leave()
+			return
+		}
+	}
+}
+
+// discover adds the members known by the seeds.
+func (s *Shards) discover(ctx context.Context) {
+	for _, seed := range s.seeds {
+		members, err := s.fetchMembers(ctx, seed)
+		if err != nil {
+			log.Warnf("failed to discover the members of the keyspace from %s: %s", seed, err)
+			continue
+		}
+		s.mut.Lock()
+		for _, m := range members {
+			s.discovered(m)
+		}
+		s.mut.Unlock()
+	}
+}
+
+func (s *Shards) fetchMembers(ctx context.Context, seed string) ([]peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, seed+"/shards", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var status []MemberStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	var members []peer.AddrInfo
+	for _, st := range status {
+		ai := peer.AddrInfo{ID: st.ID}
+		for _, a := range st.Addrs {
+			ma, err := multiaddr.NewMultiaddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address of %s: %w", st.ID, err)
+			}
+			ai.Addrs = append(ai.Addrs, ma)
+		}
+		members = append(members, ai)
+	}
+	return members, nil
+}
+
+// heartbeat pings all the known members, and adds the members they know of.
+func (s *Shards) heartbeat(ctx context.Context) {
+	s.mut.RLock()
+	self := peer.AddrInfo{ID: s.self(), Addrs: s.host.Addrs()}
+	targets := make([]peer.AddrInfo, 0, len(s.members))
+	for id, m := range s.members {
+		targets = append(targets, peer.AddrInfo{ID: id, Addrs: m.addrs})
+	}
+	s.mut.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target peer.AddrInfo) {
+			defer wg.Done()
+			resp, err := request(ctx, s.host, target, s.Timeout, message{Type: typePing, From: &self})
+			if err != nil {
+				log.Debugf("heartbeat to %s failed: %s", target.ID, err)
+				s.failed(target.ID)
+				recordHeartbeat(ctx, "failed")
+				return
+			}
+			recordHeartbeat(ctx, "succeeded")
+			s.mut.Lock()
+			s.reached(target)
+			for _, m := range resp.Members {
+				s.discovered(m)
+			}
+			s.mut.Unlock()
+		}(target)
+	}
+	wg.Wait()
+}
+
+// leave tells the alive members that this hydra leaves the keyspace.
+func (s *Shards) leave() {
+	s.mut.RLock()
+	self := peer.AddrInfo{ID: s.self()}
+	var targets []peer.AddrInfo
+	for id, m := range s.members {
+		if m.alive {
+			targets = append(targets, peer.AddrInfo{ID: id, Addrs: m.addrs})
+		}
+	}
+	s.mut.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target peer.AddrInfo) {
+			defer wg.Done()
+			if _, err := request(context.Background(), s.host, target, s.Timeout, message{Type: typeLeave, From: &self}); err != nil {
+				log.Debugf("failed to leave %s: %s", target.ID, err)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// discovered adds a member that was not reached yet. The lock must be held.
+func (s *Shards) discovered(ai peer.AddrInfo) {
+	if ai.ID == "" || ai.ID == s.self() {
+		return
+	}
+	if m, ok := s.members[ai.ID]; ok {
+		if !m.alive && len(ai.Addrs) > 0 {
+			m.addrs = ai.Addrs
+		}
+		return
+	}
+	s.members[ai.ID] = &member{addrs: ai.Addrs}
+}
+
+// reached marks a member as alive. The lock must be held.
+func (s *Shards) reached(ai peer.AddrInfo) {
+	if ai.ID == "" || ai.ID == s.self() {
+		return
+	}
+	m, ok := s.members[ai.ID]
+	if !ok {
+		m = &member{}
+		s.members[ai.ID] = m
+	}
+	if len(ai.Addrs) > 0 {
+		m.addrs = ai.Addrs
+	}
+	m.failures = 0
+	m.lastSeen = s.clock.Now()
+	if !m.alive {
+		m.alive = true
+		log.Infof("%s joined the keyspace", ai.ID)
+		s.update()
+	}
+}
+
+// failed counts a failure to reach a member, and removes it once it failed too many times in a row.
+func (s *Shards) failed(id peer.ID) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	m, ok := s.members[id]
+	if !ok {
+		return
+	}
+	m.failures++
+	if m.failures >= s.MaxFailures {
+		s.remove(id)
+	}
+}
+
+// remove removes a member. The lock must be held.
+func (s *Shards) remove(id peer.ID) {
+	m, ok := s.members[id]
+	if !ok {
+		return
+	}
+	delete(s.members, id)
+	if m.alive {
+		log.Infof("%s left the keyspace", id)
+		s.update()
+	}
+}
+
+// isMember returns true if the peer is a discovered member of the keyspace.
+func (s *Shards) isMember(id peer.ID) bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	_, ok := s.members[id]
+	return ok
+}
+
+// addrs returns the known addresses of a member.
+func (s *Shards) addrs(id peer.ID) []multiaddr.Multiaddr {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if m, ok := s.members[id]; ok {
+		return m.addrs
+	}
+	return nil
+}
+
+// update recomputes the owners of the keyspace and their shares after the alive members changed. The lock must be held.
+func (s *Shards) update() {
+	owners := []peer.ID{s.self()}
+	for id, m := range s.members {
+		if m.alive {
+			owners = append(owners, id)
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i] < owners[j] })
+	s.owners = owners
+	// keys only move to other members if there are any
+	if s.host != nil && len(owners) > 1 {
+		select {
+		case s.handoffs <- struct{}{}:
+		default:
+		}
+	}
+
+	counts := map[peer.ID]int{}
+	var sample [8]byte
+	for i := 0; i < shareSamples; i++ {
+		binary.BigEndian.PutUint64(sample[:], uint64(i))
+		key := sha256.Sum256(sample[:])
+		counts[rendezvous(owners, key[:])]++
+	}
+	previous := s.shares
+	s.shares = map[peer.ID]float64{}
+	for _, id := range owners {
+		s.shares[id] = float64(counts[id]) / shareSamples
+	}
+
+	if s.ctx == nil {
+		return
+	}
+	for id := range previous {
+		if _, ok := s.shares[id]; !ok {
+			recordShare(s.ctx, id, 0)
+		}
+	}
+	for id, share := range s.shares {
+		recordShare(s.ctx, id, share)
+	}
+	stats.Record(s.ctx, metrics.ShardMembers.M(int64(len(owners))))
+}
+
+func (s *Shards) handOffLoop(ctx context.Context) {
+	for {
+		select {
+		case <-s.handoffs:
+			s.handOff(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handOff forwards the records of the local provider store whose keys are owned by other members to them, e.g. after
+// a member joined, or when the owner of records stored locally while it was unreachable is back. Records keep their
+// origin and expiry, and are left in the local provider store until they expire.
+func (s *Shards) handOff(ctx context.Context) {
+	s.mut.RLock()
+	local := s.local
+	s.mut.RUnlock()
+	it, ok := hproviders.FindProviderRecordIterator(local)
+	if !ok {
+		log.Debugf("the local provider store cannot enumerate its provider records, not handing them off")
+		return
+	}
+
+	f := s.Forwarder()
+	now := s.clock.Now()
+	handedOff, failed := 0, 0
+	err := it.IterateProviderRecords(ctx, func(rec hproviders.ProviderRecord) error {
+		owner, isLocal := s.Owner(rec.Key)
+		if isLocal {
+			return nil
+		}
+		var ttl time.Duration
+		if !rec.Expires.IsZero() {
+			ttl = rec.Expires.Sub(now)
+			if ttl <= 0 {
+				return nil
+			}
+		}
+		err := f.AddProvider(hproviders.WithRecordOrigin(ctx, rec.Origin, ttl), owner, rec.Key, rec.Provider)
+		recordHandOff(ctx, err)
+		if err != nil {
+			log.Debugf("failed to hand off provider record to %s: %s", owner, err)
+			failed++
+		} else {
+			handedOff++
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		log.Warnf("failed to enumerate the provider records to hand off: %s", err)
+	}
+	if handedOff > 0 || failed > 0 {
+		log.Infof("handed off %d provider records to their owners, %d failed", handedOff, failed)
+	}
+}
+
+// rendezvous returns the member with the highest hash of its peer ID and the key, or an empty ID if there are no members.
+func rendezvous(members []peer.ID, key []byte) peer.ID {
+	var owner peer.ID
+	var highest uint64
+	for _, id := range members {
+		h := sha256.New()
+		h.Write([]byte(id))
+		h.Write(key)
+		score := binary.BigEndian.Uint64(h.Sum(nil)[:8])
+		if owner == "" || score > highest {
+			owner, highest = id, score
+		}
+	}
+	return owner
+}
+
+func addrStrings(addrs []multiaddr.Multiaddr) []string {
+	strs := make([]string, len(addrs))
+	for i, a := range addrs {
+		strs[i] = a.String()
+	}
+	return strs
+}
+
+func recordShare(ctx context.Context, id peer.ID, share float64) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyPeerID, id.String())}, metrics.ShardKeyspaceShare.M(share))
+}
+
+func recordHandOff(ctx context.Context, err error) {
+	status := "succeeded"
+	if err != nil {
+		status = "failed"
+	}
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyOperation, "HandOff"), tag.Upsert(metrics.KeyStatus, status)},
+		metrics.ShardForwards.M(1),
+	)
+}
+
+func recordHeartbeat(ctx context.Context, status string) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.ShardHeartbeats.M(1))
+}
@@ -0,0 +1,312 @@
+package shard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	hydratesting "github.com/libp2p/hydra-booster/testing"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordsOf returns the records of the key in the provider store.
+func recordsOf(ps *hydratesting.ProviderStore, key []byte) []hproviders.ProviderRecord {
+	var recs []hproviders.ProviderRecord
+	for _, rec := range ps.Records() {
+		if string(rec.Key) == string(key) {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+func TestRendezvous(t *testing.T) {
+	members := []peer.ID{"a", "b", "c"}
+	counts := map[peer.ID]int{}
+	for i := 0; i < 3000; i++ {
+		key := hydratesting.TestKey(i)
+		owner := rendezvous(members, key)
+		counts[owner]++
+		// only the keys of a removed member move
+		if owner != "c" {
+			assert.Equal(t, owner, rendezvous([]peer.ID{"a", "b"}, key))
+		}
+	}
+	for _, id := range members {
+		assert.InDelta(t, 1000, counts[id], 150)
+	}
+	assert.Equal(t, peer.ID(""), rendezvous(nil, hydratesting.TestKey(0)))
+}
+
+// testHydra is a member of a keyspace, with the HTTP API used to discover its members.
+type testHydra struct {
+	host   host.Host
+	local  *hydratesting.ProviderStore
+	api    *httptest.Server
+	cancel context.CancelFunc
+
+	mut    sync.Mutex
+	shards *Shards
+}
+
+// newTestHydra creates the HTTP API of a hydra, which doesn't list any member until the hydra is served, so that
+// hydras can list each other.
+func newTestHydra(t *testing.T, h host.Host) *testHydra {
+	hy := &testHydra{host: h, local: &hydratesting.ProviderStore{}}
+	hy.api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := []MemberStatus{}
+		if s := hy.getShards(); s != nil {
+			status = s.Status()
+		}
+		json.NewEncoder(w).Encode(status)
+	}))
+	t.Cleanup(hy.api.Close)
+	return hy
+}
+
+// serve joins the keyspace, discovering its members from the seeds.
+func (hy *testHydra) serve(t *testing.T, seeds ...*testHydra) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var seedURLs []string
+	for _, seed := range seeds {
+		seedURLs = append(seedURLs, seed.api.URL)
+	}
+	s := New(http.DefaultClient, seedURLs)
+	s.HeartbeatInterval = time.Hour
+	s.Timeout = time.Second
+	hy.mut.Lock()
+	hy.shards = s
+	hy.cancel = cancel
+	hy.mut.Unlock()
+	s.Serve(ctx, hy.host, hy.local)
+}
+
+func (hy *testHydra) getShards() *Shards {
+	hy.mut.Lock()
+	defer hy.mut.Unlock()
+	return hy.shards
+}
+
+// round runs a round of discovery and heartbeats of the hydras, which run a single round on their own.
+func round(hys ...*testHydra) {
+	for _, hy := range hys {
+		hy.shards.discover(context.Background())
+		hy.shards.heartbeat(context.Background())
+	}
+}
+
+func aliveMembers(s *Shards) int {
+	n := 0
+	for _, m := range s.Status() {
+		if m.State == StateAlive {
+			n++
+		}
+	}
+	return n
+}
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(3)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	hy1, hy2, hy3 := newTestHydra(t, hosts[0]), newTestHydra(t, hosts[1]), newTestHydra(t, hosts[2])
+	// hy1 and hy2 discover each other from their HTTP APIs
+	hy1.serve(t, hy2)
+	hy2.serve(t, hy1, hy3)
+	assert.Eventually(t, func() bool {
+		round(hy1, hy2)
+		return aliveMembers(hy1.shards) == 2 && aliveMembers(hy2.shards) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	// hy3 and hy2 discover each other, and hy1 discovers hy3 from the heartbeats of hy2
+	hy3.serve(t, hy2)
+	assert.Eventually(t, func() bool {
+		round(hy1, hy2, hy3)
+		return aliveMembers(hy1.shards) == 3 && aliveMembers(hy2.shards) == 3 && aliveMembers(hy3.shards) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	total := 0.0
+	for _, m := range hy1.shards.Status() {
+		assert.InDelta(t, 1.0/3, m.Share, 0.05)
+		total += m.Share
+	}
+	assert.InDelta(t, 1, total, 0.0001)
+
+	// all the members agree on the owners of keys
+	var key []byte
+	for i := 0; key == nil; i++ {
+		if owner, _ := hy1.shards.Owner(hydratesting.TestKey(i)); owner == hy2.host.ID() {
+			key = hydratesting.TestKey(i)
+		}
+	}
+	for _, hy := range []*testHydra{hy1, hy2, hy3} {
+		owner, local := hy.shards.Owner(key)
+		assert.Equal(t, hy2.host.ID(), owner)
+		assert.Equal(t, hy == hy2, local)
+	}
+
+	// requests for the key are forwarded to its owner
+	prov := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	ps1 := hproviders.NewShardedProviderStore(hy1.local, hy1.shards.Forwarder())
+	ps3 := hproviders.NewShardedProviderStore(hy3.local, hy3.shards.Forwarder())
+	assert.NoError(t, ps1.AddProvider(ctx, key, prov))
+	assert.Zero(t, hy1.local.Len())
+	provs, err := hy2.local.GetProviders(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{prov}, provs)
+	provs, err = ps3.GetProviders(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []peer.AddrInfo{prov}, provs)
+
+	// hy2 leaves, and its keys are owned by the others
+	hy2.cancel()
+	for _, hy := range []*testHydra{hy1, hy3} {
+		assert.Eventually(t, func() bool { return aliveMembers(hy.shards) == 2 }, 5*time.Second, 10*time.Millisecond)
+		owner, _ := hy.shards.Owner(key)
+		assert.NotEqual(t, hy2.host.ID(), owner)
+	}
+
+	// hy3 becomes unreachable, and is removed once it fails too many heartbeats
+	require.NoError(t, mn.UnlinkPeers(hy1.host.ID(), hy3.host.ID()))
+	require.NoError(t, mn.DisconnectPeers(hy1.host.ID(), hy3.host.ID()))
+	for i := 0; i < DefaultMaxFailures; i++ {
+		assert.Equal(t, 2, aliveMembers(hy1.shards))
+		hy1.shards.heartbeat(ctx)
+	}
+	assert.Equal(t, 1, aliveMembers(hy1.shards))
+	owner, local := hy1.shards.Owner(key)
+	assert.Equal(t, hy1.host.ID(), owner)
+	assert.True(t, local)
+}
+
+func TestShardsForwardsRecordOrigin(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	hy1, hy2 := newTestHydra(t, hosts[0]), newTestHydra(t, hosts[1])
+	hy1.serve(t, hy2)
+	hy2.serve(t, hy1)
+	assert.Eventually(t, func() bool {
+		round(hy1, hy2)
+		return aliveMembers(hy1.shards) == 2 && aliveMembers(hy2.shards) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var key []byte
+	for i := 0; key == nil; i++ {
+		if _, local := hy1.shards.Owner(hydratesting.TestKey(i)); !local {
+			key = hydratesting.TestKey(i)
+		}
+	}
+
+	// a prefetched record stays a prefetched record with its TTL on the owner, and announced records keep the default TTL
+	ps1 := hproviders.NewShardedProviderStore(hy1.local, hy1.shards.Forwarder())
+	addrs := []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	prefetched := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: addrs}
+	announced := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: addrs}
+	require.NoError(t, ps1.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Hour), key, prefetched))
+	require.NoError(t, ps1.AddProvider(ctx, key, announced))
+	assert.Zero(t, hy1.local.Len())
+	if recs := recordsOf(hy2.local, key); assert.Len(t, recs, 2) {
+		assert.Equal(t, prefetched, recs[0].Provider)
+		assert.Equal(t, hproviders.OriginPrefetched, recs[0].Origin)
+		assert.InDelta(t, time.Hour, time.Until(recs[0].Expires), float64(time.Minute))
+		assert.Equal(t, announced, recs[1].Provider)
+		assert.Equal(t, hproviders.OriginAnnounced, recs[1].Origin)
+		assert.InDelta(t, providers.ProvideValidity, time.Until(recs[1].Expires), float64(time.Minute))
+	}
+}
+
+func TestShardsHandOffOnJoin(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	// hy1 owns all the keys until hy2 joins
+	hy1, hy2 := newTestHydra(t, hosts[0]), newTestHydra(t, hosts[1])
+	hy1.serve(t, hy2)
+	ps1 := hproviders.NewShardedProviderStore(hy1.local, hy1.shards.Forwarder())
+	prov := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, ps1.AddProvider(hproviders.WithRecordOrigin(ctx, hproviders.OriginPrefetched, time.Hour), hydratesting.TestKey(i), prov))
+	}
+	assert.Equal(t, 20, hy1.local.Len())
+
+	hy2.serve(t, hy1)
+	assert.Eventually(t, func() bool {
+		round(hy1, hy2)
+		return aliveMembers(hy1.shards) == 2 && aliveMembers(hy2.shards) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the records of the keys now owned by hy2 are handed off to it, and keep their origin
+	ps2 := hproviders.NewShardedProviderStore(hy2.local, hy2.shards.Forwarder())
+	moved := 0
+	for i := 0; i < 20; i++ {
+		key := hydratesting.TestKey(i)
+		if _, local := hy2.shards.Owner(key); !local {
+			continue
+		}
+		moved++
+		assert.Eventually(t, func() bool {
+			provs, err := ps2.GetProviders(ctx, key)
+			return err == nil && len(provs) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		if recs := recordsOf(hy2.local, key); assert.Len(t, recs, 1) {
+			assert.Equal(t, hproviders.OriginPrefetched, recs[0].Origin)
+			assert.InDelta(t, time.Hour, time.Until(recs[0].Expires), float64(time.Minute))
+		}
+		// hy1 still finds them
+		provs, err := ps1.GetProviders(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []peer.AddrInfo{prov}, provs)
+	}
+	assert.Greater(t, moved, 0)
+}
+
+func TestShardsRejectsNonMembers(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	hy := newTestHydra(t, hosts[0])
+	hy.serve(t)
+	key := hydratesting.TestKey(0)
+	prov := peer.AddrInfo{ID: test.RandPeerIDFatal(t), Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")}}
+	require.NoError(t, hy.local.AddProvider(ctx, key, prov))
+
+	// an unknown peer can neither join the keyspace, nor add or get provider records
+	to := peer.AddrInfo{ID: hosts[0].ID(), Addrs: hosts[0].Addrs()}
+	from := peer.AddrInfo{ID: hosts[1].ID(), Addrs: hosts[1].Addrs()}
+	for _, req := range []message{
+		{Type: typePing, From: &from},
+		{Type: typeAddProvider, Key: hydratesting.TestKey(1), Provider: &prov},
+		{Type: typeGetProviders, Key: key},
+	} {
+		_, err := request(ctx, hosts[1], to, time.Second, req)
+		assert.EqualError(t, err, "not a member", req.Type)
+	}
+	assert.Equal(t, 1, aliveMembers(hy.shards))
+	assert.Equal(t, 1, hy.local.Len())
+}
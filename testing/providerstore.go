@@ -0,0 +1,87 @@
+package testing
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+)
+
+// ProviderStore is an in-memory provider store whose records can be enumerated. Like the default provider store, it keeps
+// a record per provider of a key with its origin and expiry: announced records always replace the record of their
+// provider, while other records only replace records that are expired, or were not announced and expire before them.
+type ProviderStore struct {
+	mut     sync.Mutex
+	records []hproviders.ProviderRecord
+}
+
+func (s *ProviderStore) AddProvider(ctx context.Context, key []byte, prov peer.AddrInfo) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	origin, ttl := hproviders.RecordOriginFromContext(ctx)
+	if origin == hproviders.OriginAnnounced || ttl <= 0 || ttl > providers.ProvideValidity {
+		ttl = providers.ProvideValidity
+	}
+	now := time.Now()
+	rec := hproviders.ProviderRecord{Key: key, Provider: prov, Expires: now.Add(ttl), Origin: origin}
+	for i, r := range s.records {
+		if string(r.Key) != string(key) || r.Provider.ID != prov.ID {
+			continue
+		}
+		live := now.Before(r.Expires)
+		if origin == hproviders.OriginAnnounced || !live || (r.Origin != hproviders.OriginAnnounced && r.Expires.Before(rec.Expires)) {
+			s.records[i] = rec
+		}
+		return nil
+	}
+	s.records = append(s.records, rec)
+	return nil
+}
+
+// GetProviders returns the providers of the live records of the key, in the order they were first added.
+func (s *ProviderStore) GetProviders(ctx context.Context, key []byte) ([]peer.AddrInfo, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	now := time.Now()
+	var provs []peer.AddrInfo
+	for _, r := range s.records {
+		if string(r.Key) == string(key) && now.Before(r.Expires) {
+			provs = append(provs, r.Provider)
+		}
+	}
+	return provs, nil
+}
+
+func (s *ProviderStore) IterateProviderRecords(ctx context.Context, fn func(hproviders.ProviderRecord) error) error {
+	for _, r := range s.Records() {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records returns all the records, in the order they were first added.
+func (s *ProviderStore) Records() []hproviders.ProviderRecord {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]hproviders.ProviderRecord{}, s.records...)
+}
+
+// Len returns the number of records.
+func (s *ProviderStore) Len() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return len(s.records)
+}
+
+// TestKey returns the i-th of a series of distinct, uniformly distributed keys.
+func TestKey(i int) []byte {
+	k := sha256.Sum256([]byte(fmt.Sprint(i)))
+	return k[:]
+}
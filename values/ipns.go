@@ -0,0 +1,125 @@
+package values
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	record "github.com/libp2p/go-libp2p-record"
+	record_pb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+)
+
+// Signature statuses of IPNS records.
+const (
+	// SignatureValid records are signed by the key of their name, and not expired.
+	SignatureValid = "valid"
+	// SignatureExpired records are signed by the key of their name, but their validity ended.
+	SignatureExpired = "expired"
+	// SignatureInvalid records are not signed by the key of their name, or malformed.
+	SignatureInvalid = "invalid"
+	// SignatureUnverifiable records cannot be verified, because the public key of their name is unknown.
+	SignatureUnverifiable = "unverifiable"
+)
+
+// IPNSRecord is a decoded IPNS record.
+type IPNSRecord struct {
+	// Name is the IPNS name of the record, as a CIDv1 in base36.
+	Name     string
+	Value    string
+	Sequence uint64
+	// Validity is when the record expires.
+	Validity time.Time
+	// TTL is how long the record may be cached for, e.g. "1h0m0s".
+	TTL string
+	// Received is when the record was last put to the Hydra.
+	Received  time.Time
+	Signature string
+	// Error explains why the signature is invalid or unverifiable.
+	Error string `json:",omitempty"`
+}
+
+// DecodeIPNSRecord decodes a DHT record holding an IPNS record, as stored by the DHT.
+func DecodeIPNSRecord(data []byte) (IPNSRecord, error) {
+	var rec record_pb.Record
+	if err := rec.Unmarshal(data); err != nil {
+		return IPNSRecord{}, fmt.Errorf("decoding DHT record: %w", err)
+	}
+	ns, name, err := record.SplitKey(string(rec.Key))
+	if err != nil || ns != NamespaceIPNS {
+		return IPNSRecord{}, fmt.Errorf("not an IPNS record: %q", rec.Key)
+	}
+	pid, err := peer.IDFromBytes([]byte(name))
+	if err != nil {
+		return IPNSRecord{}, fmt.Errorf("decoding IPNS name: %w", err)
+	}
+	var entry ipns_pb.IpnsEntry
+	if err := entry.Unmarshal(rec.Value); err != nil {
+		return IPNSRecord{}, fmt.Errorf("decoding IPNS record: %w", err)
+	}
+
+	r := IPNSRecord{
+		Value:    string(entry.GetValue()),
+		Sequence: entry.GetSequence(),
+		TTL:      time.Duration(entry.GetTtl()).String(),
+	}
+	r.Name, err = peer.ToCid(pid).StringOfBase(multibase.Base36)
+	if err != nil {
+		return IPNSRecord{}, err
+	}
+	if received, err := time.Parse(time.RFC3339Nano, rec.TimeReceived); err == nil {
+		r.Received = received
+	}
+	if eol, err := ipns.GetEOL(&entry); err == nil {
+		r.Validity = eol
+	}
+	r.Signature, err = verifyIPNSRecord(pid, &entry)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r, nil
+}
+
+// verifyIPNSRecord returns the signature status of the record, and the error if it is not valid.
+func verifyIPNSRecord(pid peer.ID, entry *ipns_pb.IpnsEntry) (string, error) {
+	pk, err := ipns.ExtractPublicKey(pid, entry)
+	if err == peer.ErrNoPublicKey {
+		return SignatureUnverifiable, err
+	}
+	if err != nil {
+		return SignatureInvalid, err
+	}
+	err = ipns.Validate(pk, entry)
+	if errors.Is(err, ipns.ErrExpiredRecord) {
+		return SignatureExpired, err
+	}
+	if err != nil {
+		return SignatureInvalid, err
+	}
+	return SignatureValid, nil
+}
+
+// IterateIPNSRecords calls fn with each IPNS record, stopping at the first error. Records that cannot be decoded are
+// skipped, and records written before they were namespaced are not listed.
+func (s *Store) IterateIPNSRecords(ctx context.Context, fn func(IPNSRecord) error) error {
+	return s.iterate(ctx, NamespaceIPNS, func(value []byte) error {
+		r, err := DecodeIPNSRecord(value)
+		if err != nil {
+			return nil
+		}
+		return fn(r)
+	})
+}
+
+// GetIPNSRecord returns the IPNS record of a name.
+func (s *Store) GetIPNSRecord(ctx context.Context, name peer.ID) (IPNSRecord, error) {
+	value, err := s.Get(ctx, recordKey(ipns.RecordKey(name)))
+	if err != nil {
+		return IPNSRecord{}, err
+	}
+	return DecodeIPNSRecord(value)
+}
@@ -0,0 +1,271 @@
+package values
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	logging "github.com/ipfs/go-log"
+	record "github.com/libp2p/go-libp2p-record"
+	record_pb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/hydra-booster/metrics"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/multiformats/go-base32"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	DefaultPrefetchTimeout          = 30 * time.Second
+	DefaultPrefetchQueueSize        = 1000
+	DefaultPrefetchWorkers          = 16
+	DefaultPrefetchNegativeCacheTTL = 5 * time.Minute
+)
+
+// ValueRouter finds value records on the network, e.g. the DHT of a head.
+type ValueRouter interface {
+	GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error)
+}
+
+// prefetchingKey marks the contexts of prefetches, whose lookups of the local value store are not prefetched again.
+type prefetchingKey struct{}
+
+type prefetchRequest struct {
+	router ValueRouter
+	store  ds.Datastore
+	key    string
+}
+
+// Prefetcher fetches the IPNS records missing from the value store from the network in the background, and stores the
+// valid ones. Keys whose prefetch found no record are remembered in a negative cache, and are not prefetched again
+// until their entry expires. A single Prefetcher is meant to be shared by all the heads of a Hydra.
+type Prefetcher struct {
+	timeout       time.Duration
+	negativeCache *hproviders.NegativeCache
+	queue         chan prefetchRequest
+	log           logging.EventLogger
+
+	mut     sync.Mutex
+	pending map[string]struct{}
+}
+
+// NewPrefetcher creates a Prefetcher running the given number of workers until the context is done.
+func NewPrefetcher(ctx context.Context, timeout time.Duration, queueSize int, workers int, negativeCache *hproviders.NegativeCache) *Prefetcher {
+	p := &Prefetcher{
+		timeout:       timeout,
+		negativeCache: negativeCache,
+		queue:         make(chan prefetchRequest, queueSize),
+		log:           logging.Logger("hydra/values"),
+		pending:       map[string]struct{}{},
+	}
+	for i := 0; i < workers; i++ {
+		go p.worker(ctx)
+	}
+	return p
+}
+
+// Prefetch queues the prefetch of the record of the key with the router, to be stored in the store. Keys that are
+// already pending or negatively cached are skipped, and the prefetch is discarded if the queue is full.
+func (p *Prefetcher) Prefetch(ctx context.Context, router ValueRouter, store ds.Datastore, key string) {
+	if p.negativeCache != nil && p.negativeCache.Has([]byte(key)) {
+		recordPrefetch(ctx, "negative-cached")
+		return
+	}
+
+	p.mut.Lock()
+	if _, ok := p.pending[key]; ok {
+		p.mut.Unlock()
+		return
+	}
+	select {
+	case p.queue <- prefetchRequest{router: router, store: store, key: key}:
+		p.pending[key] = struct{}{}
+	default:
+		p.mut.Unlock()
+		recordPrefetch(ctx, "discarded")
+		return
+	}
+	pending := len(p.pending)
+	p.mut.Unlock()
+	stats.Record(ctx, metrics.IPNSPrefetchesPending.M(int64(pending)))
+}
+
+// Invalidate forgets that the key has no record, once a record is put for it.
+func (p *Prefetcher) Invalidate(key string) {
+	if p.negativeCache != nil {
+		p.negativeCache.Remove([]byte(key))
+	}
+}
+
+func (p *Prefetcher) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-p.queue:
+			p.fetch(ctx, req)
+
+			p.mut.Lock()
+			delete(p.pending, req.key)
+			pending := len(p.pending)
+			p.mut.Unlock()
+			stats.Record(ctx, metrics.IPNSPrefetchesPending.M(int64(pending)))
+		}
+	}
+}
+
+// fetch looks up the record of the key, and stores it if it is a valid IPNS record.
+func (p *Prefetcher) fetch(ctx context.Context, req prefetchRequest) {
+	start := time.Now()
+	status := p.doFetch(ctx, req)
+	recordPrefetch(ctx, status)
+	stats.RecordWithTags(ctx,
+		[]tag.Mutator{tag.Upsert(metrics.KeyStatus, status)},
+		metrics.IPNSPrefetchDuration.M(float64(time.Since(start))/float64(time.Millisecond)),
+	)
+}
+
+func (p *Prefetcher) doFetch(ctx context.Context, req prefetchRequest) string {
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, prefetchingKey{}, true), p.timeout)
+	defer cancel()
+
+	// the record may have been put since the miss, e.g. by the PUT_VALUE request whose lookup of the existing record missed
+	if ok, err := req.store.Has(ctx, recordKey(req.key)); err == nil && ok {
+		return "present"
+	}
+
+	value, err := req.router.GetValue(ctx, req.key)
+	if errors.Is(err, routing.ErrNotFound) {
+		if p.negativeCache != nil {
+			p.negativeCache.Add([]byte(req.key))
+		}
+		return "not-found"
+	}
+	if err != nil {
+		p.log.Debugf("failed to prefetch value record: %s", err)
+		return "failed"
+	}
+
+	validity, status := validateIPNSValue(req.key, value)
+	if status != SignatureValid {
+		return status
+	}
+	// a record may have been put while the record was looked up, which is kept unless the fetched one is better
+	if data, err := req.store.Get(ctx, recordKey(req.key)); err == nil {
+		var existing record_pb.Record
+		if err := existing.Unmarshal(data); err == nil {
+			if i, err := (ipns.Validator{}).Select(req.key, [][]byte{existing.Value, value}); err == nil && i == 0 {
+				return "outdated"
+			}
+		}
+	} else if err != ds.ErrNotFound {
+		p.log.Errorf("failed to get value record: %s", err)
+		return "failed"
+	}
+
+	rec := record_pb.Record{
+		Key:          []byte(req.key),
+		Value:        value,
+		TimeReceived: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	data, err := rec.Marshal()
+	if err != nil {
+		return "failed"
+	}
+	if err := req.store.Put(ctx, recordKey(req.key), data); err != nil {
+		p.log.Errorf("failed to store prefetched value record: %s", err)
+		return "failed"
+	}
+	stats.Record(ctx, metrics.IPNSPrefetchValidity.M(int64(validity/time.Second)))
+	return "succeeded"
+}
+
+// validateIPNSValue returns the remaining validity of the IPNS record of the key, and its signature status.
+func validateIPNSValue(key string, value []byte) (time.Duration, string) {
+	_, name, err := record.SplitKey(key)
+	if err != nil {
+		return 0, SignatureInvalid
+	}
+	pid, err := peer.IDFromBytes([]byte(name))
+	if err != nil {
+		return 0, SignatureInvalid
+	}
+	var entry ipns_pb.IpnsEntry
+	if err := entry.Unmarshal(value); err != nil {
+		return 0, SignatureInvalid
+	}
+	status, _ := verifyIPNSRecord(pid, &entry)
+	if status == SignatureUnverifiable {
+		// the record was validated by the router, but it can't be checked without the public key of its name
+		return 0, SignatureInvalid
+	}
+	if status != SignatureValid {
+		return 0, status
+	}
+	eol, err := ipns.GetEOL(&entry)
+	if err != nil {
+		return 0, SignatureInvalid
+	}
+	return time.Until(eol), SignatureValid
+}
+
+func recordPrefetch(ctx context.Context, status string) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyStatus, status)}, metrics.IPNSPrefetches.M(1))
+}
+
+// PrefetchingStore prefetches the IPNS records missing from the datastore it wraps with its Router, so that they are
+// returned by later GET_VALUE requests. The Router is usually set once the DHT using the store is created.
+type PrefetchingStore struct {
+	ds.Batching
+	Prefetcher *Prefetcher
+	Router     ValueRouter
+}
+
+func NewPrefetchingStore(delegate ds.Batching, prefetcher *Prefetcher) *PrefetchingStore {
+	return &PrefetchingStore{Batching: delegate, Prefetcher: prefetcher}
+}
+
+// Get gets the record of the key from the delegate. If it is missing and the key is an IPNS key, the record is
+// prefetched in the background.
+func (s *PrefetchingStore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	value, err := s.Batching.Get(ctx, key)
+	if err == ds.ErrNotFound && s.Router != nil && ctx.Value(prefetchingKey{}) == nil {
+		if k, ok := ipnsKey(key); ok {
+			s.Prefetcher.Prefetch(ctx, s.Router, s.Batching, k)
+		}
+	}
+	return value, err
+}
+
+// Put puts the record to the delegate. The key now has a record, so the Prefetcher forgets that it has none.
+func (s *PrefetchingStore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if err := s.Batching.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if k, ok := ipnsKey(key); ok {
+		s.Prefetcher.Invalidate(k)
+	}
+	return nil
+}
+
+func (s *PrefetchingStore) Unwrap() ds.Batching {
+	return s.Batching
+}
+
+// ipnsKey returns the "/ipns/<name>" key of the record with the given datastore key, if it is an IPNS record.
+func ipnsKey(key ds.Key) (string, bool) {
+	if keyNamespace(key) != NamespaceIPNS {
+		return "", false
+	}
+	b, err := base32.RawStdEncoding.DecodeString(key.BaseNamespace())
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
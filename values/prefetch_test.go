@@ -0,0 +1,194 @@
+package values
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipns"
+	record_pb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	hproviders "github.com/libp2p/hydra-booster/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockValueRouter struct {
+	mut     sync.Mutex
+	values  map[string][]byte
+	lookups map[string]int
+	// wait, if set, blocks lookups until it is closed
+	wait chan struct{}
+}
+
+func (r *mockValueRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	r.mut.Lock()
+	r.lookups[key]++
+	r.mut.Unlock()
+	if r.wait != nil {
+		<-r.wait
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	value, ok := r.values[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return value, nil
+}
+
+func (r *mockValueRouter) Lookups(key string) int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.lookups[key]
+}
+
+// newIPNSValue returns the key and value of an IPNS record, as returned by the DHT.
+func newIPNSValue(t *testing.T, eol time.Time) (string, []byte) {
+	pid, data := newIPNSRecord(t, crypto.Ed25519, eol)
+	var rec record_pb.Record
+	require.NoError(t, rec.Unmarshal(data))
+	return ipns.RecordKey(pid), rec.Value
+}
+
+func (p *Prefetcher) numPending() int {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return len(p.pending)
+}
+
+func TestPrefetchingStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	valid, validValue := newIPNSValue(t, time.Now().Add(time.Hour))
+	expired, expiredValue := newIPNSValue(t, time.Now().Add(-time.Minute))
+	missing, _ := newIPNSValue(t, time.Now().Add(time.Hour))
+	router := &mockValueRouter{
+		values:  map[string][]byte{valid: validValue, expired: expiredValue, "/pk/key": []byte("public key")},
+		lookups: map[string]int{},
+	}
+	negativeCache, err := hproviders.NewNegativeCache(10, time.Hour)
+	require.NoError(t, err)
+	store := NewStore(dssync.MutexWrap(ds.NewMapDatastore()), false)
+	s := NewPrefetchingStore(store, NewPrefetcher(ctx, time.Second, 10, 1, negativeCache))
+	s.Router = router
+
+	// misses are prefetched in the background
+	_, err = s.Get(ctx, recordKey(valid))
+	assert.Equal(t, ds.ErrNotFound, err)
+	require.Eventually(t, func() bool {
+		ok, err := store.Has(ctx, recordKey(valid))
+		return err == nil && ok
+	}, 5*time.Second, 10*time.Millisecond)
+	pid, err := peer.IDFromBytes([]byte(valid[len("/ipns/"):]))
+	require.NoError(t, err)
+	rec, err := store.GetIPNSRecord(ctx, pid)
+	require.NoError(t, err)
+	assert.Equal(t, SignatureValid, rec.Signature)
+	assert.WithinDuration(t, time.Now(), rec.Received, time.Minute)
+
+	// expired records are not stored
+	_, err = s.Get(ctx, recordKey(expired))
+	assert.Equal(t, ds.ErrNotFound, err)
+	require.Eventually(t, func() bool { return router.Lookups(expired) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// keys without records are not looked up again until a record is put
+	_, err = s.Get(ctx, recordKey(missing))
+	assert.Equal(t, ds.ErrNotFound, err)
+	require.Eventually(t, func() bool { return negativeCache.Has([]byte(missing)) }, 5*time.Second, 10*time.Millisecond)
+	_, err = s.Get(ctx, recordKey(missing))
+	assert.Equal(t, ds.ErrNotFound, err)
+	require.NoError(t, s.Put(ctx, recordKey(missing), []byte("record")))
+	assert.False(t, negativeCache.Has([]byte(missing)))
+
+	// only IPNS records are prefetched, and not by the lookups of prefetches
+	_, err = s.Get(ctx, recordKey("/pk/key"))
+	assert.Equal(t, ds.ErrNotFound, err)
+	require.NoError(t, store.Delete(ctx, recordKey(valid)))
+	_, err = s.Get(context.WithValue(ctx, prefetchingKey{}, true), recordKey(valid))
+	assert.Equal(t, ds.ErrNotFound, err)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, router.Lookups(valid))
+	assert.Equal(t, 1, router.Lookups(missing))
+	assert.Equal(t, 0, router.Lookups("/pk/key"))
+	ok, err := store.Has(ctx, recordKey(expired))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPrefetchKeepsNewerRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	key := ipns.RecordKey(pid)
+	newIPNSRecordValue := func(seq uint64) []byte {
+		entry, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), seq, time.Now().Add(time.Hour), time.Hour)
+		require.NoError(t, err)
+		value, err := entry.Marshal()
+		require.NoError(t, err)
+		return value
+	}
+	older, newer := newIPNSRecordValue(1), newIPNSRecordValue(2)
+
+	router := &mockValueRouter{
+		values:  map[string][]byte{key: older},
+		lookups: map[string]int{},
+		wait:    make(chan struct{}),
+	}
+	store := NewStore(dssync.MutexWrap(ds.NewMapDatastore()), false)
+	p := NewPrefetcher(ctx, time.Second, 10, 1, nil)
+	s := NewPrefetchingStore(store, p)
+	s.Router = router
+
+	_, err = s.Get(ctx, recordKey(key))
+	assert.Equal(t, ds.ErrNotFound, err)
+
+	// a newer record is put while the older one is looked up
+	require.Eventually(t, func() bool { return router.Lookups(key) == 1 }, 5*time.Second, 10*time.Millisecond)
+	rec := record_pb.Record{Key: []byte(key), Value: newer}
+	data, err := rec.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, recordKey(key), data))
+	close(router.wait)
+	require.Eventually(t, func() bool { return p.numPending() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	stored, err := store.Get(ctx, recordKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, data, stored)
+}
+
+func TestPrefetchSkipsPutRecord(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key, value := newIPNSValue(t, time.Now().Add(time.Hour))
+	router := &mockValueRouter{values: map[string][]byte{}, lookups: map[string]int{}}
+	store := NewStore(dssync.MutexWrap(ds.NewMapDatastore()), false)
+	// the prefetch is queued until a worker is started
+	p := NewPrefetcher(ctx, time.Second, 10, 0, nil)
+	s := NewPrefetchingStore(store, p)
+	s.Router = router
+
+	// a PUT_VALUE request misses the existing record before putting its own
+	_, err := s.Get(ctx, recordKey(key))
+	assert.Equal(t, ds.ErrNotFound, err)
+	rec := record_pb.Record{Key: []byte(key), Value: value}
+	data, err := rec.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, recordKey(key), data))
+
+	go p.worker(ctx)
+	require.Eventually(t, func() bool { return p.numPending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, router.Lookups(key))
+}
@@ -0,0 +1,182 @@
+package values
+
+import (
+	"context"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/keytransform"
+	"github.com/ipfs/go-datastore/query"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/multiformats/go-base32"
+)
+
+// Namespaces of the value records stored by the DHT.
+const (
+	NamespaceIPNS = "ipns"
+	NamespacePK   = "pk"
+	// NamespaceOther holds the records of the custom namespaces, see ParseValidators.
+	NamespaceOther = "other"
+)
+
+// Namespaces lists the namespaces of the value records.
+var Namespaces = []string{NamespaceIPNS, NamespacePK, NamespaceOther}
+
+// keyPrefix is the prefix of the keys of the value records in the backend.
+var keyPrefix = ds.NewKey("/values")
+
+// Store is the datastore of the value records of the DHT, e.g. IPNS records. Records are stored in a backend under
+// "/values/<namespace>", so that they can be counted and listed by namespace.
+//
+// The backend is either dedicated to the value records, or the datastore shared with the rest of the Hydra. In the
+// shared datastore, records written before they were namespaced are still read and deleted under their former key,
+// until they are republished.
+type Store struct {
+	*keytransform.Datastore
+
+	backend ds.Batching
+	// legacy is the datastore the records were written to before they were namespaced, nil if the backend is dedicated
+	legacy ds.Datastore
+}
+
+// NewStore creates a store of value records in a datastore. shared is true if the datastore is shared with the rest of
+// the Hydra, and may hold records written before they were namespaced.
+func NewStore(backend ds.Batching, shared bool) *Store {
+	s := &Store{
+		Datastore: keytransform.Wrap(backend, &keytransform.Pair{Convert: convertKey, Invert: invertKey}),
+		backend:   backend,
+	}
+	if shared {
+		s.legacy = backend
+	}
+	return s
+}
+
+// convertKey converts the key of a record, the base32 encoding of its "/<namespace>/<name>" key, into its key in the backend.
+func convertKey(k ds.Key) ds.Key {
+	return keyPrefix.ChildString(keyNamespace(k)).ChildString(k.BaseNamespace())
+}
+
+func invertKey(k ds.Key) ds.Key {
+	return ds.NewKey(k.BaseNamespace())
+}
+
+func keyNamespace(k ds.Key) string {
+	b, err := base32.RawStdEncoding.DecodeString(k.BaseNamespace())
+	if err != nil {
+		return NamespaceOther
+	}
+	ns, _, err := record.SplitKey(string(b))
+	if err != nil {
+		return NamespaceOther
+	}
+	switch ns {
+	case NamespaceIPNS, NamespacePK:
+		return ns
+	}
+	return NamespaceOther
+}
+
+// recordKey returns the key of the record with the "/<namespace>/<name>" key, as written by the DHT.
+func recordKey(key string) ds.Key {
+	return ds.NewKey(base32.RawStdEncoding.EncodeToString([]byte(key)))
+}
+
+func (s *Store) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	value, err := s.Datastore.Get(ctx, key)
+	if err == ds.ErrNotFound && s.legacy != nil {
+		return s.legacy.Get(ctx, key)
+	}
+	return value, err
+}
+
+func (s *Store) Has(ctx context.Context, key ds.Key) (bool, error) {
+	ok, err := s.Datastore.Has(ctx, key)
+	if err == nil && !ok && s.legacy != nil {
+		return s.legacy.Has(ctx, key)
+	}
+	return ok, err
+}
+
+func (s *Store) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	size, err := s.Datastore.GetSize(ctx, key)
+	if err == ds.ErrNotFound && s.legacy != nil {
+		return s.legacy.GetSize(ctx, key)
+	}
+	return size, err
+}
+
+func (s *Store) Delete(ctx context.Context, key ds.Key) error {
+	if s.legacy != nil {
+		if err := s.legacy.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return s.Datastore.Delete(ctx, key)
+}
+
+// Put writes the record under its namespaced key, and deletes it from its former key in the shared datastore.
+func (s *Store) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if err := s.Datastore.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if s.legacy != nil {
+		return s.legacy.Delete(ctx, key)
+	}
+	return nil
+}
+
+// iterate calls fn with each record of the namespace, stopping at the first error.
+func (s *Store) iterate(ctx context.Context, namespace string, fn func(value []byte) error) error {
+	res, err := s.backend.Query(ctx, query.Query{Prefix: keyPrefix.ChildString(namespace).String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if err := fn(r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryCounter is implemented by datastores counting their entries without enumerating them, such as DynamoDB.
+type entryCounter interface {
+	EntryCount(context.Context) (uint64, error)
+}
+
+// CountRecords counts the records of each namespace, in a single pass over the records. Records written before they
+// were namespaced are not counted.
+//
+// Backends counting their entries without enumerating them, such as DynamoDB, whose queries scan the whole table, are
+// not enumerated: their entries are all counted as IPNS records, which they mostly are.
+func (s *Store) CountRecords(ctx context.Context) (map[string]int64, error) {
+	if c, ok := s.backend.(entryCounter); ok {
+		n, err := c.EntryCount(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("counting records: %w", err)
+		}
+		return map[string]int64{NamespaceIPNS: int64(n)}, nil
+	}
+
+	res, err := s.backend.Query(ctx, query.Query{Prefix: keyPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	counts := map[string]int64{}
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, fmt.Errorf("counting records: %w", r.Error)
+		}
+		// keys are "/values/<namespace>/<key>"
+		if ns := ds.RawKey(r.Key).Parent().BaseNamespace(); ns != "" {
+			counts[ns]++
+		}
+	}
+	return counts, nil
+}
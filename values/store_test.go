@@ -0,0 +1,154 @@
+package values
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipns"
+	record_pb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newIPNSRecord returns a DHT record holding an IPNS record signed by a new key of the given type.
+func newIPNSRecord(t *testing.T, keyType int, eol time.Time) (peer.ID, []byte) {
+	sk, _, err := crypto.GenerateKeyPair(keyType, 2048)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	entry, err := ipns.Create(sk, []byte("/ipfs/bafkqaaa"), 3, eol, time.Hour)
+	require.NoError(t, err)
+	value, err := entry.Marshal()
+	require.NoError(t, err)
+	rec := record_pb.Record{Key: []byte(ipns.RecordKey(pid)), Value: value, TimeReceived: time.Now().UTC().Format(time.RFC3339Nano)}
+	data, err := rec.Marshal()
+	require.NoError(t, err)
+	return pid, data
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	backend := dssync.MutexWrap(ds.NewMapDatastore())
+	s := NewStore(backend, true)
+
+	pid, data := newIPNSRecord(t, crypto.Ed25519, time.Now().Add(time.Hour))
+	key := recordKey(ipns.RecordKey(pid))
+	pkKey := recordKey("/pk/" + string(pid))
+
+	// records written before they were namespaced are read from their former key
+	require.NoError(t, backend.Put(ctx, key, data))
+	value, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, data, value)
+	counts, err := s.CountRecords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counts[NamespaceIPNS])
+
+	// and moved when they are written again
+	require.NoError(t, s.Put(ctx, key, data))
+	require.NoError(t, s.Put(ctx, pkKey, []byte("public key")))
+	ok, err := backend.Has(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = backend.Has(ctx, ds.NewKey("/values/ipns").ChildString(key.BaseNamespace()))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	counts, err = s.CountRecords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{NamespaceIPNS: 1, NamespacePK: 1}, counts)
+
+	rec, err := s.GetIPNSRecord(ctx, pid)
+	require.NoError(t, err)
+	name, err := peer.ToCid(pid).StringOfBase(multibase.Base36)
+	require.NoError(t, err)
+	assert.Equal(t, name, rec.Name)
+	assert.Equal(t, "/ipfs/bafkqaaa", rec.Value)
+	assert.Equal(t, uint64(3), rec.Sequence)
+	assert.Equal(t, "1h0m0s", rec.TTL)
+	assert.Equal(t, SignatureValid, rec.Signature)
+
+	var listed []IPNSRecord
+	require.NoError(t, s.IterateIPNSRecords(ctx, func(r IPNSRecord) error {
+		listed = append(listed, r)
+		return nil
+	}))
+	assert.Equal(t, []IPNSRecord{rec}, listed)
+
+	require.NoError(t, s.Delete(ctx, key))
+	_, err = s.GetIPNSRecord(ctx, pid)
+	assert.Equal(t, ds.ErrNotFound, err)
+}
+
+func TestStoreDedicated(t *testing.T) {
+	ctx := context.Background()
+	backend := dssync.MutexWrap(ds.NewMapDatastore())
+	s := NewStore(backend, false)
+
+	pid, data := newIPNSRecord(t, crypto.Ed25519, time.Now().Add(time.Hour))
+	key := recordKey(ipns.RecordKey(pid))
+	require.NoError(t, backend.Put(ctx, key, data))
+	_, err := s.Get(ctx, key)
+	assert.Equal(t, ds.ErrNotFound, err)
+}
+
+func TestDecodeIPNSRecord(t *testing.T) {
+	_, data := newIPNSRecord(t, crypto.Ed25519, time.Now().Add(-time.Minute))
+	rec, err := DecodeIPNSRecord(data)
+	require.NoError(t, err)
+	assert.Equal(t, SignatureExpired, rec.Signature)
+
+	// the public key of RSA names is not embedded in the name nor in records created without it
+	_, data = newIPNSRecord(t, crypto.RSA, time.Now().Add(time.Hour))
+	rec, err = DecodeIPNSRecord(data)
+	require.NoError(t, err)
+	assert.Equal(t, SignatureUnverifiable, rec.Signature)
+
+	// records signed by another key
+	pid, _ := newIPNSRecord(t, crypto.Ed25519, time.Now().Add(time.Hour))
+	_, data = newIPNSRecord(t, crypto.Ed25519, time.Now().Add(time.Hour))
+	var dhtRec record_pb.Record
+	require.NoError(t, dhtRec.Unmarshal(data))
+	dhtRec.Key = []byte(ipns.RecordKey(pid))
+	data, err = dhtRec.Marshal()
+	require.NoError(t, err)
+	rec, err = DecodeIPNSRecord(data)
+	require.NoError(t, err)
+	assert.Equal(t, SignatureInvalid, rec.Signature)
+	assert.NotEmpty(t, rec.Error)
+
+	_, err = DecodeIPNSRecord([]byte("not a record"))
+	assert.Error(t, err)
+}
+
+// countingDatastore counts its entries without enumerating them, like DynamoDB.
+type countingDatastore struct {
+	ds.Batching
+	queries int
+}
+
+func (d *countingDatastore) EntryCount(ctx context.Context) (uint64, error) {
+	return 3, nil
+}
+
+func (d *countingDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	d.queries++
+	return d.Batching.Query(ctx, q)
+}
+
+func TestStoreCountRecordsEntryCounter(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	s := NewStore(backend, false)
+
+	counts, err := s.CountRecords(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{NamespaceIPNS: 3}, counts)
+	assert.Equal(t, 0, backend.queries)
+}
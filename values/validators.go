@@ -0,0 +1,243 @@
+package values
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Validators of custom namespaces are configured with a JSON object mapping each namespace to the config of its
+// validator, selected by name with the "Validator" field, e.g.
+//
+//	{"myapp": {"Validator": "signed-json", "Keys": ["12D3KooW..."]}, "blobs": {"Validator": "size-limit", "MaxSize": 4096}}
+
+// ValidatorParser builds a validator from its JSON config, which includes the "Validator" field.
+type ValidatorParser func(config json.RawMessage) (record.Validator, error)
+
+var validatorParsers = map[string]ValidatorParser{}
+
+func init() {
+	RegisterValidator("signed-json", parseSignedJSONValidator)
+	RegisterValidator("size-limit", parseSizeLimitValidator)
+}
+
+// RegisterValidator registers a parser for the configs of the validator with the given name.
+// Registration is not thread safe and should be done from an init function.
+func RegisterValidator(name string, parser ValidatorParser) {
+	if _, ok := validatorParsers[name]; ok {
+		panic(fmt.Sprintf("validator %q registered twice", name))
+	}
+	validatorParsers[name] = parser
+}
+
+// ParseValidators parses the validators of custom namespaces from their JSON config.
+func ParseValidators(data []byte) (map[string]record.Validator, error) {
+	var configs map[string]json.RawMessage
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("decoding validators config: %w", err)
+	}
+	validators := map[string]record.Validator{}
+	for ns, config := range configs {
+		var c struct{ Validator string }
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, fmt.Errorf("decoding validator of namespace %q: %w", ns, err)
+		}
+		parser, ok := validatorParsers[c.Validator]
+		if !ok {
+			return nil, fmt.Errorf("unknown validator %q for namespace %q", c.Validator, ns)
+		}
+		v, err := parser(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s validator for namespace %q: %w", c.Validator, ns, err)
+		}
+		validators[ns] = v
+	}
+	return validators, nil
+}
+
+// LoadValidators reads the validators of custom namespaces from a JSON config file.
+func LoadValidators(path string) (map[string]record.Validator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseValidators(data)
+}
+
+// decodeValidatorConfig decodes the config of a validator into v, rejecting unknown fields.
+func decodeValidatorConfig(config json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// ErrValueTooLarge is returned by validators for values larger than their maximum size.
+var ErrValueTooLarge = errors.New("value too large")
+
+// SizeLimitValidator accepts values of at most MaxSize bytes, and selects the first value.
+type SizeLimitValidator struct {
+	MaxSize int
+}
+
+func (v SizeLimitValidator) Validate(key string, value []byte) error {
+	if len(value) > v.MaxSize {
+		return fmt.Errorf("%w: %d bytes, at most %d bytes are accepted", ErrValueTooLarge, len(value), v.MaxSize)
+	}
+	return nil
+}
+
+func (v SizeLimitValidator) Select(key string, values [][]byte) (int, error) {
+	if len(values) == 0 {
+		return 0, errors.New("no values to select from")
+	}
+	return 0, nil
+}
+
+// {"Validator": "size-limit", "MaxSize": <bytes>}
+func parseSizeLimitValidator(config json.RawMessage) (record.Validator, error) {
+	var c struct {
+		Validator string
+		MaxSize   int
+	}
+	if err := decodeValidatorConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if c.MaxSize <= 0 {
+		return nil, errors.New("MaxSize must be positive")
+	}
+	return SizeLimitValidator{MaxSize: c.MaxSize}, nil
+}
+
+// SignedJSON is the value of the records accepted by a SignedJSONValidator: a JSON payload signed by an allowed key.
+type SignedJSON struct {
+	Payload json.RawMessage
+	// Seq orders the values of a key, the value with the highest sequence number is selected.
+	Seq    uint64
+	Signer peer.ID
+	// Signature signs the key of the record, the sequence number and the payload, see SignJSON.
+	Signature []byte
+}
+
+// signedJSONPrefix prefixes the data signed in SignedJSON values, so that their signatures can't be used for other purposes.
+const signedJSONPrefix = "hydra-signed-json:"
+
+// signedJSONData returns the data signed in a SignedJSON value of a key.
+func signedJSONData(key string, seq uint64, payload []byte) []byte {
+	data := make([]byte, 0, len(signedJSONPrefix)+len(key)+1+8+len(payload))
+	data = append(data, signedJSONPrefix...)
+	data = append(data, key...)
+	data = append(data, 0)
+	data = binary.BigEndian.AppendUint64(data, seq)
+	return append(data, payload...)
+}
+
+// SignJSON returns a SignedJSON value of the key holding the JSON payload, signed by the private key.
+func SignJSON(sk crypto.PrivKey, key string, seq uint64, payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		return nil, errors.New("payload is not valid JSON")
+	}
+	signer, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sk.Sign(signedJSONData(key, seq, payload))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SignedJSON{Payload: payload, Seq: seq, Signer: signer, Signature: sig})
+}
+
+// SignedJSONValidator accepts SignedJSON values signed by one of its keys, of at most MaxSize bytes if MaxSize is not
+// zero, and selects the value with the highest sequence number.
+type SignedJSONValidator struct {
+	Keys    map[peer.ID]crypto.PubKey
+	MaxSize int
+}
+
+// NewSignedJSONValidator creates a validator of values signed by the given peers. The public keys of the peers must be
+// embedded in their peer IDs, as they are for Ed25519 keys.
+func NewSignedJSONValidator(signers []peer.ID, maxSize int) (*SignedJSONValidator, error) {
+	keys := map[peer.ID]crypto.PubKey{}
+	for _, p := range signers {
+		pk, err := p.ExtractPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("extracting public key of %s: %w", p, err)
+		}
+		keys[p] = pk
+	}
+	return &SignedJSONValidator{Keys: keys, MaxSize: maxSize}, nil
+}
+
+func (v *SignedJSONValidator) decode(key string, value []byte) (SignedJSON, error) {
+	var s SignedJSON
+	if v.MaxSize > 0 && len(value) > v.MaxSize {
+		return s, fmt.Errorf("%w: %d bytes, at most %d bytes are accepted", ErrValueTooLarge, len(value), v.MaxSize)
+	}
+	if err := json.Unmarshal(value, &s); err != nil {
+		return s, fmt.Errorf("decoding signed JSON: %w", err)
+	}
+	if len(s.Payload) == 0 {
+		return s, errors.New("missing payload")
+	}
+	pk, ok := v.Keys[s.Signer]
+	if !ok {
+		return s, fmt.Errorf("signer %s is not allowed", s.Signer)
+	}
+	ok, err := pk.Verify(signedJSONData(key, s.Seq, s.Payload), s.Signature)
+	if err != nil {
+		return s, fmt.Errorf("verifying signature: %w", err)
+	}
+	if !ok {
+		return s, errors.New("invalid signature")
+	}
+	return s, nil
+}
+
+func (v *SignedJSONValidator) Validate(key string, value []byte) error {
+	_, err := v.decode(key, value)
+	return err
+}
+
+// Select selects the valid value with the highest sequence number, the first one if several have it.
+func (v *SignedJSONValidator) Select(key string, values [][]byte) (int, error) {
+	best, bestSeq := -1, uint64(0)
+	for i, value := range values {
+		s, err := v.decode(key, value)
+		if err != nil {
+			continue
+		}
+		if best == -1 || s.Seq > bestSeq {
+			best, bestSeq = i, s.Seq
+		}
+	}
+	if best == -1 {
+		return 0, errors.New("no valid values to select from")
+	}
+	return best, nil
+}
+
+// {"Validator": "signed-json", "Keys": ["<peer ID>", ...], "MaxSize": <bytes>}
+func parseSignedJSONValidator(config json.RawMessage) (record.Validator, error) {
+	var c struct {
+		Validator string
+		Keys      []peer.ID
+		MaxSize   int
+	}
+	if err := decodeValidatorConfig(config, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Keys) == 0 {
+		return nil, errors.New("at least one key must be allowed")
+	}
+	if c.MaxSize < 0 {
+		return nil, errors.New("MaxSize must not be negative")
+	}
+	return NewSignedJSONValidator(c.Keys, c.MaxSize)
+}
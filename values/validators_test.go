@@ -0,0 +1,89 @@
+package values
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSigner(t *testing.T) (crypto.PrivKey, peer.ID) {
+	sk, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	return sk, pid
+}
+
+func TestSignedJSONValidator(t *testing.T) {
+	sk, signer := newSigner(t)
+	otherSk, _ := newSigner(t)
+	v, err := NewSignedJSONValidator([]peer.ID{signer}, 256)
+	require.NoError(t, err)
+
+	v1, err := SignJSON(sk, "/myapp/key", 1, []byte(`{"a":1}`))
+	require.NoError(t, err)
+	v2, err := SignJSON(sk, "/myapp/key", 2, []byte(`{"a":2}`))
+	require.NoError(t, err)
+	assert.NoError(t, v.Validate("/myapp/key", v1))
+	assert.NoError(t, v.Validate("/myapp/key", v2))
+
+	// signatures are bound to their key
+	assert.Error(t, v.Validate("/myapp/other", v1))
+	// and to their signer
+	unknown, err := SignJSON(otherSk, "/myapp/key", 3, []byte(`{"a":3}`))
+	require.NoError(t, err)
+	assert.Error(t, v.Validate("/myapp/key", unknown))
+
+	large, err := SignJSON(sk, "/myapp/key", 4, []byte(fmt.Sprintf(`{"a":"%0300d"}`, 0)))
+	require.NoError(t, err)
+	assert.True(t, errors.Is(v.Validate("/myapp/key", large), ErrValueTooLarge))
+	assert.Error(t, v.Validate("/myapp/key", []byte("not JSON")))
+
+	i, err := v.Select("/myapp/key", [][]byte{v1, unknown, v2, large})
+	require.NoError(t, err)
+	assert.Equal(t, 2, i)
+	_, err = v.Select("/myapp/key", [][]byte{unknown})
+	assert.Error(t, err)
+}
+
+func TestSizeLimitValidator(t *testing.T) {
+	v := SizeLimitValidator{MaxSize: 4}
+	assert.NoError(t, v.Validate("/blobs/key", []byte("1234")))
+	assert.True(t, errors.Is(v.Validate("/blobs/key", []byte("12345")), ErrValueTooLarge))
+	i, err := v.Select("/blobs/key", [][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, err)
+	assert.Equal(t, 0, i)
+}
+
+func TestParseValidators(t *testing.T) {
+	_, signer := newSigner(t)
+	validators, err := ParseValidators([]byte(fmt.Sprintf(`{
+		"myapp": {"Validator": "signed-json", "Keys": [%q], "MaxSize": 1024},
+		"blobs": {"Validator": "size-limit", "MaxSize": 4096}
+	}`, signer)))
+	require.NoError(t, err)
+	require.Len(t, validators, 2)
+	assert.Equal(t, record.Validator(SizeLimitValidator{MaxSize: 4096}), validators["blobs"])
+	sj, ok := validators["myapp"].(*SignedJSONValidator)
+	require.True(t, ok)
+	assert.Equal(t, 1024, sj.MaxSize)
+	assert.Contains(t, sj.Keys, signer)
+
+	for _, config := range []string{
+		`{"myapp": {"Validator": "unknown"}}`,
+		`{"myapp": {"Validator": "size-limit"}}`,
+		`{"myapp": {"Validator": "size-limit", "MaxSize": 10, "Keys": []}}`,
+		`{"myapp": {"Validator": "signed-json"}}`,
+		`{"myapp": {"Validator": "signed-json", "Keys": ["not a peer ID"]}}`,
+		`["myapp"]`,
+	} {
+		_, err := ParseValidators([]byte(config))
+		assert.Error(t, err, config)
+	}
+}